@@ -87,6 +87,13 @@ func registerHTTPEndpoints(
 			registerEndpoint("/stats", httpStats.JSONHandler())
 		}
 	}
+
+	// If we want to expose a Prometheus scrape endpoint we register it.
+	if conf.Metrics.Type == "prometheus" {
+		if promStats, ok := stats.(*metrics.Prometheus); ok {
+			registerEndpoint("/metrics", promStats.HandlerFunc())
+		}
+	}
 }
 
 //------------------------------------------------------------------------------
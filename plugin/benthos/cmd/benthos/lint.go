@@ -0,0 +1,156 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Jeffail/benthos/lib/buffer"
+	"github.com/Jeffail/benthos/lib/condition"
+	"github.com/Jeffail/benthos/lib/input"
+	"github.com/Jeffail/benthos/lib/output"
+	"github.com/Jeffail/benthos/lib/processor"
+	"github.com/Jeffail/benthos/lib/stream"
+	"github.com/Jeffail/benthos/lib/util/service/config"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// componentRegistry is implemented by config utilities that walk the entire
+// structure of a config.
+type componentRegistry interface {
+	RegisterTypes(conf interface{}, names []string)
+	RegisterDynamic(parent interface{}, key string, conf interface{})
+}
+
+// registerComponents registers every component type, along with the generic
+// fields that broker types parse into child configs, with a registry.
+func registerComponents(r componentRegistry) {
+	// Broker children may use the type 'ditto' in order to copy the previous
+	// child, optionally with a multiplier such as 'ditto_3'.
+	r.RegisterTypes(input.NewConfig(), append(input.TypeNames(), "ditto*"))
+	r.RegisterTypes(output.NewConfig(), append(output.TypeNames(), "ditto*"))
+	r.RegisterTypes(buffer.NewConfig(), buffer.TypeNames())
+	r.RegisterTypes(processor.NewConfig(), processor.TypeNames())
+	r.RegisterTypes(condition.NewConfig(), condition.TypeNames())
+	r.RegisterTypes(metrics.NewConfig(), append(metrics.TypeNames(), "none"))
+
+	r.RegisterDynamic(input.FanInConfig{}, "inputs", input.NewConfig())
+	r.RegisterDynamic(output.FanOutConfig{}, "outputs", output.NewConfig())
+	r.RegisterDynamic(output.RoundRobinConfig{}, "outputs", output.NewConfig())
+	r.RegisterDynamic(output.SwitchCaseConfig{}, "output", output.NewConfig())
+	r.RegisterDynamic(output.SwitchConfig{}, "fallback", output.NewConfig())
+	r.RegisterDynamic(output.RetryConfig{}, "dead_letter", output.NewConfig())
+}
+
+// newLinter creates a config linter aware of every registered component type.
+func newLinter() *config.Linter {
+	l := config.NewLinter()
+	registerComponents(l)
+	return l
+}
+
+// lintConfigs lints a config file, along with any stream configs within the
+// streams directory, prints each lint error and then exits. The exit code is
+// non-zero if any lint errors were found.
+func lintConfigs(path string) {
+	swapEnvs := true
+	if f := flag.Lookup("swap-envs"); f != nil {
+		swapEnvs = f.Value.String() == "true"
+	}
+
+	l := newLinter()
+	failed := false
+
+	lintFile := func(path string, conf interface{}) {
+		lints, err := l.Lint(path, swapEnvs, conf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: failed to read config: %v\n", path, err)
+			failed = true
+			return
+		}
+		for _, lint := range lints {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", path, lint)
+			failed = true
+		}
+	}
+
+	lintFile(path, NewConfig())
+	if len(*streamsDir) > 0 {
+		streamPaths := []string{}
+		filepath.Walk(*streamsDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			switch filepath.Ext(path) {
+			case ".js", ".json", ".yml", ".yaml":
+				streamPaths = append(streamPaths, path)
+			}
+			return nil
+		})
+		for _, p := range streamPaths {
+			lintFile(p, stream.NewConfig())
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+//------------------------------------------------------------------------------
+
+// dryRun validates every input, buffer, processor and output of a config, or
+// of each stream config when running in streams mode, without connecting or
+// starting them. Returns a list of errors found, prefixed with the path of the
+// offending component.
+func dryRun(conf Config, streamConfs map[string]stream.Config, log log.Modular, stats metrics.Type) []string {
+	if streamConfs == nil {
+		return stream.DryRun(stream.Config{
+			Input:  conf.Input,
+			Buffer: conf.Buffer,
+			Output: conf.Output,
+		}, log, stats)
+	}
+
+	ids := make([]string, 0, len(streamConfs))
+	for id := range streamConfs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	errs := []string{}
+	for _, id := range ids {
+		for _, err := range stream.DryRun(streamConfs[id], log, stats) {
+			errs = append(errs, "streams."+id+"."+err)
+		}
+	}
+	return errs
+}
+
+//------------------------------------------------------------------------------
@@ -34,13 +34,16 @@ import (
 	"time"
 
 	"github.com/Jeffail/benthos/lib/buffer"
+	"github.com/Jeffail/benthos/lib/condition"
 	"github.com/Jeffail/benthos/lib/input"
 	"github.com/Jeffail/benthos/lib/output"
 	"github.com/Jeffail/benthos/lib/processor"
-	"github.com/Jeffail/benthos/lib/util"
+	"github.com/Jeffail/benthos/lib/stream"
+	"github.com/Jeffail/benthos/lib/stream/manager"
 	"github.com/Jeffail/benthos/lib/util/service"
 	"github.com/Jeffail/benthos/lib/util/service/log"
 	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/benthos/lib/util/service/tracing"
 )
 
 //------------------------------------------------------------------------------
@@ -52,13 +55,15 @@ type httpConfig struct {
 
 // Config is the benthos configuration struct.
 type Config struct {
-	HTTP                 httpConfig       `json:"http"`
-	Input                input.Config     `json:"input" yaml:"input"`
-	Output               output.Config    `json:"output" yaml:"output"`
-	Buffer               buffer.Config    `json:"buffer" yaml:"buffer"`
-	Logger               log.LoggerConfig `json:"logger" yaml:"logger"`
-	Metrics              metrics.Config   `json:"metrics" yaml:"metrics"`
-	SystemCloseTimeoutMS int              `json:"sys_exit_timeout_ms" yaml:"sys_exit_timeout_ms"`
+	HTTP                 httpConfig               `json:"http"`
+	Input                input.Config             `json:"input" yaml:"input"`
+	Output               output.Config            `json:"output" yaml:"output"`
+	Buffer               buffer.Config            `json:"buffer" yaml:"buffer"`
+	Streams              map[string]stream.Config `json:"streams,omitempty" yaml:"streams,omitempty"`
+	Logger               log.LoggerConfig         `json:"logger" yaml:"logger"`
+	Metrics              metrics.Config           `json:"metrics" yaml:"metrics"`
+	Tracing              tracing.Config           `json:"tracing" yaml:"tracing"`
+	SystemCloseTimeoutMS int                      `json:"sys_exit_timeout_ms" yaml:"sys_exit_timeout_ms"`
 }
 
 // NewConfig returns a new configuration with default values.
@@ -76,6 +81,7 @@ func NewConfig() Config {
 		Buffer:               buffer.NewConfig(),
 		Logger:               log.NewLoggerConfig(),
 		Metrics:              metricsConf,
+		Tracing:              tracing.NewConfig(),
 		SystemCloseTimeoutMS: 20000,
 	}
 }
@@ -100,6 +106,34 @@ var (
 		"list-processors", false,
 		"Print a list of available processor options, then exit",
 	)
+	printConditions = flag.Bool(
+		"list-conditions", false,
+		"Print a list of available condition options, then exit",
+	)
+	streamsMode = flag.Bool(
+		"streams", false,
+		"Run in streams mode, where each entry of the streams section of the"+
+			" config is run as an isolated pipeline",
+	)
+	lintPath = flag.String(
+		"lint", "",
+		"Path to a config file to check for unknown fields, type mismatches and"+
+			" sections of types that are not selected, then exit",
+	)
+	dryRunMode = flag.Bool(
+		"dry-run", false,
+		"Validate every input, buffer, processor and output of the config"+
+			" without connecting them, then exit with a non-zero code on errors",
+	)
+	printSchemaMode = flag.Bool(
+		"print-schema", false,
+		"Print a JSON Schema of the config, including default values, then exit",
+	)
+	streamsDir = flag.String(
+		"streams-dir", "",
+		"Path to a directory of stream configs to run in streams mode, where"+
+			" the ID of each stream is its file name without the extension",
+	)
 )
 
 //------------------------------------------------------------------------------
@@ -124,7 +158,24 @@ func bootstrap() Config {
 		fmt.Fprintf(os.Stderr,
 			"\nFor example configs use --print-yaml or --print-json\n"+
 				"For a list of available inputs or outputs use --list-inputs or --list-outputs\n"+
-				"For a list of available buffer options use --list-buffers\n")
+				"For a list of available buffer options use --list-buffers\n"+
+				"For a list of available conditions use --list-conditions\n"+
+				"To run multiple isolated pipelines use --streams or --streams-dir\n"+
+				"To check a config for mistakes use --lint or --dry-run\n"+
+				"For a JSON Schema of the config use --print-schema\n")
+	}
+
+	// Ensure that cmd flags are parsed.
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+
+	// Linting reads the config file itself, so we exit before loading it.
+	if len(*lintPath) > 0 {
+		lintConfigs(*lintPath)
+	}
+	if *printSchemaMode {
+		printSchema()
 	}
 
 	// Load configuration etc
@@ -133,13 +184,16 @@ func bootstrap() Config {
 	}
 
 	// If we only want to print our inputs or outputs we should exit afterwards
-	if *printInputs || *printOutputs || *printBuffers || *printProcessors {
+	if *printInputs || *printOutputs || *printBuffers || *printProcessors || *printConditions {
 		if *printInputs {
 			fmt.Println(input.Descriptions())
 		}
 		if *printProcessors {
 			fmt.Println(processor.Descriptions())
 		}
+		if *printConditions {
+			fmt.Println(condition.Descriptions())
+		}
 		if *printBuffers {
 			fmt.Println(buffer.Descriptions())
 		}
@@ -152,72 +206,68 @@ func bootstrap() Config {
 	return config
 }
 
-// createPipeline creates a pipeline based on the supplied configuration file,
-// and return a closable pool of pipeline objects, a channel indicating that all
-// inputs and outputs have seized, or an error.
-func createPipeline(
-	config Config, logger log.Modular, stats metrics.Type,
-) (*util.ClosablePool, *util.ClosablePool, chan struct{}, error) {
-	// Create two pools, this helps manage ordered closure of all pipeline
-	// components. We have a tiered (t1) and an non-tiered (t2) pool. If the
-	// tiered pool cannot close within our allotted time period then we try
-	// closing the second non-tiered pool. If the second pool also fails then we
-	// exit the service ungracefully.
-	poolt1, poolt2 := util.NewClosablePool(), util.NewClosablePool()
-
-	// Create our input pipe
-	inputPipe, err := input.New(config.Input, logger, stats)
-	if err != nil {
-		logger.Errorf("Input error (%s): %v\n", config.Input.Type, err)
-		return nil, nil, nil, err
+// streamsConfigs returns a map of stream IDs to their configurations if
+// benthos is running in streams mode, which are gathered from both the streams
+// section of the config and any configs in the streams directory. Returns nil
+// if benthos is not running in streams mode.
+func streamsConfigs(config Config) (map[string]stream.Config, error) {
+	if !*streamsMode && len(*streamsDir) == 0 {
+		return nil, nil
 	}
-	poolt1.Add(1, inputPipe)
-	poolt2.Add(0, inputPipe)
-
-	// Create a buffer
-	buf, err := buffer.New(config.Buffer, logger, stats)
-	if err != nil {
-		logger.Errorf("Buffer error (%s): %v\n", config.Buffer.Type, err)
-		return nil, nil, nil, err
-	}
-	poolt1.Add(3, buf)
-	poolt2.Add(0, buf)
-
-	// Create our output pipe
-	outputPipe, err := output.New(config.Output, logger, stats)
-	if err != nil {
-		logger.Errorf("Output error (%s): %v\n", config.Output.Type, err)
-		return nil, nil, nil, err
+	streamConfs := map[string]stream.Config{}
+	for id, conf := range config.Streams {
+		streamConfs[id] = conf
 	}
-	poolt1.Add(10, outputPipe)
-	poolt2.Add(0, outputPipe)
-
-	util.Couple(buf, outputPipe)
-	util.Couple(inputPipe, buf)
-	closeChan := make(chan struct{})
-
-	// If our outputs close down then we should shut down the service
-	go func() {
-		for {
-			if err := outputPipe.WaitForClose(time.Second * 60); err == nil {
-				closeChan <- struct{}{}
-				return
+	if len(*streamsDir) > 0 {
+		dirConfs, err := manager.LoadStreamConfigsFromDirectory(true, *streamsDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load streams directory: %v", err)
+		}
+		for id, conf := range dirConfs {
+			if _, exists := streamConfs[id]; exists {
+				return nil, fmt.Errorf("stream '%v' is defined more than once", id)
 			}
+			streamConfs[id] = conf
 		}
-	}()
-
-	return poolt1, poolt2, closeChan, nil
+	}
+	return streamConfs, nil
 }
 
 func main() {
 	// Bootstrap by reading cmd flags and configuration file
 	config := bootstrap()
 
+	streamConfs, err := streamsConfigs(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Streams error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *dryRunMode {
+		errs := dryRun(config, streamConfs, log.NewLogger(os.Stderr, config.Logger), metrics.DudType{})
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Logging and stats aggregation
 	var logger log.Modular
 
 	// Note: Only log to Stderr if one of our outputs is stdout
-	if config.Output.Type == "stdout" {
+	logToStderr := config.Output.Type == "stdout"
+	if streamConfs != nil {
+		logToStderr = false
+		for _, conf := range streamConfs {
+			if conf.Output.Type == "stdout" {
+				logToStderr = true
+			}
+		}
+	}
+	if logToStderr {
 		logger = log.NewLogger(os.Stderr, config.Logger)
 	} else {
 		logger = log.NewLogger(os.Stdout, config.Logger)
@@ -235,10 +285,50 @@ func main() {
 
 	registerHTTPEndpoints(config, logger, stats)
 
-	poolTiered, poolNonTiered, outputsClosedChan, err := createPipeline(config, logger, stats)
-	if err != nil {
-		logger.Errorf("Service closing due to: %v\n", err)
-		return
+	// Create our tracer, which is nil unless tracing is enabled.
+	var tracer *tracing.Tracer
+	if config.Tracing.Enabled {
+		tracer = tracing.New(config.Tracing, logger, stats)
+		defer tracer.Close(time.Second * 5)
+
+		// Expose a tap of messages passing through each stage.
+		registerEndpoint("/debug/tap", tracer.TapHandler())
+	}
+
+	tout := time.Millisecond * time.Duration(config.SystemCloseTimeoutMS)
+	streamMgr := manager.NewWithTracer(logger, stats, tracer)
+
+	var pipelineTerminatedChan <-chan struct{}
+
+	if streamConfs != nil {
+		for id, conf := range streamConfs {
+			if err = streamMgr.Create(id, conf); err != nil {
+				logger.Errorf("Stream '%v' error: %v\n", id, err)
+				streamMgr.Stop(tout)
+				logger.Errorf("Service closing due to: %v\n", err)
+				return
+			}
+		}
+		logger.Infof("Running %v streams: %v\n", len(streamConfs), streamMgr.IDs())
+
+		// Expose the streams API for managing streams at runtime.
+		registerEndpoint("/streams", streamMgr.StreamsHandler())
+		registerEndpoint("/streams/", streamMgr.StreamCRUDHandler(tout))
+	} else {
+		// A single pipeline is managed as a stream with an empty ID, which
+		// keeps its logs and metrics unprefixed.
+		pipelineTerminatedChan = streamMgr.Terminated("")
+		if err = streamMgr.Create("", stream.Config{
+			Input:  config.Input,
+			Buffer: config.Buffer,
+			Output: config.Output,
+		}); err != nil {
+			logger.Errorf("Service closing due to: %v\n", err)
+			return
+		}
+
+		// Expose an API for hot reloading the pipeline config.
+		registerEndpoint("/stream", streamMgr.StreamHandler("", tout))
 	}
 
 	httpServerClosedChan := make(chan struct{})
@@ -259,10 +349,8 @@ func main() {
 		close(httpServerClosedChan)
 	}()
 
-	// Defer ordered pool clean up.
+	// Defer ordered pipeline clean up.
 	defer func() {
-		tout := time.Millisecond * time.Duration(config.SystemCloseTimeoutMS)
-
 		go func() {
 			httpServer.Shutdown(context.Background())
 			select {
@@ -272,20 +360,14 @@ func main() {
 			}
 		}()
 
-		if err := poolTiered.Close(tout / 2); err != nil {
+		if err := streamMgr.Stop(tout); err != nil {
 			logger.Warnln(
-				"Service failed to close using ordered tiers, you may receive a duplicate " +
-					"message on the next service start.",
+				"Service failed to close cleanly within allocated time. Exiting forcefully.",
 			)
-			if err = poolNonTiered.Close(tout / 2); err != nil {
-				logger.Warnln(
-					"Service failed to close cleanly within allocated time. Exiting forcefully.",
-				)
-				if config.Logger.LogLevel == "DEBUG" {
-					pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
-				}
-				os.Exit(1)
+			if config.Logger.LogLevel == "DEBUG" {
+				pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
 			}
+			os.Exit(1)
 		}
 	}()
 
@@ -296,7 +378,7 @@ func main() {
 	select {
 	case <-sigChan:
 		logger.Infoln("Received SIGTERM, the service is closing.")
-	case <-outputsClosedChan:
+	case <-pipelineTerminatedChan:
 		logger.Infoln("Pipeline has terminated. Shutting down the service.")
 	}
 }
@@ -0,0 +1,47 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Jeffail/benthos/lib/util/service/config"
+)
+
+//------------------------------------------------------------------------------
+
+// printSchema prints a JSON Schema of the entire config structure, including
+// default values, and then exits.
+func printSchema() {
+	s := config.NewSchema()
+	registerComponents(s)
+	schemaJSON, err := json.MarshalIndent(s.Generate(NewConfig()), "", "\t")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Schema marshal error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(schemaJSON))
+	os.Exit(0)
+}
+
+//------------------------------------------------------------------------------
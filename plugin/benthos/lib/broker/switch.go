@@ -0,0 +1,179 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package broker
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// SwitchSelector is a function that returns the index of the output that a
+// message should be routed to, or a negative index if the message should not
+// be routed to any output.
+type SwitchSelector func(msg *types.Message) int
+
+//------------------------------------------------------------------------------
+
+// Switch is a broker that implements types.Consumer and sends each message out
+// to a single consumer chosen from an array by a selector function. Consumers
+// that apply backpressure will block all consumers.
+type Switch struct {
+	running int32
+
+	stats metrics.Type
+
+	selector SwitchSelector
+
+	messages     <-chan types.Message
+	responseChan chan types.Response
+
+	outputMsgChans []chan types.Message
+	outputs        []types.Consumer
+
+	closedChan chan struct{}
+	closeChan  chan struct{}
+}
+
+// NewSwitch creates a new Switch type by providing consumers and a selector
+// function used to choose a consumer for each message.
+func NewSwitch(
+	selector SwitchSelector, outputs []types.Consumer, stats metrics.Type,
+) (*Switch, error) {
+	o := &Switch{
+		running:      1,
+		stats:        stats,
+		selector:     selector,
+		messages:     nil,
+		responseChan: make(chan types.Response),
+		outputs:      outputs,
+		closedChan:   make(chan struct{}),
+		closeChan:    make(chan struct{}),
+	}
+	o.outputMsgChans = make([]chan types.Message, len(o.outputs))
+	for i := range o.outputMsgChans {
+		o.outputMsgChans[i] = make(chan types.Message)
+		if err := o.outputs[i].StartReceiving(o.outputMsgChans[i]); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+//------------------------------------------------------------------------------
+
+// StartReceiving assigns a new messages channel for the broker to read.
+func (o *Switch) StartReceiving(msgs <-chan types.Message) error {
+	if o.messages != nil {
+		return types.ErrAlreadyStarted
+	}
+	o.messages = msgs
+
+	go o.loop()
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// loop is an internal loop that brokers incoming messages to many outputs.
+func (o *Switch) loop() {
+	defer func() {
+		for _, c := range o.outputMsgChans {
+			close(c)
+		}
+		close(o.responseChan)
+		close(o.closedChan)
+	}()
+
+	open := false
+	for atomic.LoadInt32(&o.running) == 1 {
+		var msg types.Message
+		select {
+		case msg, open = <-o.messages:
+			if !open {
+				return
+			}
+		case <-o.closeChan:
+			return
+		}
+		o.stats.Incr("broker.switch.messages.received", 1)
+
+		var res types.Response
+		if i := o.selector(&msg); i >= 0 && i < len(o.outputMsgChans) {
+			select {
+			case o.outputMsgChans[i] <- msg:
+			case <-o.closeChan:
+				return
+			}
+
+			select {
+			case res, open = <-o.outputs[i].ResponseChan():
+				if !open {
+					return
+				}
+			case <-o.closeChan:
+				return
+			}
+			if res.Error() != nil {
+				o.stats.Incr("broker.switch.output.error", 1)
+			} else {
+				o.stats.Incr("broker.switch.messages.sent", 1)
+			}
+		} else {
+			o.stats.Incr("broker.switch.messages.dropped", 1)
+			res = types.NewSimpleResponse(nil)
+		}
+
+		select {
+		case o.responseChan <- res:
+		case <-o.closeChan:
+			return
+		}
+	}
+}
+
+// ResponseChan returns the response channel.
+func (o *Switch) ResponseChan() <-chan types.Response {
+	return o.responseChan
+}
+
+// CloseAsync shuts down the Switch broker and stops processing requests.
+func (o *Switch) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&o.running, 1, 0) {
+		close(o.closeChan)
+	}
+}
+
+// WaitForClose blocks until the Switch broker has closed down.
+func (o *Switch) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-o.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,151 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package broker
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func TestSwitchInterfaces(t *testing.T) {
+	f := &Switch{}
+	if types.Consumer(f) == nil {
+		t.Errorf("Switch: nil types.Consumer")
+	}
+	if types.Closable(f) == nil {
+		t.Errorf("Switch: nil types.Closable")
+	}
+}
+
+func TestSwitchDoubleClose(t *testing.T) {
+	oTM, err := NewSwitch(func(*types.Message) int { return 0 }, []types.Consumer{}, metrics.DudType{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// This shouldn't cause a panic
+	oTM.CloseAsync()
+	oTM.CloseAsync()
+}
+
+//------------------------------------------------------------------------------
+
+func TestBasicSwitch(t *testing.T) {
+	nMsgs := 1000
+
+	outputs := []types.Consumer{}
+	mockOutputs := []*MockOutputType{
+		{
+			ResChan: make(chan types.Response),
+			MsgChan: make(chan types.Message),
+		},
+		{
+			ResChan: make(chan types.Response),
+			MsgChan: make(chan types.Message),
+		},
+	}
+
+	for _, o := range mockOutputs {
+		outputs = append(outputs, o)
+	}
+
+	readChan := make(chan types.Message)
+
+	// Route by the parity of the message contents, dropping multiples of five.
+	selector := func(msg *types.Message) int {
+		i, _ := strconv.Atoi(string(msg.Parts[0]))
+		if i%5 == 0 {
+			return -1
+		}
+		return i % 2
+	}
+
+	oTM, err := NewSwitch(selector, outputs, metrics.DudType{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = oTM.StartReceiving(readChan); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < nMsgs; i++ {
+		content := [][]byte{[]byte(strconv.Itoa(i))}
+		select {
+		case readChan <- types.Message{Parts: content}:
+		case <-time.After(time.Second):
+			t.Errorf("Timed out waiting for broker send")
+			return
+		}
+
+		var expErr error
+		if i%5 != 0 {
+			select {
+			case msg := <-mockOutputs[i%2].MsgChan:
+				if string(msg.Parts[0]) != string(content[0]) {
+					t.Errorf("Wrong content returned %s != %s", msg.Parts[0], content[0])
+				}
+			case <-mockOutputs[(i+1)%2].MsgChan:
+				t.Errorf("Received message on wrong output: %v", i)
+				return
+			case <-time.After(time.Second):
+				t.Errorf("Timed out waiting for broker propagate")
+				return
+			}
+
+			if i%3 == 0 {
+				expErr = errors.New("test")
+			}
+			select {
+			case mockOutputs[i%2].ResChan <- types.NewSimpleResponse(expErr):
+			case <-time.After(time.Second):
+				t.Errorf("Timed out responding to broker")
+				return
+			}
+		}
+
+		select {
+		case res := <-oTM.ResponseChan():
+			if res.Error() != expErr {
+				t.Errorf("Wrong error from broker: %v != %v", res.Error(), expErr)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Timed out responding to broker")
+			return
+		}
+	}
+
+	oTM.CloseAsync()
+	if err := oTM.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+//------------------------------------------------------------------------------
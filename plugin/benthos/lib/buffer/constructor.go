@@ -48,6 +48,7 @@ type Config struct {
 	Type   string                `json:"type" yaml:"type"`
 	Mmap   impl.MmapBufferConfig `json:"mmap_file" yaml:"mmap_file"`
 	Memory impl.MemoryConfig     `json:"memory" yaml:"memory"`
+	WAL    impl.WALBufferConfig  `json:"wal" yaml:"wal"`
 }
 
 // NewConfig returns a configuration struct fully populated with default values.
@@ -56,11 +57,23 @@ func NewConfig() Config {
 		Type:   "none",
 		Mmap:   impl.NewMmapBufferConfig(),
 		Memory: impl.NewMemoryConfig(),
+		WAL:    impl.NewWALBufferConfig(),
 	}
 }
 
 //------------------------------------------------------------------------------
 
+// TypeNames returns an alphabetically sorted list of the names of each
+// registered buffer type.
+func TypeNames() []string {
+	names := []string{}
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Descriptions returns a formatted string of collated descriptions of each type.
 func Descriptions() string {
 	// Order our buffer types alphabetically
@@ -343,6 +343,70 @@ func TestMmapBufferRecoverFiles(t *testing.T) {
 	block.Close()
 }
 
+func TestMmapBufferRecoverMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_test_")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer cleanUpMmapDir(dir)
+
+	n := 100
+
+	conf := NewMmapBufferConfig()
+	conf.FileSize = 1000
+	conf.Path = dir
+
+	block, err := NewMmapBuffer(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		msg := types.Message{
+			Parts: [][]byte{
+				[]byte("hello"),
+				[]byte(fmt.Sprintf("test%v", i)),
+			},
+		}
+		msg.SetMetadata(1, "kafka_key", fmt.Sprintf("key%v", i))
+		if _, err := block.PushMessage(msg); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	block.Close()
+
+	block, err = NewMmapBuffer(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		m, err := block.NextMessage()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if expected, actual := fmt.Sprintf("test%v", i), string(m.Parts[1]); expected != actual {
+			t.Errorf("Wrong order of messages, %v != %v", expected, actual)
+		}
+		if expected, actual := fmt.Sprintf("key%v", i), m.GetMetadata(1, "kafka_key"); expected != actual {
+			t.Errorf("Wrong metadata, %v != %v", expected, actual)
+		}
+		if _, err := block.ShiftMessage(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	block.Close()
+}
+
 func TestMmapBufferRejectLargeMessage(t *testing.T) {
 	dir, err := ioutil.TempDir("", "benthos_test_")
 	if err != nil {
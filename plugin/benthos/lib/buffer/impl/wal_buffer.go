@@ -0,0 +1,612 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package impl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// WALBufferConfig is config options for a write ahead log based buffer.
+type WALBufferConfig struct {
+	Path           string `json:"directory" yaml:"directory"`
+	SegmentSize    int    `json:"segment_size" yaml:"segment_size"`
+	Sync           string `json:"sync" yaml:"sync"`
+	SyncIntervalMS int    `json:"sync_interval_ms" yaml:"sync_interval_ms"`
+	CleanUp        bool   `json:"clean_up" yaml:"clean_up"`
+}
+
+// NewWALBufferConfig creates a WALBufferConfig oject with default values.
+func NewWALBufferConfig() WALBufferConfig {
+	return WALBufferConfig{
+		Path:           "",
+		SegmentSize:    64 * 1024 * 1024, // 64MiB
+		Sync:           "interval",
+		SyncIntervalMS: 1000, // 1 second
+		CleanUp:        true,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+const (
+	walSegmentPrefix = "wal_"
+	walSegmentSuffix = ".log"
+	walOffsetFile    = "wal.offset"
+
+	// Each record is prefixed with the size and checksum of its contents.
+	walRecordHeaderSize = 8
+
+	// The offset file contains two slots which are written alternately, each
+	// slot is a sequence number, segment index and offset followed by a
+	// checksum.
+	walOffsetSlotSize = 32
+)
+
+var walCastagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var errWALBadSync = errors.New("wal sync policy must be one of: always, interval, none")
+
+// WALBuffer is a buffer implemented as a write ahead log of segment files,
+// where each record is checksummed. The read offset is committed to disk each
+// time a message is shifted, and on start up the log is scanned from the
+// committed offset in order to recover the backlog and remove torn records
+// left by an interrupted write.
+type WALBuffer struct {
+	config WALBufferConfig
+
+	logger log.Modular
+	stats  metrics.Type
+
+	cond *sync.Cond
+
+	writeIndex int
+	writtenTo  int64
+	writeFile  *os.File
+
+	readIndex int
+	readFrom  int64
+	readFile  *os.File
+
+	offsetFile *os.File
+	offsetSeq  uint64
+
+	backlog int
+	dirty   bool
+	closed  bool
+
+	closeChan chan struct{}
+}
+
+// NewWALBuffer creates a write ahead log based buffer, recovering any messages
+// that were previously written to the directory and not yet shifted.
+func NewWALBuffer(config WALBufferConfig, log log.Modular, stats metrics.Type) (*WALBuffer, error) {
+	switch config.Sync {
+	case "always", "interval", "none":
+	default:
+		return nil, errWALBadSync
+	}
+	if len(config.Path) == 0 {
+		return nil, errors.New("wal buffer requires a directory")
+	}
+	if config.SegmentSize <= walRecordHeaderSize {
+		return nil, fmt.Errorf("wal segment size too small: %v", config.SegmentSize)
+	}
+	if err := os.MkdirAll(config.Path, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &WALBuffer{
+		config:    config,
+		logger:    log,
+		stats:     stats,
+		cond:      sync.NewCond(&sync.Mutex{}),
+		closeChan: make(chan struct{}),
+	}
+	if err := w.recover(); err != nil {
+		w.closeFiles()
+		return nil, err
+	}
+
+	w.logger.Infof("Storing messages to write ahead log in: %s\n", config.Path)
+	if config.Sync == "interval" && config.SyncIntervalMS > 0 {
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+//------------------------------------------------------------------------------
+
+func (w *WALBuffer) segmentPath(index int) string {
+	return filepath.Join(w.config.Path, fmt.Sprintf("%v%08d%v", walSegmentPrefix, index, walSegmentSuffix))
+}
+
+// listSegments returns the indexes of all segment files in order.
+func (w *WALBuffer) listSegments() ([]int, error) {
+	matches, err := filepath.Glob(filepath.Join(w.config.Path, walSegmentPrefix+"*"+walSegmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	indexes := []int{}
+	for _, match := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), walSegmentPrefix), walSegmentSuffix)
+		index, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+// readOffset reads the most recent valid slot of the offset file.
+func (w *WALBuffer) readOffset() (seq uint64, index int, offset int64, ok bool) {
+	buf := make([]byte, walOffsetSlotSize*2)
+	n, _ := w.offsetFile.ReadAt(buf, 0)
+	for slot := 0; (slot+1)*walOffsetSlotSize <= n; slot++ {
+		b := buf[slot*walOffsetSlotSize : (slot+1)*walOffsetSlotSize]
+		if crc32.Checksum(b[:24], walCastagnoli) != binary.BigEndian.Uint32(b[24:]) {
+			continue
+		}
+		if s := binary.BigEndian.Uint64(b); !ok || s > seq {
+			seq, ok = s, true
+			index = int(binary.BigEndian.Uint64(b[8:]))
+			offset = int64(binary.BigEndian.Uint64(b[16:]))
+		}
+	}
+	return
+}
+
+// commitOffset writes the current read position to the offset file. Slots are
+// written alternately so that a torn write never loses the previous offset.
+func (w *WALBuffer) commitOffset() error {
+	w.offsetSeq++
+	b := make([]byte, walOffsetSlotSize)
+	binary.BigEndian.PutUint64(b, w.offsetSeq)
+	binary.BigEndian.PutUint64(b[8:], uint64(w.readIndex))
+	binary.BigEndian.PutUint64(b[16:], uint64(w.readFrom))
+	binary.BigEndian.PutUint32(b[24:], crc32.Checksum(b[:24], walCastagnoli))
+
+	if _, err := w.offsetFile.WriteAt(b, int64(w.offsetSeq%2)*walOffsetSlotSize); err != nil {
+		return err
+	}
+	if w.config.Sync == "always" {
+		return w.offsetFile.Sync()
+	}
+	w.dirty = true
+	return nil
+}
+
+// readWALHeader reads the header of the record at an offset of a file.
+func readWALHeader(f *os.File, offset int64) (size int64, checksum uint32, err error) {
+	header := make([]byte, walRecordHeaderSize)
+	if _, err = f.ReadAt(header, offset); err != nil {
+		return
+	}
+	size = int64(binary.BigEndian.Uint32(header))
+	checksum = binary.BigEndian.Uint32(header[4:])
+	return
+}
+
+// scanWALSegment validates each record of a segment from an offset and returns
+// the end of the last valid record and the total size of valid record
+// contents.
+func scanWALSegment(f *os.File, from int64) (validTo int64, contentSize int, err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	fileSize := info.Size()
+
+	validTo = from
+	for validTo+walRecordHeaderSize <= fileSize {
+		size, checksum, err := readWALHeader(f, validTo)
+		if err != nil {
+			return 0, 0, err
+		}
+		end := validTo + walRecordHeaderSize + size
+		if size == 0 || end > fileSize {
+			break
+		}
+		content := make([]byte, size)
+		if _, err = f.ReadAt(content, validTo+walRecordHeaderSize); err != nil {
+			return 0, 0, err
+		}
+		if crc32.Checksum(content, walCastagnoli) != checksum {
+			break
+		}
+		validTo = end
+		contentSize += int(size)
+	}
+	return validTo, contentSize, nil
+}
+
+// recover opens the log, truncating any torn records and calculating the
+// backlog from the committed read offset.
+func (w *WALBuffer) recover() error {
+	var err error
+	if w.offsetFile, err = os.OpenFile(filepath.Join(w.config.Path, walOffsetFile), os.O_RDWR|os.O_CREATE, 0644); err != nil {
+		return err
+	}
+
+	segments, err := w.listSegments()
+	if err != nil {
+		return err
+	}
+
+	seq, index, offset, ok := w.readOffset()
+	if ok {
+		w.offsetSeq, w.readIndex, w.readFrom = seq, index, offset
+	} else if len(segments) > 0 {
+		w.readIndex, w.readFrom = segments[0], 0
+	}
+
+	// Segments before the read index have been fully shifted.
+	live := []int{}
+	for _, s := range segments {
+		if s < w.readIndex {
+			if w.config.CleanUp {
+				os.Remove(w.segmentPath(s))
+			}
+			continue
+		}
+		live = append(live, s)
+	}
+	if len(live) > 0 && live[0] > w.readIndex {
+		w.logger.Warnf("WAL segment %v is missing, continuing from segment %v\n", w.readIndex, live[0])
+		w.readIndex, w.readFrom = live[0], 0
+	}
+	if len(live) == 0 {
+		live = []int{w.readIndex}
+		if w.readFrom > 0 {
+			w.logger.Warnf("WAL segment %v is missing, starting a new log\n", w.readIndex)
+			w.readFrom = 0
+		}
+	}
+
+	for i, s := range live {
+		f, err := os.OpenFile(w.segmentPath(s), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+
+		from := int64(0)
+		if s == w.readIndex {
+			from = w.readFrom
+			if info, err := f.Stat(); err == nil && from > info.Size() {
+				w.logger.Errorf("WAL read offset %v exceeds segment %v size %v, data may have been lost\n", from, s, info.Size())
+				w.stats.Incr("buffer.wal.recover.offset_error", 1)
+				from = info.Size()
+				w.readFrom = from
+			}
+		}
+
+		validTo, contentSize, err := scanWALSegment(f, from)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if info, err := f.Stat(); err == nil && info.Size() > validTo {
+			w.logger.Warnf("Truncating %v bytes of torn records from WAL segment %v\n", info.Size()-validTo, s)
+			w.stats.Incr("buffer.wal.recover.truncated", 1)
+			if err = f.Truncate(validTo); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		w.backlog += contentSize
+
+		if i == len(live)-1 {
+			w.writeIndex, w.writtenTo, w.writeFile = s, validTo, f
+		} else {
+			f.Close()
+		}
+	}
+
+	if w.readFile, err = os.Open(w.segmentPath(w.readIndex)); err != nil {
+		return err
+	}
+	return w.commitOffset()
+}
+
+//------------------------------------------------------------------------------
+
+// syncLoop periodically flushes written data to disk.
+func (w *WALBuffer) syncLoop() {
+	period := time.Duration(w.config.SyncIntervalMS) * time.Millisecond
+	for {
+		select {
+		case <-time.After(period):
+		case <-w.closeChan:
+			return
+		}
+		w.cond.L.Lock()
+		if err := w.sync(); err != nil {
+			w.logger.Errorf("Failed to sync WAL: %v\n", err)
+			w.stats.Incr("buffer.wal.sync.error", 1)
+		}
+		w.cond.L.Unlock()
+	}
+}
+
+// sync flushes the current write segment and offset file to disk if there are
+// unsynced writes.
+func (w *WALBuffer) sync() error {
+	if !w.dirty || w.closed {
+		return nil
+	}
+	if err := w.writeFile.Sync(); err != nil {
+		return err
+	}
+	if err := w.offsetFile.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// closeFiles closes any open file handles.
+func (w *WALBuffer) closeFiles() {
+	for _, f := range []*os.File{w.writeFile, w.readFile, w.offsetFile} {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// rotate closes the current write segment and starts a new one.
+func (w *WALBuffer) rotate() error {
+	if w.config.Sync != "none" {
+		if err := w.writeFile.Sync(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(w.segmentPath(w.writeIndex+1), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w.writeFile.Close()
+	w.writeIndex, w.writtenTo, w.writeFile = w.writeIndex+1, 0, f
+	w.stats.Incr("buffer.wal.segment.rotate", 1)
+	return nil
+}
+
+// nextSegment moves the reader onto the next segment once the current one is
+// fully shifted.
+func (w *WALBuffer) nextSegment() error {
+	f, err := os.Open(w.segmentPath(w.readIndex + 1))
+	if err != nil {
+		return err
+	}
+	prevIndex := w.readIndex
+	w.readFile.Close()
+	w.readIndex, w.readFrom, w.readFile = w.readIndex+1, 0, f
+	if err = w.commitOffset(); err != nil {
+		return err
+	}
+	if w.config.CleanUp {
+		if err = os.Remove(w.segmentPath(prevIndex)); err != nil {
+			w.logger.Errorf("Failed to remove WAL segment %v: %v\n", prevIndex, err)
+		}
+	}
+	return nil
+}
+
+// readEnd returns the end of the readable data of the current read segment.
+func (w *WALBuffer) readEnd() int64 {
+	if w.readIndex == w.writeIndex {
+		return w.writtenTo
+	}
+	if info, err := w.readFile.Stat(); err == nil {
+		return info.Size()
+	}
+	return w.readFrom
+}
+
+// waitForRecord blocks until there is a record to read, moving onto the next
+// segment where needed.
+func (w *WALBuffer) waitForRecord() error {
+	for !w.closed {
+		if w.readFrom < w.readEnd() {
+			return nil
+		}
+		if w.readIndex < w.writeIndex {
+			if err := w.nextSegment(); err != nil {
+				return err
+			}
+			continue
+		}
+		w.cond.Wait()
+	}
+	return types.ErrTypeClosed
+}
+
+//------------------------------------------------------------------------------
+
+// CloseOnceEmpty closes the WAL buffer once the backlog reaches 0.
+func (w *WALBuffer) CloseOnceEmpty() {
+	w.cond.L.Lock()
+	for w.backlog > 0 && !w.closed {
+		w.cond.Wait()
+	}
+	w.cond.L.Unlock()
+	w.Close()
+}
+
+// Close unblocks any blocked calls, flushes pending writes to disk and closes
+// the log. Unlike other buffers the log is preserved.
+func (w *WALBuffer) Close() {
+	w.cond.L.Lock()
+	defer w.cond.L.Unlock()
+
+	if w.closed {
+		return
+	}
+	if w.config.Sync != "none" {
+		w.dirty = true
+		if err := w.sync(); err != nil {
+			w.logger.Errorf("Failed to sync WAL: %v\n", err)
+		}
+	}
+	w.closed = true
+	close(w.closeChan)
+	w.cond.Broadcast()
+	w.closeFiles()
+}
+
+// ShiftMessage removes the oldest message and commits the new read offset.
+// Returns the backlog in bytes.
+func (w *WALBuffer) ShiftMessage() (int, error) {
+	w.cond.L.Lock()
+	defer func() {
+		w.cond.Broadcast()
+		w.cond.L.Unlock()
+	}()
+
+	if w.closed {
+		return w.backlog, types.ErrTypeClosed
+	}
+	end := w.readEnd()
+	for w.readFrom >= end && w.readIndex < w.writeIndex {
+		if err := w.nextSegment(); err != nil {
+			return w.backlog, err
+		}
+		end = w.readEnd()
+	}
+	if w.readFrom >= end {
+		return w.backlog, nil
+	}
+
+	size, _, err := readWALHeader(w.readFile, w.readFrom)
+	if err != nil {
+		return w.backlog, err
+	}
+	if w.readFrom += walRecordHeaderSize + size; w.readFrom > end {
+		// The header is corrupt, skip the remainder of the segment.
+		w.readFrom = end
+	}
+	if w.backlog -= int(size); w.backlog < 0 {
+		w.backlog = 0
+	}
+	if err = w.commitOffset(); err != nil {
+		w.stats.Incr("buffer.wal.commit.error", 1)
+		return w.backlog, err
+	}
+	return w.backlog, nil
+}
+
+// NextMessage reads the oldest message, blocking until there's something to
+// read. The message is preserved until ShiftMessage is called.
+func (w *WALBuffer) NextMessage() (types.Message, error) {
+	w.cond.L.Lock()
+	defer w.cond.L.Unlock()
+
+	if err := w.waitForRecord(); err != nil {
+		return types.Message{}, err
+	}
+
+	size, checksum, err := readWALHeader(w.readFile, w.readFrom)
+	if err != nil {
+		return types.Message{}, err
+	}
+	if w.readFrom+walRecordHeaderSize+size > w.readEnd() {
+		return types.Message{}, types.ErrBlockCorrupted
+	}
+	content := make([]byte, size)
+	if _, err = w.readFile.ReadAt(content, w.readFrom+walRecordHeaderSize); err != nil && err != io.EOF {
+		return types.Message{}, err
+	}
+	if crc32.Checksum(content, walCastagnoli) != checksum {
+		return types.Message{}, types.ErrBlockCorrupted
+	}
+	return types.FromBytes(content)
+}
+
+// PushMessage appends a new message to the log. Returns the backlog in bytes.
+func (w *WALBuffer) PushMessage(msg types.Message) (int, error) {
+	w.cond.L.Lock()
+	defer func() {
+		w.cond.Broadcast()
+		w.cond.L.Unlock()
+	}()
+
+	if w.closed {
+		return 0, types.ErrTypeClosed
+	}
+
+	blob := msg.Bytes()
+	recordSize := int64(walRecordHeaderSize + len(blob))
+	if recordSize > int64(w.config.SegmentSize) {
+		return w.backlog, types.ErrMessageTooLarge
+	}
+	if w.writtenTo > 0 && w.writtenTo+recordSize > int64(w.config.SegmentSize) {
+		if err := w.rotate(); err != nil {
+			return w.backlog, err
+		}
+	}
+
+	record := make([]byte, recordSize)
+	binary.BigEndian.PutUint32(record, uint32(len(blob)))
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(blob, walCastagnoli))
+	copy(record[walRecordHeaderSize:], blob)
+
+	// The record is written in a single call so that an interrupted write can
+	// only leave a torn record at the tail of the log.
+	if _, err := w.writeFile.WriteAt(record, w.writtenTo); err != nil {
+		w.writeFile.Truncate(w.writtenTo)
+		w.stats.Incr("buffer.wal.write.error", 1)
+		return w.backlog, err
+	}
+	if w.config.Sync == "always" {
+		if err := w.writeFile.Sync(); err != nil {
+			w.writeFile.Truncate(w.writtenTo)
+			w.stats.Incr("buffer.wal.sync.error", 1)
+			return w.backlog, err
+		}
+	} else {
+		w.dirty = true
+	}
+
+	w.writtenTo += recordSize
+	w.backlog += len(blob)
+	return w.backlog, nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,473 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package impl
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func newTestWAL(t *testing.T, dir string, segmentSize int) *WALBuffer {
+	conf := NewWALBufferConfig()
+	conf.Path = dir
+	conf.SegmentSize = segmentSize
+	conf.Sync = "none"
+
+	w, err := NewWALBuffer(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func walTestMessage(i int) types.Message {
+	return types.Message{Parts: [][]byte{[]byte(fmt.Sprintf("test%v", i))}}
+}
+
+// expectWALMessages reads and shifts messages from a WAL, checking that they
+// are numbered consecutively from start, until the backlog is empty.
+func expectWALMessages(t *testing.T, w *WALBuffer, start int) int {
+	i := start
+	for {
+		w.cond.L.Lock()
+		backlog := w.backlog
+		w.cond.L.Unlock()
+		if backlog == 0 {
+			return i
+		}
+		msg, err := w.NextMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp, act := fmt.Sprintf("test%v", i), string(msg.Parts[0]); exp != act {
+			t.Fatalf("Wrong message: %v != %v", act, exp)
+		}
+		if _, err = w.ShiftMessage(); err != nil {
+			t.Fatal(err)
+		}
+		i++
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestWALBufferInterface(t *testing.T) {
+	w := &WALBuffer{}
+	if c := Buffer(w); c == nil {
+		t.Error("WALBuffer does not satisfy the Buffer interface")
+	}
+}
+
+func TestWALBufferBadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_wal_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewWALBufferConfig()
+	conf.Path = dir
+	conf.Sync = "sometimes"
+	if _, err = NewWALBuffer(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad sync policy")
+	}
+
+	conf = NewWALBufferConfig()
+	if _, err = NewWALBuffer(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{}); err == nil {
+		t.Error("Expected error from missing directory")
+	}
+}
+
+func TestWALBufferBasic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_wal_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, sync := range []string{"always", "interval", "none"} {
+		conf := NewWALBufferConfig()
+		conf.Path = filepath.Join(dir, sync)
+		conf.SegmentSize = 1000
+		conf.Sync = sync
+		conf.SyncIntervalMS = 1
+
+		w, err := NewWALBuffer(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 100
+		for i := 0; i < n; i++ {
+			msg := walTestMessage(i)
+			msg.SetMetadata(0, "index", strconv.Itoa(i))
+			if _, err = w.PushMessage(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for i := 0; i < n; i++ {
+			msg, err := w.NextMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp, act := fmt.Sprintf("test%v", i), string(msg.Parts[0]); exp != act {
+				t.Errorf("Wrong message: %v != %v", act, exp)
+			}
+			if exp, act := strconv.Itoa(i), msg.GetMetadata(0, "index"); exp != act {
+				t.Errorf("Wrong metadata: %v != %v", act, exp)
+			}
+			backlog, err := w.ShiftMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == n-1 && backlog != 0 {
+				t.Errorf("Wrong final backlog: %v", backlog)
+			}
+		}
+		w.Close()
+
+		// Segments that have been fully read should have been removed.
+		segments, err := filepath.Glob(filepath.Join(conf.Path, "wal_*.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(segments) != 1 {
+			t.Errorf("Wrong count of remaining segments: %v", segments)
+		}
+	}
+}
+
+func TestWALBufferRejectLargeMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_wal_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := newTestWAL(t, dir, 20)
+	defer w.Close()
+
+	if _, err = w.PushMessage(types.Message{
+		Parts: [][]byte{[]byte("hello world this message is too large")},
+	}); err != types.ErrMessageTooLarge {
+		t.Errorf("Unexpected error: %v != %v", err, types.ErrMessageTooLarge)
+	}
+}
+
+func TestWALBufferBlockingRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_wal_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := newTestWAL(t, dir, 1000)
+
+	go func() {
+		<-time.After(10 * time.Millisecond)
+		w.PushMessage(walTestMessage(0))
+	}()
+
+	msg, err := w.NextMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "test0", string(msg.Parts[0]); exp != act {
+		t.Errorf("Wrong message: %v != %v", act, exp)
+	}
+
+	go func() {
+		<-time.After(10 * time.Millisecond)
+		w.Close()
+	}()
+	w.ShiftMessage()
+	if _, err = w.NextMessage(); err != types.ErrTypeClosed {
+		t.Errorf("Wrong error returned: %v != %v", err, types.ErrTypeClosed)
+	}
+}
+
+func TestWALBufferRecoverOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_wal_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := newTestWAL(t, dir, 200)
+	n := 100
+	for i := 0; i < n; i++ {
+		if _, err = w.PushMessage(walTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Read but do not shift a message, it must be read again after restart.
+	shifted := 37
+	for i := 0; i < shifted; i++ {
+		if _, err = w.NextMessage(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.ShiftMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = w.NextMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Abandon the buffer without closing it in order to simulate a crash.
+	w.closeFiles()
+
+	w = newTestWAL(t, dir, 200)
+	defer w.Close()
+
+	if exp, act := n, expectWALMessages(t, w, shifted); exp != act {
+		t.Errorf("Wrong count of messages recovered: %v != %v", act, exp)
+	}
+}
+
+func TestWALBufferTornRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_wal_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := 10
+	tails := map[string]func(content []byte) []byte{
+		"partial header": func(content []byte) []byte {
+			return append(content, 0, 0)
+		},
+		"partial record": func(content []byte) []byte {
+			return append(content, 0, 0, 0, 100, 1, 2, 3, 4, 5, 6, 7)
+		},
+		"bad checksum": func(content []byte) []byte {
+			content[len(content)-1]++
+			return content
+		},
+	}
+
+	for name, tail := range tails {
+		path := filepath.Join(dir, name)
+
+		w := newTestWAL(t, path, 100000)
+		for i := 0; i < n; i++ {
+			if _, err = w.PushMessage(walTestMessage(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.closeFiles()
+
+		segment := w.segmentPath(w.writeIndex)
+		content, err := ioutil.ReadFile(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(segment, tail(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		exp := n
+		if name == "bad checksum" {
+			exp = n - 1
+		}
+
+		w = newTestWAL(t, path, 100000)
+
+		// New writes must follow on from the last valid record.
+		if _, err = w.PushMessage(walTestMessage(exp)); err != nil {
+			t.Fatal(err)
+		}
+		if act := expectWALMessages(t, w, 0); act != exp+1 {
+			t.Errorf("%v: Wrong count of messages recovered: %v != %v", name, act, exp+1)
+		}
+		w.Close()
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// The crash tests run a child process of the test binary which uses a WAL and
+// kills itself at a random point with SIGKILL, the parent then recovers the WAL
+// and checks that messages reported by the child as pushed or shifted have
+// neither been lost nor replayed.
+
+const (
+	walCrashDirEnv  = "BENTHOS_WAL_CRASH_DIR"
+	walCrashModeEnv = "BENTHOS_WAL_CRASH_MODE"
+)
+
+func TestWALBufferCrashHelper(t *testing.T) {
+	dir := os.Getenv(walCrashDirEnv)
+	if len(dir) == 0 {
+		t.Skip("Only runs as a child of the WAL crash tests")
+	}
+
+	conf := NewWALBufferConfig()
+	conf.Path = dir
+	conf.SegmentSize = 2000
+	conf.Sync = "none"
+
+	w, err := NewWALBuffer(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	go func() {
+		<-time.After(time.Duration(rand.Intn(50)+10) * time.Millisecond)
+		p, _ := os.FindProcess(os.Getpid())
+		p.Kill()
+	}()
+
+	for i := 0; ; i++ {
+		switch os.Getenv(walCrashModeEnv) {
+		case "push":
+			if _, err = w.PushMessage(walTestMessage(i)); err != nil {
+				os.Exit(1)
+			}
+		case "shift":
+			if _, err = w.NextMessage(); err != nil {
+				os.Exit(1)
+			}
+			if _, err = w.ShiftMessage(); err != nil {
+				os.Exit(1)
+			}
+		}
+		fmt.Fprintf(os.Stdout, "%v\n", i)
+	}
+}
+
+// runWALCrashChild runs a crash helper and returns the last index it reported,
+// or -1 if it reported nothing.
+func runWALCrashChild(t *testing.T, dir, mode string) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWALBufferCrashHelper$")
+	cmd.Env = append(os.Environ(), walCrashDirEnv+"="+dir, walCrashModeEnv+"="+mode)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	last := -1
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if i, err := strconv.Atoi(scanner.Text()); err == nil {
+			last = i
+		}
+	}
+	cmd.Wait()
+	return last
+}
+
+func TestWALBufferCrashWriter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping crash test in short mode")
+	}
+
+	dir, err := ioutil.TempDir("", "benthos_wal_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for run := 0; run < 5; run++ {
+		runDir := filepath.Join(dir, strconv.Itoa(run))
+		lastPushed := runWALCrashChild(t, runDir, "push")
+		if lastPushed < 0 {
+			t.Fatal("Crash helper pushed no messages")
+		}
+
+		w := newTestWAL(t, runDir, 2000)
+
+		// Every reported push must be recovered in order, a push that was
+		// interrupted before being reported may or may not be present.
+		recovered := expectWALMessages(t, w, 0)
+		if recovered != lastPushed+1 && recovered != lastPushed+2 {
+			t.Errorf("Wrong count of messages recovered: %v, last pushed: %v", recovered, lastPushed)
+		}
+		w.Close()
+	}
+}
+
+func TestWALBufferCrashReader(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping crash test in short mode")
+	}
+
+	dir, err := ioutil.TempDir("", "benthos_wal_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := 20000
+	for run := 0; run < 5; run++ {
+		runDir := filepath.Join(dir, strconv.Itoa(run))
+
+		w := newTestWAL(t, runDir, 2000)
+		for i := 0; i < n; i++ {
+			if _, err = w.PushMessage(walTestMessage(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Close()
+
+		lastShifted := runWALCrashChild(t, runDir, "shift")
+
+		// The next message must follow the last reported shift, unless a shift
+		// was interrupted after being committed but before being reported.
+		w = newTestWAL(t, runDir, 2000)
+		next := n
+		if w.backlog > 0 {
+			msg, err := w.NextMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next, err = strconv.Atoi(string(msg.Parts[0])[4:]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if next != lastShifted+1 && next != lastShifted+2 {
+			t.Errorf("Wrong next message after crash: %v, last shifted: %v", next, lastShifted)
+		}
+		if act := expectWALMessages(t, w, next); act != n {
+			t.Errorf("Wrong count of messages recovered: %v != %v", act, n)
+		}
+		w.Close()
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package buffer
+
+import (
+	"github.com/Jeffail/benthos/lib/buffer/impl"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["wal"] = typeSpec{
+		constructor: NewWAL,
+		description: `
+The wal buffer type writes messages to a write ahead log on disk, made up of
+segment files within a designated writeable directory. Each message is stored
+as a checksummed record, and the position of the reader is committed to disk
+each time a message is successfully sent to the output. When Benthos restarts,
+even after a crash, no messages are lost and messages already acknowledged by
+the output are not sent again.
+
+When Benthos starts it scans the log from the committed position, removing any
+incomplete records left by an interrupted write.
+
+The 'sync' field determines when data is flushed to disk, and can be one of
+'always' (after every write and commit), 'interval' (every 'sync_interval_ms')
+or 'none' (left to the operating system). Only 'always' protects against the
+loss of messages during a power failure.
+
+Segments are deleted once they have been fully read, you can disable this
+feature if you wish to preserve the data indefinitely.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// NewWAL creates a buffer persisted to disk as a write ahead log.
+func NewWAL(config Config, log log.Modular, stats metrics.Type) (Type, error) {
+	b, err := impl.NewWALBuffer(config.WAL, log.NewModule(".buffer.wal"), stats)
+	if err != nil {
+		return nil, err
+	}
+	return NewOutputWrapper(b, stats), nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,143 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package condition
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// typeSpec Constructor and a usage description for each condition type.
+type typeSpec struct {
+	constructor func(conf Config, log log.Modular, stats metrics.Type) (Type, error)
+	description string
+}
+
+var constructors = map[string]typeSpec{}
+
+//------------------------------------------------------------------------------
+
+// Config is the all encompassing configuration struct for all condition types.
+type Config struct {
+	Type      string          `json:"type" yaml:"type"`
+	JSONField JSONFieldConfig `json:"json_field" yaml:"json_field"`
+	Regex     RegexConfig     `json:"regex" yaml:"regex"`
+	PartCount PartCountConfig `json:"part_count" yaml:"part_count"`
+}
+
+// NewConfig returns a configuration struct fully populated with default values.
+func NewConfig() Config {
+	return Config{
+		Type:      "json_field",
+		JSONField: NewJSONFieldConfig(),
+		Regex:     NewRegexConfig(),
+		PartCount: NewPartCountConfig(),
+	}
+}
+
+// UnmarshalJSON ensures that when parsing configs that are in a slice the
+// default values are still applied.
+func (m *Config) UnmarshalJSON(bytes []byte) error {
+	type confAlias Config
+	aliased := confAlias(NewConfig())
+
+	if err := json.Unmarshal(bytes, &aliased); err != nil {
+		return err
+	}
+
+	*m = Config(aliased)
+	return nil
+}
+
+// UnmarshalYAML ensures that when parsing configs that are in a slice the
+// default values are still applied.
+func (m *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type confAlias Config
+	aliased := confAlias(NewConfig())
+
+	if err := unmarshal(&aliased); err != nil {
+		return err
+	}
+
+	*m = Config(aliased)
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// TypeNames returns an alphabetically sorted list of the names of each
+// registered condition type.
+func TypeNames() []string {
+	names := []string{}
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Descriptions returns a formatted string of collated descriptions of each
+// type.
+func Descriptions() string {
+	// Order our condition types alphabetically
+	names := []string{}
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bytes.Buffer{}
+	buf.WriteString("CONDITIONS\n")
+	buf.WriteString(strings.Repeat("=", 10))
+	buf.WriteString("\n\n")
+	buf.WriteString("This document has been generated with `benthos --list-conditions`.")
+	buf.WriteString("\n\n")
+
+	// Append each description
+	for i, name := range names {
+		buf.WriteString("## ")
+		buf.WriteString("`" + name + "`")
+		buf.WriteString("\n")
+		buf.WriteString(constructors[name].description)
+		if i != (len(names) - 1) {
+			buf.WriteString("\n\n")
+		}
+	}
+	return buf.String()
+}
+
+// New creates a condition type based on a condition configuration.
+func New(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	if c, ok := constructors[conf.Type]; ok {
+		return c.constructor(conf, log, stats)
+	}
+	return nil, types.ErrInvalidConditionType
+}
+
+//------------------------------------------------------------------------------
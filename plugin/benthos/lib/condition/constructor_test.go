@@ -0,0 +1,101 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package condition
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestConstructorDescription(t *testing.T) {
+	if len(Descriptions()) == 0 {
+		t.Error("package descriptions were empty")
+	}
+}
+
+func TestConstructorBadType(t *testing.T) {
+	conf := NewConfig()
+	conf.Type = "not_exist"
+
+	logConfig := log.NewLoggerConfig()
+	logConfig.LogLevel = "NONE"
+
+	if _, err := New(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{}); err == nil {
+		t.Error("Expected error, received nil for invalid type")
+	}
+}
+
+func TestConstructorConfigDefaults(t *testing.T) {
+	conf := []Config{}
+
+	if err := json.Unmarshal([]byte(`[
+		{
+			"type": "json_field",
+			"json_field": {
+				"path": "foo.bar"
+			}
+		}
+	]`), &conf); err != nil {
+		t.Error(err)
+	}
+
+	if exp, act := 1, len(conf); exp != act {
+		t.Errorf("Wrong number of config parts: %v != %v", act, exp)
+		return
+	}
+	if exp, act := "equals", conf[0].JSONField.Operator; exp != act {
+		t.Errorf("Wrong default operator: %v != %v", act, exp)
+	}
+	if exp, act := "foo.bar", conf[0].JSONField.Path; exp != act {
+		t.Errorf("Wrong overridden path: %v != %v", act, exp)
+	}
+}
+
+func TestConstructorConfigDefaultsYAML(t *testing.T) {
+	conf := []Config{}
+
+	if err := yaml.Unmarshal([]byte(`[
+		{
+			"type": "part_count",
+			"part_count": {
+				"max_parts": 5
+			}
+		}
+	]`), &conf); err != nil {
+		t.Error(err)
+	}
+
+	if exp, act := 1, len(conf); exp != act {
+		t.Errorf("Wrong number of config parts: %v != %v", act, exp)
+		return
+	}
+	if exp, act := 1, conf[0].PartCount.MinParts; exp != act {
+		t.Errorf("Wrong default min parts: %v != %v", act, exp)
+	}
+	if exp, act := 5, conf[0].PartCount.MaxParts; exp != act {
+		t.Errorf("Wrong overridden max parts: %v != %v", act, exp)
+	}
+}
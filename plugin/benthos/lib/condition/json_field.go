@@ -0,0 +1,157 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package condition
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/gabs"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["json_field"] = typeSpec{
+		constructor: NewJSONField,
+		description: `
+Parses a message part as a JSON document and checks a field, identified by a
+dot separated path, against an operator. If the part cannot be parsed as JSON
+then the condition fails.
+
+The available operators are: equals|not_equals|exists|not_exists
+
+String fields are compared against 'value' directly, all other field types are
+compared using their JSON representation, e.g. a 'value' of "5" matches both the
+number 5 and the string "5". An empty path targets the root of the document.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// JSONFieldConfig contains configuration for the JSONField condition.
+type JSONFieldConfig struct {
+	Part     int    `json:"part" yaml:"part"`
+	Path     string `json:"path" yaml:"path"`
+	Operator string `json:"operator" yaml:"operator"`
+	Value    string `json:"value" yaml:"value"`
+}
+
+// NewJSONFieldConfig returns a JSONFieldConfig with default values.
+func NewJSONFieldConfig() JSONFieldConfig {
+	return JSONFieldConfig{
+		Part:     0,
+		Path:     "",
+		Operator: "equals",
+		Value:    "",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// JSONField is a condition that checks a field of a JSON message part.
+type JSONField struct {
+	conf  JSONFieldConfig
+	log   log.Modular
+	stats metrics.Type
+
+	check func(data interface{}) bool
+}
+
+// NewJSONField returns a JSONField condition.
+func NewJSONField(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	c := &JSONField{
+		conf:  conf.JSONField,
+		log:   log.NewModule(".condition.json_field"),
+		stats: stats,
+	}
+
+	switch conf.JSONField.Operator {
+	case "equals":
+		c.check = func(data interface{}) bool {
+			return data != nil && jsonFieldString(data) == conf.JSONField.Value
+		}
+	case "not_equals":
+		c.check = func(data interface{}) bool {
+			return data == nil || jsonFieldString(data) != conf.JSONField.Value
+		}
+	case "exists":
+		c.check = func(data interface{}) bool {
+			return data != nil
+		}
+	case "not_exists":
+		c.check = func(data interface{}) bool {
+			return data == nil
+		}
+	default:
+		return nil, fmt.Errorf("json_field operator not recognised: %v", conf.JSONField.Operator)
+	}
+
+	return c, nil
+}
+
+//------------------------------------------------------------------------------
+
+// jsonFieldString returns the string form of a parsed JSON value, where
+// strings are returned as is and all other types as their JSON representation.
+func jsonFieldString(data interface{}) string {
+	if str, ok := data.(string); ok {
+		return str
+	}
+	jBytes, _ := json.Marshal(data)
+	return string(jBytes)
+}
+
+// Check attempts to parse a message part as JSON and checks a field against
+// the configured operator.
+func (c *JSONField) Check(msg *types.Message) bool {
+	c.stats.Incr("condition.json_field.count", 1)
+
+	index := c.conf.Part
+	if index < 0 || index >= len(msg.Parts) {
+		c.stats.Incr("condition.json_field.skipped.part_out_of_bounds", 1)
+		return false
+	}
+
+	jObj, err := gabs.ParseJSON(msg.Parts[index])
+	if err != nil {
+		c.stats.Incr("condition.json_field.skipped.json_parse", 1)
+		c.log.Debugf("Failed to parse message part as JSON: %v\n", err)
+		return false
+	}
+
+	field := jObj
+	if len(c.conf.Path) > 0 {
+		field = jObj.Path(c.conf.Path)
+	}
+
+	if c.check(field.Data()) {
+		c.stats.Incr("condition.json_field.true", 1)
+		return true
+	}
+	c.stats.Incr("condition.json_field.false", 1)
+	return false
+}
+
+//------------------------------------------------------------------------------
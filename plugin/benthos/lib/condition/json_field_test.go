@@ -0,0 +1,91 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package condition
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func TestJSONFieldCheck(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	type test struct {
+		name     string
+		operator string
+		path     string
+		value    string
+		part     int
+		input    [][]byte
+		expected bool
+	}
+
+	tests := []test{
+		{"string equals", "equals", "foo.bar", "baz", 0, [][]byte{[]byte(`{"foo":{"bar":"baz"}}`)}, true},
+		{"string not equals", "equals", "foo.bar", "qux", 0, [][]byte{[]byte(`{"foo":{"bar":"baz"}}`)}, false},
+		{"number equals", "equals", "foo", "5", 0, [][]byte{[]byte(`{"foo":5}`)}, true},
+		{"bool equals", "equals", "foo", "true", 0, [][]byte{[]byte(`{"foo":true}`)}, true},
+		{"missing equals", "equals", "foo.nope", "", 0, [][]byte{[]byte(`{"foo":{}}`)}, false},
+		{"second part", "equals", "foo", "bar", 1, [][]byte{[]byte(`{}`), []byte(`{"foo":"bar"}`)}, true},
+		{"part out of bounds", "equals", "foo", "bar", 2, [][]byte{[]byte(`{"foo":"bar"}`)}, false},
+		{"invalid json", "equals", "foo", "bar", 0, [][]byte{[]byte(`not json`)}, false},
+		{"not equals", "not_equals", "foo", "bar", 0, [][]byte{[]byte(`{"foo":"baz"}`)}, true},
+		{"not equals missing", "not_equals", "foo", "bar", 0, [][]byte{[]byte(`{}`)}, true},
+		{"not equals same", "not_equals", "foo", "bar", 0, [][]byte{[]byte(`{"foo":"bar"}`)}, false},
+		{"exists", "exists", "foo.bar", "", 0, [][]byte{[]byte(`{"foo":{"bar":[]}}`)}, true},
+		{"not exists", "not_exists", "foo.bar", "", 0, [][]byte{[]byte(`{"foo":{"bar":[]}}`)}, false},
+		{"root equals", "equals", "", "[1,2]", 0, [][]byte{[]byte(`[1,2]`)}, true},
+	}
+
+	for _, test := range tests {
+		conf := NewConfig()
+		conf.Type = "json_field"
+		conf.JSONField.Operator = test.operator
+		conf.JSONField.Path = test.path
+		conf.JSONField.Value = test.value
+		conf.JSONField.Part = test.part
+
+		c, err := New(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if exp, act := test.expected, c.Check(&types.Message{Parts: test.input}); exp != act {
+			t.Errorf("%v: Wrong result: %v != %v", test.name, act, exp)
+		}
+	}
+}
+
+func TestJSONFieldBadOperator(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Type = "json_field"
+	conf.JSONField.Operator = "not_exist"
+
+	if _, err := New(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad operator")
+	}
+}
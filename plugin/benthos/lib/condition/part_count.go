@@ -0,0 +1,89 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package condition
+
+import (
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["part_count"] = typeSpec{
+		constructor: NewPartCount,
+		description: `
+Checks whether the number of parts of a message is within an inclusive range. A
+'max_parts' of zero or less means there is no upper limit.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// PartCountConfig contains configuration for the PartCount condition.
+type PartCountConfig struct {
+	MinParts int `json:"min_parts" yaml:"min_parts"`
+	MaxParts int `json:"max_parts" yaml:"max_parts"`
+}
+
+// NewPartCountConfig returns a PartCountConfig with default values.
+func NewPartCountConfig() PartCountConfig {
+	return PartCountConfig{
+		MinParts: 1,
+		MaxParts: 0,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// PartCount is a condition that checks the number of parts of a message.
+type PartCount struct {
+	conf  PartCountConfig
+	log   log.Modular
+	stats metrics.Type
+}
+
+// NewPartCount returns a PartCount condition.
+func NewPartCount(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	return &PartCount{
+		conf:  conf.PartCount,
+		log:   log.NewModule(".condition.part_count"),
+		stats: stats,
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Check checks whether the number of parts of a message is within range.
+func (c *PartCount) Check(msg *types.Message) bool {
+	c.stats.Incr("condition.part_count.count", 1)
+
+	lParts := len(msg.Parts)
+	if lParts < c.conf.MinParts || (c.conf.MaxParts > 0 && lParts > c.conf.MaxParts) {
+		c.stats.Incr("condition.part_count.false", 1)
+		return false
+	}
+	c.stats.Incr("condition.part_count.true", 1)
+	return true
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,71 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package condition
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func TestPartCountCheck(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	type test struct {
+		min      int
+		max      int
+		parts    int
+		expected bool
+	}
+
+	tests := []test{
+		{1, 0, 1, true},
+		{1, 0, 100, true},
+		{1, 0, 0, false},
+		{2, 3, 1, false},
+		{2, 3, 2, true},
+		{2, 3, 3, true},
+		{2, 3, 4, false},
+	}
+
+	for _, test := range tests {
+		conf := NewConfig()
+		conf.Type = "part_count"
+		conf.PartCount.MinParts = test.min
+		conf.PartCount.MaxParts = test.max
+
+		c, err := New(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := types.NewMessage()
+		for i := 0; i < test.parts; i++ {
+			msg.Parts = append(msg.Parts, []byte("foo"))
+		}
+		if exp, act := test.expected, c.Check(&msg); exp != act {
+			t.Errorf("Wrong result for %v parts in [%v, %v]: %v != %v", test.parts, test.min, test.max, act, exp)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package condition
+
+import (
+	"regexp"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["regex"] = typeSpec{
+		constructor: NewRegex,
+		description: `
+Checks whether a message part matches a regular expression. The syntax of the
+expression is the same as that of the Go regexp package
+(https://golang.org/s/re2syntax).`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// RegexConfig contains configuration for the Regex condition.
+type RegexConfig struct {
+	Part    int    `json:"part" yaml:"part"`
+	Pattern string `json:"pattern" yaml:"pattern"`
+}
+
+// NewRegexConfig returns a RegexConfig with default values.
+func NewRegexConfig() RegexConfig {
+	return RegexConfig{
+		Part:    0,
+		Pattern: "",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Regex is a condition that checks a message part against a regular
+// expression.
+type Regex struct {
+	part  int
+	re    *regexp.Regexp
+	log   log.Modular
+	stats metrics.Type
+}
+
+// NewRegex returns a Regex condition.
+func NewRegex(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	re, err := regexp.Compile(conf.Regex.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Regex{
+		part:  conf.Regex.Part,
+		re:    re,
+		log:   log.NewModule(".condition.regex"),
+		stats: stats,
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Check checks whether a message part matches the regular expression.
+func (c *Regex) Check(msg *types.Message) bool {
+	c.stats.Incr("condition.regex.count", 1)
+
+	if c.part < 0 || c.part >= len(msg.Parts) {
+		c.stats.Incr("condition.regex.skipped.part_out_of_bounds", 1)
+		return false
+	}
+
+	if c.re.Match(msg.Parts[c.part]) {
+		c.stats.Incr("condition.regex.true", 1)
+		return true
+	}
+	c.stats.Incr("condition.regex.false", 1)
+	return false
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,74 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package condition
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func TestRegexCheck(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Type = "regex"
+	conf.Regex.Part = 1
+	conf.Regex.Pattern = "^foo[0-9]+$"
+
+	c, err := New(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		input    [][]byte
+		expected bool
+	}
+
+	tests := []test{
+		{[][]byte{[]byte("foo1"), []byte("foo123")}, true},
+		{[][]byte{[]byte("foo1"), []byte("foo")}, false},
+		{[][]byte{[]byte("foo1"), []byte("bar123")}, false},
+		{[][]byte{[]byte("foo1")}, false},
+	}
+
+	for _, test := range tests {
+		if exp, act := test.expected, c.Check(&types.Message{Parts: test.input}); exp != act {
+			t.Errorf("Wrong result for %s: %v != %v", test.input, act, exp)
+		}
+	}
+}
+
+func TestRegexBadPattern(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Type = "regex"
+	conf.Regex.Pattern = "foo("
+
+	if _, err := New(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad pattern")
+	}
+}
@@ -40,7 +40,11 @@ func init() {
 AMQP (0.91) is the underlying messaging protocol that is used by various message
 brokers, including RabbitMQ.
 
-Exchange type options are: direct|fanout|topic|x-custom`,
+Exchange type options are: direct|fanout|topic|x-custom
+
+Any headers of a delivery are added to the message as metadata, along with the
+fields 'amqp_routing_key', 'amqp_exchange', 'amqp_content_type',
+'amqp_content_encoding', 'amqp_correlation_id' and 'amqp_message_id'.`,
 	}
 }
 
@@ -252,7 +256,7 @@ func (a *AMQP) loop() {
 		// If bytes are read then try and propagate.
 		if data != nil {
 			select {
-			case a.messages <- types.Message{Parts: [][]byte{data.Body}}:
+			case a.messages <- amqpMessage(data):
 			case <-a.closeChan:
 				return
 			}
@@ -274,6 +278,28 @@ func (a *AMQP) loop() {
 
 }
 
+// amqpMessage creates a message from an AMQP delivery, where the headers and
+// delivery properties are added as metadata.
+func amqpMessage(data *amqp.Delivery) types.Message {
+	msg := types.Message{Parts: [][]byte{data.Body}}
+	for k, v := range data.Headers {
+		msg.SetMetadata(0, k, fmt.Sprintf("%v", v))
+	}
+	for k, v := range map[string]string{
+		"amqp_routing_key":      data.RoutingKey,
+		"amqp_exchange":         data.Exchange,
+		"amqp_content_type":     data.ContentType,
+		"amqp_content_encoding": data.ContentEncoding,
+		"amqp_correlation_id":   data.CorrelationId,
+		"amqp_message_id":       data.MessageId,
+	} {
+		if len(v) > 0 {
+			msg.SetMetadata(0, k, v)
+		}
+	}
+	return msg
+}
+
 // StartListening sets the channel used by the input to validate message
 // receipt.
 func (a *AMQP) StartListening(responses <-chan types.Response) error {
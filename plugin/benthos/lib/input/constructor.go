@@ -60,12 +60,17 @@ type Config struct {
 	ZMQ4          *ZMQ4Config         `json:"zmq4,omitempty" yaml:"zmq4,omitempty"`
 	Kafka         KafkaConfig         `json:"kafka" yaml:"kafka"`
 	KafkaBalanced KafkaBalancedConfig `json:"kafka_balanced" yaml:"kafka_balanced"`
+	KafkaMulti    KafkaMultiConfig    `json:"kafka_multi" yaml:"kafka_multi"`
 	AMQP          AMQPConfig          `json:"amqp" yaml:"amqp"`
 	NSQ           NSQConfig           `json:"nsq" yaml:"nsq"`
 	NATS          NATSConfig          `json:"nats" yaml:"nats"`
 	NATSStream    NATSStreamConfig    `json:"nats_stream" yaml:"nats_stream"`
 	RedisPubSub   RedisPubSubConfig   `json:"redis_pubsub" yaml:"redis_pubsub"`
+	RedisList     RedisListConfig     `json:"redis_list" yaml:"redis_list"`
+	RedisStreams  RedisStreamsConfig  `json:"redis_streams" yaml:"redis_streams"`
 	File          FileConfig          `json:"file" yaml:"file"`
+	FileTail      FileTailConfig      `json:"file_tail" yaml:"file_tail"`
+	Websocket     WebsocketConfig     `json:"websocket" yaml:"websocket"`
 	STDIN         STDINConfig         `json:"stdin" yaml:"stdin"`
 	FanIn         FanInConfig         `json:"fan_in" yaml:"fan_in"`
 	Processors    []processor.Config  `json:"processors" yaml:"processors"`
@@ -80,12 +85,17 @@ func NewConfig() Config {
 		ZMQ4:          NewZMQ4Config(),
 		Kafka:         NewKafkaConfig(),
 		KafkaBalanced: NewKafkaBalancedConfig(),
+		KafkaMulti:    NewKafkaMultiConfig(),
 		AMQP:          NewAMQPConfig(),
 		NSQ:           NewNSQConfig(),
 		NATS:          NewNATSConfig(),
 		NATSStream:    NewNATSStreamConfig(),
 		RedisPubSub:   NewRedisPubSubConfig(),
+		RedisList:     NewRedisListConfig(),
+		RedisStreams:  NewRedisStreamsConfig(),
 		File:          NewFileConfig(),
+		FileTail:      NewFileTailConfig(),
+		Websocket:     NewWebsocketConfig(),
 		STDIN:         NewSTDINConfig(),
 		FanIn:         NewFanInConfig(),
 		Processors:    []processor.Config{processor.NewConfig()},
@@ -94,6 +104,17 @@ func NewConfig() Config {
 
 //------------------------------------------------------------------------------
 
+// TypeNames returns an alphabetically sorted list of the names of each
+// registered input type.
+func TypeNames() []string {
+	names := []string{}
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Descriptions returns a formatted string of descriptions for each type.
 func Descriptions() string {
 	// Order our input types alphabetically
@@ -151,6 +172,9 @@ func New(
 		for err != nil {
 			return nil, err
 		}
+		if len(pipelines) == 0 {
+			return input, nil
+		}
 		return WrapWithPipelines(input, pipelines...)
 	}
 	return nil, types.ErrInvalidInputType
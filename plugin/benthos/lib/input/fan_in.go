@@ -220,6 +220,12 @@ func parseInputConfsWithDefaults(conf FanInConfig) ([]Config, error) {
 	return inputConfs, nil
 }
 
+// ParseConfigs parses a list of generic input configs, such as the children of
+// a fan_in input, into input configs with default values applied.
+func ParseConfigs(inConfs []interface{}) ([]Config, error) {
+	return parseInputConfsWithDefaults(FanInConfig{Inputs: inConfs})
+}
+
 //------------------------------------------------------------------------------
 
 // NewFanIn creates a new FanIn input type.
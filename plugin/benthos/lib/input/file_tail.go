@@ -0,0 +1,516 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/fsnotify/fsnotify"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["file_tail"] = typeSpec{
+		constructor: NewFileTail,
+		description: `
+The file_tail type watches all files matching a list of glob patterns and reads
+data as it is appended to them, following files that are rotated or truncated.
+Files are split into messages in the same way as the file input, where if
+multipart is set to false each line is read as a separate message. If multipart
+is set to true each line is read as a message part, and an empty line indicates
+the end of a message. A custom delimiter can be set that is used instead of line
+breaks.
+
+Changes are detected with inotify where available, and the files are also
+polled every 'poll_interval_ms' milliseconds as a fallback.
+
+If 'checkpoint_path' is set then the offset of each file is written to that
+path as messages are acknowledged, and when restarted the input resumes each
+file from its last acknowledged offset. Files without a checkpoint that exist
+when the input starts are read from the beginning if 'start_from_beginning' is
+true, otherwise from their current end. Files created afterwards are always
+read from the beginning.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// FileTailConfig is configuration values for the FileTail input type.
+type FileTailConfig struct {
+	Paths              []string `json:"paths" yaml:"paths"`
+	Multipart          bool     `json:"multipart" yaml:"multipart"`
+	MaxBuffer          int      `json:"max_buffer" yaml:"max_buffer"`
+	CustomDelim        string   `json:"custom_delimiter" yaml:"custom_delimiter"`
+	CheckpointPath     string   `json:"checkpoint_path" yaml:"checkpoint_path"`
+	StartFromBeginning bool     `json:"start_from_beginning" yaml:"start_from_beginning"`
+	PollIntervalMS     int      `json:"poll_interval_ms" yaml:"poll_interval_ms"`
+	Inotify            bool     `json:"inotify" yaml:"inotify"`
+}
+
+// NewFileTailConfig creates a new FileTailConfig with default values.
+func NewFileTailConfig() FileTailConfig {
+	return FileTailConfig{
+		Paths:              []string{},
+		Multipart:          false,
+		MaxBuffer:          bufio.MaxScanTokenSize,
+		CustomDelim:        "",
+		CheckpointPath:     "",
+		StartFromBeginning: true,
+		PollIntervalMS:     1000,
+		Inotify:            true,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// fileCheckpoint is the last acknowledged position of a file.
+type fileCheckpoint struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+}
+
+// tailedFile is the state of a file being followed.
+type tailedFile struct {
+	handle  *os.File
+	info    os.FileInfo
+	id      string
+	offset  int64
+	buf     []byte
+	builder partsBuilder
+}
+
+// readPos returns the position of the file that has been read up to.
+func (t *tailedFile) readPos() int64 {
+	return t.offset + int64(len(t.buf))
+}
+
+//------------------------------------------------------------------------------
+
+// FileTail is an input type that follows files matching glob patterns.
+type FileTail struct {
+	running int32
+
+	conf  FileTailConfig
+	stats metrics.Type
+	log   log.Modular
+
+	split bufio.SplitFunc
+
+	files       map[string]*tailedFile
+	checkpoints map[string]fileCheckpoint
+	pending     map[string]fileCheckpoint
+
+	messages  chan types.Message
+	responses <-chan types.Response
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewFileTail creates a new FileTail input type.
+func NewFileTail(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	if len(conf.FileTail.Paths) == 0 {
+		return nil, errors.New("at least one path must be specified")
+	}
+	if conf.FileTail.PollIntervalMS <= 0 {
+		return nil, errors.New("poll_interval_ms must be greater than zero")
+	}
+	for _, pattern := range conf.FileTail.Paths {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	delim := []byte("\n")
+	if len(conf.FileTail.CustomDelim) > 0 {
+		delim = []byte(conf.FileTail.CustomDelim)
+	}
+
+	f := &FileTail{
+		running:     1,
+		conf:        conf.FileTail,
+		stats:       stats,
+		log:         log.NewModule(".input.file_tail"),
+		split:       delimSplitFunc(delim),
+		files:       map[string]*tailedFile{},
+		checkpoints: map[string]fileCheckpoint{},
+		pending:     map[string]fileCheckpoint{},
+		messages:    make(chan types.Message),
+		responses:   nil,
+		closeChan:   make(chan struct{}),
+		closedChan:  make(chan struct{}),
+	}
+
+	if len(f.conf.CheckpointPath) > 0 {
+		if err := f.readCheckpoints(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+//------------------------------------------------------------------------------
+
+// readCheckpoints reads the checkpoint file if it exists.
+func (f *FileTail) readCheckpoints() error {
+	data, err := ioutil.ReadFile(f.conf.CheckpointPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &f.checkpoints)
+}
+
+// writeCheckpoints writes all checkpoints to the checkpoint file.
+func (f *FileTail) writeCheckpoints() error {
+	data, err := json.Marshal(f.checkpoints)
+	if err != nil {
+		return err
+	}
+	tmpPath := f.conf.CheckpointPath + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, f.conf.CheckpointPath)
+}
+
+// commit moves all pending offsets into the checkpoints and writes them.
+func (f *FileTail) commit() {
+	if len(f.pending) == 0 {
+		return
+	}
+	for path, c := range f.pending {
+		f.checkpoints[path] = c
+	}
+	f.pending = map[string]fileCheckpoint{}
+
+	if len(f.conf.CheckpointPath) == 0 {
+		return
+	}
+	if err := f.writeCheckpoints(); err != nil {
+		f.stats.Incr("input.file_tail.checkpoint.error", 1)
+		f.log.Errorf("Failed to write checkpoint: %v\n", err)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// open begins following a file, resuming from its checkpoint if one exists.
+func (f *FileTail) open(path string, existing bool) {
+	handle, err := os.Open(path)
+	if err != nil {
+		f.log.Errorf("Failed to open file '%v': %v\n", path, err)
+		return
+	}
+	info, err := handle.Stat()
+	if err != nil {
+		handle.Close()
+		f.log.Errorf("Failed to stat file '%v': %v\n", path, err)
+		return
+	}
+
+	t := &tailedFile{
+		handle:  handle,
+		info:    info,
+		id:      fileID(info),
+		builder: partsBuilder{multipart: f.conf.Multipart},
+	}
+	if c, exists := f.checkpoints[path]; exists {
+		if c.ID == t.id && c.Offset <= info.Size() {
+			t.offset = c.Offset
+		}
+	} else if existing && !f.conf.StartFromBeginning {
+		t.offset = info.Size()
+	}
+
+	f.log.Infof("Following file '%v' from offset %v\n", path, t.offset)
+	f.files[path] = t
+}
+
+// scan matches the configured patterns and updates the set of followed files.
+// Returns files that have been rotated or removed and should be drained.
+func (f *FileTail) scan(existing bool) []*tailedFile {
+	matched := map[string]struct{}{}
+	for _, pattern := range f.conf.Paths {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				matched[path] = struct{}{}
+			}
+		}
+	}
+
+	var drain []*tailedFile
+	for path, t := range f.files {
+		if _, exists := matched[path]; !exists {
+			f.log.Infof("File '%v' was removed\n", path)
+			drain = append(drain, t)
+			delete(f.files, path)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !os.SameFile(t.info, info) {
+			f.stats.Incr("input.file_tail.rotated", 1)
+			f.log.Infof("File '%v' was rotated\n", path)
+			drain = append(drain, t)
+			delete(f.files, path)
+			continue
+		}
+		if info.Size() < t.readPos() {
+			f.stats.Incr("input.file_tail.truncated", 1)
+			f.log.Infof("File '%v' was truncated\n", path)
+			t.offset = 0
+			t.buf = nil
+			t.builder = partsBuilder{multipart: f.conf.Multipart}
+		}
+		t.info = info
+	}
+
+	for path := range matched {
+		if _, exists := f.files[path]; !exists {
+			f.open(path, existing)
+		}
+	}
+
+	f.stats.Gauge("input.file_tail.files", int64(len(f.files)))
+	return drain
+}
+
+// send sends a message and waits for a successful response, the offset is
+// committed as the position of the file once acknowledged. Returns false if
+// the input is closing.
+func (f *FileTail) send(path string, t *tailedFile, parts [][]byte) bool {
+	msg := types.Message{Parts: parts}
+	for {
+		select {
+		case f.messages <- msg:
+		case <-f.closeChan:
+			return false
+		}
+
+		res, open := <-f.responses
+		if !open {
+			return false
+		}
+		if res.Error() == nil {
+			f.stats.Incr("input.file_tail.send.success", 1)
+			f.pending[path] = fileCheckpoint{ID: t.id, Offset: t.offset}
+			if !res.SkipAck() {
+				f.commit()
+			}
+			return true
+		}
+		f.stats.Incr("input.file_tail.send.error", 1)
+	}
+}
+
+// sendParts sends the parts of a message if there are any. Returns false if the
+// input is closing.
+func (f *FileTail) sendParts(path string, t *tailedFile, parts [][]byte) bool {
+	if len(parts) == 0 {
+		return true
+	}
+	f.stats.Incr("input.file_tail.count", 1)
+	return f.send(path, t, parts)
+}
+
+// read reads any new data from a file and sends the resulting messages. If
+// final is true the file will not be written to again and any non-terminated
+// data is also sent. Returns false if the input is closing.
+func (f *FileTail) read(path string, t *tailedFile, final bool) bool {
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := t.handle.ReadAt(chunk, t.readPos())
+		t.buf = append(t.buf, chunk[:n]...)
+		if err != nil && err != io.EOF {
+			f.log.Errorf("Failed to read file '%v': %v\n", path, err)
+			return true
+		}
+		atEOF := final && err == io.EOF
+
+		for {
+			advance, token, _ := f.split(t.buf, atEOF)
+			if advance == 0 {
+				break
+			}
+			t.offset += int64(advance)
+			t.buf = t.buf[advance:]
+			if !f.sendParts(path, t, t.builder.push(token)) {
+				return false
+			}
+		}
+		if atEOF {
+			// Terminate any multipart message at the end of the file.
+			if !f.sendParts(path, t, t.builder.push(nil)) {
+				return false
+			}
+		}
+		if len(t.buf) == 0 {
+			t.buf = nil
+		} else if len(t.buf) > f.conf.MaxBuffer {
+			f.stats.Incr("input.file_tail.error.max_buffer", 1)
+			f.log.Errorf("Skipping data in file '%v' that exceeds max buffer\n", path)
+			t.offset += int64(len(t.buf))
+			t.buf = nil
+		}
+
+		if err == io.EOF {
+			return true
+		}
+	}
+}
+
+// watch creates an inotify watcher on the directories of all patterns.
+func (f *FileTail) watch() *fsnotify.Watcher {
+	if !f.conf.Inotify {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		f.log.Warnf("Failed to create inotify watcher, falling back to polling: %v\n", err)
+		return nil
+	}
+	for _, pattern := range f.conf.Paths {
+		dir := filepath.Dir(pattern)
+		if strings.ContainsAny(dir, "*?[") {
+			f.log.Warnf("Cannot watch directory pattern '%v', falling back to polling\n", dir)
+			continue
+		}
+		if err = watcher.Add(dir); err != nil {
+			f.log.Warnf("Failed to watch directory '%v', falling back to polling: %v\n", dir, err)
+		}
+	}
+	return watcher
+}
+
+func (f *FileTail) loop() {
+	defer func() {
+		for _, t := range f.files {
+			t.handle.Close()
+		}
+		atomic.StoreInt32(&f.running, 0)
+
+		close(f.messages)
+		close(f.closedChan)
+	}()
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if watcher := f.watch(); watcher != nil {
+		defer watcher.Close()
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	pollTicker := time.NewTicker(time.Duration(f.conf.PollIntervalMS) * time.Millisecond)
+	defer pollTicker.Stop()
+
+	existing := true
+	for atomic.LoadInt32(&f.running) == 1 {
+		for _, t := range f.scan(existing) {
+			// Rotated or removed files are read to the end before closing.
+			path := t.handle.Name()
+			ok := f.read(path, t, true)
+			t.handle.Close()
+			if !ok {
+				return
+			}
+			if _, exists := f.files[path]; !exists {
+				delete(f.checkpoints, path)
+				delete(f.pending, path)
+			}
+		}
+		existing = false
+
+		paths := make([]string, 0, len(f.files))
+		for path := range f.files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			if !f.read(path, f.files[path], false) {
+				return
+			}
+		}
+
+		select {
+		case <-pollTicker.C:
+		case <-events:
+		case err := <-errs:
+			f.log.Warnf("Inotify watcher error: %v\n", err)
+		case <-f.closeChan:
+			return
+		}
+	}
+}
+
+// StartListening sets the channel used by the input to validate message
+// receipt.
+func (f *FileTail) StartListening(responses <-chan types.Response) error {
+	if f.responses != nil {
+		return types.ErrAlreadyStarted
+	}
+	f.responses = responses
+	go f.loop()
+	return nil
+}
+
+// MessageChan returns the messages channel.
+func (f *FileTail) MessageChan() <-chan types.Message {
+	return f.messages
+}
+
+// CloseAsync shuts down the FileTail input and stops processing requests.
+func (f *FileTail) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&f.running, 1, 0) {
+		close(f.closeChan)
+	}
+}
+
+// WaitForClose blocks until the FileTail input has closed down.
+func (f *FileTail) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-f.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,276 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func appendToFile(t *testing.T, path, data string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func startFileTail(t *testing.T, conf Config) (Type, chan types.Response) {
+	f, err := NewFileTail(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resChan := make(chan types.Response)
+	if err = f.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	return f, resChan
+}
+
+func stopFileTail(t *testing.T, f Type) {
+	f.CloseAsync()
+	if err := f.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func expectFileTailMsgs(t *testing.T, f Type, resChan chan types.Response, res types.Response, exp ...[][]byte) {
+	for _, parts := range exp {
+		select {
+		case msg, open := <-f.MessageChan():
+			if !open {
+				t.Fatal("Channel closed early")
+			}
+			if !reflect.DeepEqual(parts, msg.Parts) {
+				t.Errorf("Wrong message received: %s != %s", msg.Parts, parts)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Timed out waiting for message: %s", parts)
+		}
+		select {
+		case resChan <- res:
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timed out sending response")
+		}
+	}
+}
+
+func expectNoFileTailMsgs(t *testing.T, f Type) {
+	select {
+	case msg := <-f.MessageChan():
+		t.Errorf("Unexpected message: %s", msg.Parts)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestFileTailBadConfig(t *testing.T) {
+	conf := NewConfig()
+	if _, err := NewFileTail(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{}); err == nil {
+		t.Error("Expected error from no paths")
+	}
+
+	conf.FileTail.Paths = []string{"[bad"}
+	if _, err := NewFileTail(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad pattern")
+	}
+}
+
+func TestFileTailFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_file_tail_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.log")
+	appendToFile(t, path, "foo\nbar\n")
+	appendToFile(t, filepath.Join(dir, "ignored.txt"), "ignored\n")
+
+	conf := NewConfig()
+	conf.FileTail.Paths = []string{filepath.Join(dir, "*.log")}
+	conf.FileTail.PollIntervalMS = 10
+
+	f, resChan := startFileTail(t, conf)
+	defer stopFileTail(t, f)
+
+	res := types.NewSimpleResponse(nil)
+	expectFileTailMsgs(t, f, resChan, res,
+		[][]byte{[]byte("foo")},
+		[][]byte{[]byte("bar")},
+	)
+
+	// Partial lines are held until terminated.
+	appendToFile(t, path, "baz")
+	expectNoFileTailMsgs(t, f)
+	appendToFile(t, path, "\nqux\n")
+	expectFileTailMsgs(t, f, resChan, res,
+		[][]byte{[]byte("baz")},
+		[][]byte{[]byte("qux")},
+	)
+
+	// New files are read from the beginning.
+	appendToFile(t, filepath.Join(dir, "b.log"), "quz\n")
+	expectFileTailMsgs(t, f, resChan, res,
+		[][]byte{[]byte("quz")},
+	)
+
+	// Rotated files are read to the end before following the new file.
+	appendToFile(t, path, "before rotate")
+	if err = os.Rename(path, filepath.Join(dir, "a.log.1")); err != nil {
+		t.Fatal(err)
+	}
+	appendToFile(t, path, "after rotate\n")
+	expectFileTailMsgs(t, f, resChan, res,
+		[][]byte{[]byte("before rotate")},
+		[][]byte{[]byte("after rotate")},
+	)
+
+	// Truncated files are read from the beginning.
+	if err = os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	expectNoFileTailMsgs(t, f)
+	appendToFile(t, path, "new\n")
+	expectFileTailMsgs(t, f, resChan, res,
+		[][]byte{[]byte("new")},
+	)
+}
+
+func TestFileTailMultipart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_file_tail_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.log")
+	appendToFile(t, path, "foo|bar||baz|")
+
+	conf := NewConfig()
+	conf.FileTail.Paths = []string{path}
+	conf.FileTail.PollIntervalMS = 10
+	conf.FileTail.Multipart = true
+	conf.FileTail.CustomDelim = "|"
+
+	f, resChan := startFileTail(t, conf)
+	defer stopFileTail(t, f)
+
+	res := types.NewSimpleResponse(nil)
+	expectFileTailMsgs(t, f, resChan, res,
+		[][]byte{[]byte("foo"), []byte("bar")},
+	)
+	expectNoFileTailMsgs(t, f)
+
+	appendToFile(t, path, "qux||")
+	expectFileTailMsgs(t, f, resChan, res,
+		[][]byte{[]byte("baz"), []byte("qux")},
+	)
+}
+
+func TestFileTailCheckpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_file_tail_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.log")
+	appendToFile(t, path, "foo\nbar\nbaz\n")
+
+	conf := NewConfig()
+	conf.FileTail.Paths = []string{path}
+	conf.FileTail.PollIntervalMS = 10
+	conf.FileTail.CheckpointPath = filepath.Join(dir, "checkpoint.json")
+
+	f, resChan := startFileTail(t, conf)
+	expectFileTailMsgs(t, f, resChan, types.NewSimpleResponse(nil),
+		[][]byte{[]byte("foo")},
+	)
+	expectFileTailMsgs(t, f, resChan, types.NewUnacknowledgedResponse(),
+		[][]byte{[]byte("bar")},
+	)
+	stopFileTail(t, f)
+
+	// Unacknowledged messages are read again.
+	f, resChan = startFileTail(t, conf)
+	expectFileTailMsgs(t, f, resChan, types.NewSimpleResponse(nil),
+		[][]byte{[]byte("bar")},
+		[][]byte{[]byte("baz")},
+	)
+	expectNoFileTailMsgs(t, f)
+	stopFileTail(t, f)
+
+	appendToFile(t, path, "qux\n")
+
+	f, resChan = startFileTail(t, conf)
+	expectFileTailMsgs(t, f, resChan, types.NewSimpleResponse(nil),
+		[][]byte{[]byte("qux")},
+	)
+	stopFileTail(t, f)
+
+	// A different file at the same path is read from the beginning.
+	if err = os.Rename(path, filepath.Join(dir, "old.log")); err != nil {
+		t.Fatal(err)
+	}
+	appendToFile(t, path, "new\n")
+
+	f, resChan = startFileTail(t, conf)
+	expectFileTailMsgs(t, f, resChan, types.NewSimpleResponse(nil),
+		[][]byte{[]byte("new")},
+	)
+	stopFileTail(t, f)
+}
+
+func TestFileTailStartFromEnd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_file_tail_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.log")
+	appendToFile(t, path, "foo\n")
+
+	conf := NewConfig()
+	conf.FileTail.Paths = []string{path}
+	conf.FileTail.PollIntervalMS = 10
+	conf.FileTail.StartFromBeginning = false
+
+	f, resChan := startFileTail(t, conf)
+	defer stopFileTail(t, f)
+
+	expectNoFileTailMsgs(t, f)
+	appendToFile(t, path, "bar\n")
+	expectFileTailMsgs(t, f, resChan, types.NewSimpleResponse(nil),
+		[][]byte{[]byte("bar")},
+	)
+}
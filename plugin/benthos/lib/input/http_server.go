@@ -32,6 +32,7 @@ import (
 	"time"
 
 	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/endpoint"
 	"github.com/Jeffail/benthos/lib/util/service/log"
 	"github.com/Jeffail/benthos/lib/util/service/metrics"
 )
@@ -46,7 +47,11 @@ Receive messages POSTed over HTTP(S). HTTP 2.0 is supported when using TLS,
 which is enabled when key and cert files are specified.
 
 You can leave the 'address' config field blank in order to use the default
-service, but this will ignore TLS options.`,
+service, but this will ignore TLS options.
+
+The headers of a request are added as metadata to each part of the resulting
+message, with the header names in canonical form (e.g. 'Content-Type'). For
+multipart requests the headers of each part are added to that part.`,
 	}
 }
 
@@ -82,8 +87,9 @@ type HTTPServer struct {
 	stats metrics.Type
 	log   log.Modular
 
-	mux    *http.ServeMux
-	server *http.Server
+	mux      *http.ServeMux
+	server   *http.Server
+	bindings endpoint.Bindings
 
 	messages  chan types.Message
 	responses <-chan types.Response
@@ -100,8 +106,6 @@ func NewHTTPServer(conf Config, log log.Modular, stats metrics.Type) (Type, erro
 	if len(conf.HTTPServer.Address) > 0 {
 		mux = http.NewServeMux()
 		server = &http.Server{Addr: conf.HTTPServer.Address, Handler: mux}
-	} else {
-		mux = http.DefaultServeMux
 	}
 
 	h := HTTPServer{
@@ -117,7 +121,11 @@ func NewHTTPServer(conf Config, log log.Modular, stats metrics.Type) (Type, erro
 		closedChan: make(chan struct{}),
 	}
 
-	mux.HandleFunc(h.conf.HTTPServer.Path, h.postHandler)
+	if server != nil {
+		mux.HandleFunc(h.conf.HTTPServer.Path, h.postHandler)
+	} else if err := h.bindings.Bind(h.conf.HTTPServer.Path, h.postHandler); err != nil {
+		return nil, err
+	}
 
 	return &h, nil
 }
@@ -173,6 +181,9 @@ func (h *HTTPServer) postHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			msg.Parts = append(msg.Parts, msgBytes)
+			for k := range p.Header {
+				msg.SetMetadata(len(msg.Parts)-1, k, p.Header.Get(k))
+			}
 		}
 	} else {
 		var msgBytes []byte
@@ -182,6 +193,14 @@ func (h *HTTPServer) postHandler(w http.ResponseWriter, r *http.Request) {
 		msg.Parts = [][]byte{msgBytes}
 	}
 
+	for i := range msg.Parts {
+		for k := range r.Header {
+			if len(msg.GetMetadata(i, k)) == 0 {
+				msg.SetMetadata(i, k, r.Header.Get(k))
+			}
+		}
+	}
+
 	select {
 	case h.messages <- msg:
 	case <-time.After(time.Millisecond * time.Duration(h.conf.HTTPServer.TimeoutMS)):
@@ -277,6 +296,7 @@ func (h *HTTPServer) MessageChan() <-chan types.Message {
 // CloseAsync shuts down the HTTPServer input and stops processing requests.
 func (h *HTTPServer) CloseAsync() {
 	if atomic.CompareAndSwapInt32(&h.running, 1, 0) {
+		h.bindings.Release()
 		close(h.closeChan)
 	}
 }
@@ -21,6 +21,7 @@
 package input
 
 import (
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -40,7 +41,10 @@ func init() {
 Connects to a kafka (0.8+) server. Offsets are managed within kafka as per the
 consumer group (set via config). Only one partition per input is supported, if
 you wish to balance partitions across a consumer group look at the
-'kafka_balanced' input type instead.`,
+'kafka_balanced' input type instead.
+
+The metadata fields 'kafka_key', 'kafka_topic', 'kafka_partition' and
+'kafka_offset' are added to each message.`,
 	}
 }
 
@@ -52,7 +56,7 @@ type KafkaConfig struct {
 	ClientID        string   `json:"client_id" yaml:"client_id"`
 	ConsumerGroup   string   `json:"consumer_group" yaml:"consumer_group"`
 	Topic           string   `json:"topic" yaml:"topic"`
-	Partition       int32    `json:"partition" yam:"partition"`
+	Partition       int32    `json:"partition" yaml:"partition"`
 	StartFromOldest bool     `json:"start_from_oldest" yaml:"start_from_oldest"`
 }
 
@@ -119,6 +123,21 @@ func NewKafka(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
 
 //------------------------------------------------------------------------------
 
+// kafkaMessage creates a message from a Kafka message, where the key, topic,
+// partition and offset are added as metadata.
+func kafkaMessage(data *sarama.ConsumerMessage) types.Message {
+	msg := types.Message{Parts: [][]byte{data.Value}}
+	if data.Key != nil {
+		msg.SetMetadata(0, "kafka_key", string(data.Key))
+	}
+	msg.SetMetadata(0, "kafka_topic", data.Topic)
+	msg.SetMetadata(0, "kafka_partition", strconv.Itoa(int(data.Partition)))
+	msg.SetMetadata(0, "kafka_offset", strconv.FormatInt(data.Offset, 10))
+	return msg
+}
+
+//------------------------------------------------------------------------------
+
 func (k *Kafka) commitOffset() error {
 	commitReq := sarama.OffsetCommitRequest{}
 	commitReq.ConsumerGroup = k.conf.Kafka.ConsumerGroup
@@ -290,7 +309,7 @@ func (k *Kafka) loop() {
 		if data != nil {
 			start := time.Now()
 			select {
-			case k.messages <- types.Message{Parts: [][]byte{data.Value}}:
+			case k.messages <- kafkaMessage(data):
 			case <-k.closeChan:
 				return
 			}
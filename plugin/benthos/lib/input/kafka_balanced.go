@@ -40,7 +40,10 @@ func init() {
 		description: `
 Connects to a kafka (0.9+) server. Offsets are managed within kafka as per the
 consumer group (set via config), and partitions are automatically balanced
-across any members of the consumer group.`,
+across any members of the consumer group.
+
+The metadata fields 'kafka_key', 'kafka_topic', 'kafka_partition' and
+'kafka_offset' are added to each message.`,
 	}
 }
 
@@ -224,7 +227,7 @@ func (k *KafkaBalanced) loop() {
 		// If bytes are read then try and propagate.
 		if data != nil {
 			select {
-			case k.messages <- types.Message{Parts: [][]byte{data.Value}}:
+			case k.messages <- kafkaMessage(data):
 			case <-k.closeChan:
 				return
 			}
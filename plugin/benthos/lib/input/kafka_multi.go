@@ -0,0 +1,618 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Shopify/sarama"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["kafka_multi"] = typeSpec{
+		constructor: NewKafkaMulti,
+		description: `
+Connects to a kafka (0.8+) server and consumes all partitions of a list of
+topics. Topics can also be selected with a regular expression 'topic_pattern',
+which is matched against all topics of the cluster when connecting, and can be
+combined with an explicit list of topics.
+
+Offsets are managed within kafka as per the consumer group (set via config).
+Offsets are only committed for messages that have been acknowledged by the
+output, and are committed in order for each partition. Acknowledged offsets are
+committed periodically according to 'commit_period_ms', and when the input is
+closed.
+
+The consumer lag of each partition, which is the difference between the high
+water mark of the partition and the next offset to be committed, is exposed as
+the gauge 'input.kafka_multi.lag.<topic>.<partition>' and is refreshed at the
+same period as commits.
+
+The metadata fields 'kafka_key', 'kafka_topic', 'kafka_partition' and
+'kafka_offset' are added to each message.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// KafkaMultiConfig is configuration for the KafkaMulti input type.
+type KafkaMultiConfig struct {
+	Addresses       []string `json:"addresses" yaml:"addresses"`
+	ClientID        string   `json:"client_id" yaml:"client_id"`
+	ConsumerGroup   string   `json:"consumer_group" yaml:"consumer_group"`
+	Topics          []string `json:"topics" yaml:"topics"`
+	TopicPattern    string   `json:"topic_pattern" yaml:"topic_pattern"`
+	StartFromOldest bool     `json:"start_from_oldest" yaml:"start_from_oldest"`
+	CommitPeriodMS  int      `json:"commit_period_ms" yaml:"commit_period_ms"`
+}
+
+// NewKafkaMultiConfig creates a new KafkaMultiConfig with default values.
+func NewKafkaMultiConfig() KafkaMultiConfig {
+	return KafkaMultiConfig{
+		Addresses:       []string{"localhost:9092"},
+		ClientID:        "benthos_kafka_input",
+		ConsumerGroup:   "benthos_consumer_group",
+		Topics:          []string{"benthos_stream"},
+		TopicPattern:    "",
+		StartFromOldest: true,
+		CommitPeriodMS:  1000,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// kafkaPartition identifies a single partition of a topic.
+type kafkaPartition struct {
+	topic     string
+	partition int32
+}
+
+// kafkaOffsetTracker tracks the offsets of messages that have been
+// acknowledged for each partition, and therefore which offsets are ready to be
+// committed. Messages that are propagated with a response that skips
+// acknowledgement are held as pending until a later message is acknowledged.
+type kafkaOffsetTracker struct {
+	acked   map[kafkaPartition]int64
+	pending map[kafkaPartition]int64
+	dirty   map[kafkaPartition]struct{}
+}
+
+func newKafkaOffsetTracker() *kafkaOffsetTracker {
+	return &kafkaOffsetTracker{
+		acked:   map[kafkaPartition]int64{},
+		pending: map[kafkaPartition]int64{},
+		dirty:   map[kafkaPartition]struct{}{},
+	}
+}
+
+// Init sets the offset that consumption of a partition begins from, which is
+// not considered ready for committing.
+func (k *kafkaOffsetTracker) Init(p kafkaPartition, offset int64) {
+	k.acked[p] = offset
+}
+
+// Ack marks a message of a partition as having been successfully propagated.
+// If skipAck is true the message is held as pending, otherwise the message and
+// all pending messages are acknowledged.
+func (k *kafkaOffsetTracker) Ack(p kafkaPartition, offset int64, skipAck bool) {
+	if skipAck {
+		k.pending[p] = offset + 1
+		return
+	}
+	for pp, next := range k.pending {
+		k.setAcked(pp, next)
+	}
+	k.pending = map[kafkaPartition]int64{}
+	k.setAcked(p, offset+1)
+}
+
+func (k *kafkaOffsetTracker) setAcked(p kafkaPartition, next int64) {
+	if current, exists := k.acked[p]; exists && current >= next {
+		return
+	}
+	k.acked[p] = next
+	k.dirty[p] = struct{}{}
+}
+
+// Next returns the next offset of a partition to be committed.
+func (k *kafkaOffsetTracker) Next(p kafkaPartition) (int64, bool) {
+	offset, exists := k.acked[p]
+	return offset, exists
+}
+
+// Uncommitted returns the acknowledged offsets of all partitions that have
+// changed since they were last committed.
+func (k *kafkaOffsetTracker) Uncommitted() map[kafkaPartition]int64 {
+	offsets := map[kafkaPartition]int64{}
+	for p := range k.dirty {
+		offsets[p] = k.acked[p]
+	}
+	return offsets
+}
+
+// Committed marks offsets as having been committed.
+func (k *kafkaOffsetTracker) Committed(offsets map[kafkaPartition]int64) {
+	for p, offset := range offsets {
+		if k.acked[p] == offset {
+			delete(k.dirty, p)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// KafkaMulti is an input type that reads from all partitions of multiple Kafka
+// topics.
+type KafkaMulti struct {
+	running int32
+
+	client         sarama.Client
+	coordinator    *sarama.Broker
+	consumer       sarama.Consumer
+	partConsumers  map[kafkaPartition]sarama.PartitionConsumer
+	consumerWG     sync.WaitGroup
+	offsets        *kafkaOffsetTracker
+	topicPattern   *regexp.Regexp
+	commitPeriod   time.Duration
+	internalMsgs   chan *sarama.ConsumerMessage
+	consumersStop  chan struct{}
+	consumersEnded chan struct{}
+
+	addresses []string
+	topics    []string
+	conf      Config
+	stats     metrics.Type
+	log       log.Modular
+
+	messages  chan types.Message
+	responses <-chan types.Response
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewKafkaMulti creates a new KafkaMulti input type.
+func NewKafkaMulti(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	k := KafkaMulti{
+		running:      1,
+		offsets:      newKafkaOffsetTracker(),
+		commitPeriod: time.Millisecond * time.Duration(conf.KafkaMulti.CommitPeriodMS),
+		internalMsgs: make(chan *sarama.ConsumerMessage),
+		conf:         conf,
+		stats:        stats,
+		log:          log.NewModule(".input.kafka_multi"),
+		messages:     make(chan types.Message),
+		responses:    nil,
+		closeChan:    make(chan struct{}),
+		closedChan:   make(chan struct{}),
+	}
+	for _, addr := range conf.KafkaMulti.Addresses {
+		for _, splitAddr := range strings.Split(addr, ",") {
+			if len(splitAddr) > 0 {
+				k.addresses = append(k.addresses, splitAddr)
+			}
+		}
+	}
+	for _, topic := range conf.KafkaMulti.Topics {
+		for _, splitTopic := range strings.Split(topic, ",") {
+			if len(splitTopic) > 0 {
+				k.topics = append(k.topics, splitTopic)
+			}
+		}
+	}
+	if len(conf.KafkaMulti.TopicPattern) > 0 {
+		var err error
+		if k.topicPattern, err = regexp.Compile(conf.KafkaMulti.TopicPattern); err != nil {
+			return nil, fmt.Errorf("failed to compile topic pattern: %v", err)
+		}
+	}
+	if len(k.topics) == 0 && k.topicPattern == nil {
+		return nil, errors.New("at least one topic or a topic pattern must be specified")
+	}
+	if k.commitPeriod <= 0 {
+		return nil, errors.New("commit period must be greater than zero")
+	}
+
+	return &k, nil
+}
+
+//------------------------------------------------------------------------------
+
+// resolveTopics returns a sorted list of all topics to consume from, which are
+// the explicitly listed topics and all topics of the cluster that match the
+// topic pattern.
+func (k *KafkaMulti) resolveTopics(client sarama.Client) ([]string, error) {
+	topicSet := map[string]struct{}{}
+	for _, topic := range k.topics {
+		topicSet[topic] = struct{}{}
+	}
+	if k.topicPattern != nil {
+		allTopics, err := client.Topics()
+		if err != nil {
+			return nil, err
+		}
+		for _, topic := range allTopics {
+			if k.topicPattern.MatchString(topic) {
+				topicSet[topic] = struct{}{}
+			}
+		}
+	}
+	if len(topicSet) == 0 {
+		return nil, errors.New("no topics matched the topic pattern")
+	}
+
+	topics := make([]string, 0, len(topicSet))
+	for topic := range topicSet {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// fetchOffsets obtains the committed offsets of the consumer group for a set
+// of partitions. Partitions without a committed offset are omitted.
+func (k *KafkaMulti) fetchOffsets(partitions []kafkaPartition) map[kafkaPartition]int64 {
+	offsetReq := sarama.OffsetFetchRequest{}
+	offsetReq.ConsumerGroup = k.conf.KafkaMulti.ConsumerGroup
+	for _, p := range partitions {
+		offsetReq.AddPartition(p.topic, p.partition)
+	}
+
+	offsets := map[kafkaPartition]int64{}
+	offsetRes, err := k.coordinator.FetchOffset(&offsetReq)
+	if err != nil {
+		k.log.Warnf("Failed to fetch committed offsets: %v\n", err)
+		return offsets
+	}
+	for _, p := range partitions {
+		block := offsetRes.GetBlock(p.topic, p.partition)
+		if block != nil && block.Err == sarama.ErrNoError && block.Offset >= 0 {
+			offsets[p] = block.Offset
+		}
+	}
+	return offsets
+}
+
+// consumePartition creates a consumer for a partition starting from an offset,
+// falling back to either the oldest or newest offset if that fails.
+func (k *KafkaMulti) consumePartition(p kafkaPartition, offset int64, hasOffset bool) error {
+	offsetTarget, offsetTargetStr := sarama.OffsetOldest, "oldest"
+	if !k.conf.KafkaMulti.StartFromOldest {
+		offsetTarget, offsetTargetStr = sarama.OffsetNewest, "newest"
+	}
+
+	var err error
+	var partConsumer sarama.PartitionConsumer
+	if hasOffset {
+		if partConsumer, err = k.consumer.ConsumePartition(p.topic, p.partition, offset); err != nil {
+			k.log.Warnf(
+				"Failed to read from stored offset of topic %s partition %v, restarting from %v offset\n",
+				p.topic, p.partition, offsetTargetStr,
+			)
+		}
+	}
+	if partConsumer == nil {
+		if offset, err = k.client.GetOffset(p.topic, p.partition, offsetTarget); err != nil {
+			return err
+		}
+		if partConsumer, err = k.consumer.ConsumePartition(p.topic, p.partition, offset); err != nil {
+			return err
+		}
+	}
+
+	k.offsets.Init(p, offset)
+	k.partConsumers[p] = partConsumer
+
+	stopChan := k.consumersStop
+	k.consumerWG.Add(2)
+	go func() {
+		defer k.consumerWG.Done()
+		for msg := range partConsumer.Messages() {
+			select {
+			case k.internalMsgs <- msg:
+			case <-stopChan:
+			}
+		}
+	}()
+	go func() {
+		defer k.consumerWG.Done()
+		for err := range partConsumer.Errors() {
+			k.log.Errorf("Kafka message recv error: %v\n", err)
+			k.stats.Incr("input.kafka_multi.recv.error", 1)
+		}
+	}()
+	return nil
+}
+
+func (k *KafkaMulti) connect() error {
+	config := sarama.NewConfig()
+	config.ClientID = k.conf.KafkaMulti.ClientID
+	config.Net.DialTimeout = time.Second
+	config.Consumer.Return.Errors = true
+
+	var err error
+	defer func() {
+		if err != nil {
+			k.closeClients()
+		}
+	}()
+
+	if k.client, err = sarama.NewClient(k.addresses, config); err != nil {
+		return err
+	}
+	if k.coordinator, err = k.client.Coordinator(k.conf.KafkaMulti.ConsumerGroup); err != nil {
+		return err
+	}
+	if k.consumer, err = sarama.NewConsumerFromClient(k.client); err != nil {
+		return err
+	}
+
+	var topics []string
+	if topics, err = k.resolveTopics(k.client); err != nil {
+		return err
+	}
+
+	var partitions []kafkaPartition
+	for _, topic := range topics {
+		var ids []int32
+		if ids, err = k.client.Partitions(topic); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			partitions = append(partitions, kafkaPartition{topic: topic, partition: id})
+		}
+	}
+
+	committed := k.fetchOffsets(partitions)
+
+	k.partConsumers = map[kafkaPartition]sarama.PartitionConsumer{}
+	k.consumersStop = make(chan struct{})
+	for _, p := range partitions {
+		offset, hasOffset := committed[p]
+		if err = k.consumePartition(p, offset, hasOffset); err != nil {
+			return err
+		}
+	}
+
+	// Only signal the end of consumption once all consumers have started, as
+	// the partition consumers of a failed attempt are closed by closeClients.
+	consumersEnded := make(chan struct{})
+	go func() {
+		k.consumerWG.Wait()
+		close(consumersEnded)
+	}()
+	k.consumersEnded = consumersEnded
+
+	k.log.Infof("Consuming %v partitions of topics: %s\n", len(partitions), topics)
+	return nil
+}
+
+// closeClients closes the kafka clients, which stops all partition consumers.
+func (k *KafkaMulti) closeClients() {
+	if k.consumersStop != nil {
+		close(k.consumersStop)
+		k.consumersStop = nil
+	}
+	for _, partConsumer := range k.partConsumers {
+		partConsumer.AsyncClose()
+	}
+	k.partConsumers = nil
+	if k.consumer != nil {
+		k.consumer.Close()
+		k.consumer = nil
+	}
+	if k.coordinator != nil {
+		k.coordinator.Close()
+		k.coordinator = nil
+	}
+	if k.client != nil {
+		k.client.Close()
+		k.client = nil
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// commitOffsets commits all acknowledged offsets that have not yet been
+// committed.
+func (k *KafkaMulti) commitOffsets() {
+	offsets := k.offsets.Uncommitted()
+	if len(offsets) == 0 || k.coordinator == nil {
+		return
+	}
+
+	commitReq := sarama.OffsetCommitRequest{}
+	commitReq.ConsumerGroup = k.conf.KafkaMulti.ConsumerGroup
+	for p, offset := range offsets {
+		commitReq.AddBlock(p.topic, p.partition, offset, 0, "")
+	}
+
+	commitRes, err := k.coordinator.CommitOffset(&commitReq)
+	if err != nil {
+		k.log.Errorf("Failed to commit offsets: %v\n", err)
+		k.stats.Incr("input.kafka_multi.commit.error", 1)
+		if err == sarama.ErrNotConnected {
+			// Attempt to reconnect
+			if newCoord, err := k.client.Coordinator(k.conf.KafkaMulti.ConsumerGroup); err != nil {
+				k.log.Errorf("Failed to create new coordinator: %v\n", err)
+			} else {
+				k.coordinator.Close()
+				k.coordinator = newCoord
+			}
+		}
+		return
+	}
+
+	for p := range offsets {
+		if kErr := commitRes.Errors[p.topic][p.partition]; kErr != sarama.ErrNoError {
+			k.log.Errorf(
+				"Failed to commit offset of topic %s partition %v: %v\n",
+				p.topic, p.partition, kErr,
+			)
+			k.stats.Incr("input.kafka_multi.commit.error", 1)
+			delete(offsets, p)
+		}
+	}
+	k.offsets.Committed(offsets)
+	k.stats.Incr("input.kafka_multi.commit.success", 1)
+}
+
+// reportLag sets the consumer lag gauge of each partition.
+func (k *KafkaMulti) reportLag() {
+	for p, partConsumer := range k.partConsumers {
+		next, exists := k.offsets.Next(p)
+		if !exists {
+			continue
+		}
+		lag := partConsumer.HighWaterMarkOffset() - next
+		if lag < 0 {
+			lag = 0
+		}
+		k.stats.Gauge(
+			"input.kafka_multi.lag."+p.topic+"."+strconv.Itoa(int(p.partition)), lag,
+		)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (k *KafkaMulti) loop() {
+	defer func() {
+		atomic.StoreInt32(&k.running, 0)
+
+		k.commitOffsets()
+		k.closeClients()
+		if k.consumersEnded != nil {
+			<-k.consumersEnded
+		}
+
+		close(k.messages)
+		close(k.closedChan)
+	}()
+
+	for {
+		if err := k.connect(); err != nil {
+			k.log.Errorf("Failed to connect to Kafka: %v\n", err)
+			select {
+			case <-time.After(time.Second):
+			case <-k.closeChan:
+				return
+			}
+		} else {
+			break
+		}
+	}
+	k.log.Infof("Receiving Kafka messages from addresses: %s\n", k.addresses)
+
+	commitTicker := time.NewTicker(k.commitPeriod)
+	defer commitTicker.Stop()
+
+	var data *sarama.ConsumerMessage
+
+	for atomic.LoadInt32(&k.running) == 1 {
+		// If no bytes then read a message
+		if data == nil {
+			select {
+			case data = <-k.internalMsgs:
+				k.stats.Incr("input.kafka_multi.count", 1)
+			case <-commitTicker.C:
+				k.commitOffsets()
+				k.reportLag()
+				continue
+			case <-k.consumersEnded:
+				return
+			case <-k.closeChan:
+				return
+			}
+		}
+
+		// If bytes are read then try and propagate.
+		start := time.Now()
+		select {
+		case k.messages <- kafkaMessage(data):
+		case <-k.closeChan:
+			return
+		}
+		res, open := <-k.responses
+		if !open {
+			return
+		}
+		if resErr := res.Error(); resErr == nil {
+			k.stats.Timing("input.kafka_multi.timing", int64(time.Since(start)))
+			k.offsets.Ack(
+				kafkaPartition{topic: data.Topic, partition: data.Partition},
+				data.Offset, res.SkipAck(),
+			)
+			k.stats.Incr("input.kafka_multi.send.success", 1)
+			data = nil
+		} else {
+			k.stats.Incr("input.kafka_multi.send.error", 1)
+		}
+	}
+}
+
+// StartListening sets the channel used by the input to validate message
+// receipt.
+func (k *KafkaMulti) StartListening(responses <-chan types.Response) error {
+	if k.responses != nil {
+		return types.ErrAlreadyStarted
+	}
+	k.responses = responses
+	go k.loop()
+	return nil
+}
+
+// MessageChan returns the messages channel.
+func (k *KafkaMulti) MessageChan() <-chan types.Message {
+	return k.messages
+}
+
+// CloseAsync shuts down the KafkaMulti input and stops processing requests.
+func (k *KafkaMulti) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&k.running, 1, 0) {
+		close(k.closeChan)
+	}
+}
+
+// WaitForClose blocks until the KafkaMulti input has closed down.
+func (k *KafkaMulti) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-k.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,386 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Shopify/sarama"
+)
+
+//------------------------------------------------------------------------------
+
+type gaugeStats struct {
+	metrics.DudType
+	sync.Mutex
+	gauges map[string]int64
+}
+
+func (g *gaugeStats) Gauge(path string, value int64) error {
+	g.Lock()
+	g.gauges[path] = value
+	g.Unlock()
+	return nil
+}
+
+func (g *gaugeStats) get(path string) (int64, bool) {
+	g.Lock()
+	defer g.Unlock()
+	value, exists := g.gauges[path]
+	return value, exists
+}
+
+// committedOffsets returns the offsets of all commit requests received by a
+// mock broker, in the order they were received.
+func committedOffsets(broker *sarama.MockBroker, partitions []kafkaPartition) []map[kafkaPartition]int64 {
+	commits := []map[kafkaPartition]int64{}
+	for _, rr := range broker.History() {
+		req, ok := rr.Request.(*sarama.OffsetCommitRequest)
+		if !ok {
+			continue
+		}
+		offsets := map[kafkaPartition]int64{}
+		for _, p := range partitions {
+			if offset, _, err := req.Offset(p.topic, p.partition); err == nil {
+				offsets[p] = offset
+			}
+		}
+		commits = append(commits, offsets)
+	}
+	return commits
+}
+
+func newKafkaMultiMockBroker(t *testing.T, group string) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("foo", 0, broker.BrokerID()).
+			SetLeader("foo", 1, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, group, broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(group, "foo", 0, 5, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetVersion(1).
+			SetOffset("foo", 0, sarama.OffsetOldest, 0).
+			SetOffset("foo", 0, sarama.OffsetNewest, 10).
+			SetOffset("foo", 1, sarama.OffsetOldest, 0).
+			SetOffset("foo", 1, sarama.OffsetNewest, 10),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetVersion(4).
+			SetMessage("foo", 0, 5, sarama.StringEncoder("foo0")).
+			SetMessage("foo", 1, 0, sarama.StringEncoder("foo1")).
+			SetHighWaterMark("foo", 0, 10).
+			SetHighWaterMark("foo", 1, 10),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+	return broker
+}
+
+//------------------------------------------------------------------------------
+
+func TestKafkaMultiOffsetTracker(t *testing.T) {
+	foo0 := kafkaPartition{topic: "foo", partition: 0}
+	foo1 := kafkaPartition{topic: "foo", partition: 1}
+	bar0 := kafkaPartition{topic: "bar", partition: 0}
+
+	tracker := newKafkaOffsetTracker()
+	tracker.Init(foo0, 10)
+	tracker.Init(foo1, 20)
+
+	if exp, act := map[kafkaPartition]int64{}, tracker.Uncommitted(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong uncommitted offsets: %v != %v", act, exp)
+	}
+	if next, _ := tracker.Next(foo0); next != 10 {
+		t.Errorf("Wrong next offset: %v != %v", next, 10)
+	}
+
+	tracker.Ack(foo0, 10, false)
+	tracker.Ack(foo1, 20, true)
+	tracker.Ack(foo1, 21, true)
+
+	exp := map[kafkaPartition]int64{foo0: 11}
+	if act := tracker.Uncommitted(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong uncommitted offsets: %v != %v", act, exp)
+	}
+
+	tracker.Ack(bar0, 5, false)
+
+	exp = map[kafkaPartition]int64{foo0: 11, foo1: 22, bar0: 6}
+	if act := tracker.Uncommitted(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong uncommitted offsets: %v != %v", act, exp)
+	}
+
+	tracker.Committed(map[kafkaPartition]int64{foo0: 11, foo1: 22})
+
+	exp = map[kafkaPartition]int64{bar0: 6}
+	if act := tracker.Uncommitted(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong uncommitted offsets: %v != %v", act, exp)
+	}
+
+	// Offsets must never move backwards.
+	tracker.Ack(foo0, 3, false)
+	tracker.Committed(map[kafkaPartition]int64{bar0: 6})
+
+	exp = map[kafkaPartition]int64{}
+	if act := tracker.Uncommitted(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong uncommitted offsets: %v != %v", act, exp)
+	}
+	if next, _ := tracker.Next(foo0); next != 11 {
+		t.Errorf("Wrong next offset: %v != %v", next, 11)
+	}
+
+	// An offset acknowledged during a commit remains uncommitted.
+	tracker.Ack(foo0, 11, false)
+	uncommitted := tracker.Uncommitted()
+	tracker.Ack(foo0, 12, false)
+	tracker.Committed(uncommitted)
+
+	exp = map[kafkaPartition]int64{foo0: 13}
+	if act := tracker.Uncommitted(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong uncommitted offsets: %v != %v", act, exp)
+	}
+}
+
+func TestKafkaMultiConfigErrors(t *testing.T) {
+	conf := NewConfig()
+	conf.KafkaMulti.Topics = []string{}
+	if _, err := NewKafkaMulti(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{}); err == nil {
+		t.Error("Expected error from no topics")
+	}
+
+	conf = NewConfig()
+	conf.KafkaMulti.TopicPattern = "foo("
+	if _, err := NewKafkaMulti(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad topic pattern")
+	}
+
+	conf = NewConfig()
+	conf.KafkaMulti.CommitPeriodMS = 0
+	if _, err := NewKafkaMulti(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{}); err == nil {
+		t.Error("Expected error from zero commit period")
+	}
+}
+
+func TestKafkaMultiResolveTopics(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("foo", 0, broker.BrokerID()).
+			SetLeader("bar", 0, broker.BrokerID()).
+			SetLeader("baz_1", 0, broker.BrokerID()).
+			SetLeader("baz_2", 0, broker.BrokerID()).
+			SetLeader("baz_2", 1, broker.BrokerID()),
+	})
+
+	config := sarama.NewConfig()
+	config.Net.DialTimeout = time.Second
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conf := NewConfig()
+	conf.KafkaMulti.Topics = []string{"foo,qux"}
+	conf.KafkaMulti.TopicPattern = "^baz_"
+
+	input, err := NewKafkaMulti(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topics, err := input.(*KafkaMulti).resolveTopics(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := []string{"baz_1", "baz_2", "foo", "qux"}, topics; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong topics: %v != %v", act, exp)
+	}
+
+	conf.KafkaMulti.Topics = []string{}
+	conf.KafkaMulti.TopicPattern = "^nope"
+
+	if input, err = NewKafkaMulti(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = input.(*KafkaMulti).resolveTopics(client); err == nil {
+		t.Error("Expected error from no matched topics")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestKafkaMultiConsume(t *testing.T) {
+	conf := NewConfig()
+	conf.KafkaMulti.Topics = []string{"foo"}
+	conf.KafkaMulti.CommitPeriodMS = 10
+
+	broker := newKafkaMultiMockBroker(t, conf.KafkaMulti.ConsumerGroup)
+	defer broker.Close()
+	conf.KafkaMulti.Addresses = []string{broker.Addr()}
+
+	stats := &gaugeStats{gauges: map[string]int64{}}
+	input, err := NewKafkaMulti(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resChan := make(chan types.Response)
+	if err = input.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		input.CloseAsync()
+		if err := input.WaitForClose(time.Second * 5); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	foo0 := kafkaPartition{topic: "foo", partition: 0}
+	foo1 := kafkaPartition{topic: "foo", partition: 1}
+	partitions := []kafkaPartition{foo0, foo1}
+
+	received := map[string]string{}
+	for i := 0; i < 2; i++ {
+		var msg types.Message
+		select {
+		case msg = <-input.MessageChan():
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timed out waiting for message")
+		}
+		received[msg.GetMetadata(0, "kafka_partition")] = string(msg.Parts[0])
+
+		// The first message is held downstream, and is therefore not
+		// committed until the second message is acknowledged.
+		var res types.Response = types.NewSimpleResponse(nil)
+		if i == 0 {
+			res = types.NewUnacknowledgedResponse()
+		}
+		select {
+		case resChan <- res:
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timed out waiting for response")
+		}
+		if i == 0 {
+			<-time.After(time.Millisecond * 50)
+			if commits := committedOffsets(broker, partitions); len(commits) > 0 {
+				t.Errorf("Unexpected commit of held message: %v", commits)
+			}
+		}
+	}
+	if exp := map[string]string{"0": "foo0", "1": "foo1"}; !reflect.DeepEqual(exp, received) {
+		t.Errorf("Wrong messages: %v != %v", received, exp)
+	}
+
+	exp := map[kafkaPartition]int64{foo0: 6, foo1: 1}
+	expLag := map[string]int64{
+		"input.kafka_multi.lag.foo.0": 4,
+		"input.kafka_multi.lag.foo.1": 9,
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		committed := map[kafkaPartition]int64{}
+		for _, offsets := range committedOffsets(broker, partitions) {
+			for p, offset := range offsets {
+				if offset < committed[p] {
+					t.Errorf("Offset of %v committed out of order: %v < %v", p, offset, committed[p])
+				}
+				committed[p] = offset
+			}
+		}
+		lag := map[string]int64{}
+		for path := range expLag {
+			if value, exists := stats.get(path); exists {
+				lag[path] = value
+			}
+		}
+		if reflect.DeepEqual(exp, committed) && reflect.DeepEqual(expLag, lag) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Wrong commits or lag: %v != %v, %v != %v", committed, exp, lag, expLag)
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+}
+
+func TestKafkaMultiCloseAfterFailedConnect(t *testing.T) {
+	conf := NewConfig()
+	conf.KafkaMulti.Topics = []string{"foo"}
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	conf.KafkaMulti.Addresses = []string{broker.Addr()}
+
+	// The leader of partition 1 is unreachable, therefore consuming it fails
+	// after the consumer of partition 0 has started.
+	deadBroker := sarama.NewMockBroker(t, 2)
+	deadBroker.Close()
+
+	group := conf.KafkaMulti.ConsumerGroup
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetBroker(deadBroker.Addr(), deadBroker.BrokerID()).
+			SetLeader("foo", 0, broker.BrokerID()).
+			SetLeader("foo", 1, deadBroker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, group, broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(group, "foo", 0, 0, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetVersion(1).
+			SetOffset("foo", 0, sarama.OffsetOldest, 0).
+			SetOffset("foo", 0, sarama.OffsetNewest, 10),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetVersion(4).
+			SetMessage("foo", 0, 0, sarama.StringEncoder("foo0")).
+			SetHighWaterMark("foo", 0, 10),
+	})
+
+	input, err := NewKafkaMulti(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = input.StartListening(make(chan types.Response)); err != nil {
+		t.Fatal(err)
+	}
+
+	<-time.After(time.Millisecond * 500)
+
+	input.CloseAsync()
+	if err = input.WaitForClose(time.Second * 5); err != nil {
+		t.Error(err)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,55 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+//------------------------------------------------------------------------------
+
+func TestKafkaMessageMetadata(t *testing.T) {
+	msg := kafkaMessage(&sarama.ConsumerMessage{
+		Key:       []byte("foo"),
+		Value:     []byte("hello world"),
+		Topic:     "bar",
+		Partition: 3,
+		Offset:    42,
+	})
+
+	if exp, act := "hello world", string(msg.Parts[0]); exp != act {
+		t.Errorf("Wrong message contents: %v != %v", act, exp)
+	}
+	for k, exp := range map[string]string{
+		"kafka_key":       "foo",
+		"kafka_topic":     "bar",
+		"kafka_partition": "3",
+		"kafka_offset":    "42",
+	} {
+		if act := msg.GetMetadata(0, k); exp != act {
+			t.Errorf("Wrong metadata value for %v: %v != %v", k, act, exp)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -23,6 +23,7 @@ package input
 import (
 	"fmt"
 	"math/rand"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -45,7 +46,10 @@ semantics.
 
 Tracking and persisting offsets through a durable name is also optional and
 works with or without a queue. If a durable name is not provided then subjects
-are consumed from the most recently published message.`,
+are consumed from the most recently published message.
+
+The subject and sequence of each message are added as the metadata fields
+'nats_stream_subject' and 'nats_stream_sequence'.`,
 	}
 }
 
@@ -157,8 +161,12 @@ func (n *NATSStream) loop() {
 	handler := func(m *stan.Msg) {
 		n.stats.Incr("input.nats_stream.count", 1)
 
+		msg := types.Message{Parts: [][]byte{m.Data}}
+		msg.SetMetadata(0, "nats_stream_subject", m.Subject)
+		msg.SetMetadata(0, "nats_stream_sequence", strconv.FormatUint(m.Sequence, 10))
+
 		select {
-		case n.messages <- types.Message{Parts: [][]byte{m.Data}}:
+		case n.messages <- msg:
 		case <-n.closeChan:
 			return
 		}
@@ -34,6 +34,61 @@ import (
 
 //------------------------------------------------------------------------------
 
+// delimSplitFunc returns a bufio.SplitFunc that splits data by a delimiter. At
+// EOF any remaining non-terminated data is returned as a final token.
+func delimSplitFunc(delim []byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+
+		if i := bytes.Index(data, delim); i >= 0 {
+			// We have a full terminated line.
+			return i + len(delim), data[0:i], nil
+		}
+
+		// If we're at EOF, we have a final, non-terminated line. Return it.
+		if atEOF {
+			return len(data), data, nil
+		}
+
+		// Request more data.
+		return 0, nil, nil
+	}
+}
+
+// partsBuilder builds messages from delimited tokens. If multipart is false
+// each non-empty token is a message, otherwise each non-empty token is a
+// message part and an empty token indicates the end of a message.
+type partsBuilder struct {
+	multipart bool
+	parts     [][]byte
+}
+
+// push adds a token, which is copied, and returns the parts of a message if
+// the token completes one.
+func (b *partsBuilder) push(token []byte) [][]byte {
+	if len(token) > 0 {
+		newPart := make([]byte, len(token))
+		copy(newPart, token)
+		if !b.multipart {
+			return [][]byte{newPart}
+		}
+		b.parts = append(b.parts, newPart)
+		return nil
+	}
+	if !b.multipart {
+		return nil
+	}
+
+	// Empty line means we're finished reading parts for this message.
+	parts := b.parts
+	b.parts = nil
+	return parts
+}
+
+//------------------------------------------------------------------------------
+
 // reader is an input type that reads messages from an io.Reader type.
 type reader struct {
 	running int32
@@ -97,45 +152,16 @@ func (s *reader) readLoop() {
 	if s.maxBuffer != bufio.MaxScanTokenSize {
 		scanner.Buffer([]byte{}, s.maxBuffer)
 	}
-	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
+	scanner.Split(delimSplitFunc(s.customDelim))
 
-		if i := bytes.Index(data, s.customDelim); i >= 0 {
-			// We have a full terminated line.
-			return i + len(s.customDelim), data[0:i], nil
-		}
-
-		// If we're at EOF, we have a final, non-terminated line. Return it.
-		if atEOF {
-			return len(data), data, nil
-		}
-
-		// Request more data.
-		return 0, nil, nil
-	})
-
-	var partsToSend, parts [][]byte
+	var partsToSend [][]byte
+	builder := partsBuilder{multipart: s.multipart}
 
 	for atomic.LoadInt32(&s.running) == 1 {
 		// If no bytes then read a line
 		if len(partsToSend) == 0 {
 			if scanner.Scan() {
-				newPart := make([]byte, len(scanner.Bytes()))
-				copy(newPart, scanner.Bytes())
-				if len(newPart) > 0 {
-					if s.multipart {
-						parts = append(parts, newPart)
-					} else {
-						partsToSend = append(partsToSend, newPart)
-					}
-				} else if s.multipart {
-					// Empty line means we're finished reading parts for this
-					// message.
-					partsToSend = parts
-					parts = nil
-				}
+				partsToSend = builder.push(scanner.Bytes())
 			} else {
 				if err := scanner.Err(); err != nil {
 					s.log.Errorf("Failed to read input: %v\n", err)
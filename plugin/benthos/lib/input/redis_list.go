@@ -0,0 +1,312 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/go-redis/redis"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["redis_list"] = typeSpec{
+		constructor: NewRedisList,
+		description: `
+Pops messages from the end of a Redis list using BRPOPLPUSH, which atomically
+moves each message into a processing list. A message is only removed from the
+processing list once it has been acknowledged, giving at-least-once delivery.
+
+On start up any messages left in the processing list (from a previous run that
+was not shut down cleanly) are moved back onto the list in order to be
+reprocessed. Therefore each consumer of a list should have its own processing
+list, which defaults to the name of the list with the suffix '_processing'.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// RedisListConfig is configuration for the RedisList input type.
+type RedisListConfig struct {
+	URL           string `json:"url" yaml:"url"`
+	Key           string `json:"key" yaml:"key"`
+	ProcessingKey string `json:"processing_key" yaml:"processing_key"`
+	TimeoutMS     int64  `json:"timeout_ms" yaml:"timeout_ms"`
+}
+
+// NewRedisListConfig creates a new RedisListConfig with default values.
+func NewRedisListConfig() RedisListConfig {
+	return RedisListConfig{
+		URL:           "tcp://localhost:6379",
+		Key:           "benthos_list",
+		ProcessingKey: "",
+		TimeoutMS:     5000,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// RedisList is an input type that reads messages from a Redis list.
+type RedisList struct {
+	running int32
+
+	client *redis.Client
+
+	url           *url.URL
+	processingKey string
+	timeout       time.Duration
+
+	conf  Config
+	stats metrics.Type
+	log   log.Modular
+
+	messages  chan types.Message
+	responses <-chan types.Response
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewRedisList creates a new RedisList input type.
+func NewRedisList(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	r := &RedisList{
+		running:       1,
+		conf:          conf,
+		stats:         stats,
+		log:           log.NewModule(".input.redis_list"),
+		processingKey: conf.RedisList.ProcessingKey,
+		timeout:       time.Duration(conf.RedisList.TimeoutMS) * time.Millisecond,
+		messages:      make(chan types.Message),
+		responses:     nil,
+		closeChan:     make(chan struct{}),
+		closedChan:    make(chan struct{}),
+	}
+	if len(r.processingKey) == 0 {
+		r.processingKey = conf.RedisList.Key + "_processing"
+	}
+
+	var err error
+	r.url, err = url.Parse(r.conf.RedisList.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+//------------------------------------------------------------------------------
+
+// connect establishes a connection to a Redis server.
+func (r *RedisList) connect() error {
+	var pass string
+	if r.url.User != nil {
+		pass, _ = r.url.User.Password()
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     r.url.Host,
+		Network:  r.url.Scheme,
+		Password: pass,
+	})
+
+	if _, err := client.Ping().Result(); err != nil {
+		return err
+	}
+
+	r.client = client
+	return nil
+}
+
+// disconnect safely closes a connection to a Redis server.
+func (r *RedisList) disconnect() error {
+	if r.client != nil {
+		err := r.client.Close()
+		r.client = nil
+		return err
+	}
+	return nil
+}
+
+// requeue moves any messages left in the processing list back onto the list.
+func (r *RedisList) requeue() error {
+	for {
+		_, err := r.client.RPopLPush(r.processingKey, r.conf.RedisList.Key).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r.stats.Incr("input.redis_list.requeued", 1)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (r *RedisList) loop() {
+	defer func() {
+		atomic.StoreInt32(&r.running, 0)
+		if err := r.disconnect(); err != nil {
+			r.log.Errorf("Failed to disconnect redis client: %v\n", err)
+		}
+
+		close(r.messages)
+		close(r.closedChan)
+	}()
+
+	for {
+		err := r.connect()
+		if err == nil {
+			if err = r.requeue(); err != nil {
+				r.disconnect()
+			}
+		}
+		if err != nil {
+			r.log.Errorf("Failed to connect to Redis: %v\n", err)
+			select {
+			case <-time.After(time.Second):
+			case <-r.closeChan:
+				return
+			}
+		} else {
+			break
+		}
+	}
+	r.log.Infof("Receiving messages from Redis list: %s\n", r.conf.RedisList.Key)
+
+	var data *string
+	var pending []string
+
+	for atomic.LoadInt32(&r.running) == 1 {
+		for r.client == nil {
+			r.log.Warnln("Lost Redis connection, attempting to reconnect.")
+			if err := r.connect(); err != nil {
+				r.stats.Incr("input.redis_list.reconnect.error", 1)
+				select {
+				case <-time.After(time.Second):
+				case <-r.closeChan:
+					return
+				}
+			} else {
+				r.log.Warnln("Successfully reconnected to Redis.")
+				r.stats.Incr("input.redis_list.reconnect.success", 1)
+			}
+		}
+
+		// If no bytes then read a message
+		if data == nil {
+			res, err := r.client.BRPopLPush(
+				r.conf.RedisList.Key, r.processingKey, r.timeout,
+			).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				r.log.Errorf("Failed to pop from Redis list: %v\n", err)
+				r.stats.Incr("input.redis_list.receive.error", 1)
+				r.disconnect()
+				continue
+			}
+			data = &res
+			r.stats.Incr("input.redis_list.count", 1)
+		}
+
+		// If bytes are read then try and propagate.
+		select {
+		case r.messages <- types.Message{Parts: [][]byte{[]byte(*data)}}:
+		case <-r.closeChan:
+			return
+		}
+		res, open := <-r.responses
+		if !open {
+			return
+		}
+		if resErr := res.Error(); resErr != nil {
+			r.stats.Incr("input.redis_list.send.error", 1)
+			continue
+		}
+		r.stats.Incr("input.redis_list.send.success", 1)
+		pending = append(pending, *data)
+		data = nil
+		if !res.SkipAck() {
+			r.ack(pending)
+			pending = nil
+		}
+	}
+}
+
+// ack removes messages from the processing list. Messages that fail to be
+// removed remain in the processing list and are requeued on the next start up.
+func (r *RedisList) ack(pending []string) {
+	_, err := r.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, p := range pending {
+			pipe.LRem(r.processingKey, 1, p)
+		}
+		return nil
+	})
+	if err != nil {
+		r.log.Errorf("Failed to acknowledge messages: %v\n", err)
+		r.stats.Incr("input.redis_list.ack.error", 1)
+		r.disconnect()
+		return
+	}
+	r.stats.Incr("input.redis_list.ack.success", 1)
+}
+
+// StartListening sets the channel used by the input to validate message
+// receipt.
+func (r *RedisList) StartListening(responses <-chan types.Response) error {
+	if r.responses != nil {
+		return types.ErrAlreadyStarted
+	}
+	r.responses = responses
+	go r.loop()
+	return nil
+}
+
+// MessageChan returns the messages channel.
+func (r *RedisList) MessageChan() <-chan types.Message {
+	return r.messages
+}
+
+// CloseAsync shuts down the RedisList input and stops processing requests.
+func (r *RedisList) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&r.running, 1, 0) {
+		close(r.closeChan)
+	}
+}
+
+// WaitForClose blocks until the RedisList input has closed down.
+func (r *RedisList) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-r.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
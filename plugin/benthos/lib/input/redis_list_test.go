@@ -0,0 +1,133 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package input
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/benthos/lib/util/test"
+)
+
+//------------------------------------------------------------------------------
+
+// redisWaitFor polls a condition until it is true, failing the test if it
+// takes too long.
+func redisWaitFor(t *testing.T, desc string, cond func() bool) {
+	for i := 0; !cond(); i++ {
+		if i >= 500 {
+			t.Fatalf("Timed out waiting for %v", desc)
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+}
+
+// redisReceive reads a message from an input and responds to it.
+func redisReceive(t *testing.T, in Type, resChan chan<- types.Response, res types.Response) types.Message {
+	var msg types.Message
+	select {
+	case msg = <-in.MessageChan():
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for message")
+	}
+	select {
+	case resChan <- res:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for response to be read")
+	}
+	return msg
+}
+
+//------------------------------------------------------------------------------
+
+func TestRedisListRequeueAndAck(t *testing.T) {
+	server, err := test.NewRedisServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// A message left in the processing list by a previous run.
+	server.SetList("foo", "bar")
+	server.SetList("foo_processing", "baz")
+
+	conf := NewConfig()
+	conf.RedisList.URL = server.URL()
+	conf.RedisList.Key = "foo"
+	conf.RedisList.TimeoutMS = 1000
+
+	in, err := NewRedisList(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resChan := make(chan types.Response)
+	if err = in.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		in.CloseAsync()
+		if err := in.WaitForClose(time.Second * 5); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	errTest := errors.New("test err")
+	if act := string(redisReceive(t, in, resChan, types.NewSimpleResponse(errTest)).Parts[0]); act != "bar" {
+		t.Errorf("Wrong message: %v != bar", act)
+	}
+	if exp, act := []string{"baz"}, server.List("foo"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Expected processing list to be requeued: %v != %v", act, exp)
+	}
+
+	// A failed message is sent again and remains in the processing list.
+	if act := string(redisReceive(t, in, resChan, types.NewUnacknowledgedResponse()).Parts[0]); act != "bar" {
+		t.Errorf("Wrong message: %v != bar", act)
+	}
+	if act := string(redisReceive(t, in, resChan, types.NewSimpleResponse(nil)).Parts[0]); act != "baz" {
+		t.Errorf("Wrong message: %v != baz", act)
+	}
+
+	redisWaitFor(t, "messages to be acknowledged", func() bool {
+		return len(server.List("foo_processing")) == 0
+	})
+
+	var cmds []string
+	for _, cmd := range server.Commands() {
+		switch cmd[0] {
+		case "BRPOPLPUSH", "LREM":
+			cmds = append(cmds, cmd[0]+" "+cmd[len(cmd)-1])
+		}
+	}
+	exp := []string{"BRPOPLPUSH 1", "BRPOPLPUSH 1", "LREM bar", "LREM baz"}
+	if len(cmds) > len(exp) {
+		cmds = cmds[:len(exp)]
+	}
+	if !reflect.DeepEqual(exp, cmds) {
+		t.Errorf("Wrong commands: %v != %v", cmds, exp)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,485 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/go-redis/redis"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["redis_streams"] = typeSpec{
+		constructor: NewRedisStreams,
+		description: `
+Pulls messages from Redis (v5.0+) streams with the XREADGROUP command. The
+'client_id' should be unique for each consumer of a group.
+
+Messages are acknowledged with XACK once they have been successfully
+propagated, giving at-least-once delivery. On start up any messages previously
+delivered to this consumer that were not acknowledged are read again. Messages
+delivered to other consumers of the group that have remained unacknowledged for
+longer than 'claim_idle_ms' are claimed with XCLAIM and reprocessed, which
+allows recovery from consumers that have disappeared. Setting 'claim_idle_ms'
+to zero disables claiming.
+
+The field 'body_key' of a stream entry is used as the contents of the message,
+all other fields are added as metadata along with the stream name and entry ID
+as 'redis_stream' and 'redis_stream_id' respectively.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// RedisStreamsConfig is configuration for the RedisStreams input type.
+type RedisStreamsConfig struct {
+	URL             string   `json:"url" yaml:"url"`
+	Streams         []string `json:"streams" yaml:"streams"`
+	BodyKey         string   `json:"body_key" yaml:"body_key"`
+	ConsumerGroup   string   `json:"consumer_group" yaml:"consumer_group"`
+	ClientID        string   `json:"client_id" yaml:"client_id"`
+	CreateStreams   bool     `json:"create_streams" yaml:"create_streams"`
+	StartFromOldest bool     `json:"start_from_oldest" yaml:"start_from_oldest"`
+	Limit           int64    `json:"limit" yaml:"limit"`
+	TimeoutMS       int64    `json:"timeout_ms" yaml:"timeout_ms"`
+	ClaimIdleMS     int64    `json:"claim_idle_ms" yaml:"claim_idle_ms"`
+}
+
+// NewRedisStreamsConfig creates a new RedisStreamsConfig with default values.
+func NewRedisStreamsConfig() RedisStreamsConfig {
+	return RedisStreamsConfig{
+		URL:             "tcp://localhost:6379",
+		Streams:         []string{"benthos_stream"},
+		BodyKey:         "body",
+		ConsumerGroup:   "benthos_group",
+		ClientID:        "benthos_consumer",
+		CreateStreams:   true,
+		StartFromOldest: true,
+		Limit:           10,
+		TimeoutMS:       5000,
+		ClaimIdleMS:     60000,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// redisStreamEntry is an entry read from a stream that has yet to be
+// propagated.
+type redisStreamEntry struct {
+	stream string
+	msg    redis.XMessage
+}
+
+// RedisStreams is an input type that reads messages from Redis streams using a
+// consumer group.
+type RedisStreams struct {
+	running int32
+
+	client *redis.Client
+
+	url       *url.URL
+	timeout   time.Duration
+	claimIdle time.Duration
+	lastClaim time.Time
+
+	// Entries read but not yet propagated, and the IDs from which entries
+	// still pending for this consumer are to be read before new entries.
+	backlog     []redisStreamEntry
+	pendingFrom map[string]string
+
+	conf  Config
+	stats metrics.Type
+	log   log.Modular
+
+	messages  chan types.Message
+	responses <-chan types.Response
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewRedisStreams creates a new RedisStreams input type.
+func NewRedisStreams(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	r := &RedisStreams{
+		running:    1,
+		conf:       conf,
+		stats:      stats,
+		log:        log.NewModule(".input.redis_streams"),
+		timeout:    time.Duration(conf.RedisStreams.TimeoutMS) * time.Millisecond,
+		claimIdle:  time.Duration(conf.RedisStreams.ClaimIdleMS) * time.Millisecond,
+		messages:   make(chan types.Message),
+		responses:  nil,
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+
+	if len(conf.RedisStreams.Streams) == 0 {
+		return nil, fmt.Errorf("at least one stream must be specified")
+	}
+
+	var err error
+	r.url, err = url.Parse(r.conf.RedisStreams.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+//------------------------------------------------------------------------------
+
+// connect establishes a connection to a Redis server and ensures that the
+// consumer group exists for each stream.
+func (r *RedisStreams) connect() error {
+	var pass string
+	if r.url.User != nil {
+		pass, _ = r.url.User.Password()
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     r.url.Host,
+		Network:  r.url.Scheme,
+		Password: pass,
+	})
+
+	if _, err := client.Ping().Result(); err != nil {
+		return err
+	}
+
+	start := "$"
+	if r.conf.RedisStreams.StartFromOldest {
+		start = "0"
+	}
+	for _, s := range r.conf.RedisStreams.Streams {
+		var err error
+		if r.conf.RedisStreams.CreateStreams {
+			err = client.XGroupCreateMkStream(s, r.conf.RedisStreams.ConsumerGroup, start).Err()
+		} else {
+			err = client.XGroupCreate(s, r.conf.RedisStreams.ConsumerGroup, start).Err()
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			client.Close()
+			return fmt.Errorf("failed to create consumer group for stream '%v': %v", s, err)
+		}
+	}
+
+	r.client = client
+	r.backlog = nil
+	r.pendingFrom = map[string]string{}
+	for _, s := range r.conf.RedisStreams.Streams {
+		r.pendingFrom[s] = "0"
+	}
+	return nil
+}
+
+// disconnect safely closes a connection to a Redis server.
+func (r *RedisStreams) disconnect() error {
+	if r.client != nil {
+		err := r.client.Close()
+		r.client = nil
+		return err
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// redisStreamsMessage creates a message from a stream entry, where fields other
+// than the body are added as metadata.
+func redisStreamsMessage(bodyKey, stream string, xmsg redis.XMessage) types.Message {
+	var body []byte
+	if v, exists := xmsg.Values[bodyKey]; exists {
+		body = []byte(fmt.Sprintf("%v", v))
+	}
+	msg := types.Message{Parts: [][]byte{body}}
+	for k, v := range xmsg.Values {
+		if k != bodyKey {
+			msg.SetMetadata(0, k, fmt.Sprintf("%v", v))
+		}
+	}
+	msg.SetMetadata(0, "redis_stream", stream)
+	msg.SetMetadata(0, "redis_stream_id", xmsg.ID)
+	return msg
+}
+
+// read adds entries to the backlog, either by reading pending entries of this
+// consumer, claiming stale entries of other consumers, or reading new entries.
+func (r *RedisStreams) read() error {
+	streams := r.conf.RedisStreams.Streams
+
+	if len(r.pendingFrom) > 0 {
+		args := &redis.XReadGroupArgs{
+			Group:    r.conf.RedisStreams.ConsumerGroup,
+			Consumer: r.conf.RedisStreams.ClientID,
+			Count:    r.conf.RedisStreams.Limit,
+			Block:    -1,
+		}
+		var ids []string
+		for s, id := range r.pendingFrom {
+			args.Streams = append(args.Streams, s)
+			ids = append(ids, id)
+		}
+		args.Streams = append(args.Streams, ids...)
+
+		res, err := r.client.XReadGroup(args).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		exhausted := map[string]struct{}{}
+		for s := range r.pendingFrom {
+			exhausted[s] = struct{}{}
+		}
+		for _, strm := range res {
+			for _, xmsg := range strm.Messages {
+				delete(exhausted, strm.Stream)
+				r.pendingFrom[strm.Stream] = xmsg.ID
+				r.backlog = append(r.backlog, redisStreamEntry{stream: strm.Stream, msg: xmsg})
+			}
+		}
+		for s := range exhausted {
+			delete(r.pendingFrom, s)
+		}
+		if len(r.backlog) > 0 {
+			return nil
+		}
+	}
+
+	if r.claimIdle > 0 && time.Since(r.lastClaim) >= r.claimIdle {
+		r.lastClaim = time.Now()
+		if err := r.claim(); err != nil {
+			return err
+		}
+		if len(r.backlog) > 0 {
+			return nil
+		}
+	}
+
+	args := &redis.XReadGroupArgs{
+		Group:    r.conf.RedisStreams.ConsumerGroup,
+		Consumer: r.conf.RedisStreams.ClientID,
+		Streams:  make([]string, 0, len(streams)*2),
+		Count:    r.conf.RedisStreams.Limit,
+		Block:    r.timeout,
+	}
+	args.Streams = append(args.Streams, streams...)
+	for range streams {
+		args.Streams = append(args.Streams, ">")
+	}
+	res, err := r.client.XReadGroup(args).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	for _, strm := range res {
+		for _, xmsg := range strm.Messages {
+			r.backlog = append(r.backlog, redisStreamEntry{stream: strm.Stream, msg: xmsg})
+		}
+	}
+	return nil
+}
+
+// claim takes ownership of entries that have been pending with other consumers
+// for longer than the claim idle period.
+func (r *RedisStreams) claim() error {
+	for _, s := range r.conf.RedisStreams.Streams {
+		pending, err := r.client.XPendingExt(&redis.XPendingExtArgs{
+			Stream: s,
+			Group:  r.conf.RedisStreams.ConsumerGroup,
+			Start:  "-",
+			End:    "+",
+			Count:  r.conf.RedisStreams.Limit,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		var ids []string
+		for _, p := range pending {
+			if p.Consumer != r.conf.RedisStreams.ClientID && p.Idle >= r.claimIdle {
+				ids = append(ids, p.Id)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		claimed, err := r.client.XClaim(&redis.XClaimArgs{
+			Stream:   s,
+			Group:    r.conf.RedisStreams.ConsumerGroup,
+			Consumer: r.conf.RedisStreams.ClientID,
+			MinIdle:  r.claimIdle,
+			Messages: ids,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		for _, xmsg := range claimed {
+			r.backlog = append(r.backlog, redisStreamEntry{stream: s, msg: xmsg})
+		}
+		r.stats.Incr("input.redis_streams.claimed", int64(len(claimed)))
+	}
+	return nil
+}
+
+// ack acknowledges entries of each stream. Entries that fail to be
+// acknowledged are read again on the next connection.
+func (r *RedisStreams) ack(pending map[string][]string) {
+	_, err := r.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for s, ids := range pending {
+			pipe.XAck(s, r.conf.RedisStreams.ConsumerGroup, ids...)
+		}
+		return nil
+	})
+	if err != nil {
+		r.log.Errorf("Failed to acknowledge messages: %v\n", err)
+		r.stats.Incr("input.redis_streams.ack.error", 1)
+		r.disconnect()
+		return
+	}
+	r.stats.Incr("input.redis_streams.ack.success", 1)
+}
+
+//------------------------------------------------------------------------------
+
+func (r *RedisStreams) loop() {
+	defer func() {
+		atomic.StoreInt32(&r.running, 0)
+		if err := r.disconnect(); err != nil {
+			r.log.Errorf("Failed to disconnect redis client: %v\n", err)
+		}
+
+		close(r.messages)
+		close(r.closedChan)
+	}()
+
+	for {
+		if err := r.connect(); err != nil {
+			r.log.Errorf("Failed to connect to Redis: %v\n", err)
+			select {
+			case <-time.After(time.Second):
+			case <-r.closeChan:
+				return
+			}
+		} else {
+			break
+		}
+	}
+	r.log.Infof(
+		"Receiving messages from Redis streams: %s\n",
+		strings.Join(r.conf.RedisStreams.Streams, ", "),
+	)
+
+	pending := map[string][]string{}
+
+	for atomic.LoadInt32(&r.running) == 1 {
+		for r.client == nil {
+			r.log.Warnln("Lost Redis connection, attempting to reconnect.")
+			if err := r.connect(); err != nil {
+				r.stats.Incr("input.redis_streams.reconnect.error", 1)
+				select {
+				case <-time.After(time.Second):
+				case <-r.closeChan:
+					return
+				}
+			} else {
+				r.log.Warnln("Successfully reconnected to Redis.")
+				r.stats.Incr("input.redis_streams.reconnect.success", 1)
+			}
+		}
+
+		if len(r.backlog) == 0 {
+			if err := r.read(); err != nil {
+				r.log.Errorf("Failed to read from Redis streams: %v\n", err)
+				r.stats.Incr("input.redis_streams.receive.error", 1)
+				r.disconnect()
+				continue
+			}
+			if len(r.backlog) == 0 {
+				continue
+			}
+			r.stats.Incr("input.redis_streams.count", int64(len(r.backlog)))
+		}
+
+		entry := r.backlog[0]
+		select {
+		case r.messages <- redisStreamsMessage(r.conf.RedisStreams.BodyKey, entry.stream, entry.msg):
+		case <-r.closeChan:
+			return
+		}
+		res, open := <-r.responses
+		if !open {
+			return
+		}
+		if resErr := res.Error(); resErr != nil {
+			r.stats.Incr("input.redis_streams.send.error", 1)
+			continue
+		}
+		r.stats.Incr("input.redis_streams.send.success", 1)
+		r.backlog = r.backlog[1:]
+		pending[entry.stream] = append(pending[entry.stream], entry.msg.ID)
+		if !res.SkipAck() {
+			r.ack(pending)
+			pending = map[string][]string{}
+		}
+	}
+}
+
+// StartListening sets the channel used by the input to validate message
+// receipt.
+func (r *RedisStreams) StartListening(responses <-chan types.Response) error {
+	if r.responses != nil {
+		return types.ErrAlreadyStarted
+	}
+	r.responses = responses
+	go r.loop()
+	return nil
+}
+
+// MessageChan returns the messages channel.
+func (r *RedisStreams) MessageChan() <-chan types.Message {
+	return r.messages
+}
+
+// CloseAsync shuts down the RedisStreams input and stops processing requests.
+func (r *RedisStreams) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&r.running, 1, 0) {
+		close(r.closeChan)
+	}
+}
+
+// WaitForClose blocks until the RedisStreams input has closed down.
+func (r *RedisStreams) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-r.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
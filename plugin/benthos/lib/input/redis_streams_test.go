@@ -0,0 +1,231 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/benthos/lib/util/test"
+	"github.com/go-redis/redis"
+)
+
+//------------------------------------------------------------------------------
+
+func newRedisStreamsTestInput(t *testing.T, url, clientID string, claimIdleMS int64) (Type, chan types.Response) {
+	conf := NewConfig()
+	conf.RedisStreams.URL = url
+	conf.RedisStreams.Streams = []string{"foo"}
+	conf.RedisStreams.ClientID = clientID
+	conf.RedisStreams.TimeoutMS = 100
+	conf.RedisStreams.ClaimIdleMS = claimIdleMS
+
+	in, err := NewRedisStreams(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resChan := make(chan types.Response)
+	if err = in.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	return in, resChan
+}
+
+func closeRedisStreamsTestInput(t *testing.T, in Type) {
+	in.CloseAsync()
+	if err := in.WaitForClose(time.Second * 5); err != nil {
+		t.Error(err)
+	}
+}
+
+// redisCommandNames returns the names of commands received by a server that are
+// within a set.
+func redisCommandNames(server *test.RedisServer, names ...string) []string {
+	var cmds []string
+	for _, cmd := range server.Commands() {
+		for _, n := range names {
+			if cmd[0] == n {
+				cmds = append(cmds, n)
+			}
+		}
+	}
+	return cmds
+}
+
+//------------------------------------------------------------------------------
+
+func TestRedisStreamsMessage(t *testing.T) {
+	msg := redisStreamsMessage("body", "foo", redis.XMessage{
+		ID: "1526919030474-55",
+		Values: map[string]interface{}{
+			"body":   "hello world",
+			"source": "bar",
+		},
+	})
+
+	if len(msg.Parts) != 1 {
+		t.Fatalf("Wrong count of parts: %v", len(msg.Parts))
+	}
+	if exp, act := "hello world", string(msg.Parts[0]); exp != act {
+		t.Errorf("Wrong body: %v != %v", act, exp)
+	}
+	for k, exp := range map[string]string{
+		"source":          "bar",
+		"redis_stream":    "foo",
+		"redis_stream_id": "1526919030474-55",
+		"body":            "",
+	} {
+		if act := msg.GetMetadata(0, k); act != exp {
+			t.Errorf("Wrong metadata value for '%v': %v != %v", k, act, exp)
+		}
+	}
+
+	msg = redisStreamsMessage("body", "foo", redis.XMessage{
+		ID:     "1526919030474-56",
+		Values: map[string]interface{}{"other": "baz"},
+	})
+	if len(msg.Parts) != 1 || len(msg.Parts[0]) != 0 {
+		t.Errorf("Expected empty part: %s", msg.Parts)
+	}
+}
+
+func TestRedisStreamsAckAfterSuccess(t *testing.T) {
+	server, err := test.NewRedisServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	id1 := server.AddStreamEntry("foo", map[string]string{"body": "hello 1"})
+	id2 := server.AddStreamEntry("foo", map[string]string{"body": "hello 2"})
+
+	in, resChan := newRedisStreamsTestInput(t, server.URL(), "c1", 0)
+	defer closeRedisStreamsTestInput(t, in)
+
+	// A failed message is sent again and is not acknowledged.
+	msg := redisReceive(t, in, resChan, types.NewSimpleResponse(errors.New("test err")))
+	if act := msg.GetMetadata(0, "redis_stream_id"); act != id1 {
+		t.Errorf("Wrong message: %v != %v", act, id1)
+	}
+	if cmds := redisCommandNames(server, "XACK"); len(cmds) > 0 {
+		t.Errorf("Unexpected acknowledgement after failed send: %v", cmds)
+	}
+	msg = redisReceive(t, in, resChan, types.NewSimpleResponse(nil))
+	if act := string(msg.Parts[0]); act != "hello 1" {
+		t.Errorf("Wrong message: %v != hello 1", act)
+	}
+
+	redisWaitFor(t, "first message to be acknowledged", func() bool {
+		return len(server.Pending("foo", "benthos_group")) == 1
+	})
+	if act := server.Pending("foo", "benthos_group")[0].ID; act != id2 {
+		t.Errorf("Wrong pending entry: %v != %v", act, id2)
+	}
+
+	// Acknowledgements are held until a response without skip ack.
+	redisReceive(t, in, resChan, types.NewUnacknowledgedResponse())
+	id3 := server.AddStreamEntry("foo", map[string]string{"body": "hello 3"})
+	msg = redisReceive(t, in, resChan, types.NewSimpleResponse(nil))
+	if act := msg.GetMetadata(0, "redis_stream_id"); act != id3 {
+		t.Errorf("Wrong message: %v != %v", act, id3)
+	}
+
+	redisWaitFor(t, "all messages to be acknowledged", func() bool {
+		return len(server.Pending("foo", "benthos_group")) == 0
+	})
+	if exp, act := []string{"XACK", "XACK"}, redisCommandNames(server, "XACK"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong acknowledgements: %v != %v", act, exp)
+	}
+}
+
+func TestRedisStreamsPendingOnRestart(t *testing.T) {
+	server, err := test.NewRedisServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	id := server.AddStreamEntry("foo", map[string]string{"body": "hello world"})
+
+	in, resChan := newRedisStreamsTestInput(t, server.URL(), "c1", 0)
+	redisReceive(t, in, resChan, types.NewUnacknowledgedResponse())
+	closeRedisStreamsTestInput(t, in)
+
+	in, resChan = newRedisStreamsTestInput(t, server.URL(), "c1", 0)
+	defer closeRedisStreamsTestInput(t, in)
+
+	msg := redisReceive(t, in, resChan, types.NewSimpleResponse(nil))
+	if act := msg.GetMetadata(0, "redis_stream_id"); act != id {
+		t.Errorf("Wrong message: %v != %v", act, id)
+	}
+	redisWaitFor(t, "message to be acknowledged", func() bool {
+		return len(server.Pending("foo", "benthos_group")) == 0
+	})
+}
+
+func TestRedisStreamsClaim(t *testing.T) {
+	server, err := test.NewRedisServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	id := server.AddStreamEntry("foo", map[string]string{"body": "hello world"})
+
+	// A consumer that disappears without acknowledging its message.
+	in, resChan := newRedisStreamsTestInput(t, server.URL(), "c1", 0)
+	redisReceive(t, in, resChan, types.NewUnacknowledgedResponse())
+	closeRedisStreamsTestInput(t, in)
+
+	<-time.After(time.Millisecond * 100)
+
+	in, resChan = newRedisStreamsTestInput(t, server.URL(), "c2", 50)
+	defer closeRedisStreamsTestInput(t, in)
+
+	msg := redisReceive(t, in, resChan, types.NewUnacknowledgedResponse())
+	if act := msg.GetMetadata(0, "redis_stream_id"); act != id {
+		t.Errorf("Wrong message: %v != %v", act, id)
+	}
+	pending := server.Pending("foo", "benthos_group")
+	if len(pending) != 1 || pending[0].Consumer != "c2" {
+		t.Errorf("Expected message to be claimed: %v", pending)
+	}
+	if exp, act := []string{"XPENDING", "XCLAIM"}, redisCommandNames(server, "XPENDING", "XCLAIM"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong commands: %v != %v", act, exp)
+	}
+
+	id2 := server.AddStreamEntry("foo", map[string]string{"body": "hello world 2"})
+	msg = redisReceive(t, in, resChan, types.NewSimpleResponse(nil))
+	if act := msg.GetMetadata(0, "redis_stream_id"); act != id2 {
+		t.Errorf("Wrong message: %v != %v", act, id2)
+	}
+	redisWaitFor(t, "claimed message to be acknowledged", func() bool {
+		return len(server.Pending("foo", "benthos_group")) == 0
+	})
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,363 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/endpoint"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/gorilla/websocket"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["websocket"] = typeSpec{
+		constructor: NewWebsocket,
+		description: `
+Receive messages over websocket connections, either by dialing a server in
+'client' mode or by accepting connections in 'server' mode. Both text and binary
+frames are supported, and each frame received becomes a single part message.
+
+Frames are acknowledged individually, the next frame of a connection is not read
+until the previous one has been successfully propagated, which means back
+pressure is applied to the sender.
+
+In server mode you can leave the 'address' config field blank in order to use
+the default service, but this will ignore TLS options.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// WebsocketConfig is configuration for the Websocket input type.
+type WebsocketConfig struct {
+	Mode     string `json:"mode" yaml:"mode"`
+	URL      string `json:"url" yaml:"url"`
+	Address  string `json:"address" yaml:"address"`
+	Path     string `json:"path" yaml:"path"`
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+}
+
+// NewWebsocketConfig creates a new WebsocketConfig with default values.
+func NewWebsocketConfig() WebsocketConfig {
+	return WebsocketConfig{
+		Mode:     "client",
+		URL:      "ws://localhost:4195/get/ws",
+		Address:  "",
+		Path:     "/post/ws",
+		CertFile: "",
+		KeyFile:  "",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Websocket is an input type that reads frames from websocket connections.
+type Websocket struct {
+	running int32
+
+	conf  Config
+	stats metrics.Type
+	log   log.Modular
+
+	mux      *http.ServeMux
+	server   *http.Server
+	bindings endpoint.Bindings
+	upgrader websocket.Upgrader
+
+	connsMut sync.Mutex
+	conns    map[*websocket.Conn]struct{}
+
+	// sendMut ensures that only one connection at a time is waiting on a
+	// response, as responses are not attributed to specific messages.
+	sendMut sync.Mutex
+
+	messages  chan types.Message
+	responses <-chan types.Response
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewWebsocket creates a new Websocket input type.
+func NewWebsocket(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	w := &Websocket{
+		running:    1,
+		conf:       conf,
+		stats:      stats,
+		log:        log.NewModule(".input.websocket"),
+		conns:      map[*websocket.Conn]struct{}{},
+		messages:   make(chan types.Message),
+		responses:  nil,
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+
+	switch conf.Websocket.Mode {
+	case "client":
+		if len(conf.Websocket.URL) == 0 {
+			return nil, errors.New("a url must be specified in client mode")
+		}
+	case "server":
+		if len(conf.Websocket.Address) > 0 {
+			w.mux = http.NewServeMux()
+			w.server = &http.Server{Addr: conf.Websocket.Address, Handler: w.mux}
+			w.mux.HandleFunc(conf.Websocket.Path, w.wsHandler)
+		} else if err := w.bindings.Bind(conf.Websocket.Path, w.wsHandler); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("websocket mode not recognised: " + conf.Websocket.Mode)
+	}
+
+	return w, nil
+}
+
+//------------------------------------------------------------------------------
+
+// addConn registers a connection so that it can be closed during shutdown,
+// returns false if the input is already closing.
+func (w *Websocket) addConn(conn *websocket.Conn) bool {
+	w.connsMut.Lock()
+	defer w.connsMut.Unlock()
+	if atomic.LoadInt32(&w.running) != 1 {
+		return false
+	}
+	w.conns[conn] = struct{}{}
+	return true
+}
+
+func (w *Websocket) removeConn(conn *websocket.Conn) {
+	w.connsMut.Lock()
+	delete(w.conns, conn)
+	w.connsMut.Unlock()
+	conn.Close()
+}
+
+func (w *Websocket) closeConns() {
+	w.connsMut.Lock()
+	for conn := range w.conns {
+		conn.Close()
+	}
+	w.conns = map[*websocket.Conn]struct{}{}
+	w.connsMut.Unlock()
+}
+
+//------------------------------------------------------------------------------
+
+// send propagates a single frame through the pipeline, resending it until it
+// is acknowledged. Returns false if the input is closing.
+func (w *Websocket) send(data []byte) bool {
+	w.sendMut.Lock()
+	defer w.sendMut.Unlock()
+
+	for atomic.LoadInt32(&w.running) == 1 {
+		select {
+		case w.messages <- types.Message{Parts: [][]byte{data}}:
+		case <-w.closeChan:
+			return false
+		}
+		res, open := <-w.responses
+		if !open {
+			return false
+		}
+		if res.Error() == nil {
+			w.stats.Incr("input.websocket.send.success", 1)
+			return true
+		}
+		w.stats.Incr("input.websocket.send.error", 1)
+	}
+	return false
+}
+
+// readConn reads frames from a connection until it is closed or errors.
+func (w *Websocket) readConn(conn *websocket.Conn) error {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		w.stats.Incr("input.websocket.count", 1)
+		if !w.send(data) {
+			return nil
+		}
+	}
+}
+
+func (w *Websocket) wsHandler(rw http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&w.running) != 1 {
+		http.Error(rw, "Server closing", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := w.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		w.stats.Incr("input.websocket.upgrade.error", 1)
+		w.log.Warnf("Failed to upgrade connection: %v\n", err)
+		return
+	}
+	if !w.addConn(conn) {
+		conn.Close()
+		return
+	}
+	defer w.removeConn(conn)
+
+	w.stats.Incr("input.websocket.connection.opened", 1)
+	if err = w.readConn(conn); err != nil && atomic.LoadInt32(&w.running) == 1 {
+		if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			w.log.Warnf("Connection closed unexpectedly: %v\n", err)
+		}
+	}
+	w.stats.Incr("input.websocket.connection.closed", 1)
+}
+
+//------------------------------------------------------------------------------
+
+func (w *Websocket) clientLoop() {
+	for atomic.LoadInt32(&w.running) == 1 {
+		conn, _, err := websocket.DefaultDialer.Dial(w.conf.Websocket.URL, nil)
+		if err == nil && !w.addConn(conn) {
+			conn.Close()
+			return
+		}
+		if err != nil {
+			w.log.Errorf("Failed to connect to websocket: %v\n", err)
+			w.stats.Incr("input.websocket.connect.error", 1)
+			select {
+			case <-time.After(time.Second):
+			case <-w.closeChan:
+				return
+			}
+			continue
+		}
+
+		w.log.Infof("Receiving websocket messages from URL: %s\n", w.conf.Websocket.URL)
+		w.stats.Incr("input.websocket.connect.success", 1)
+
+		err = w.readConn(conn)
+		w.removeConn(conn)
+		if err != nil && atomic.LoadInt32(&w.running) == 1 {
+			w.log.Warnf("Lost websocket connection, attempting reconnect: %v\n", err)
+		}
+	}
+}
+
+func (w *Websocket) serverLoop() {
+	if w.server == nil {
+		w.log.Infof("Receiving websocket messages at path: %s\n", w.conf.Websocket.Path)
+		<-w.closeChan
+		return
+	}
+
+	w.log.Infof(
+		"Receiving websocket messages at: %s\n",
+		w.conf.Websocket.Address+w.conf.Websocket.Path,
+	)
+
+	go func() {
+		var err error
+		if len(w.conf.Websocket.KeyFile) > 0 || len(w.conf.Websocket.CertFile) > 0 {
+			err = w.server.ListenAndServeTLS(
+				w.conf.Websocket.CertFile, w.conf.Websocket.KeyFile,
+			)
+		} else {
+			err = w.server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			w.log.Errorf("Server error: %v\n", err)
+		}
+	}()
+
+	<-w.closeChan
+	w.server.Shutdown(context.Background())
+}
+
+func (w *Websocket) loop() {
+	defer func() {
+		atomic.StoreInt32(&w.running, 0)
+		w.closeConns()
+
+		// Wait for any pending sends to complete before closing.
+		w.sendMut.Lock()
+		close(w.messages)
+		w.sendMut.Unlock()
+
+		close(w.closedChan)
+	}()
+
+	// Ensure connections blocked on reads are unblocked during shutdown.
+	go func() {
+		<-w.closeChan
+		w.closeConns()
+	}()
+
+	if w.conf.Websocket.Mode == "server" {
+		w.serverLoop()
+	} else {
+		w.clientLoop()
+	}
+}
+
+// StartListening sets the channel used by the input to validate message
+// receipt.
+func (w *Websocket) StartListening(responses <-chan types.Response) error {
+	if w.responses != nil {
+		return types.ErrAlreadyStarted
+	}
+	w.responses = responses
+	go w.loop()
+	return nil
+}
+
+// MessageChan returns the messages channel.
+func (w *Websocket) MessageChan() <-chan types.Message {
+	return w.messages
+}
+
+// CloseAsync shuts down the Websocket input and stops processing requests.
+func (w *Websocket) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&w.running, 1, 0) {
+		w.bindings.Release()
+		close(w.closeChan)
+	}
+}
+
+// WaitForClose blocks until the Websocket input has closed down.
+func (w *Websocket) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-w.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
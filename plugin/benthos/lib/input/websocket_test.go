@@ -0,0 +1,151 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/gorilla/websocket"
+)
+
+func expectWSFrame(t *testing.T, w Type, resChan chan types.Response, exp string, res error) {
+	select {
+	case msg := <-w.MessageChan():
+		if len(msg.Parts) != 1 {
+			t.Fatalf("Wrong count of parts: %v", len(msg.Parts))
+		}
+		if act := string(msg.Parts[0]); act != exp {
+			t.Errorf("Wrong result: %v != %v", act, exp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for message")
+	}
+	select {
+	case resChan <- types.NewSimpleResponse(res):
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for response")
+	}
+}
+
+func TestWebsocketServer(t *testing.T) {
+	conf := NewConfig()
+	conf.Websocket.Mode = "server"
+	conf.Websocket.Address = "localhost:1245"
+	conf.Websocket.Path = "/testws"
+
+	w, err := NewWebsocket(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resChan := make(chan types.Response)
+	if err = w.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.StartListening(resChan); err == nil {
+		t.Error("Expected error from double listen")
+	}
+
+	defer func() {
+		w.CloseAsync()
+		if err := w.WaitForClose(time.Second); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	var conn *websocket.Conn
+	for i := 0; i < 20; i++ {
+		if conn, _, err = websocket.DefaultDialer.Dial("ws://localhost:1245/testws", nil); err == nil {
+			break
+		}
+		<-time.After(time.Millisecond * 50)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err = conn.WriteMessage(websocket.TextMessage, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.WriteMessage(websocket.BinaryMessage, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+
+	expectWSFrame(t, w, resChan, "foo", nil)
+
+	// A failed frame should be resent.
+	expectWSFrame(t, w, resChan, "bar", types.ErrTimeout)
+	expectWSFrame(t, w, resChan, "bar", nil)
+}
+
+func TestWebsocketClient(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte("foo"))
+		conn.WriteMessage(websocket.BinaryMessage, []byte("bar"))
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	conf := NewConfig()
+	conf.Websocket.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+
+	w, err := NewWebsocket(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resChan := make(chan types.Response)
+	if err = w.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+
+	expectWSFrame(t, w, resChan, "foo", nil)
+	expectWSFrame(t, w, resChan, "bar", nil)
+
+	w.CloseAsync()
+	if err := w.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWebsocketBadConfig(t *testing.T) {
+	conf := NewConfig()
+	conf.Websocket.Mode = "nope"
+	if _, err := NewWebsocket(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad mode")
+	}
+}
@@ -85,9 +85,21 @@ func (i *WithPipeline) StartListening(resChan <-chan types.Response) error {
 
 //------------------------------------------------------------------------------
 
-// CloseAsync triggers a closure of this object but does not block.
+// CloseAsync triggers a closure of this object but does not block. If the
+// pipeline flushes the state of its processors when closed then it is closed
+// first, and the input is closed once the flush is complete, allowing the
+// responses to flushed messages to be acknowledged by the input.
 func (i *WithPipeline) CloseAsync() {
-	i.in.CloseAsync()
+	flusher, ok := i.pipe.(pipeline.Flusher)
+	if !ok || !flusher.Flushes() {
+		i.in.CloseAsync()
+		return
+	}
+	i.pipe.CloseAsync()
+	go func() {
+		flusher.WaitForFlush(time.Second * 5)
+		i.in.CloseAsync()
+	}()
 }
 
 // WaitForClose is a blocking call to wait until the object has finished closing
@@ -484,3 +484,96 @@ func TestBasicWrapDoubleProcessors(t *testing.T) {
 }
 
 //------------------------------------------------------------------------------
+
+type mockHoldProc struct {
+	parts [][]byte
+}
+
+func (m *mockHoldProc) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	m.parts = append(m.parts, msg.Parts...)
+	return nil, types.NewUnacknowledgedResponse(), false
+}
+
+func (m *mockHoldProc) Flush() (*types.Message, bool) {
+	if len(m.parts) == 0 {
+		return nil, false
+	}
+	msg := types.NewMessage()
+	msg.Parts, m.parts = m.parts, nil
+	return &msg, true
+}
+
+func TestWrapPipelineFlushOnClose(t *testing.T) {
+	mockIn := &mockInput{msgs: make(chan types.Message)}
+
+	l := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	pipe := pipeline.NewProcessor(l, metrics.DudType{}, &mockHoldProc{})
+
+	newInput, err := WrapWithPipeline(mockIn, func() (pipeline.Type, error) {
+		return pipe, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resChan := make(chan types.Response)
+	if err = newInput.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+
+	sendMsg := func(part string) types.Response {
+		select {
+		case mockIn.msgs <- types.Message{Parts: [][]byte{[]byte(part)}}:
+		case <-time.After(time.Second):
+			t.Fatal("action timed out")
+		}
+		select {
+		case res, open := <-mockIn.res:
+			if !open {
+				t.Fatal("Channel was closed")
+			}
+			return res
+		case <-time.After(time.Second):
+			t.Fatal("action timed out")
+		}
+		return nil
+	}
+
+	if res := sendMsg("foo"); !res.SkipAck() {
+		t.Error("Expected unacknowledged response")
+	}
+
+	newInput.CloseAsync()
+
+	// The input remains open until the pipeline has flushed, and the response
+	// to the next message acknowledges the flushed messages.
+	go func() {
+		select {
+		case newMsg, open := <-newInput.MessageChan():
+			if !open {
+				t.Error("channel was closed")
+				return
+			}
+			if exp, act := "foo,bar", string(newMsg.Parts[0])+","+string(newMsg.Parts[1]); exp != act {
+				t.Errorf("Wrong message received: %v != %v", act, exp)
+			}
+		case <-time.After(time.Second):
+			t.Error("action timed out")
+			return
+		}
+		resChan <- types.NewSimpleResponse(nil)
+	}()
+
+	res := sendMsg("bar")
+	if res.Error() != nil {
+		t.Error(res.Error())
+	} else if res.SkipAck() {
+		t.Error("Expected acknowledged response")
+	}
+
+	if err = newInput.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -38,7 +38,10 @@ func init() {
 		constructor: NewAMQP,
 		description: `
 AMQP (0.91) is the underlying messaging protocol that is used by various message
-brokers, including RabbitMQ.`,
+brokers, including RabbitMQ.
+
+The metadata fields of each message part are sent as headers of the
+corresponding AMQP message.`,
 	}
 }
 
@@ -204,14 +207,18 @@ func (a *AMQP) loop() {
 
 		a.stats.Incr("output.amqp.count", 1)
 		var err error
-		for _, part := range msg.Parts {
+		for i, part := range msg.Parts {
+			headers := amqp.Table{}
+			for k, v := range msg.PartMetadata(i) {
+				headers[k] = v
+			}
 			err = a.amqpChan.Publish(
 				a.conf.AMQP.Exchange,   // publish to an exchange
 				a.conf.AMQP.BindingKey, // routing to 0 or more queues
 				false, // mandatory
 				false, // immediate
 				amqp.Publishing{
-					Headers:         amqp.Table{},
+					Headers:         headers,
 					ContentType:     "application/octet-stream",
 					ContentEncoding: "",
 					Body:            part,
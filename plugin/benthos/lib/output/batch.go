@@ -0,0 +1,304 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// BatchConfig contains configuration for the batching policy of an output.
+// Batching is disabled when all fields are zero.
+type BatchConfig struct {
+	Count    int   `json:"count" yaml:"count"`
+	ByteSize int   `json:"byte_size" yaml:"byte_size"`
+	PeriodMS int64 `json:"period_ms" yaml:"period_ms"`
+}
+
+// NewBatchConfig creates a new BatchConfig with default values.
+func NewBatchConfig() BatchConfig {
+	return BatchConfig{
+		Count:    0,
+		ByteSize: 0,
+		PeriodMS: 0,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Batcher is a type that wraps an output and collects messages into batches,
+// which are flushed to the output as a single multiple part message once a
+// count, byte size or period is reached.
+//
+// Messages added to a batch are responded to with an unacknowledged response,
+// and the message that triggers a flush is responded to with the result of the
+// flush, which acknowledges the whole batch at the input. Batches flushed due
+// to the period are acknowledged along with the next message that is sent.
+//
+// Acknowledgements are only delayed until a flush for inputs that acknowledge
+// messages cumulatively, other inputs treat messages added to a batch as
+// delivered. When the Batcher is closed any pending batch is flushed before
+// the wrapped output is closed.
+type Batcher struct {
+	running int32
+
+	conf  BatchConfig
+	log   log.Modular
+	stats metrics.Type
+
+	out Type
+
+	batch     []types.Message
+	byteSize  int
+	flushedAt time.Time
+
+	messagesIn   <-chan types.Message
+	responsesOut chan types.Response
+	outMessages  chan types.Message
+
+	closeChan  chan struct{}
+	killChan   chan struct{}
+	killOnce   sync.Once
+	closedChan chan struct{}
+}
+
+// WrapWithBatcher wraps an output with a batching policy. If the policy is
+// disabled the output is returned unchanged.
+func WrapWithBatcher(
+	out Type, conf BatchConfig, log log.Modular, stats metrics.Type,
+) (Type, error) {
+	if conf.Count <= 1 && conf.ByteSize <= 0 && conf.PeriodMS <= 0 {
+		return out, nil
+	}
+
+	b := &Batcher{
+		running:      1,
+		conf:         conf,
+		log:          log.NewModule(".batch"),
+		stats:        stats,
+		out:          out,
+		responsesOut: make(chan types.Response),
+		outMessages:  make(chan types.Message),
+		closeChan:    make(chan struct{}),
+		killChan:     make(chan struct{}),
+		closedChan:   make(chan struct{}),
+	}
+	if err := out.StartReceiving(b.outMessages); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//------------------------------------------------------------------------------
+
+// full returns true if the current batch has reached a count or size limit.
+func (b *Batcher) full() bool {
+	if b.conf.Count > 0 && len(b.batch) >= b.conf.Count {
+		return true
+	}
+	if b.conf.ByteSize > 0 && b.byteSize >= b.conf.ByteSize {
+		return true
+	}
+	return false
+}
+
+// add appends a message to the current batch.
+func (b *Batcher) add(msg types.Message) {
+	if len(b.batch) == 0 {
+		b.flushedAt = time.Now()
+	}
+	b.batch = append(b.batch, msg)
+	for _, part := range msg.Parts {
+		b.byteSize += len(part)
+	}
+}
+
+// removeLast removes the most recently added message from the current batch.
+func (b *Batcher) removeLast() {
+	last := b.batch[len(b.batch)-1]
+	for _, part := range last.Parts {
+		b.byteSize -= len(part)
+	}
+	b.batch = b.batch[:len(b.batch)-1]
+}
+
+// flush sends the current batch to the output as a single message and returns
+// the response. The batch is reset only if the flush succeeds. A flush in
+// progress is only abandoned once the Batcher is killed.
+func (b *Batcher) flush() (types.Response, bool) {
+	batchMsg := types.Message{}
+	hasMeta := false
+	for _, msg := range b.batch {
+		hasMeta = hasMeta || len(msg.Metadata) > 0
+	}
+	for _, msg := range b.batch {
+		for i, part := range msg.Parts {
+			batchMsg.Parts = append(batchMsg.Parts, part)
+			if hasMeta {
+				batchMsg.Metadata = append(batchMsg.Metadata, msg.PartMetadata(i))
+			}
+		}
+	}
+
+	b.stats.Incr("output.batch.flush.count", 1)
+	select {
+	case b.outMessages <- batchMsg:
+	case <-b.killChan:
+		return nil, false
+	}
+
+	var res types.Response
+	var open bool
+	select {
+	case res, open = <-b.out.ResponseChan():
+		if !open {
+			return nil, false
+		}
+	case <-b.killChan:
+		return nil, false
+	}
+
+	if res.Error() == nil {
+		b.stats.Incr("output.batch.flush.success", 1)
+		b.batch = nil
+		b.byteSize = 0
+	} else {
+		b.stats.Incr("output.batch.flush.error", 1)
+		b.log.Errorf("Failed to flush batch of %v messages: %v\n", len(b.batch), res.Error())
+	}
+	return res, true
+}
+
+// loop is an internal loop that collects incoming messages into batches.
+func (b *Batcher) loop() {
+	defer func() {
+		if len(b.batch) > 0 {
+			if res, open := b.flush(); !open || res.Error() != nil {
+				b.log.Warnf("Discarding %v unacknowledged batched messages\n", len(b.batch))
+			}
+		}
+		close(b.outMessages)
+		b.out.CloseAsync()
+		close(b.responsesOut)
+		close(b.closedChan)
+	}()
+
+	period := time.Duration(b.conf.PeriodMS) * time.Millisecond
+
+	for atomic.LoadInt32(&b.running) == 1 {
+		var timerChan <-chan time.Time
+		if period > 0 && len(b.batch) > 0 {
+			timerChan = time.After(period - time.Since(b.flushedAt))
+		}
+
+		var res types.Response
+		select {
+		case msg, open := <-b.messagesIn:
+			if !open {
+				return
+			}
+			b.stats.Incr("output.batch.count", 1)
+			b.add(msg)
+			if !b.full() {
+				res = types.NewUnacknowledgedResponse()
+				break
+			}
+			if res, open = b.flush(); !open {
+				return
+			}
+			if res.Error() != nil {
+				// The input will resend this message, so it is removed from
+				// the batch in order to avoid duplicates.
+				b.removeLast()
+			}
+		case <-timerChan:
+			if _, open := b.flush(); !open {
+				return
+			}
+			b.flushedAt = time.Now()
+			continue
+		case <-b.closeChan:
+			return
+		}
+
+		select {
+		case b.responsesOut <- res:
+		case <-b.closeChan:
+			return
+		}
+	}
+}
+
+// kill abandons any flush in progress and closes the wrapped output.
+func (b *Batcher) kill() {
+	b.killOnce.Do(func() {
+		close(b.killChan)
+		b.out.CloseAsync()
+	})
+}
+
+//------------------------------------------------------------------------------
+
+// StartReceiving assigns a messages channel for the output to read.
+func (b *Batcher) StartReceiving(msgs <-chan types.Message) error {
+	if b.messagesIn != nil {
+		return types.ErrAlreadyStarted
+	}
+	b.messagesIn = msgs
+	go b.loop()
+	return nil
+}
+
+// ResponseChan returns the channel used for reading response messages from this
+// output.
+func (b *Batcher) ResponseChan() <-chan types.Response {
+	return b.responsesOut
+}
+
+// CloseAsync shuts down the Batcher output and stops processing messages. Any
+// pending batch is flushed before the wrapped output is closed.
+func (b *Batcher) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&b.running, 1, 0) {
+		close(b.closeChan)
+	}
+}
+
+// WaitForClose blocks until the Batcher output has closed down. If the pending
+// batch has not been flushed within the timeout it is abandoned.
+func (b *Batcher) WaitForClose(timeout time.Duration) error {
+	tStarted := time.Now()
+	select {
+	case <-b.closedChan:
+	case <-time.After(timeout):
+		b.kill()
+		return types.ErrTimeout
+	}
+	return b.out.WaitForClose(timeout - time.Since(tStarted))
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,244 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func sendAndExpect(t *testing.T, sendChan chan<- types.Message, resChan <-chan types.Response, msg string, skipAck bool, err error) {
+	select {
+	case sendChan <- types.Message{Parts: [][]byte{[]byte(msg)}}:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for send")
+	}
+	select {
+	case res := <-resChan:
+		if res.SkipAck() != skipAck {
+			t.Errorf("Wrong skip ack for %v: %v != %v", msg, res.SkipAck(), skipAck)
+		}
+		if res.Error() != err {
+			t.Errorf("Wrong error for %v: %v != %v", msg, res.Error(), err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for response")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestBatcherDisabled(t *testing.T) {
+	out := &mockOutput{res: make(chan types.Response)}
+
+	wrapped, err := WrapWithBatcher(out, NewBatchConfig(), log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped != out {
+		t.Error("Expected output to be returned unwrapped")
+	}
+}
+
+func TestBatcherCount(t *testing.T) {
+	out := &mockOutput{res: make(chan types.Response)}
+
+	conf := NewBatchConfig()
+	conf.Count = 3
+
+	b, err := WrapWithBatcher(out, conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendChan := make(chan types.Message)
+	if err = b.StartReceiving(sendChan); err != nil {
+		t.Fatal(err)
+	}
+
+	errTest := errors.New("test err")
+
+	sendAndExpect(t, sendChan, b.ResponseChan(), "foo", true, nil)
+	sendAndExpect(t, sendChan, b.ResponseChan(), "bar", true, nil)
+
+	// First flush fails, the input would then resend the final message.
+	go func() {
+		msg := out.respond(t, errTest)
+		if exp, act := [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}, msg.Parts; !reflect.DeepEqual(exp, act) {
+			t.Errorf("Wrong batch: %s != %s", act, exp)
+		}
+		msg = out.respond(t, nil)
+		if exp, act := [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}, msg.Parts; !reflect.DeepEqual(exp, act) {
+			t.Errorf("Wrong batch: %s != %s", act, exp)
+		}
+	}()
+	sendAndExpect(t, sendChan, b.ResponseChan(), "baz", false, errTest)
+	sendAndExpect(t, sendChan, b.ResponseChan(), "baz", false, nil)
+
+	b.CloseAsync()
+	if err = b.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatcherByteSize(t *testing.T) {
+	out := &mockOutput{res: make(chan types.Response)}
+
+	conf := NewBatchConfig()
+	conf.ByteSize = 5
+
+	b, err := WrapWithBatcher(out, conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendChan := make(chan types.Message)
+	if err = b.StartReceiving(sendChan); err != nil {
+		t.Fatal(err)
+	}
+
+	sendAndExpect(t, sendChan, b.ResponseChan(), "foo", true, nil)
+	go func() {
+		msg := out.respond(t, nil)
+		if exp, act := [][]byte{[]byte("foo"), []byte("bar")}, msg.Parts; !reflect.DeepEqual(exp, act) {
+			t.Errorf("Wrong batch: %s != %s", act, exp)
+		}
+	}()
+	sendAndExpect(t, sendChan, b.ResponseChan(), "bar", false, nil)
+
+	b.CloseAsync()
+	if err = b.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatcherPeriod(t *testing.T) {
+	out := &mockOutput{res: make(chan types.Response)}
+
+	conf := NewBatchConfig()
+	conf.Count = 10
+	conf.PeriodMS = 10
+
+	b, err := WrapWithBatcher(out, conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendChan := make(chan types.Message)
+	if err = b.StartReceiving(sendChan); err != nil {
+		t.Fatal(err)
+	}
+
+	sendAndExpect(t, sendChan, b.ResponseChan(), "foo", true, nil)
+	sendAndExpect(t, sendChan, b.ResponseChan(), "bar", true, nil)
+
+	msg := out.respond(t, nil)
+	if exp, act := [][]byte{[]byte("foo"), []byte("bar")}, msg.Parts; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong batch: %s != %s", act, exp)
+	}
+
+	b.CloseAsync()
+	if err = b.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatcherFlushOnClose(t *testing.T) {
+	out := &mockOutput{res: make(chan types.Response)}
+
+	conf := NewBatchConfig()
+	conf.Count = 10
+
+	b, err := WrapWithBatcher(out, conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendChan := make(chan types.Message)
+	if err = b.StartReceiving(sendChan); err != nil {
+		t.Fatal(err)
+	}
+
+	sendAndExpect(t, sendChan, b.ResponseChan(), "foo", true, nil)
+	sendAndExpect(t, sendChan, b.ResponseChan(), "bar", true, nil)
+
+	b.CloseAsync()
+
+	msg := out.respond(t, nil)
+	if exp, act := [][]byte{[]byte("foo"), []byte("bar")}, msg.Parts; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong batch: %s != %s", act, exp)
+	}
+
+	if err = b.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatcherMetadata(t *testing.T) {
+	out := &mockOutput{res: make(chan types.Response)}
+
+	conf := NewBatchConfig()
+	conf.Count = 2
+
+	b, err := WrapWithBatcher(out, conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendChan := make(chan types.Message)
+	if err = b.StartReceiving(sendChan); err != nil {
+		t.Fatal(err)
+	}
+
+	first := types.Message{Parts: [][]byte{[]byte("foo")}}
+	second := types.Message{Parts: [][]byte{[]byte("bar")}}
+	second.SetMetadata(0, "baz", "qux")
+
+	go func() {
+		msg := out.respond(t, nil)
+		if act := msg.GetMetadata(0, "baz"); len(act) > 0 {
+			t.Errorf("Unexpected metadata: %v", act)
+		}
+		if exp, act := "qux", msg.GetMetadata(1, "baz"); exp != act {
+			t.Errorf("Wrong metadata: %v != %v", act, exp)
+		}
+	}()
+	for _, msg := range []types.Message{first, second} {
+		sendChan <- msg
+		<-b.ResponseChan()
+	}
+
+	b.CloseAsync()
+	if err = b.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+//------------------------------------------------------------------------------
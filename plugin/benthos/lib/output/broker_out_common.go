@@ -108,4 +108,10 @@ func parseOutputConfsWithDefaults(outConfs []interface{}) ([]Config, error) {
 	return outputConfs, nil
 }
 
+// ParseConfigs parses a list of generic output configs, such as the children of
+// a broker type, into output configs with default values applied.
+func ParseConfigs(outConfs []interface{}) ([]Config, error) {
+	return parseOutputConfsWithDefaults(outConfs)
+}
+
 //------------------------------------------------------------------------------
@@ -21,7 +21,9 @@
 package output
 
 import (
+	"errors"
 	"os"
+	"time"
 
 	"github.com/Jeffail/benthos/lib/util/service/log"
 	"github.com/Jeffail/benthos/lib/util/service/metrics"
@@ -44,7 +46,24 @@ bar\n
 baz\n\n
 
 You can alternatively specify a custom delimiter that will follow the same rules
-as '\n' above.`,
+as '\n' above.
+
+The file can be rotated by setting 'max_size' to a number of bytes and/or
+'rotate_interval_ms' to a maximum age. The path may then contain placeholders
+that are resolved each time a new file is started: '${date:<layout>}' is the
+current date formatted with a Go time layout (defaulting to 2006-01-02 with
+'${date}'), '${hostname}' is the hostname of the machine and '${counter}' is a
+number that increases with each new file. The path is also rotated when the
+date it resolves to changes.
+
+When rotating, the file being written is kept at its path with the extension
+'.tmp' and is only synced to disk and moved to its final path once complete, so
+that readers of the directory only ever see complete files. A numbered suffix
+is added to the path if a completed file already exists there. Any '.tmp'
+files left behind by a previous run are completed on startup. Completed files
+can be compressed with gzip by setting 'gzip' to true, and if 'max_files' is
+greater than zero then only that many of the most recent completed files that
+match the path are kept.`,
 	}
 }
 
@@ -52,15 +71,23 @@ as '\n' above.`,
 
 // FileConfig is configuration values for the file based output type.
 type FileConfig struct {
-	Path        string `json:"path" yaml:"path"`
-	CustomDelim string `json:"custom_delimiter" yaml:"custom_delimiter"`
+	Path             string `json:"path" yaml:"path"`
+	CustomDelim      string `json:"custom_delimiter" yaml:"custom_delimiter"`
+	MaxSize          int    `json:"max_size" yaml:"max_size"`
+	RotateIntervalMS int    `json:"rotate_interval_ms" yaml:"rotate_interval_ms"`
+	Gzip             bool   `json:"gzip" yaml:"gzip"`
+	MaxFiles         int    `json:"max_files" yaml:"max_files"`
 }
 
 // NewFileConfig creates a new FileConfig with default values.
 func NewFileConfig() FileConfig {
 	return FileConfig{
-		Path:        "",
-		CustomDelim: "",
+		Path:             "",
+		CustomDelim:      "",
+		MaxSize:          0,
+		RotateIntervalMS: 0,
+		Gzip:             false,
+		MaxFiles:         0,
 	}
 }
 
@@ -68,6 +95,19 @@ func NewFileConfig() FileConfig {
 
 // NewFile creates a new File output type.
 func NewFile(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	if conf.File.MaxSize < 0 || conf.File.RotateIntervalMS < 0 {
+		return nil, errors.New("max_size and rotate_interval_ms cannot be negative")
+	}
+	if conf.File.MaxSize > 0 || conf.File.RotateIntervalMS > 0 {
+		file := newRollingFile(conf.File.Path, rollingFileConfig{
+			maxSize:  int64(conf.File.MaxSize),
+			interval: time.Duration(conf.File.RotateIntervalMS) * time.Millisecond,
+			gzip:     conf.File.Gzip,
+			maxFiles: conf.File.MaxFiles,
+		}, log.NewModule(".output.file"), stats)
+		return newWriter(file, []byte(conf.File.CustomDelim), log, stats)
+	}
+
 	file, err := os.OpenFile(conf.File.Path, os.O_CREATE|os.O_RDWR|os.O_APPEND, os.FileMode(0666))
 	if err != nil {
 		return nil, err
@@ -31,6 +31,7 @@ import (
 	"time"
 
 	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/endpoint"
 	"github.com/Jeffail/benthos/lib/util/service/log"
 	"github.com/Jeffail/benthos/lib/util/service/metrics"
 )
@@ -88,8 +89,9 @@ type HTTPServer struct {
 	stats metrics.Type
 	log   log.Modular
 
-	mux    *http.ServeMux
-	server *http.Server
+	mux      *http.ServeMux
+	server   *http.Server
+	bindings endpoint.Bindings
 
 	messages     <-chan types.Message
 	responseChan chan types.Response
@@ -105,8 +107,6 @@ func NewHTTPServer(conf Config, log log.Modular, stats metrics.Type) (Type, erro
 	if len(conf.HTTPServer.Address) > 0 {
 		mux = http.NewServeMux()
 		server = &http.Server{Addr: conf.HTTPServer.Address, Handler: mux}
-	} else {
-		mux = http.DefaultServeMux
 	}
 
 	h := HTTPServer{
@@ -122,14 +122,29 @@ func NewHTTPServer(conf Config, log log.Modular, stats metrics.Type) (Type, erro
 	}
 
 	if len(h.conf.HTTPServer.Path) > 0 {
-		h.mux.HandleFunc(h.conf.HTTPServer.Path, h.getHandler)
+		if err := h.handle(h.conf.HTTPServer.Path, h.getHandler); err != nil {
+			return nil, err
+		}
 	}
 	if len(h.conf.HTTPServer.StreamPath) > 0 {
-		h.mux.HandleFunc(h.conf.HTTPServer.StreamPath, h.streamHandler)
+		if err := h.handle(h.conf.HTTPServer.StreamPath, h.streamHandler); err != nil {
+			h.bindings.Release()
+			return nil, err
+		}
 	}
 	return &h, nil
 }
 
+// handle registers a handler with the server of the output, or binds it to the
+// default server when the output does not have its own.
+func (h *HTTPServer) handle(path string, handler http.HandlerFunc) error {
+	if h.server != nil {
+		h.mux.HandleFunc(path, handler)
+		return nil
+	}
+	return h.bindings.Bind(path, handler)
+}
+
 //------------------------------------------------------------------------------
 
 func (h *HTTPServer) getHandler(w http.ResponseWriter, r *http.Request) {
@@ -296,6 +311,7 @@ func (h *HTTPServer) ResponseChan() <-chan types.Response {
 // CloseAsync shuts down the HTTPServer input and stops processing requests.
 func (h *HTTPServer) CloseAsync() {
 	if atomic.CompareAndSwapInt32(&h.running, 1, 0) {
+		h.bindings.Release()
 		if h.server != nil {
 			h.server.Shutdown(context.Background())
 		} else {
@@ -0,0 +1,401 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// IdempotenceConfig contains configuration for deduplicating messages sent to
+// an output by a message ID. Idempotence is disabled when IDMetadataKeys is
+// empty.
+type IdempotenceConfig struct {
+	IDMetadataKeys []string `json:"id_metadata_keys" yaml:"id_metadata_keys"`
+	Path           string   `json:"path" yaml:"path"`
+	MaxIDs         int      `json:"max_ids" yaml:"max_ids"`
+}
+
+// NewIdempotenceConfig creates a new IdempotenceConfig with default values.
+func NewIdempotenceConfig() IdempotenceConfig {
+	return IdempotenceConfig{
+		IDMetadataKeys: []string{},
+		Path:           "",
+		MaxIDs:         100000,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// idLog is a bounded set of message IDs that is optionally persisted to disk as
+// an append only file of quoted IDs, one per line. The file is compacted down
+// to the most recent IDs once it grows beyond twice the maximum.
+type idLog struct {
+	max   int
+	ids   map[string]struct{}
+	order []string
+
+	path      string
+	file      *os.File
+	fileLines int
+}
+
+func newIDLog(path string, max int) (*idLog, error) {
+	l := &idLog{
+		max:  max,
+		ids:  map[string]struct{}{},
+		path: path,
+	}
+	if len(path) == 0 {
+		return l, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if id, err := strconv.Unquote(scanner.Text()); err == nil {
+				l.add(id)
+			}
+			l.fileLines++
+		}
+		f.Close()
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := l.compact(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// add inserts an ID into the in memory set, evicting the oldest ID if the set
+// is full.
+func (l *idLog) add(id string) {
+	if _, exists := l.ids[id]; exists {
+		return
+	}
+	l.ids[id] = struct{}{}
+	l.order = append(l.order, id)
+	if len(l.order) > l.max {
+		delete(l.ids, l.order[0])
+		l.order = l.order[1:]
+	}
+}
+
+// Has returns true if an ID exists in the set.
+func (l *idLog) Has(id string) bool {
+	_, exists := l.ids[id]
+	return exists
+}
+
+// Record adds IDs to the set and, if persisted, writes them to the file and
+// syncs it before returning.
+func (l *idLog) Record(ids ...string) error {
+	for _, id := range ids {
+		l.add(id)
+	}
+	if l.file == nil {
+		return nil
+	}
+
+	var buf []byte
+	for _, id := range ids {
+		buf = append(strconv.AppendQuote(buf, id), '\n')
+	}
+	if _, err := l.file.Write(buf); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	if l.fileLines += len(ids); l.fileLines > l.max*2 {
+		return l.compact()
+	}
+	return nil
+}
+
+// compact rewrites the file with only the IDs currently in the set and opens
+// it for appending.
+func (l *idLog) compact() error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+
+	tmpPath := l.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmpFile)
+	for _, id := range l.order {
+		writer.WriteString(strconv.Quote(id))
+		writer.WriteByte('\n')
+	}
+	if err = writer.Flush(); err == nil {
+		err = tmpFile.Sync()
+	}
+	tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, l.path); err != nil {
+		return err
+	}
+
+	l.fileLines = len(l.order)
+	l.file, err = os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0644)
+	return err
+}
+
+// Close closes the file of the log.
+func (l *idLog) Close() error {
+	if l.file != nil {
+		return l.file.Close()
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// Idempotence is a type that wraps an output and drops messages that have
+// already been delivered, identified by an ID built from the metadata of the
+// first part of each message. IDs are recorded once a message is acknowledged
+// by the output, and can be persisted to disk in order to survive restarts.
+//
+// This allows an input that replays messages after a crash, such as the kafka
+// inputs, to avoid duplicates at outputs that have no transactional support.
+// A message delivered immediately before a crash, but before its ID is
+// recorded, can still be duplicated.
+type Idempotence struct {
+	running int32
+
+	conf  IdempotenceConfig
+	log   log.Modular
+	stats metrics.Type
+
+	out Type
+	ids *idLog
+
+	pending []string
+
+	messagesIn   <-chan types.Message
+	responsesOut chan types.Response
+	outMessages  chan types.Message
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// WrapWithIdempotence wraps an output with message deduplication. If
+// idempotence is disabled the output is returned unchanged.
+func WrapWithIdempotence(
+	out Type, conf IdempotenceConfig, log log.Modular, stats metrics.Type,
+) (Type, error) {
+	if len(conf.IDMetadataKeys) == 0 {
+		return out, nil
+	}
+
+	max := conf.MaxIDs
+	if max <= 0 {
+		max = NewIdempotenceConfig().MaxIDs
+	}
+	ids, err := newIDLog(conf.Path, max)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Idempotence{
+		running:      1,
+		conf:         conf,
+		log:          log.NewModule(".idempotence"),
+		stats:        stats,
+		out:          out,
+		ids:          ids,
+		responsesOut: make(chan types.Response),
+		outMessages:  make(chan types.Message),
+		closeChan:    make(chan struct{}),
+		closedChan:   make(chan struct{}),
+	}
+	if err := out.StartReceiving(i.outMessages); err != nil {
+		ids.Close()
+		return nil, err
+	}
+	return i, nil
+}
+
+//------------------------------------------------------------------------------
+
+// messageID returns the ID of a message, or an empty string if the message has
+// none of the ID metadata keys. Each value is prefixed with its length so that
+// values containing the separator cannot collide.
+func (i *Idempotence) messageID(msg *types.Message) string {
+	var id []byte
+	found := false
+	for _, k := range i.conf.IDMetadataKeys {
+		value := msg.GetMetadata(0, k)
+		found = found || len(value) > 0
+		id = strconv.AppendInt(id, int64(len(value)), 10)
+		id = append(append(id, ':'), value...)
+	}
+	if !found {
+		return ""
+	}
+	return string(id)
+}
+
+// record stores the IDs of all acknowledged messages.
+func (i *Idempotence) record(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	if err := i.ids.Record(ids...); err != nil {
+		i.log.Errorf("Failed to record message IDs: %v\n", err)
+		i.stats.Incr("output.idempotence.record.error", 1)
+	}
+}
+
+// loop is an internal loop that deduplicates incoming messages.
+func (i *Idempotence) loop() {
+	defer func() {
+		i.ids.Close()
+		close(i.outMessages)
+		close(i.responsesOut)
+		close(i.closedChan)
+	}()
+
+	for atomic.LoadInt32(&i.running) == 1 {
+		var msg types.Message
+		var open bool
+		select {
+		case msg, open = <-i.messagesIn:
+			if !open {
+				return
+			}
+		case <-i.closeChan:
+			return
+		}
+		i.stats.Incr("output.idempotence.count", 1)
+
+		var res types.Response
+		id := i.messageID(&msg)
+		if len(id) > 0 && i.ids.Has(id) {
+			i.stats.Incr("output.idempotence.duplicate", 1)
+			if len(i.pending) > 0 {
+				// Acknowledging now would also acknowledge messages that
+				// are still held by the output.
+				res = types.NewUnacknowledgedResponse()
+			} else {
+				res = types.NewSimpleResponse(nil)
+			}
+		} else {
+			if len(id) == 0 {
+				i.stats.Incr("output.idempotence.missing_id", 1)
+			}
+
+			select {
+			case i.outMessages <- msg:
+			case <-i.closeChan:
+				return
+			}
+			select {
+			case res, open = <-i.out.ResponseChan():
+				if !open {
+					return
+				}
+			case <-i.closeChan:
+				return
+			}
+
+			if res.Error() == nil {
+				if len(id) > 0 {
+					i.pending = append(i.pending, id)
+				}
+				if !res.SkipAck() {
+					i.record(i.pending)
+					i.pending = nil
+				}
+			}
+		}
+
+		select {
+		case i.responsesOut <- res:
+		case <-i.closeChan:
+			return
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// StartReceiving assigns a messages channel for the output to read.
+func (i *Idempotence) StartReceiving(msgs <-chan types.Message) error {
+	if i.messagesIn != nil {
+		return types.ErrAlreadyStarted
+	}
+	i.messagesIn = msgs
+	go i.loop()
+	return nil
+}
+
+// ResponseChan returns the channel used for reading response messages from this
+// output.
+func (i *Idempotence) ResponseChan() <-chan types.Response {
+	return i.responsesOut
+}
+
+// CloseAsync shuts down the Idempotence output and stops processing messages.
+func (i *Idempotence) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&i.running, 1, 0) {
+		close(i.closeChan)
+	}
+	i.out.CloseAsync()
+}
+
+// WaitForClose blocks until the Idempotence output has closed down.
+func (i *Idempotence) WaitForClose(timeout time.Duration) error {
+	tStarted := time.Now()
+	select {
+	case <-i.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return i.out.WaitForClose(timeout - time.Since(tStarted))
+}
+
+//------------------------------------------------------------------------------
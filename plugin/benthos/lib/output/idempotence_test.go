@@ -0,0 +1,289 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+type idempotenceTester struct {
+	t    *testing.T
+	out  *mockOutput
+	send chan types.Message
+	res  <-chan types.Response
+}
+
+func newIdempotenceTester(t *testing.T, conf IdempotenceConfig) *idempotenceTester {
+	out := &mockOutput{res: make(chan types.Response)}
+	wrapped, err := WrapWithIdempotence(out, conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := make(chan types.Message)
+	if err = wrapped.StartReceiving(send); err != nil {
+		t.Fatal(err)
+	}
+	return &idempotenceTester{t: t, out: out, send: send, res: wrapped.ResponseChan()}
+}
+
+// sendMsg sends a message with an ID, if delivered is true the message is
+// expected to reach the output, which responds with res.
+func (i *idempotenceTester) sendMsg(id string, delivered bool, res types.Response) types.Response {
+	msg := types.Message{Parts: [][]byte{[]byte("hello world")}}
+	if len(id) > 0 {
+		msg.SetMetadata(0, "id", id)
+	}
+	select {
+	case i.send <- msg:
+	case <-time.After(time.Second):
+		i.t.Fatal("Timed out waiting for send")
+	}
+	if delivered {
+		select {
+		case outMsg := <-i.out.msgs:
+			if act := outMsg.GetMetadata(0, "id"); act != id {
+				i.t.Errorf("Wrong message delivered: %v != %v", act, id)
+			}
+		case <-time.After(time.Second):
+			i.t.Fatalf("Timed out waiting for message %v to be delivered", id)
+		}
+		select {
+		case i.out.res <- res:
+		case <-time.After(time.Second):
+			i.t.Fatal("Timed out waiting for response to be read")
+		}
+	}
+	select {
+	case r := <-i.res:
+		return r
+	case <-time.After(time.Second):
+		i.t.Fatalf("Timed out waiting for response to message %v", id)
+	}
+	return nil
+}
+
+func (i *idempotenceTester) close() {
+	close(i.send)
+	select {
+	case _, open := <-i.res:
+		if open {
+			i.t.Error("Expected response chan to be closed")
+		}
+	case <-time.After(time.Second):
+		i.t.Error("Timed out waiting for close")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestIdempotenceDisabled(t *testing.T) {
+	out := &mockOutput{res: make(chan types.Response)}
+
+	wrapped, err := WrapWithIdempotence(out, NewIdempotenceConfig(), log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped != out {
+		t.Error("Expected output to be returned unwrapped")
+	}
+}
+
+func TestIdempotenceBasic(t *testing.T) {
+	conf := NewIdempotenceConfig()
+	conf.IDMetadataKeys = []string{"id"}
+
+	tester := newIdempotenceTester(t, conf)
+	errTest := errors.New("test err")
+
+	if res := tester.sendMsg("foo", true, types.NewSimpleResponse(nil)); res.Error() != nil || res.SkipAck() {
+		t.Errorf("Unexpected response: %v", res)
+	}
+	if res := tester.sendMsg("foo", false, nil); res.Error() != nil || res.SkipAck() {
+		t.Errorf("Unexpected response: %v", res)
+	}
+	if res := tester.sendMsg("bar", true, types.NewSimpleResponse(errTest)); res.Error() != errTest {
+		t.Errorf("Unexpected response: %v", res)
+	}
+	if res := tester.sendMsg("bar", true, types.NewSimpleResponse(nil)); res.Error() != nil {
+		t.Errorf("Unexpected response: %v", res)
+	}
+	if res := tester.sendMsg("bar", false, nil); res.Error() != nil {
+		t.Errorf("Unexpected response: %v", res)
+	}
+	if res := tester.sendMsg("", true, types.NewSimpleResponse(nil)); res.Error() != nil {
+		t.Errorf("Unexpected response: %v", res)
+	}
+	if res := tester.sendMsg("", true, types.NewSimpleResponse(nil)); res.Error() != nil {
+		t.Errorf("Unexpected response: %v", res)
+	}
+
+	tester.close()
+}
+
+func TestIdempotenceUnacknowledged(t *testing.T) {
+	conf := NewIdempotenceConfig()
+	conf.IDMetadataKeys = []string{"id"}
+
+	tester := newIdempotenceTester(t, conf)
+
+	if res := tester.sendMsg("bar", true, types.NewSimpleResponse(nil)); res.SkipAck() {
+		t.Error("Expected acknowledged response")
+	}
+	if res := tester.sendMsg("foo", true, types.NewUnacknowledgedResponse()); !res.SkipAck() {
+		t.Error("Expected skip ack response")
+	}
+
+	// A duplicate must not acknowledge messages still held by the output.
+	if res := tester.sendMsg("bar", false, nil); !res.SkipAck() {
+		t.Error("Expected skip ack response for duplicate whilst messages are pending")
+	}
+
+	if res := tester.sendMsg("baz", true, types.NewSimpleResponse(nil)); res.SkipAck() {
+		t.Error("Expected acknowledged response")
+	}
+	if res := tester.sendMsg("foo", false, nil); res.Error() != nil || res.SkipAck() {
+		t.Errorf("Unexpected response: %v", res)
+	}
+
+	tester.close()
+}
+
+func TestIdempotenceMessageID(t *testing.T) {
+	i := &Idempotence{conf: NewIdempotenceConfig()}
+	i.conf.IDMetadataKeys = []string{"a", "b"}
+
+	newMsg := func(a, b string) *types.Message {
+		msg := &types.Message{Parts: [][]byte{[]byte("hello world")}}
+		if len(a) > 0 {
+			msg.SetMetadata(0, "a", a)
+		}
+		if len(b) > 0 {
+			msg.SetMetadata(0, "b", b)
+		}
+		return msg
+	}
+
+	if id := i.messageID(newMsg("", "")); len(id) > 0 {
+		t.Errorf("Expected empty ID, received: %v", id)
+	}
+
+	ids := map[string]struct{}{}
+	for _, values := range [][2]string{
+		{"foo:bar", "baz"},
+		{"foo", "bar:baz"},
+		{"foo:bar:baz", ""},
+		{"", "foo:bar:baz"},
+		{"3:foo", ""},
+		{"foo", ""},
+	} {
+		id := i.messageID(newMsg(values[0], values[1]))
+		if _, exists := ids[id]; exists {
+			t.Errorf("Duplicate ID for values %q: %v", values, id)
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+func TestIdempotencePersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_idempotence_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewIdempotenceConfig()
+	conf.IDMetadataKeys = []string{"id"}
+	conf.Path = filepath.Join(dir, "ids", "log")
+
+	tester := newIdempotenceTester(t, conf)
+	tester.sendMsg("foo", true, types.NewSimpleResponse(nil))
+	tester.sendMsg("bar\nbaz", true, types.NewSimpleResponse(nil))
+	tester.sendMsg("qux", true, types.NewUnacknowledgedResponse())
+	tester.close()
+
+	tester = newIdempotenceTester(t, conf)
+	tester.sendMsg("foo", false, nil)
+	tester.sendMsg("bar\nbaz", false, nil)
+	tester.sendMsg("qux", true, types.NewSimpleResponse(nil))
+	tester.close()
+}
+
+func TestIdempotenceIDLogCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_idempotence_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+	ids, err := newIDLog(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if err = ids.Record(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		lines++
+	}
+	f.Close()
+	if lines > 6 {
+		t.Errorf("Expected file to be compacted, found %v lines", lines)
+	}
+
+	if ids, err = newIDLog(path, 3); err != nil {
+		t.Fatal(err)
+	}
+	defer ids.Close()
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		if ids.Has(id) {
+			t.Errorf("Expected %v to have been evicted", id)
+		}
+	}
+	for _, id := range []string{"f", "g", "h"} {
+		if !ids.Has(id) {
+			t.Errorf("Expected %v to be retained", id)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -21,6 +21,7 @@
 package output
 
 import (
+	"errors"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -40,7 +41,32 @@ func init() {
 The kafka output type writes messages to a kafka broker, these messages are
 acknowledged, which is propagated back to the input. The config field
 'ack_replicas' determines whether we wait for acknowledgement from all replicas
-or just a single broker.`,
+or just a single broker.
+
+Each part of a multiple part message is sent as a separate Kafka message, and
+all parts are produced together as a single batch.
+
+If a message part has the metadata field 'kafka_key' then its value is used as
+the key of the Kafka message.
+
+When 'idempotent' is true the producer is idempotent, which prevents retries
+within the producer from writing duplicates, but does not prevent duplicates of
+messages that are replayed by an input.
+
+When 'transactional_id' is set each message is written within a transaction,
+which also commits the consumer offsets of the Kafka messages it was created
+from to the consumer group 'consumer_group'. The offsets are read from the
+metadata fields 'kafka_topic', 'kafka_partition' and 'kafka_offset', which are
+added by the Kafka inputs. Setting 'consumer_group' to the consumer group of a
+Kafka input therefore makes a Kafka to Kafka stream exactly-once, as a message
+and the offset it was consumed from are either both committed or neither are.
+Consumers of the output topic must read with the isolation level
+'read_committed' in order to ignore messages of aborted transactions. The
+transactional ID must be unique to each instance of a stream, and requires Kafka
+0.11 or above.
+
+For other outputs, or Kafka brokers older than 0.11, duplicates of replayed
+messages can be avoided with the 'idempotence' field of this output.`,
 	}
 }
 
@@ -48,21 +74,27 @@ or just a single broker.`,
 
 // KafkaConfig is configuration for the Kafka output type.
 type KafkaConfig struct {
-	Addresses   []string `json:"addresses" yaml:"addresses"`
-	ClientID    string   `json:"client_id" yaml:"client_id"`
-	Topic       string   `json:"topic" yaml:"topic"`
-	TimeoutMS   int      `json:"timeout_ms" yaml:"timeout_ms"`
-	AckReplicas bool     `json:"ack_replicas" yaml:"ack_replicas"`
+	Addresses       []string `json:"addresses" yaml:"addresses"`
+	ClientID        string   `json:"client_id" yaml:"client_id"`
+	Topic           string   `json:"topic" yaml:"topic"`
+	TimeoutMS       int      `json:"timeout_ms" yaml:"timeout_ms"`
+	AckReplicas     bool     `json:"ack_replicas" yaml:"ack_replicas"`
+	Idempotent      bool     `json:"idempotent" yaml:"idempotent"`
+	TransactionalID string   `json:"transactional_id" yaml:"transactional_id"`
+	ConsumerGroup   string   `json:"consumer_group" yaml:"consumer_group"`
 }
 
 // NewKafkaConfig creates a new KafkaConfig with default values.
 func NewKafkaConfig() KafkaConfig {
 	return KafkaConfig{
-		Addresses:   []string{"localhost:9092"},
-		ClientID:    "benthos_kafka_output",
-		Topic:       "benthos_stream",
-		TimeoutMS:   5000,
-		AckReplicas: true,
+		Addresses:       []string{"localhost:9092"},
+		ClientID:        "benthos_kafka_output",
+		Topic:           "benthos_stream",
+		TimeoutMS:       5000,
+		AckReplicas:     true,
+		Idempotent:      false,
+		TransactionalID: "",
+		ConsumerGroup:   "",
 	}
 }
 
@@ -78,7 +110,8 @@ type Kafka struct {
 	addresses []string
 	conf      Config
 
-	producer sarama.SyncProducer
+	producer   sarama.SyncProducer
+	transactor *kafkaTransactor
 
 	messages     <-chan types.Message
 	responseChan chan types.Response
@@ -106,6 +139,12 @@ func NewKafka(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
 			}
 		}
 	}
+	if (conf.Kafka.Idempotent || len(conf.Kafka.TransactionalID) > 0) && !conf.Kafka.AckReplicas {
+		return nil, errors.New("idempotent and transactional producing require ack_replicas")
+	}
+	if len(conf.Kafka.ConsumerGroup) > 0 && len(conf.Kafka.TransactionalID) == 0 {
+		return nil, errors.New("consumer_group requires a transactional_id")
+	}
 
 	return &k, nil
 }
@@ -126,12 +165,44 @@ func (k *Kafka) connect() error {
 		config.Producer.RequiredAcks = sarama.WaitForLocal
 	}
 
+	if k.conf.Kafka.Idempotent {
+		config.Producer.Idempotent = true
+		config.Net.MaxOpenRequests = 1
+	}
+
+	if len(k.conf.Kafka.TransactionalID) > 0 {
+		client, err := sarama.NewClient(k.addresses, config)
+		if err != nil {
+			return err
+		}
+		k.transactor, err = newKafkaTransactor(client, k.conf.Kafka)
+		return err
+	}
+
 	var err error
 	k.producer, err = sarama.NewSyncProducer(k.addresses, config)
 
 	return err
 }
 
+// send writes the parts of a message to Kafka.
+func (k *Kafka) send(msg types.Message) error {
+	if k.transactor != nil {
+		return k.transactor.Send(msg)
+	}
+	pMsgs := make([]*sarama.ProducerMessage, len(msg.Parts))
+	for i, part := range msg.Parts {
+		pMsgs[i] = &sarama.ProducerMessage{
+			Topic: k.conf.Kafka.Topic,
+			Value: sarama.ByteEncoder(part),
+		}
+		if key := msg.GetMetadata(i, "kafka_key"); len(key) > 0 {
+			pMsgs[i].Key = sarama.StringEncoder(key)
+		}
+	}
+	return k.producer.SendMessages(pMsgs)
+}
+
 // loop is an internal loop that brokers incoming messages to output pipe, does
 // not use select.
 func (k *Kafka) loop() {
@@ -140,6 +211,9 @@ func (k *Kafka) loop() {
 		if nil != k.producer {
 			k.producer.Close()
 		}
+		if nil != k.transactor {
+			k.transactor.Close()
+		}
 		close(k.responseChan)
 		close(k.closedChan)
 	}()
@@ -165,17 +239,11 @@ func (k *Kafka) loop() {
 			return
 		}
 		k.stats.Incr("output.kafka.count", 1)
-		var err error
-		for _, part := range msg.Parts {
-			if _, _, err = k.producer.SendMessage(&sarama.ProducerMessage{
-				Topic: k.conf.Kafka.Topic,
-				Value: sarama.ByteEncoder(part),
-			}); err != nil {
-				k.stats.Incr("output.kafka.send.error", 1)
-				break
-			} else {
-				k.stats.Incr("output.kafka.send.success", 1)
-			}
+		err := k.send(msg)
+		if err != nil {
+			k.stats.Incr("output.kafka.send.error", 1)
+		} else {
+			k.stats.Incr("output.kafka.send.success", 1)
 		}
 		select {
 		case k.responseChan <- types.NewSimpleResponse(err):
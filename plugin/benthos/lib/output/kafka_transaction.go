@@ -0,0 +1,373 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Shopify/sarama"
+)
+
+//------------------------------------------------------------------------------
+
+// kafkaTopicPartition identifies a single partition of a topic.
+type kafkaTopicPartition struct {
+	topic     string
+	partition int32
+}
+
+// kafkaError returns a Kafka error code as an error, or nil if it is
+// ErrNoError.
+func kafkaError(kErr sarama.KError) error {
+	if kErr != sarama.ErrNoError {
+		return kErr
+	}
+	return nil
+}
+
+// consumedOffsets returns the offsets to commit for the messages consumed from
+// Kafka that a message was created from, which are read from the metadata
+// fields 'kafka_topic', 'kafka_partition' and 'kafka_offset' of each part.
+func consumedOffsets(msg types.Message) map[kafkaTopicPartition]int64 {
+	offsets := map[kafkaTopicPartition]int64{}
+	for i := range msg.Parts {
+		topic := msg.GetMetadata(i, "kafka_topic")
+		partition, err := strconv.ParseInt(msg.GetMetadata(i, "kafka_partition"), 10, 32)
+		if len(topic) == 0 || err != nil {
+			continue
+		}
+		offset, err := strconv.ParseInt(msg.GetMetadata(i, "kafka_offset"), 10, 64)
+		if err != nil {
+			continue
+		}
+		p := kafkaTopicPartition{topic: topic, partition: int32(partition)}
+		if current, exists := offsets[p]; !exists || offset+1 > current {
+			offsets[p] = offset + 1
+		}
+	}
+	return offsets
+}
+
+//------------------------------------------------------------------------------
+
+// kafkaTransactor writes messages to Kafka within transactions, where each
+// transaction also commits the offsets of the messages consumed from Kafka that
+// the written messages were created from.
+type kafkaTransactor struct {
+	id      string
+	group   string
+	topic   string
+	timeout time.Duration
+
+	client      sarama.Client
+	partitioner sarama.Partitioner
+	coordinator *sarama.Broker
+
+	initialised   bool
+	producerID    int64
+	producerEpoch int16
+	sequences     map[kafkaTopicPartition]int32
+}
+
+func newKafkaTransactor(client sarama.Client, conf KafkaConfig) (*kafkaTransactor, error) {
+	t := &kafkaTransactor{
+		id:          conf.TransactionalID,
+		group:       conf.ConsumerGroup,
+		topic:       conf.Topic,
+		timeout:     time.Duration(conf.TimeoutMS) * time.Millisecond,
+		client:      client,
+		partitioner: sarama.NewHashPartitioner(conf.Topic),
+	}
+	if err := t.init(); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// init obtains the producer ID and epoch of the transactional ID, which also
+// aborts any transaction left open by a previous producer with the same ID.
+func (t *kafkaTransactor) init() error {
+	t.initialised = false
+	if t.coordinator == nil {
+		controller, err := t.client.Controller()
+		if err != nil {
+			return err
+		}
+		res, err := controller.FindCoordinator(&sarama.FindCoordinatorRequest{
+			Version:         1,
+			CoordinatorKey:  t.id,
+			CoordinatorType: sarama.CoordinatorTransaction,
+		})
+		if err == nil {
+			err = kafkaError(res.Err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find transaction coordinator: %v", err)
+		}
+		coordinator := res.Coordinator
+		if err = coordinator.Open(t.client.Config()); err != nil {
+			return err
+		}
+		t.coordinator = coordinator
+	}
+
+	res, err := t.coordinator.InitProducerID(&sarama.InitProducerIDRequest{
+		TransactionalID:    &t.id,
+		TransactionTimeout: t.timeout,
+	})
+	if err == nil {
+		err = kafkaError(res.Err)
+	}
+	if err != nil {
+		t.coordinator.Close()
+		t.coordinator = nil
+		return fmt.Errorf("failed to initialise transactional producer: %v", err)
+	}
+
+	t.producerID = res.ProducerID
+	t.producerEpoch = res.ProducerEpoch
+	t.sequences = map[kafkaTopicPartition]int32{}
+	t.initialised = true
+	return nil
+}
+
+// partitionErrors returns the first error of a map of partition errors.
+func partitionErrors(errs map[string][]*sarama.PartitionError) error {
+	for topic, pErrs := range errs {
+		for _, pErr := range pErrs {
+			if pErr.Err != sarama.ErrNoError {
+				return fmt.Errorf("topic %v partition %v: %v", topic, pErr.Partition, pErr.Err)
+			}
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// Send writes each part of a message to Kafka and commits the offsets consumed
+// from Kafka within a single transaction. If the transaction fails it is
+// aborted and none of the parts or offsets are visible to readers.
+func (t *kafkaTransactor) Send(msg types.Message) error {
+	if !t.initialised {
+		if err := t.init(); err != nil {
+			return err
+		}
+	}
+
+	err := t.transact(msg)
+	if err == nil {
+		return nil
+	}
+
+	t.coordinator.EndTxn(&sarama.EndTxnRequest{
+		TransactionalID:   t.id,
+		ProducerID:        t.producerID,
+		ProducerEpoch:     t.producerEpoch,
+		TransactionResult: false,
+	})
+
+	// Obtaining a new producer epoch aborts the transaction in case the abort
+	// request failed, and also resets the sequence numbers of partitions.
+	if initErr := t.init(); initErr != nil {
+		return fmt.Errorf("%v, and failed to reset producer: %v", err, initErr)
+	}
+	return err
+}
+
+func (t *kafkaTransactor) transact(msg types.Message) error {
+	records := map[kafkaTopicPartition][]*sarama.Record{}
+	topicPartitions := map[string][]int32{}
+
+	partitions, err := t.client.Partitions(t.topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("topic %v has no partitions", t.topic)
+	}
+	for i, part := range msg.Parts {
+		pMsg := &sarama.ProducerMessage{
+			Topic: t.topic,
+			Value: sarama.ByteEncoder(part),
+		}
+		record := &sarama.Record{Value: part}
+		if key := msg.GetMetadata(i, "kafka_key"); len(key) > 0 {
+			pMsg.Key = sarama.StringEncoder(key)
+			record.Key = []byte(key)
+		}
+		index, err := t.partitioner.Partition(pMsg, int32(len(partitions)))
+		if err != nil {
+			return err
+		}
+		p := kafkaTopicPartition{topic: t.topic, partition: partitions[index]}
+		if _, exists := records[p]; !exists {
+			topicPartitions[p.topic] = append(topicPartitions[p.topic], p.partition)
+		}
+		record.OffsetDelta = int64(len(records[p]))
+		records[p] = append(records[p], record)
+	}
+
+	addRes, err := t.coordinator.AddPartitionsToTxn(&sarama.AddPartitionsToTxnRequest{
+		TransactionalID: t.id,
+		ProducerID:      t.producerID,
+		ProducerEpoch:   t.producerEpoch,
+		TopicPartitions: topicPartitions,
+	})
+	if err == nil {
+		err = partitionErrors(addRes.Errors)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add partitions to transaction: %v", err)
+	}
+
+	if err = t.produce(records); err != nil {
+		return err
+	}
+	if err = t.commitOffsets(consumedOffsets(msg)); err != nil {
+		return err
+	}
+
+	endRes, err := t.coordinator.EndTxn(&sarama.EndTxnRequest{
+		TransactionalID:   t.id,
+		ProducerID:        t.producerID,
+		ProducerEpoch:     t.producerEpoch,
+		TransactionResult: true,
+	})
+	if err == nil {
+		err = kafkaError(endRes.Err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// produce writes transactional record batches to the leaders of their
+// partitions.
+func (t *kafkaTransactor) produce(records map[kafkaTopicPartition][]*sarama.Record) error {
+	requests := map[*sarama.Broker]*sarama.ProduceRequest{}
+	now := time.Now()
+	for p, recs := range records {
+		leader, err := t.client.Leader(p.topic, p.partition)
+		if err != nil {
+			return err
+		}
+		req, exists := requests[leader]
+		if !exists {
+			req = &sarama.ProduceRequest{
+				TransactionalID: &t.id,
+				RequiredAcks:    sarama.WaitForAll,
+				Timeout:         int32(t.timeout / time.Millisecond),
+				Version:         3,
+			}
+			requests[leader] = req
+		}
+		req.AddBatch(p.topic, p.partition, &sarama.RecordBatch{
+			Version:         2,
+			FirstTimestamp:  now,
+			MaxTimestamp:    now,
+			LastOffsetDelta: int32(len(recs) - 1),
+			ProducerID:      t.producerID,
+			ProducerEpoch:   t.producerEpoch,
+			FirstSequence:   t.sequences[p],
+			IsTransactional: true,
+			Records:         recs,
+		})
+	}
+
+	for leader, req := range requests {
+		res, err := leader.Produce(req)
+		if err != nil {
+			return fmt.Errorf("failed to produce messages: %v", err)
+		}
+		for p := range records {
+			if block := res.GetBlock(p.topic, p.partition); block != nil && block.Err != sarama.ErrNoError {
+				return fmt.Errorf("failed to produce messages: %v", block.Err)
+			}
+		}
+	}
+	for p, recs := range records {
+		t.sequences[p] += int32(len(recs))
+	}
+	return nil
+}
+
+// commitOffsets adds the offsets of consumed messages to the transaction.
+func (t *kafkaTransactor) commitOffsets(offsets map[kafkaTopicPartition]int64) error {
+	if len(offsets) == 0 || len(t.group) == 0 {
+		return nil
+	}
+
+	addRes, err := t.coordinator.AddOffsetsToTxn(&sarama.AddOffsetsToTxnRequest{
+		TransactionalID: t.id,
+		ProducerID:      t.producerID,
+		ProducerEpoch:   t.producerEpoch,
+		GroupID:         t.group,
+	})
+	if err == nil {
+		err = kafkaError(addRes.Err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add offsets to transaction: %v", err)
+	}
+
+	groupCoordinator, err := t.client.Coordinator(t.group)
+	if err != nil {
+		return err
+	}
+	topics := map[string][]*sarama.PartitionOffsetMetadata{}
+	for p, offset := range offsets {
+		topics[p.topic] = append(topics[p.topic], &sarama.PartitionOffsetMetadata{
+			Partition: p.partition,
+			Offset:    offset,
+		})
+	}
+	commitRes, err := groupCoordinator.TxnOffsetCommit(&sarama.TxnOffsetCommitRequest{
+		TransactionalID: t.id,
+		GroupID:         t.group,
+		ProducerID:      t.producerID,
+		ProducerEpoch:   t.producerEpoch,
+		Topics:          topics,
+	})
+	if err == nil {
+		err = partitionErrors(commitRes.Topics)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to commit offsets: %v", err)
+	}
+	return nil
+}
+
+// Close closes the connection to the transaction coordinator and the client.
+func (t *kafkaTransactor) Close() error {
+	if t.coordinator != nil {
+		t.coordinator.Close()
+		t.coordinator = nil
+	}
+	return t.client.Close()
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,222 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Shopify/sarama"
+)
+
+//------------------------------------------------------------------------------
+
+func TestKafkaConsumedOffsets(t *testing.T) {
+	msg := types.Message{Parts: [][]byte{
+		[]byte("foo"), []byte("bar"), []byte("baz"), []byte("qux"),
+	}}
+	for i, meta := range [][3]string{
+		{"foo", "0", "5"},
+		{"foo", "0", "7"},
+		{"foo", "1", "2"},
+		{"", "", ""},
+	} {
+		if len(meta[0]) > 0 {
+			msg.SetMetadata(i, "kafka_topic", meta[0])
+			msg.SetMetadata(i, "kafka_partition", meta[1])
+			msg.SetMetadata(i, "kafka_offset", meta[2])
+		}
+	}
+
+	exp := map[kafkaTopicPartition]int64{
+		{topic: "foo", partition: 0}: 8,
+		{topic: "foo", partition: 1}: 3,
+	}
+	if act := consumedOffsets(msg); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong offsets: %v != %v", act, exp)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func newTransactionMockBroker(t *testing.T, produceErr sarama.KError) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("out", 0, broker.BrokerID()),
+		// The transaction coordinator is found first, followed by the group
+		// coordinator, which the client requests with an older version.
+		"FindCoordinatorRequest": sarama.NewMockSequence(
+			sarama.NewMockWrapper(&sarama.FindCoordinatorResponse{
+				Version:     1,
+				Coordinator: sarama.NewBroker(broker.Addr()),
+			}),
+			sarama.NewMockWrapper(&sarama.FindCoordinatorResponse{
+				Version:     0,
+				Coordinator: sarama.NewBroker(broker.Addr()),
+			}),
+		),
+		"InitProducerIDRequest": sarama.NewMockWrapper(&sarama.InitProducerIDResponse{
+			ProducerID:    10,
+			ProducerEpoch: 1,
+		}),
+		"AddPartitionsToTxnRequest": sarama.NewMockWrapper(&sarama.AddPartitionsToTxnResponse{
+			Errors: map[string][]*sarama.PartitionError{
+				"out": {{Partition: 0, Err: sarama.ErrNoError}},
+			},
+		}),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetVersion(3).
+			SetError("out", 0, produceErr),
+		"AddOffsetsToTxnRequest": sarama.NewMockWrapper(&sarama.AddOffsetsToTxnResponse{}),
+		"TxnOffsetCommitRequest": sarama.NewMockWrapper(&sarama.TxnOffsetCommitResponse{
+			Topics: map[string][]*sarama.PartitionError{
+				"in": {{Partition: 0, Err: sarama.ErrNoError}},
+			},
+		}),
+		"EndTxnRequest": sarama.NewMockWrapper(&sarama.EndTxnResponse{}),
+	})
+	return broker
+}
+
+// transactionRequests returns the transactional requests received by a mock
+// broker in the order they were received.
+func transactionRequests(broker *sarama.MockBroker) []interface{} {
+	reqs := []interface{}{}
+	for _, rr := range broker.History() {
+		switch rr.Request.(type) {
+		case *sarama.InitProducerIDRequest,
+			*sarama.AddPartitionsToTxnRequest,
+			*sarama.ProduceRequest,
+			*sarama.AddOffsetsToTxnRequest,
+			*sarama.TxnOffsetCommitRequest,
+			*sarama.EndTxnRequest:
+			reqs = append(reqs, rr.Request)
+		}
+	}
+	return reqs
+}
+
+func newTestTransactor(t *testing.T, broker *sarama.MockBroker) *kafkaTransactor {
+	config := sarama.NewConfig()
+	config.Net.DialTimeout = time.Second
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewKafkaConfig()
+	conf.Topic = "out"
+	conf.TransactionalID = "txn"
+	conf.ConsumerGroup = "group"
+
+	txn, err := newKafkaTransactor(client, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return txn
+}
+
+func TestKafkaTransactorSend(t *testing.T) {
+	broker := newTransactionMockBroker(t, sarama.ErrNoError)
+	defer broker.Close()
+
+	txn := newTestTransactor(t, broker)
+	defer txn.Close()
+
+	msg := types.Message{Parts: [][]byte{[]byte("foo"), []byte("bar")}}
+	for i, offset := range []string{"5", "6"} {
+		msg.SetMetadata(i, "kafka_topic", "in")
+		msg.SetMetadata(i, "kafka_partition", "0")
+		msg.SetMetadata(i, "kafka_offset", offset)
+	}
+	if err := txn.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := transactionRequests(broker)
+	if exp, act := 6, len(reqs); exp != act {
+		t.Fatalf("Wrong count of requests: %v != %v", act, exp)
+	}
+	if _, ok := reqs[0].(*sarama.InitProducerIDRequest); !ok {
+		t.Errorf("Expected producer to be initialised, received %T", reqs[0])
+	}
+	if req, ok := reqs[1].(*sarama.AddPartitionsToTxnRequest); !ok {
+		t.Errorf("Expected partitions to be added, received %T", reqs[1])
+	} else if exp, act := map[string][]int32{"out": {0}}, req.TopicPartitions; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong partitions added: %v != %v", act, exp)
+	}
+	if req, ok := reqs[2].(*sarama.ProduceRequest); !ok {
+		t.Errorf("Expected messages to be produced, received %T", reqs[2])
+	} else if req.TransactionalID == nil || *req.TransactionalID != "txn" {
+		t.Errorf("Messages were not produced transactionally: %v", req.TransactionalID)
+	}
+	if req, ok := reqs[3].(*sarama.AddOffsetsToTxnRequest); !ok {
+		t.Errorf("Expected offsets to be added, received %T", reqs[3])
+	} else if exp, act := "group", req.GroupID; exp != act {
+		t.Errorf("Wrong group: %v != %v", act, exp)
+	}
+	if req, ok := reqs[4].(*sarama.TxnOffsetCommitRequest); !ok {
+		t.Errorf("Expected offsets to be committed, received %T", reqs[4])
+	} else if offsets := req.Topics["in"]; len(offsets) != 1 || offsets[0].Offset != 7 {
+		t.Errorf("Wrong offsets committed: %v", offsets)
+	}
+	if req, ok := reqs[5].(*sarama.EndTxnRequest); !ok {
+		t.Errorf("Expected transaction to end, received %T", reqs[5])
+	} else if !req.TransactionResult {
+		t.Error("Expected transaction to be committed")
+	}
+}
+
+func TestKafkaTransactorAbort(t *testing.T) {
+	broker := newTransactionMockBroker(t, sarama.ErrNotEnoughReplicas)
+	defer broker.Close()
+
+	txn := newTestTransactor(t, broker)
+	defer txn.Close()
+
+	msg := types.Message{Parts: [][]byte{[]byte("foo")}}
+	msg.SetMetadata(0, "kafka_topic", "in")
+	msg.SetMetadata(0, "kafka_partition", "0")
+	msg.SetMetadata(0, "kafka_offset", "5")
+	if err := txn.Send(msg); err == nil {
+		t.Fatal("Expected error from failed produce")
+	}
+
+	reqs := transactionRequests(broker)
+	if exp, act := 5, len(reqs); exp != act {
+		t.Fatalf("Wrong count of requests: %v != %v", act, exp)
+	}
+	if req, ok := reqs[3].(*sarama.EndTxnRequest); !ok {
+		t.Errorf("Expected transaction to end, received %T", reqs[3])
+	} else if req.TransactionResult {
+		t.Error("Expected transaction to be aborted")
+	}
+	if _, ok := reqs[4].(*sarama.InitProducerIDRequest); !ok {
+		t.Errorf("Expected producer to be reset, received %T", reqs[4])
+	}
+}
+
+//------------------------------------------------------------------------------
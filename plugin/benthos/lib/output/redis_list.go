@@ -0,0 +1,246 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/go-redis/redis"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["redis_list"] = typeSpec{
+		constructor: NewRedisList,
+		description: `
+Pushes messages onto the start of a Redis list using LPUSH, where each part of a
+message is pushed as an individual item. A message is acknowledged once all of
+its parts have been pushed.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// RedisListConfig is configuration for the RedisList output type.
+type RedisListConfig struct {
+	URL string `json:"url" yaml:"url"`
+	Key string `json:"key" yaml:"key"`
+}
+
+// NewRedisListConfig creates a new RedisListConfig with default values.
+func NewRedisListConfig() RedisListConfig {
+	return RedisListConfig{
+		URL: "tcp://localhost:6379",
+		Key: "benthos_list",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// RedisList is an output type that pushes messages onto a Redis list.
+type RedisList struct {
+	running int32
+
+	log   log.Modular
+	stats metrics.Type
+
+	url  *url.URL
+	conf Config
+
+	client *redis.Client
+
+	messages     <-chan types.Message
+	responseChan chan types.Response
+
+	closedChan chan struct{}
+	closeChan  chan struct{}
+}
+
+// NewRedisList creates a new RedisList output type.
+func NewRedisList(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	r := &RedisList{
+		running:      1,
+		log:          log.NewModule(".output.redis_list"),
+		stats:        stats,
+		conf:         conf,
+		messages:     nil,
+		responseChan: make(chan types.Response),
+		closedChan:   make(chan struct{}),
+		closeChan:    make(chan struct{}),
+	}
+
+	var err error
+	r.url, err = url.Parse(conf.RedisList.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+//------------------------------------------------------------------------------
+
+// connect establishes a connection to a Redis server.
+func (r *RedisList) connect() error {
+	var pass string
+	if r.url.User != nil {
+		pass, _ = r.url.User.Password()
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     r.url.Host,
+		Network:  r.url.Scheme,
+		Password: pass,
+	})
+
+	if _, err := client.Ping().Result(); err != nil {
+		return err
+	}
+
+	r.client = client
+	return nil
+}
+
+// disconnect safely closes a connection to a Redis server.
+func (r *RedisList) disconnect() error {
+	if r.client != nil {
+		err := r.client.Close()
+		r.client = nil
+		return err
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// loop is an internal loop that brokers incoming messages to output pipe.
+func (r *RedisList) loop() {
+	defer func() {
+		atomic.StoreInt32(&r.running, 0)
+
+		if err := r.disconnect(); err != nil {
+			r.log.Errorf("Failed to disconnect redis client: %v\n", err)
+		}
+
+		close(r.responseChan)
+		close(r.closedChan)
+	}()
+
+	for {
+		if err := r.connect(); err != nil {
+			r.log.Errorf("Failed to connect to Redis: %v\n", err)
+			select {
+			case <-time.After(time.Second):
+			case <-r.closeChan:
+				return
+			}
+		} else {
+			break
+		}
+	}
+	r.log.Infof("Sending messages to Redis list: %s\n", r.conf.RedisList.Key)
+
+	var open bool
+	for atomic.LoadInt32(&r.running) == 1 {
+		for r.client == nil {
+			r.log.Warnln("Lost Redis connection, attempting to reconnect.")
+			if err := r.connect(); err != nil {
+				r.stats.Incr("output.redis_list.reconnect.error", 1)
+				select {
+				case <-time.After(time.Second):
+				case <-r.closeChan:
+					return
+				}
+			} else {
+				r.log.Warnln("Successfully reconnected to Redis.")
+				r.stats.Incr("output.redis_list.reconnect.success", 1)
+			}
+		}
+
+		var msg types.Message
+		select {
+		case msg, open = <-r.messages:
+			if !open {
+				return
+			}
+		case <-r.closeChan:
+			return
+		}
+
+		r.stats.Incr("output.redis_list.count", 1)
+		parts := make([]interface{}, len(msg.Parts))
+		for i, part := range msg.Parts {
+			parts[i] = part
+		}
+		err := r.client.LPush(r.conf.RedisList.Key, parts...).Err()
+		if err == nil {
+			r.stats.Incr("output.redis_list.send.success", 1)
+		} else {
+			r.disconnect()
+			r.stats.Incr("output.redis_list.send.error", 1)
+		}
+
+		select {
+		case r.responseChan <- types.NewSimpleResponse(err):
+		case <-r.closeChan:
+			return
+		}
+	}
+}
+
+// StartReceiving assigns a messages channel for the output to read.
+func (r *RedisList) StartReceiving(msgs <-chan types.Message) error {
+	if r.messages != nil {
+		return types.ErrAlreadyStarted
+	}
+	r.messages = msgs
+	go r.loop()
+	return nil
+}
+
+// ResponseChan returns the errors channel.
+func (r *RedisList) ResponseChan() <-chan types.Response {
+	return r.responseChan
+}
+
+// CloseAsync shuts down the RedisList output and stops processing messages.
+func (r *RedisList) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&r.running, 1, 0) {
+		close(r.closeChan)
+	}
+}
+
+// WaitForClose blocks until the RedisList output has closed down.
+func (r *RedisList) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-r.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package output
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/benthos/lib/util/test"
+)
+
+//------------------------------------------------------------------------------
+
+// redisSend sends a message to an output and returns the response.
+func redisSend(t *testing.T, msgChan chan<- types.Message, out Type, msg types.Message) types.Response {
+	select {
+	case msgChan <- msg:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for message to be read")
+	}
+	select {
+	case res := <-out.ResponseChan():
+		return res
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for response")
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+func TestRedisListOutput(t *testing.T) {
+	server, err := test.NewRedisServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewConfig()
+	conf.RedisList.URL = server.URL()
+	conf.RedisList.Key = "foo"
+
+	out, err := NewRedisList(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgChan := make(chan types.Message)
+	if err = out.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		out.CloseAsync()
+		if err := out.WaitForClose(time.Second * 5); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res := redisSend(t, msgChan, out, types.Message{Parts: [][]byte{[]byte("bar"), []byte("baz")}})
+	if err = res.Error(); err != nil {
+		t.Fatal(err)
+	}
+	res = redisSend(t, msgChan, out, types.Message{Parts: [][]byte{[]byte("qux")}})
+	if err = res.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := []string{"qux", "baz", "bar"}, server.List("foo"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong list contents: %v != %v", act, exp)
+	}
+
+	// Failing to push a message results in an error response.
+	server.Close()
+	if res = redisSend(t, msgChan, out, types.Message{Parts: [][]byte{[]byte("quz")}}); res.Error() == nil {
+		t.Error("Expected error response from closed server")
+	}
+}
+
+//------------------------------------------------------------------------------
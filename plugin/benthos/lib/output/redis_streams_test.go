@@ -0,0 +1,94 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package output
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/benthos/lib/util/test"
+)
+
+//------------------------------------------------------------------------------
+
+func TestRedisStreamsOutput(t *testing.T) {
+	server, err := test.NewRedisServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	conf := NewConfig()
+	conf.RedisStreams.URL = server.URL()
+	conf.RedisStreams.Stream = "foo"
+	conf.RedisStreams.MaxLength = 3
+
+	out, err := NewRedisStreams(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgChan := make(chan types.Message)
+	if err = out.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		out.CloseAsync()
+		if err := out.WaitForClose(time.Second * 5); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	msg := types.Message{Parts: [][]byte{[]byte("hello 1"), []byte("hello 2")}}
+	msg.SetMetadata(0, "source", "bar")
+	if err = redisSend(t, msgChan, out, msg).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := server.Stream("foo")
+	if len(entries) != 2 {
+		t.Fatalf("Wrong count of entries: %v", len(entries))
+	}
+	if exp, act := map[string]string{"body": "hello 1", "source": "bar"}, entries[0].Values; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong entry values: %v != %v", act, exp)
+	}
+	if exp, act := map[string]string{"body": "hello 2"}, entries[1].Values; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong entry values: %v != %v", act, exp)
+	}
+
+	// The stream is trimmed to the max length as entries are added.
+	msg = types.Message{Parts: [][]byte{[]byte("hello 3"), []byte("hello 4")}}
+	if err = redisSend(t, msgChan, out, msg).Error(); err != nil {
+		t.Fatal(err)
+	}
+	bodies := []string{}
+	for _, e := range server.Stream("foo") {
+		bodies = append(bodies, e.Values["body"])
+	}
+	if exp := []string{"hello 2", "hello 3", "hello 4"}; !reflect.DeepEqual(exp, bodies) {
+		t.Errorf("Wrong stream contents: %v != %v", bodies, exp)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,306 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// RetryConfig contains configuration for the retry policy of an output. When
+// MaxAttempts is zero failed messages are returned to the input, which retries
+// them indefinitely. Otherwise messages that fail every attempt are sent to the
+// dead letter output when set, or are dropped.
+type RetryConfig struct {
+	MaxAttempts      int         `json:"max_attempts" yaml:"max_attempts"`
+	InitialBackoffMS int64       `json:"initial_backoff_ms" yaml:"initial_backoff_ms"`
+	MaxBackoffMS     int64       `json:"max_backoff_ms" yaml:"max_backoff_ms"`
+	Jitter           float64     `json:"jitter" yaml:"jitter"`
+	DeadLetter       interface{} `json:"dead_letter" yaml:"dead_letter"`
+}
+
+// NewRetryConfig creates a new RetryConfig with default values.
+func NewRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:      0,
+		InitialBackoffMS: 100,
+		MaxBackoffMS:     5000,
+		Jitter:           0.2,
+		DeadLetter:       nil,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// DeadLetterErrorKey is the metadata key set on each part of a message sent to
+// a dead letter output, containing the last error returned by the output.
+const DeadLetterErrorKey = "dead_letter_error"
+
+// ErrDeadLetterNoMaxAttempts is returned when a dead letter output is
+// configured without a maximum number of retry attempts.
+var ErrDeadLetterNoMaxAttempts = errors.New("a dead letter output requires retry max_attempts to be set")
+
+// Retry is a type that wraps an output and resends messages that fail to be
+// delivered according to a retry policy. Messages that exhaust their attempts
+// are sent to an optional dead letter output, and are otherwise dropped.
+type Retry struct {
+	running int32
+
+	conf  RetryConfig
+	log   log.Modular
+	stats metrics.Type
+
+	out        Type
+	deadLetter Type
+
+	messagesIn   <-chan types.Message
+	responsesOut chan types.Response
+
+	outMessages chan types.Message
+	dlMessages  chan types.Message
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// WrapWithRetry wraps an output with a retry policy. If the policy is disabled
+// the output is returned unchanged.
+func WrapWithRetry(
+	out Type, conf RetryConfig, log log.Modular, stats metrics.Type,
+) (Type, error) {
+	if conf.MaxAttempts <= 0 {
+		if conf.DeadLetter != nil {
+			return nil, ErrDeadLetterNoMaxAttempts
+		}
+		return out, nil
+	}
+
+	r := &Retry{
+		running:      1,
+		conf:         conf,
+		log:          log.NewModule(".retry"),
+		stats:        stats,
+		out:          out,
+		responsesOut: make(chan types.Response),
+		outMessages:  make(chan types.Message),
+		closeChan:    make(chan struct{}),
+		closedChan:   make(chan struct{}),
+	}
+	if err := out.StartReceiving(r.outMessages); err != nil {
+		return nil, err
+	}
+
+	if conf.DeadLetter != nil {
+		dlConfs, err := parseOutputConfsWithDefaults([]interface{}{conf.DeadLetter})
+		if err != nil {
+			return nil, err
+		}
+		if len(dlConfs) != 1 {
+			return nil, errors.New("dead letter output cannot be duplicated with ditto")
+		}
+		if r.deadLetter, err = New(dlConfs[0], log.NewModule(".dead_letter"), stats); err != nil {
+			return nil, err
+		}
+		r.dlMessages = make(chan types.Message)
+		if err = r.deadLetter.StartReceiving(r.dlMessages); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+//------------------------------------------------------------------------------
+
+// backoff returns the period to wait before the next attempt, where attempt is
+// the number of attempts that have already failed.
+func (r *Retry) backoff(attempt int) time.Duration {
+	backoff := r.conf.InitialBackoffMS
+	for i := 1; i < attempt && backoff < r.conf.MaxBackoffMS; i++ {
+		backoff *= 2
+	}
+	if backoff > r.conf.MaxBackoffMS {
+		backoff = r.conf.MaxBackoffMS
+	}
+	period := time.Duration(backoff) * time.Millisecond
+	if r.conf.Jitter > 0 {
+		period -= time.Duration(r.conf.Jitter * rand.Float64() * float64(period))
+	}
+	return period
+}
+
+// send writes a message to an output and returns its response, or false if
+// the type was closed whilst waiting.
+func (r *Retry) send(
+	msgChan chan<- types.Message, resChan <-chan types.Response, msg types.Message,
+) (types.Response, bool) {
+	select {
+	case msgChan <- msg:
+	case <-r.closeChan:
+		return nil, false
+	}
+	select {
+	case res, open := <-resChan:
+		return res, open
+	case <-r.closeChan:
+		return nil, false
+	}
+}
+
+// deadLetterMessage returns a copy of a message tagged with an error.
+func deadLetterMessage(msg types.Message, err error) types.Message {
+	dlMsg := types.Message{
+		Parts: msg.Parts,
+	}
+	for i := range msg.Parts {
+		for k, v := range msg.PartMetadata(i) {
+			dlMsg.SetMetadata(i, k, v)
+		}
+		dlMsg.SetMetadata(i, DeadLetterErrorKey, err.Error())
+	}
+	return dlMsg
+}
+
+// loop is an internal loop that forwards messages to the wrapped output and
+// applies the retry policy to failed sends.
+func (r *Retry) loop() {
+	defer func() {
+		close(r.outMessages)
+		if r.dlMessages != nil {
+			close(r.dlMessages)
+		}
+		close(r.responsesOut)
+		close(r.closedChan)
+	}()
+
+	for atomic.LoadInt32(&r.running) == 1 {
+		var msg types.Message
+		var open bool
+		select {
+		case msg, open = <-r.messagesIn:
+			if !open {
+				return
+			}
+		case <-r.closeChan:
+			return
+		}
+
+		var res types.Response
+		for attempt := 1; ; attempt++ {
+			if res, open = r.send(r.outMessages, r.out.ResponseChan(), msg); !open {
+				return
+			}
+			if res.Error() == nil || attempt >= r.conf.MaxAttempts {
+				break
+			}
+			r.stats.Incr("output.retry.count", 1)
+			r.log.Warnf("Failed to send message, attempt %v of %v: %v\n", attempt, r.conf.MaxAttempts, res.Error())
+			select {
+			case <-time.After(r.backoff(attempt)):
+			case <-r.closeChan:
+				return
+			}
+		}
+
+		if res.Error() != nil {
+			r.stats.Incr("output.retry.exhausted", 1)
+			if r.deadLetter == nil {
+				r.stats.Incr("output.retry.dropped", 1)
+				r.log.Errorf("Dropping message after %v failed attempts: %v\n", r.conf.MaxAttempts, res.Error())
+				res = types.NewSimpleResponse(nil)
+			} else {
+				r.log.Errorf("Sending message to dead letter output after %v failed attempts: %v\n", r.conf.MaxAttempts, res.Error())
+				r.stats.Incr("output.retry.dead_letter.count", 1)
+				if res, open = r.send(r.dlMessages, r.deadLetter.ResponseChan(), deadLetterMessage(msg, res.Error())); !open {
+					r.stats.Incr("output.retry.dead_letter.error", 1)
+					return
+				}
+				if res.Error() == nil {
+					r.stats.Incr("output.retry.dead_letter.success", 1)
+				} else {
+					r.stats.Incr("output.retry.dead_letter.error", 1)
+					r.log.Errorf("Failed to send message to dead letter output, returning it to the input: %v\n", res.Error())
+				}
+			}
+		}
+
+		select {
+		case r.responsesOut <- res:
+		case <-r.closeChan:
+			return
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// StartReceiving assigns a messages channel for the output to read.
+func (r *Retry) StartReceiving(msgs <-chan types.Message) error {
+	if r.messagesIn != nil {
+		return types.ErrAlreadyStarted
+	}
+	r.messagesIn = msgs
+	go r.loop()
+	return nil
+}
+
+// ResponseChan returns the channel used for reading response messages from this
+// output.
+func (r *Retry) ResponseChan() <-chan types.Response {
+	return r.responsesOut
+}
+
+// CloseAsync shuts down the Retry output and stops processing messages.
+func (r *Retry) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&r.running, 1, 0) {
+		close(r.closeChan)
+	}
+	r.out.CloseAsync()
+	if r.deadLetter != nil {
+		r.deadLetter.CloseAsync()
+	}
+}
+
+// WaitForClose blocks until the Retry output has closed down.
+func (r *Retry) WaitForClose(timeout time.Duration) error {
+	tStarted := time.Now()
+	select {
+	case <-r.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	if err := r.out.WaitForClose(timeout - time.Since(tStarted)); err != nil {
+		return err
+	}
+	if r.deadLetter != nil {
+		return r.deadLetter.WaitForClose(timeout - time.Since(tStarted))
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,200 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func TestRetryDisabled(t *testing.T) {
+	out := &mockOutput{res: make(chan types.Response)}
+
+	wrapped, err := WrapWithRetry(out, NewRetryConfig(), log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped != out {
+		t.Error("Expected output to be returned unwrapped")
+	}
+
+	conf := NewRetryConfig()
+	conf.DeadLetter = NewConfig()
+	if _, err = WrapWithRetry(out, conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{}); err == nil {
+		t.Error("Expected error from dead letter without max attempts")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	r := &Retry{conf: NewRetryConfig()}
+	r.conf.Jitter = 0
+
+	exp := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		1600 * time.Millisecond,
+		3200 * time.Millisecond,
+		5000 * time.Millisecond,
+		5000 * time.Millisecond,
+	}
+	for i, e := range exp {
+		if act := r.backoff(i + 1); act != e {
+			t.Errorf("Wrong backoff for attempt %v: %v != %v", i+1, act, e)
+		}
+	}
+
+	r.conf.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if act := r.backoff(1); act > 100*time.Millisecond || act < 50*time.Millisecond {
+			t.Errorf("Backoff with jitter out of range: %v", act)
+		}
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	out := &mockOutput{res: make(chan types.Response)}
+
+	conf := NewRetryConfig()
+	conf.MaxAttempts = 3
+	conf.InitialBackoffMS = 1
+	conf.MaxBackoffMS = 1
+
+	r, err := WrapWithRetry(out, conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendChan := make(chan types.Message)
+	if err = r.StartReceiving(sendChan); err != nil {
+		t.Fatal(err)
+	}
+
+	errTest := errors.New("test err")
+
+	// Succeeds on the second attempt.
+	sendChan <- types.Message{Parts: [][]byte{[]byte("foo")}}
+	out.respond(t, errTest)
+	out.respond(t, nil)
+	if res := <-r.ResponseChan(); res.Error() != nil {
+		t.Errorf("Unexpected error: %v", res.Error())
+	}
+
+	// Fails every attempt and is dropped.
+	sendChan <- types.Message{Parts: [][]byte{[]byte("bar")}}
+	for i := 0; i < conf.MaxAttempts; i++ {
+		out.respond(t, errTest)
+	}
+	if res := <-r.ResponseChan(); res.Error() != nil {
+		t.Errorf("Expected dropped message to be acknowledged: %v", res.Error())
+	}
+
+	r.CloseAsync()
+	if err = r.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRetryDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_retry_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dlPath := filepath.Join(dir, "dead_letter.txt")
+
+	dlConf := NewConfig()
+	dlConf.Type = "file"
+	dlConf.File.Path = dlPath
+
+	conf := NewRetryConfig()
+	conf.MaxAttempts = 2
+	conf.InitialBackoffMS = 1
+	conf.DeadLetter = dlConf
+
+	out := &mockOutput{res: make(chan types.Response)}
+	r, err := WrapWithRetry(out, conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendChan := make(chan types.Message)
+	if err = r.StartReceiving(sendChan); err != nil {
+		t.Fatal(err)
+	}
+
+	sendChan <- types.Message{Parts: [][]byte{[]byte("foo")}}
+	out.respond(t, errors.New("test err"))
+	out.respond(t, errors.New("test err"))
+	select {
+	case res := <-r.ResponseChan():
+		if res.Error() != nil {
+			t.Errorf("Unexpected error: %v", res.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for response")
+	}
+
+	r.CloseAsync()
+	if err = r.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+
+	content, err := ioutil.ReadFile(dlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "foo\n", string(content); exp != act {
+		t.Errorf("Wrong dead letter content: %v != %v", act, exp)
+	}
+}
+
+func TestRetryDeadLetterMessage(t *testing.T) {
+	msg := types.Message{Parts: [][]byte{[]byte("foo"), []byte("bar")}}
+	msg.SetMetadata(0, "baz", "qux")
+
+	dlMsg := deadLetterMessage(msg, errors.New("test err"))
+	for i := range msg.Parts {
+		if exp, act := "test err", dlMsg.GetMetadata(i, DeadLetterErrorKey); exp != act {
+			t.Errorf("Wrong error metadata for part %v: %v != %v", i, act, exp)
+		}
+	}
+	if exp, act := "qux", dlMsg.GetMetadata(0, "baz"); exp != act {
+		t.Errorf("Wrong metadata: %v != %v", act, exp)
+	}
+	if act := msg.GetMetadata(0, DeadLetterErrorKey); len(act) > 0 {
+		t.Errorf("Original message was modified: %v", act)
+	}
+}
+
+//------------------------------------------------------------------------------
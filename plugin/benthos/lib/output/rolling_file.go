@@ -0,0 +1,422 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+var pathTemplateRegex = regexp.MustCompile(`\$\{(date(:[^}]*)?|hostname|counter)\}`)
+
+// pathTemplate resolves file paths containing placeholders for the date, the
+// hostname and a counter.
+type pathTemplate struct {
+	template string
+	hostname string
+}
+
+func newPathTemplate(template string) pathTemplate {
+	hostname, _ := os.Hostname()
+	return pathTemplate{
+		template: template,
+		hostname: hostname,
+	}
+}
+
+// resolve returns the path for a time and counter.
+func (p pathTemplate) resolve(t time.Time, counter int) string {
+	return pathTemplateRegex.ReplaceAllStringFunc(p.template, func(match string) string {
+		name := match[2 : len(match)-1]
+		switch {
+		case name == "hostname":
+			return p.hostname
+		case name == "counter":
+			return strconv.Itoa(counter)
+		case strings.HasPrefix(name, "date:"):
+			return t.Format(name[5:])
+		}
+		return t.Format("2006-01-02")
+	})
+}
+
+// hasCounter returns true if the template contains a counter placeholder.
+func (p pathTemplate) hasCounter() bool {
+	return strings.Contains(p.template, "${counter}")
+}
+
+// glob returns a glob pattern matching all paths the template can resolve to,
+// including any suffixes added to closed files.
+func (p pathTemplate) glob() string {
+	return pathTemplateRegex.ReplaceAllStringFunc(p.template, func(match string) string {
+		if match == "${hostname}" {
+			return p.hostname
+		}
+		return "*"
+	}) + "*"
+}
+
+//------------------------------------------------------------------------------
+
+// rollingFileConfig contains the rotation rules of a rollingFile.
+type rollingFileConfig struct {
+	maxSize  int64
+	interval time.Duration
+	gzip     bool
+	maxFiles int
+}
+
+// rollingFile is an io.WriteCloser that writes to a sequence of files,
+// rotating to a new file once the current one exceeds a size or age. The
+// current file is written to a temporary path and is only moved to its final
+// path once it is complete. Temporary files left behind by a previous run are
+// completed on startup.
+type rollingFile struct {
+	conf rollingFileConfig
+	path pathTemplate
+
+	log   log.Modular
+	stats metrics.Type
+
+	mut       sync.Mutex
+	counter   int
+	file      *os.File
+	filePath  string
+	fileBase  string
+	fileSize  int64
+	fileStart time.Time
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+func newRollingFile(
+	path string,
+	conf rollingFileConfig,
+	log log.Modular,
+	stats metrics.Type,
+) *rollingFile {
+	r := &rollingFile{
+		conf:       conf,
+		path:       newPathTemplate(path),
+		log:        log,
+		stats:      stats,
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+	r.recover()
+	go r.loop()
+	return r
+}
+
+//------------------------------------------------------------------------------
+
+// exists returns true if a path, or the gzipped form of it, exists.
+func exists(path string) bool {
+	if _, err := os.Stat(path); err == nil {
+		return true
+	}
+	if _, err := os.Stat(path + ".gz"); err == nil {
+		return true
+	}
+	return false
+}
+
+// open begins a new file. Must be called with the lock held.
+func (r *rollingFile) open(now time.Time) error {
+	path := r.path.resolve(now, r.counter)
+	if r.path.hasCounter() {
+		for exists(path) || exists(path+".tmp") {
+			r.counter++
+			path = r.path.resolve(now, r.counter)
+		}
+	}
+	if dir := filepath.Dir(path); len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	// A temporary file may still contain data from a previous run, in which
+	// case it is appended to rather than truncated.
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.filePath = path
+	r.fileBase = r.path.resolve(now, 0)
+	r.fileSize = info.Size()
+	r.fileStart = now
+	r.counter++
+	return nil
+}
+
+// rotate completes the current file by syncing it to disk and moving it to its
+// final path. Must be called with the lock held.
+func (r *rollingFile) rotate() error {
+	if r.file == nil {
+		return nil
+	}
+	file, path := r.file, r.filePath
+	r.file = nil
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := r.complete(path); err != nil {
+		return err
+	}
+
+	r.removeOld()
+	return nil
+}
+
+// complete moves the temporary file of a path to its final path, gzipping it
+// if configured to.
+func (r *rollingFile) complete(path string) error {
+	// Never overwrite an existing file, instead add a numbered suffix.
+	finalPath := path
+	for i := 1; exists(finalPath); i++ {
+		finalPath = path + "." + strconv.Itoa(i)
+	}
+	if err := os.Rename(path+".tmp", finalPath); err != nil {
+		return err
+	}
+
+	if r.conf.gzip {
+		if err := gzipFile(finalPath); err != nil {
+			r.stats.Incr("output.file.gzip.error", 1)
+			r.log.Errorf("Failed to gzip file '%v': %v\n", finalPath, err)
+		}
+	}
+
+	r.stats.Incr("output.file.rotated", 1)
+	r.log.Infof("Completed file '%v'\n", finalPath)
+	return nil
+}
+
+// recover completes any temporary files left behind by a previous run, which
+// would otherwise never be moved to their final paths. Partially written gzip
+// files are removed, as their source files still exist.
+func (r *rollingFile) recover() {
+	matches, err := filepath.Glob(r.path.glob())
+	if err != nil {
+		return
+	}
+	for _, path := range matches {
+		if strings.HasSuffix(path, ".gz.tmp") {
+			if err = os.Remove(path); err != nil {
+				r.log.Errorf("Failed to remove partial gzip file '%v': %v\n", path, err)
+			}
+			continue
+		}
+		if !strings.HasSuffix(path, ".tmp") {
+			continue
+		}
+		r.stats.Incr("output.file.recovered", 1)
+		if err = r.complete(strings.TrimSuffix(path, ".tmp")); err != nil {
+			r.stats.Incr("output.file.rotate.error", 1)
+			r.log.Errorf("Failed to complete file '%v': %v\n", path, err)
+		}
+	}
+	r.removeOld()
+}
+
+// gzipFile compresses a file into a new file with the extension .gz, and then
+// removes the original.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz.tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(out)
+	if _, err = io.Copy(gw, in); err == nil {
+		err = gw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz.tmp")
+		return err
+	}
+	if err = os.Rename(path+".gz.tmp", path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// removeOld removes the oldest completed files until at most maxFiles remain.
+func (r *rollingFile) removeOld() {
+	if r.conf.maxFiles <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(r.path.glob())
+	if err != nil {
+		return
+	}
+
+	type fileAge struct {
+		path    string
+		modTime time.Time
+	}
+	files := []fileAge{}
+	for _, path := range matches {
+		if strings.HasSuffix(path, ".tmp") {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			files = append(files, fileAge{path: path, modTime: info.ModTime()})
+		}
+	}
+	if len(files) <= r.conf.maxFiles {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files[:len(files)-r.conf.maxFiles] {
+		if err := os.Remove(f.path); err != nil {
+			r.log.Errorf("Failed to remove old file '%v': %v\n", f.path, err)
+		} else {
+			r.stats.Incr("output.file.removed", 1)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// shouldRotate returns true if the current file should be rotated before
+// writing n bytes to it. Must be called with the lock held.
+func (r *rollingFile) shouldRotate(now time.Time, n int) bool {
+	if r.file == nil {
+		return false
+	}
+	if r.conf.maxSize > 0 && r.fileSize > 0 && r.fileSize+int64(n) > r.conf.maxSize {
+		return true
+	}
+	if r.conf.interval > 0 && now.Sub(r.fileStart) >= r.conf.interval {
+		return true
+	}
+	return r.path.resolve(now, 0) != r.fileBase
+}
+
+// Write writes data to the current file, rotating it first if needed.
+func (r *rollingFile) Write(p []byte) (int, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	now := time.Now()
+	if r.shouldRotate(now, len(p)) {
+		if err := r.rotate(); err != nil {
+			r.stats.Incr("output.file.rotate.error", 1)
+			r.log.Errorf("Failed to rotate file: %v\n", err)
+		}
+	}
+	if r.file == nil {
+		if err := r.open(now); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.fileSize += int64(n)
+	return n, err
+}
+
+// loop rotates the current file once it reaches its maximum age, even when no
+// further data is written to it.
+func (r *rollingFile) loop() {
+	defer close(r.closedChan)
+
+	if r.conf.interval <= 0 {
+		<-r.closeChan
+		return
+	}
+
+	ticker := time.NewTicker(r.conf.interval / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.mut.Lock()
+			if r.file != nil && time.Since(r.fileStart) >= r.conf.interval {
+				if err := r.rotate(); err != nil {
+					r.stats.Incr("output.file.rotate.error", 1)
+					r.log.Errorf("Failed to rotate file: %v\n", err)
+				}
+			}
+			r.mut.Unlock()
+		case <-r.closeChan:
+			return
+		}
+	}
+}
+
+// Close completes the current file.
+func (r *rollingFile) Close() error {
+	close(r.closeChan)
+	<-r.closedChan
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if err := r.rotate(); err != nil {
+		return fmt.Errorf("failed to complete file: %v", err)
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
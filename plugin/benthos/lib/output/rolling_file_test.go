@@ -0,0 +1,321 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func listDir(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readFileString(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPathTemplate(t *testing.T) {
+	tmpl := newPathTemplate("/tmp/${hostname}/${date}/out-${date:15}-${counter}-${nope}.log")
+	tmpl.hostname = "foo"
+
+	now := time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC)
+	if exp, act := "/tmp/foo/2018-03-04/out-05-3-${nope}.log", tmpl.resolve(now, 3); exp != act {
+		t.Errorf("Wrong resolved path: %v != %v", act, exp)
+	}
+	if exp, act := "/tmp/foo/*/out-*-*-${nope}.log*", tmpl.glob(); exp != act {
+		t.Errorf("Wrong glob: %v != %v", act, exp)
+	}
+	if !tmpl.hasCounter() {
+		t.Error("Expected template to have counter")
+	}
+}
+
+func TestRollingFileMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_rolling_file_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testLog := log.NewLogger(os.Stdout, logConfig)
+	r := newRollingFile(filepath.Join(dir, "out-${counter}.log"), rollingFileConfig{
+		maxSize: 8,
+	}, testLog, metrics.DudType{})
+
+	for _, data := range []string{"foo\n", "bar\n", "baz\n"} {
+		if _, err = r.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if exp, act := []string{"out-0.log", "out-1.log.tmp"}, listDir(t, dir); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong files: %v != %v", act, exp)
+	}
+	if exp, act := "foo\nbar\n", readFileString(t, filepath.Join(dir, "out-0.log")); exp != act {
+		t.Errorf("Wrong file contents: %v != %v", act, exp)
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := []string{"out-0.log", "out-1.log"}, listDir(t, dir); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong files: %v != %v", act, exp)
+	}
+
+	// Restarting does not overwrite existing files.
+	r = newRollingFile(filepath.Join(dir, "out-${counter}.log"), rollingFileConfig{
+		maxSize: 8,
+	}, testLog, metrics.DudType{})
+	if _, err = r.Write([]byte("qux\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "qux\n", readFileString(t, filepath.Join(dir, "out-2.log")); exp != act {
+		t.Errorf("Wrong file contents: %v != %v", act, exp)
+	}
+}
+
+func TestRollingFileRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_rolling_file_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Files left behind by a crashed run.
+	if err = ioutil.WriteFile(filepath.Join(dir, "out-0.log.tmp"), []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "out-1.log.gz.tmp"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "out-1.log"), []byte("bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRollingFile(filepath.Join(dir, "out-${counter}.log"), rollingFileConfig{
+		maxSize: 8,
+	}, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if exp, act := []string{"out-0.log", "out-1.log"}, listDir(t, dir); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong files: %v != %v", act, exp)
+	}
+
+	if _, err = r.Write([]byte("baz\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, act := []string{"out-0.log", "out-1.log", "out-2.log"}, listDir(t, dir); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong files: %v != %v", act, exp)
+	}
+	for name, exp := range map[string]string{
+		"out-0.log": "foo\n",
+		"out-1.log": "bar\n",
+		"out-2.log": "baz\n",
+	} {
+		if act := readFileString(t, filepath.Join(dir, name)); exp != act {
+			t.Errorf("Wrong file contents of %v: %v != %v", name, act, exp)
+		}
+	}
+}
+
+func TestRollingFileSuffix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_rolling_file_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newRollingFile(filepath.Join(dir, "out.log"), rollingFileConfig{
+		maxSize: 4,
+	}, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+
+	for _, data := range []string{"foo\n", "bar\n", "baz\n"} {
+		if _, err = r.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"out.log", "out.log.1", "out.log.2"}
+	if act := listDir(t, dir); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong files: %v != %v", act, exp)
+	}
+	if exp, act := "baz\n", readFileString(t, filepath.Join(dir, "out.log.2")); exp != act {
+		t.Errorf("Wrong file contents: %v != %v", act, exp)
+	}
+}
+
+func TestRollingFileGzipMaxFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_rolling_file_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newRollingFile(filepath.Join(dir, "out-${counter}.log"), rollingFileConfig{
+		maxSize:  4,
+		gzip:     true,
+		maxFiles: 2,
+	}, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+
+	for _, data := range []string{"foo\n", "bar\n", "baz\n", "qux\n"} {
+		if _, err = r.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		// Ensure modification times are ordered.
+		<-time.After(time.Millisecond * 10)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"out-2.log.gz", "out-3.log.gz"}
+	if act := listDir(t, dir); !reflect.DeepEqual(exp, act) {
+		t.Fatalf("Wrong files: %v != %v", act, exp)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "out-3.log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "qux\n", string(data); exp != act {
+		t.Errorf("Wrong file contents: %v != %v", act, exp)
+	}
+}
+
+func TestRollingFileInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_rolling_file_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newRollingFile(filepath.Join(dir, "out-${counter}.log"), rollingFileConfig{
+		interval: time.Millisecond * 50,
+	}, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	defer r.Close()
+
+	if _, err = r.Write([]byte("foo\n")); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := []string{"out-0.log.tmp"}, listDir(t, dir); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong files: %v != %v", act, exp)
+	}
+
+	// The file is completed without further writes.
+	<-time.After(time.Millisecond * 200)
+	if exp, act := []string{"out-0.log"}, listDir(t, dir); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong files: %v != %v", act, exp)
+	}
+}
+
+func TestFileOutputRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_rolling_file_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewConfig()
+	conf.File.Path = filepath.Join(dir, "out-${counter}.log")
+	conf.File.MaxSize = 10
+
+	f, err := NewFile(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgChan := make(chan types.Message)
+	if err = f.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, parts := range [][][]byte{
+		{[]byte("foo")},
+		{[]byte("bar"), []byte("baz")},
+	} {
+		select {
+		case msgChan <- types.Message{Parts: parts}:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out")
+		}
+		select {
+		case res := <-f.ResponseChan():
+			if res.Error() != nil {
+				t.Error(res.Error())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out")
+		}
+	}
+
+	f.CloseAsync()
+	if err = f.WaitForClose(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, act := "foo\n", readFileString(t, filepath.Join(dir, "out-0.log")); exp != act {
+		t.Errorf("Wrong file contents: %v != %v", act, exp)
+	}
+	if exp, act := "bar\nbaz\n\n", readFileString(t, filepath.Join(dir, "out-1.log")); exp != act {
+		t.Errorf("Wrong file contents: %v != %v", act, exp)
+	}
+}
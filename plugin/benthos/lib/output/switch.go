@@ -0,0 +1,144 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Jeffail/benthos/lib/broker"
+	"github.com/Jeffail/benthos/lib/condition"
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+var (
+	// ErrSwitchNoOutputs is returned when creating a Switch type with zero
+	// outputs.
+	ErrSwitchNoOutputs = errors.New("attempting to create switch output type with no outputs")
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["switch"] = typeSpec{
+		constructor: NewSwitch,
+		description: `
+The switch output type allows you to route messages to different outputs based
+on their contents. Each case has a condition and an output, and each message is
+sent to the output of the first case where the condition passes. If no case
+matches then the message is sent to the 'fallback' output, and if a fallback is
+not configured the message is dropped.
+
+The available conditions can be listed with ` + "`benthos --list-conditions`" + `.
+
+If an output applies back pressure this will also block other outputs from
+receiving content.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// SwitchCaseConfig contains a condition and an output that messages matching
+// the condition are sent to.
+type SwitchCaseConfig struct {
+	Condition condition.Config `json:"condition" yaml:"condition"`
+	Output    interface{}      `json:"output" yaml:"output"`
+}
+
+// SwitchConfig is configuration for the Switch output type.
+type SwitchConfig struct {
+	Cases    []SwitchCaseConfig `json:"cases" yaml:"cases"`
+	Fallback interface{}        `json:"fallback" yaml:"fallback"`
+}
+
+// NewSwitchConfig creates a new SwitchConfig with default values.
+func NewSwitchConfig() SwitchConfig {
+	return SwitchConfig{
+		Cases:    []SwitchCaseConfig{},
+		Fallback: nil,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// NewSwitch creates a new Switch output type. Messages will be sent to the
+// output of the first case with a passing condition, or to the fallback output.
+// If an output blocks this will block all throughput.
+func NewSwitch(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	lCases := len(conf.Switch.Cases)
+	if lCases == 0 && conf.Switch.Fallback == nil {
+		return nil, ErrSwitchNoOutputs
+	}
+
+	boxedConfs := make([]interface{}, 0, lCases+1)
+	for _, c := range conf.Switch.Cases {
+		if c.Output == nil {
+			return nil, errors.New("switch case is missing an output")
+		}
+		boxedConfs = append(boxedConfs, c.Output)
+	}
+	if conf.Switch.Fallback != nil {
+		boxedConfs = append(boxedConfs, conf.Switch.Fallback)
+	}
+
+	outputConfs, err := parseOutputConfsWithDefaults(boxedConfs)
+	if err != nil {
+		return nil, err
+	}
+	if len(outputConfs) != len(boxedConfs) {
+		return nil, errors.New("switch outputs cannot be duplicated with ditto")
+	}
+
+	conditions := make([]condition.Type, lCases)
+	for i, c := range conf.Switch.Cases {
+		if conditions[i], err = condition.New(c.Condition, log.NewModule(".output.switch"), stats); err != nil {
+			return nil, fmt.Errorf("failed to create switch case '%v' condition: %v", i, err)
+		}
+	}
+
+	outputs := make([]types.Consumer, len(outputConfs))
+	for i, oConf := range outputConfs {
+		outputs[i], err = New(oConf, log, stats)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fallback := -1
+	if conf.Switch.Fallback != nil {
+		fallback = lCases
+	}
+
+	return broker.NewSwitch(func(msg *types.Message) int {
+		for i, c := range conditions {
+			if c.Check(msg) {
+				return i
+			}
+		}
+		return fallback
+	}, outputs, stats)
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,163 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/condition"
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestSwitchNoOutputs(t *testing.T) {
+	conf := NewConfig()
+	conf.Type = "switch"
+
+	if _, err := New(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{}); err != ErrSwitchNoOutputs {
+		t.Errorf("Wrong error returned: %v != %v", err, ErrSwitchNoOutputs)
+	}
+}
+
+func TestSwitchBadCondition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_switch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outConf := NewConfig()
+	outConf.Type = "file"
+	outConf.File.Path = filepath.Join(dir, "foo.txt")
+
+	condConf := condition.NewConfig()
+	condConf.Type = "not_exist"
+
+	conf := NewConfig()
+	conf.Type = "switch"
+	conf.Switch.Cases = append(conf.Switch.Cases, SwitchCaseConfig{
+		Condition: condConf,
+		Output:    outConf,
+	})
+
+	if _, err := New(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad condition")
+	}
+}
+
+func TestSwitchWithFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_switch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fooPath, barPath, otherPath := filepath.Join(dir, "foo.txt"), filepath.Join(dir, "bar.txt"), filepath.Join(dir, "other.txt")
+
+	conf := NewConfig()
+	if err = yaml.Unmarshal([]byte(`
+type: switch
+switch:
+  cases:
+  - condition:
+      type: json_field
+      json_field:
+        path: type
+        value: foo
+    output:
+      type: file
+      file:
+        path: `+fooPath+`
+  - condition:
+      type: regex
+      regex:
+        pattern: bar
+    output:
+      type: file
+      file:
+        path: `+barPath+`
+  fallback:
+    type: file
+    file:
+      path: `+otherPath+`
+`), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendChan := make(chan types.Message)
+	if err = s.StartReceiving(sendChan); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []string{
+		`{"type":"foo","id":1}`,
+		`{"type":"bar","id":2}`,
+		`{"type":"baz","id":3}`,
+		`{"type":"foo","id":4}`,
+	}
+	for _, input := range inputs {
+		select {
+		case sendChan <- types.Message{Parts: [][]byte{[]byte(input)}}:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for send")
+		}
+		select {
+		case res := <-s.ResponseChan():
+			if res.Error() != nil {
+				t.Error(res.Error())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for response")
+		}
+	}
+
+	s.CloseAsync()
+	if err = s.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+
+	exp := map[string]string{
+		fooPath:   inputs[0] + "\n" + inputs[3] + "\n",
+		barPath:   inputs[1] + "\n",
+		otherPath: inputs[2] + "\n",
+	}
+	for path, expContent := range exp {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if act := string(content); act != expContent {
+			t.Errorf("Wrong content in %v: %v != %v", path, act, expContent)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/endpoint"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/gorilla/websocket"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["websocket"] = typeSpec{
+		constructor: NewWebsocket,
+		description: `
+Sends messages over websocket connections, either by dialing a server in
+'client' mode or by accepting connections in 'server' mode. Each part of a
+message is sent as an individual frame, where the 'message_type' field
+determines whether frames are sent as 'text' or 'binary'.
+
+In server mode each message is sent to all connected clients, and a message is
+considered delivered when at least one client has received it. Whilst no
+clients are connected messages are held back until one connects. You can leave
+the 'address' config field blank in order to use the default service, but this
+will ignore TLS options.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// WebsocketConfig is configuration for the Websocket output type.
+type WebsocketConfig struct {
+	Mode        string `json:"mode" yaml:"mode"`
+	URL         string `json:"url" yaml:"url"`
+	Address     string `json:"address" yaml:"address"`
+	Path        string `json:"path" yaml:"path"`
+	MessageType string `json:"message_type" yaml:"message_type"`
+	TimeoutMS   int64  `json:"timeout_ms" yaml:"timeout_ms"`
+	CertFile    string `json:"cert_file" yaml:"cert_file"`
+	KeyFile     string `json:"key_file" yaml:"key_file"`
+}
+
+// NewWebsocketConfig creates a new WebsocketConfig with default values.
+func NewWebsocketConfig() WebsocketConfig {
+	return WebsocketConfig{
+		Mode:        "client",
+		URL:         "ws://localhost:4195/post/ws",
+		Address:     "",
+		Path:        "/get/ws",
+		MessageType: "binary",
+		TimeoutMS:   5000,
+		CertFile:    "",
+		KeyFile:     "",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Websocket is an output type that writes messages to websocket connections.
+type Websocket struct {
+	running int32
+
+	conf  Config
+	stats metrics.Type
+	log   log.Modular
+
+	frameType int
+
+	mux      *http.ServeMux
+	server   *http.Server
+	bindings endpoint.Bindings
+	upgrader websocket.Upgrader
+
+	client *websocket.Conn
+
+	clientsMut sync.Mutex
+	clients    map[*websocket.Conn]struct{}
+	connected  chan struct{}
+
+	messages     <-chan types.Message
+	responseChan chan types.Response
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewWebsocket creates a new Websocket output type.
+func NewWebsocket(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	w := &Websocket{
+		running:      1,
+		conf:         conf,
+		stats:        stats,
+		log:          log.NewModule(".output.websocket"),
+		clients:      map[*websocket.Conn]struct{}{},
+		connected:    make(chan struct{}, 1),
+		messages:     nil,
+		responseChan: make(chan types.Response),
+		closeChan:    make(chan struct{}),
+		closedChan:   make(chan struct{}),
+	}
+
+	switch conf.Websocket.MessageType {
+	case "binary":
+		w.frameType = websocket.BinaryMessage
+	case "text":
+		w.frameType = websocket.TextMessage
+	default:
+		return nil, errors.New("websocket message type not recognised: " + conf.Websocket.MessageType)
+	}
+
+	switch conf.Websocket.Mode {
+	case "client":
+		if len(conf.Websocket.URL) == 0 {
+			return nil, errors.New("a url must be specified in client mode")
+		}
+	case "server":
+		if len(conf.Websocket.Address) > 0 {
+			w.mux = http.NewServeMux()
+			w.server = &http.Server{Addr: conf.Websocket.Address, Handler: w.mux}
+			w.mux.HandleFunc(conf.Websocket.Path, w.wsHandler)
+		} else if err := w.bindings.Bind(conf.Websocket.Path, w.wsHandler); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("websocket mode not recognised: " + conf.Websocket.Mode)
+	}
+
+	return w, nil
+}
+
+//------------------------------------------------------------------------------
+
+// discardReads reads from a connection until it fails, which is required in
+// order for control frames to be processed.
+func discardReads(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+// writeMessage writes each part of a message as a frame to a connection.
+func (w *Websocket) writeMessage(conn *websocket.Conn, msg types.Message) error {
+	for _, part := range msg.Parts {
+		conn.SetWriteDeadline(time.Now().Add(time.Millisecond * time.Duration(w.conf.Websocket.TimeoutMS)))
+		if err := conn.WriteMessage(w.frameType, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+func (w *Websocket) wsHandler(rw http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&w.running) != 1 {
+		http.Error(rw, "Server closing", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := w.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		w.stats.Incr("output.websocket.upgrade.error", 1)
+		w.log.Warnf("Failed to upgrade connection: %v\n", err)
+		return
+	}
+
+	w.clientsMut.Lock()
+	if atomic.LoadInt32(&w.running) != 1 {
+		w.clientsMut.Unlock()
+		conn.Close()
+		return
+	}
+	w.clients[conn] = struct{}{}
+	w.clientsMut.Unlock()
+
+	select {
+	case w.connected <- struct{}{}:
+	default:
+	}
+
+	w.stats.Incr("output.websocket.connection.opened", 1)
+	discardReads(conn)
+	w.removeClient(conn)
+	w.stats.Incr("output.websocket.connection.closed", 1)
+}
+
+func (w *Websocket) removeClient(conn *websocket.Conn) {
+	w.clientsMut.Lock()
+	delete(w.clients, conn)
+	w.clientsMut.Unlock()
+	conn.Close()
+}
+
+func (w *Websocket) closeClients() {
+	w.clientsMut.Lock()
+	for conn := range w.clients {
+		conn.Close()
+	}
+	w.clients = map[*websocket.Conn]struct{}{}
+	w.clientsMut.Unlock()
+}
+
+// getClients returns the currently connected clients, blocking until at least
+// one is connected. Returns nil if the output is closing.
+func (w *Websocket) getClients() []*websocket.Conn {
+	for {
+		w.clientsMut.Lock()
+		clients := make([]*websocket.Conn, 0, len(w.clients))
+		for conn := range w.clients {
+			clients = append(clients, conn)
+		}
+		w.clientsMut.Unlock()
+
+		if len(clients) > 0 {
+			return clients
+		}
+		select {
+		case <-w.connected:
+		case <-w.closeChan:
+			return nil
+		}
+	}
+}
+
+// broadcast writes a message to all connected clients, clients that fail to
+// receive the message are disconnected.
+func (w *Websocket) broadcast(msg types.Message) error {
+	clients := w.getClients()
+	if clients == nil {
+		return types.ErrTypeClosed
+	}
+
+	delivered := 0
+	var err error
+	for _, conn := range clients {
+		if err = w.writeMessage(conn, msg); err != nil {
+			w.stats.Incr("output.websocket.send.client_error", 1)
+			w.log.Debugf("Failed to send message to client: %v\n", err)
+			w.removeClient(conn)
+		} else {
+			delivered++
+		}
+	}
+	if delivered > 0 {
+		return nil
+	}
+	return err
+}
+
+//------------------------------------------------------------------------------
+
+func (w *Websocket) connect() error {
+	conn, _, err := websocket.DefaultDialer.Dial(w.conf.Websocket.URL, nil)
+	if err != nil {
+		return err
+	}
+	go discardReads(conn)
+	w.client = conn
+	return nil
+}
+
+func (w *Websocket) disconnect() error {
+	if w.client != nil {
+		err := w.client.Close()
+		w.client = nil
+		return err
+	}
+	return nil
+}
+
+// send writes a message to the server in client mode, reconnecting if
+// necessary.
+func (w *Websocket) send(msg types.Message) error {
+	for w.client == nil {
+		if err := w.connect(); err != nil {
+			w.log.Errorf("Failed to connect to websocket: %v\n", err)
+			w.stats.Incr("output.websocket.connect.error", 1)
+			select {
+			case <-time.After(time.Second):
+			case <-w.closeChan:
+				return types.ErrTypeClosed
+			}
+		} else {
+			w.log.Infof("Sending websocket messages to URL: %s\n", w.conf.Websocket.URL)
+			w.stats.Incr("output.websocket.connect.success", 1)
+		}
+	}
+	err := w.writeMessage(w.client, msg)
+	if err != nil {
+		w.disconnect()
+	}
+	return err
+}
+
+//------------------------------------------------------------------------------
+
+func (w *Websocket) loop() {
+	defer func() {
+		atomic.StoreInt32(&w.running, 0)
+
+		if w.server != nil {
+			w.server.Shutdown(context.Background())
+		}
+		w.disconnect()
+		w.closeClients()
+
+		close(w.responseChan)
+		close(w.closedChan)
+	}()
+
+	if w.server != nil {
+		w.log.Infof(
+			"Sending websocket messages to clients at: %s\n",
+			w.conf.Websocket.Address+w.conf.Websocket.Path,
+		)
+
+		go func() {
+			var err error
+			if len(w.conf.Websocket.KeyFile) > 0 || len(w.conf.Websocket.CertFile) > 0 {
+				err = w.server.ListenAndServeTLS(
+					w.conf.Websocket.CertFile, w.conf.Websocket.KeyFile,
+				)
+			} else {
+				err = w.server.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				w.log.Errorf("Server error: %v\n", err)
+			}
+		}()
+	}
+
+	var open bool
+	for atomic.LoadInt32(&w.running) == 1 {
+		var msg types.Message
+		select {
+		case msg, open = <-w.messages:
+			if !open {
+				return
+			}
+		case <-w.closeChan:
+			return
+		}
+		w.stats.Incr("output.websocket.count", 1)
+
+		var err error
+		if w.conf.Websocket.Mode == "server" {
+			err = w.broadcast(msg)
+		} else {
+			err = w.send(msg)
+		}
+		if err == types.ErrTypeClosed {
+			return
+		}
+		if err != nil {
+			w.stats.Incr("output.websocket.send.error", 1)
+		} else {
+			w.stats.Incr("output.websocket.send.success", 1)
+		}
+
+		select {
+		case w.responseChan <- types.NewSimpleResponse(err):
+		case <-w.closeChan:
+			return
+		}
+	}
+}
+
+// StartReceiving assigns a messages channel for the output to read.
+func (w *Websocket) StartReceiving(msgs <-chan types.Message) error {
+	if w.messages != nil {
+		return types.ErrAlreadyStarted
+	}
+	w.messages = msgs
+	go w.loop()
+	return nil
+}
+
+// ResponseChan returns the errors channel.
+func (w *Websocket) ResponseChan() <-chan types.Response {
+	return w.responseChan
+}
+
+// CloseAsync shuts down the Websocket output and stops processing messages.
+func (w *Websocket) CloseAsync() {
+	if atomic.CompareAndSwapInt32(&w.running, 1, 0) {
+		w.bindings.Release()
+		close(w.closeChan)
+	}
+}
+
+// WaitForClose blocks until the Websocket output has closed down.
+func (w *Websocket) WaitForClose(timeout time.Duration) error {
+	select {
+	case <-w.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
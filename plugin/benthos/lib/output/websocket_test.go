@@ -0,0 +1,187 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/gorilla/websocket"
+)
+
+func sendWSMessage(t *testing.T, msgChan chan types.Message, w Type, parts ...string) {
+	msg := types.Message{}
+	for _, p := range parts {
+		msg.Parts = append(msg.Parts, []byte(p))
+	}
+	select {
+	case msgChan <- msg:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out sending message")
+	}
+	select {
+	case res := <-w.ResponseChan():
+		if res.Error() != nil {
+			t.Error(res.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for response")
+	}
+}
+
+func expectWSFrames(t *testing.T, conn *websocket.Conn, frameType int, exp ...string) {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for _, e := range exp {
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mt != frameType {
+			t.Errorf("Wrong frame type: %v != %v", mt, frameType)
+		}
+		if act := string(data); act != e {
+			t.Errorf("Wrong result: %v != %v", act, e)
+		}
+	}
+}
+
+func TestWebsocketServerFanOut(t *testing.T) {
+	conf := NewConfig()
+	conf.Websocket.Mode = "server"
+	conf.Websocket.Address = "localhost:1246"
+	conf.Websocket.Path = "/testws"
+	conf.Websocket.MessageType = "text"
+
+	w, err := NewWebsocket(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgChan := make(chan types.Message)
+	if err = w.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		w.CloseAsync()
+		if err := w.WaitForClose(time.Second); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	var connOne, connTwo *websocket.Conn
+	for i := 0; i < 20; i++ {
+		if connOne, _, err = websocket.DefaultDialer.Dial("ws://localhost:1246/testws", nil); err == nil {
+			break
+		}
+		<-time.After(time.Millisecond * 50)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connOne.Close()
+
+	if connTwo, _, err = websocket.DefaultDialer.Dial("ws://localhost:1246/testws", nil); err != nil {
+		t.Fatal(err)
+	}
+	defer connTwo.Close()
+
+	// Wait for both clients to be registered.
+	for i := 0; i < 20; i++ {
+		wImpl := w.(*Websocket)
+		wImpl.clientsMut.Lock()
+		n := len(wImpl.clients)
+		wImpl.clientsMut.Unlock()
+		if n == 2 {
+			break
+		}
+		<-time.After(time.Millisecond * 50)
+	}
+
+	sendWSMessage(t, msgChan, w, "foo", "bar")
+	expectWSFrames(t, connOne, websocket.TextMessage, "foo", "bar")
+	expectWSFrames(t, connTwo, websocket.TextMessage, "foo", "bar")
+
+	// Messages continue to reach remaining clients after one disconnects.
+	connOne.Close()
+	sendWSMessage(t, msgChan, w, "baz")
+	expectWSFrames(t, connTwo, websocket.TextMessage, "baz")
+}
+
+func TestWebsocketClient(t *testing.T) {
+	frames := make(chan string, 10)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if mt != websocket.BinaryMessage {
+				t.Errorf("Wrong frame type: %v", mt)
+			}
+			frames <- string(data)
+		}
+	}))
+	defer server.Close()
+
+	conf := NewConfig()
+	conf.Websocket.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+
+	w, err := NewWebsocket(conf, log.NewLogger(os.Stdout, logConfig), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgChan := make(chan types.Message)
+	if err = w.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	sendWSMessage(t, msgChan, w, "foo", "bar")
+	for _, exp := range []string{"foo", "bar"} {
+		select {
+		case act := <-frames:
+			if act != exp {
+				t.Errorf("Wrong result: %v != %v", act, exp)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for frame")
+		}
+	}
+
+	w.CloseAsync()
+	if err := w.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
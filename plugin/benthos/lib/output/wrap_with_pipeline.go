@@ -81,11 +81,17 @@ func (i *WithPipeline) StartReceiving(msgChan <-chan types.Message) error {
 
 //------------------------------------------------------------------------------
 
-// CloseAsync triggers a closure of this object but does not block.
+// CloseAsync triggers a closure of this object but does not block. The output
+// is closed once the pipeline has flushed the state of its processors, or has
+// closed.
 func (i *WithPipeline) CloseAsync() {
 	i.pipe.CloseAsync()
 	go func() {
-		i.pipe.WaitForClose(time.Second)
+		if flusher, ok := i.pipe.(pipeline.Flusher); ok && flusher.Flushes() {
+			flusher.WaitForFlush(time.Second * 5)
+		} else {
+			i.pipe.WaitForClose(time.Second)
+		}
 		i.out.CloseAsync()
 	}()
 }
@@ -62,6 +62,27 @@ func (m *mockOutput) WaitForClose(dur time.Duration) error {
 	return nil
 }
 
+// respond reads a message sent to the output, replies with err and returns the
+// message.
+func (m *mockOutput) respond(t *testing.T, err error) types.Message {
+	var msg types.Message
+	var open bool
+	select {
+	case msg, open = <-m.msgs:
+		if !open {
+			t.Fatal("Message channel closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for message")
+	}
+	select {
+	case m.res <- types.NewSimpleResponse(err):
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for response read")
+	}
+	return msg
+}
+
 //------------------------------------------------------------------------------
 
 type mockPipe struct {
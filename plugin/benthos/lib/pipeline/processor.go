@@ -21,6 +21,8 @@
 package pipeline
 
 import (
+	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -28,29 +30,83 @@ import (
 	"github.com/Jeffail/benthos/lib/types"
 	"github.com/Jeffail/benthos/lib/util/service/log"
 	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/benthos/lib/util/service/tracing"
 )
 
 //------------------------------------------------------------------------------
 
+const (
+	// tickPeriod is the period at which processors that implement
+	// processor.Ticker are ticked.
+	tickPeriod = time.Millisecond * 100
+
+	// flushWaitPeriod is how long a closing pipeline that holds unacknowledged
+	// messages waits for another message from its input, the response to which
+	// acknowledges the held messages once the processors are flushed.
+	flushWaitPeriod = time.Millisecond * 500
+
+	// retryPeriod is how long to wait before sending a flushed message again
+	// after it was rejected downstream.
+	retryPeriod = time.Millisecond * 100
+
+	// killTimeout is how long a closed pipeline continues to propagate
+	// messages before it stops regardless of whether its input has closed.
+	killTimeout = time.Second * 5
+)
+
+//------------------------------------------------------------------------------
+
+// split is a set of messages split from a message by the processor at an
+// index, which are propagated through the processors that follow it.
+type split struct {
+	index int
+	msgs  []*types.Message
+}
+
+//------------------------------------------------------------------------------
+
 // Processor is a pipeline that supports both Consumer and Producer interfaces.
 // The processor will read from a source, perform some processing, and then
 // either propagate a new message or drop it.
+//
+// When closed the processor flushes the state of any processors that implement
+// processor.Flusher and then continues to propagate messages until its input
+// is closed, allowing components upstream to flush their own state through it.
+// A processor without any processors that implement processor.Flusher or
+// processor.Ticker instead stops immediately.
 type Processor struct {
 	running int32
 
+	// flushes is true when any processors hold state that is flushed when the
+	// pipeline is closed.
+	flushes bool
+
 	log   log.Modular
 	stats metrics.Type
 
 	msgProcessors []processor.Type
 
+	tracer    *tracing.Tracer
+	stage     string
+	spanNames []string
+
 	messagesOut  chan types.Message
 	responsesOut chan types.Response
 
 	messagesIn  <-chan types.Message
 	responsesIn <-chan types.Response
 
+	// held is true when the last response sent upstream was successful but
+	// did not acknowledge its message, which is therefore held by a processor.
+	held bool
+
 	closeChan chan struct{}
+	killChan  chan struct{}
+	flushed   chan struct{}
 	closed    chan struct{}
+
+	flushOnce sync.Once
+	closeOnce sync.Once
 }
 
 // NewProcessor returns a new message processing pipeline.
@@ -59,81 +115,402 @@ func NewProcessor(
 	stats metrics.Type,
 	msgProcessors ...processor.Type,
 ) *Processor {
+	flushes := false
+	for _, proc := range msgProcessors {
+		_, isFlusher := proc.(processor.Flusher)
+		_, isTicker := proc.(processor.Ticker)
+		if isFlusher || isTicker {
+			flushes = true
+		}
+	}
 	return &Processor{
 		running:       1,
+		flushes:       flushes,
 		msgProcessors: msgProcessors,
 		log:           log.NewModule(".pipeline.processor"),
 		stats:         stats,
 		messagesOut:   make(chan types.Message),
 		responsesOut:  make(chan types.Response),
 		closeChan:     make(chan struct{}),
+		killChan:      make(chan struct{}),
+		flushed:       make(chan struct{}),
 		closed:        make(chan struct{}),
 	}
 }
 
+// NewTracedProcessor returns a new message processing pipeline that records
+// spans with a tracer for each processor, and for the time taken for each
+// message to be acknowledged downstream, as part of a named stage. When the
+// stage is tracing.StageInput messages are sampled for tracing as they enter
+// the pipeline, and when it is tracing.StageOutput traces are finished once a
+// message is acknowledged.
+func NewTracedProcessor(
+	log log.Modular,
+	stats metrics.Type,
+	tracer *tracing.Tracer,
+	stage string,
+	msgProcessors ...processor.Type,
+) *Processor {
+	p := NewProcessor(log, stats, msgProcessors...)
+	p.tracer = tracer
+	p.stage = stage
+	p.spanNames = make([]string, len(msgProcessors))
+	for i := range msgProcessors {
+		p.spanNames[i] = fmt.Sprintf("%v.processor.%v", stage, i)
+	}
+	return p
+}
+
 //------------------------------------------------------------------------------
 
 // loop is the processing loop of this pipeline.
 func (p *Processor) loop() {
 	defer func() {
 		atomic.StoreInt32(&p.running, 0)
+		p.closeProcessors()
+		p.markFlushed()
 
 		close(p.responsesOut)
 		close(p.messagesOut)
 		close(p.closed)
 	}()
 
-	var open bool
-	for atomic.LoadInt32(&p.running) == 1 {
-		var msg types.Message
+	var tickChan <-chan time.Time
+	for _, proc := range p.msgProcessors {
+		if _, ok := proc.(processor.Ticker); ok {
+			ticker := time.NewTicker(tickPeriod)
+			defer ticker.Stop()
+			tickChan = ticker.C
+			break
+		}
+	}
+
+	closeChan := (<-chan struct{})(p.closeChan)
+	var flushWait <-chan time.Time
+
+	for {
+		// Closing takes priority over processing another message.
 		select {
-		case msg, open = <-p.messagesIn:
+		case <-closeChan:
+			closeChan = nil
+			flushWait = p.closeFlush()
+		default:
+		}
+
+		select {
+		case msg, open := <-p.messagesIn:
 			if !open {
+				p.flush()
+				return
+			}
+			res, ok := p.process(msg)
+			if !ok {
 				return
 			}
-		case <-p.closeChan:
+			if flushWait != nil {
+				// The pipeline is closing, therefore flush before responding
+				// so that the response acknowledges all held messages.
+				flushWait = nil
+				if err := p.flush(); res.Error() == nil {
+					res = types.NewSimpleResponse(err)
+				}
+				p.markFlushed()
+			}
+			select {
+			case p.responsesOut <- res:
+			case <-p.killChan:
+				return
+			}
+			if res.Error() == nil {
+				p.held = res.SkipAck()
+			}
+		case now := <-tickChan:
+			p.tick(now)
+		case <-closeChan:
+			closeChan = nil
+			flushWait = p.closeFlush()
+		case <-flushWait:
+			flushWait = nil
+			p.log.Warnln(
+				"Flushing processors without a message from the input, held " +
+					"messages will not be acknowledged and may be sent again on " +
+					"the next start.",
+			)
+			p.flush()
+			p.markFlushed()
+		case <-p.killChan:
 			return
 		}
-		p.stats.Incr("pipeline.processor.count", 1)
+	}
+}
+
+// process runs a message through the processors and sends the result
+// downstream. Returns the response to send upstream and a bool flag that is
+// false if the pipeline should stop.
+func (p *Processor) process(msg types.Message) (types.Response, bool) {
+	p.stats.Incr("pipeline.processor.count", 1)
+
+	if p.stage == tracing.StageInput {
+		p.tracer.Sample(&msg)
+	}
+	p.tracer.Enter(p.stage, &msg)
 
-		resultMsg := &msg
-		var resultRes types.Response
-		sending := true
-		for i := 0; sending && i < len(p.msgProcessors); i++ {
+	resultMsg := &msg
+	var resultRes types.Response
+	var splits []split
+	sending, skipAck := true, false
+	invoked := 0
+	for i := 0; sending && i < len(p.msgProcessors); i++ {
+		invoked++
+		if p.tracer == nil {
 			resultMsg, resultRes, sending = p.msgProcessors[i].ProcessMessage(resultMsg)
+		} else {
+			inMsg, tStart := resultMsg, time.Now()
+			resultMsg, resultRes, sending = p.msgProcessors[i].ProcessMessage(resultMsg)
+			p.tracer.Record(inMsg, p.spanNames[i], tStart)
 		}
 		if !sending {
-			p.stats.Incr("pipeline.processor.dropped", 1)
-			select {
-			case p.responsesOut <- resultRes:
-			case <-p.closeChan:
-				return
-			}
 			continue
 		}
+		if resultRes != nil && resultRes.SkipAck() {
+			skipAck = true
+		}
+		splits = p.split(i, splits)
+	}
+	if !sending {
+		if resultRes == nil {
+			resultRes = types.NewSimpleResponse(nil)
+		}
+		p.stats.Incr("pipeline.processor.dropped", 1)
+		p.tracer.Finish(&msg, "dropped")
+		p.acknowledge(0, invoked, resultRes)
+		if err := p.deliverSplits(splits); err != nil {
+			return types.NewSimpleResponse(err), true
+		}
+		return resultRes, true
+	}
 
-		select {
-		case p.messagesOut <- *resultMsg:
-		case <-p.closeChan:
-			return
+	p.tracer.Exit(p.stage, resultMsg)
+	tSend := time.Now()
+
+	closedRes := types.NewSimpleResponse(types.ErrTypeClosed)
+	select {
+	case p.messagesOut <- *resultMsg:
+	case <-p.killChan:
+		p.acknowledge(0, invoked, closedRes)
+		return nil, false
+	}
+	var res types.Response
+	var open bool
+	select {
+	case res, open = <-p.responsesIn:
+		if !open {
+			p.acknowledge(0, invoked, closedRes)
+			return nil, false
 		}
-		var res types.Response
-		if res, open = <-p.responsesIn; !open {
-			return
+	case <-p.killChan:
+		p.acknowledge(0, invoked, closedRes)
+		return nil, false
+	}
+	p.acknowledge(0, invoked, res)
+	if res.Error() == nil {
+		if err := p.deliverSplits(splits); err != nil {
+			res = types.NewSimpleResponse(err)
 		}
-		if res.Error() == nil {
-			p.stats.Incr("pipeline.processor.send.success", 1)
-		} else {
-			p.stats.Incr("pipeline.processor.send.error", 1)
+	}
+	if res.Error() == nil {
+		p.stats.Incr("pipeline.processor.send.success", 1)
+		p.tracer.Record(resultMsg, p.stage+".send", tSend)
+		if p.stage == tracing.StageOutput {
+			p.tracer.Finish(resultMsg, "delivered")
+		}
+	} else {
+		p.stats.Incr("pipeline.processor.send.error", 1)
+		p.tracer.RecordTagged(resultMsg, p.stage+".send", tSend, map[string]string{
+			"error": res.Error().Error(),
+		})
+	}
+	if skipAck && res.Error() == nil {
+		res = types.NewUnacknowledgedResponse()
+	}
+	return res, true
+}
+
+// processFrom runs a message created by the processor at an index through the
+// processors that follow it, sends the result downstream, and returns an error
+// if it could not be sent.
+func (p *Processor) processFrom(index int, msg *types.Message) error {
+	var res types.Response
+	var splits []split
+	sending := true
+	invoked := index + 1
+	for ; sending && invoked < len(p.msgProcessors); invoked++ {
+		if msg, res, sending = p.msgProcessors[invoked].ProcessMessage(msg); sending {
+			splits = p.split(invoked, splits)
 		}
+	}
+	if !sending {
+		p.acknowledge(index+1, invoked, res)
+		return p.deliverSplits(splits)
+	}
+	err := p.deliver(msg)
+	p.acknowledge(index+1, invoked, types.NewSimpleResponse(err))
+	if err != nil {
+		return err
+	}
+	return p.deliverSplits(splits)
+}
+
+// split appends any messages split by the processor at an index from the
+// message it last processed.
+func (p *Processor) split(index int, splits []split) []split {
+	splitter, ok := p.msgProcessors[index].(processor.Splitter)
+	if !ok {
+		return splits
+	}
+	if msgs := splitter.Split(); len(msgs) > 0 {
+		splits = append(splits, split{index: index, msgs: msgs})
+	}
+	return splits
+}
+
+// deliverSplits propagates messages split by processors through the processors
+// that follow them and sends them downstream. Messages split by later
+// processors are sent first, as they follow the message already sent before
+// those split by earlier processors. Returns an error if a message could not be
+// sent.
+func (p *Processor) deliverSplits(splits []split) error {
+	for i := len(splits) - 1; i >= 0; i-- {
+		for _, msg := range splits[i].msgs {
+			p.stats.Incr("pipeline.processor.split.count", 1)
+			if err := p.processFrom(splits[i].index, msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// acknowledge calls any processors within a range that implement
+// processor.Acknowledger with the response to the last message they processed.
+func (p *Processor) acknowledge(from, to int, res types.Response) {
+	if res == nil {
+		res = types.NewSimpleResponse(nil)
+	}
+	for i := from; i < to; i++ {
+		if acker, ok := p.msgProcessors[i].(processor.Acknowledger); ok {
+			acker.Acknowledge(res)
+		}
+	}
+}
+
+// deliver sends a message created by the processors downstream, and sends it
+// again until it is acknowledged or the pipeline is stopped.
+func (p *Processor) deliver(msg *types.Message) error {
+	for {
 		select {
-		case p.responsesOut <- res:
-		case <-p.closeChan:
-			return
+		case p.messagesOut <- *msg:
+		case <-p.killChan:
+			return types.ErrTypeClosed
+		}
+		select {
+		case res, open := <-p.responsesIn:
+			if !open {
+				return types.ErrTypeClosed
+			}
+			if res.Error() == nil {
+				return nil
+			}
+			p.stats.Incr("pipeline.processor.flush.error", 1)
+			p.log.Errorf("Failed to send flushed message: %v\n", res.Error())
+		case <-p.killChan:
+			return types.ErrTypeClosed
+		}
+		select {
+		case <-time.After(retryPeriod):
+		case <-p.killChan:
+			return types.ErrTypeClosed
+		}
+	}
+}
+
+// flush collects the state of any processors that implement
+// processor.Flusher, runs it through the processors that follow them, and sends
+// the results downstream. Returns an error if a result could not be sent.
+func (p *Processor) flush() error {
+	var err error
+	for i, proc := range p.msgProcessors {
+		flusher, ok := proc.(processor.Flusher)
+		if !ok {
+			continue
+		}
+		resultMsg, sending := flusher.Flush()
+		if !sending {
+			continue
+		}
+		p.stats.Incr("pipeline.processor.flush.count", 1)
+		if dErr := p.processFrom(i, resultMsg); dErr != nil {
+			err = dErr
+			continue
+		}
+		p.stats.Incr("pipeline.processor.flush.success", 1)
+	}
+	return err
+}
+
+// tick calls any processors that implement processor.Ticker, runs the results
+// through the processors that follow them, and sends them downstream. Messages
+// held by processors remain unacknowledged until the response to a later
+// message acknowledges them.
+func (p *Processor) tick(now time.Time) {
+	for i, proc := range p.msgProcessors {
+		ticker, ok := proc.(processor.Ticker)
+		if !ok {
+			continue
+		}
+		resultMsg, sending := ticker.Tick(now)
+		if !sending {
+			continue
+		}
+		p.stats.Incr("pipeline.processor.tick.count", 1)
+		if err := p.processFrom(i, resultMsg); err == nil {
+			p.stats.Incr("pipeline.processor.tick.success", 1)
 		}
 	}
 }
 
+// closeFlush flushes the processors once the pipeline is closed. If messages
+// are held by processors then the flush is instead delayed until the next
+// message from the input, and a channel is returned that signals when to stop
+// waiting for that message.
+func (p *Processor) closeFlush() <-chan time.Time {
+	if p.held {
+		return time.After(flushWaitPeriod)
+	}
+	p.flush()
+	p.markFlushed()
+	return nil
+}
+
+// markFlushed signals that the processors have been flushed after the
+// pipeline was closed.
+func (p *Processor) markFlushed() {
+	p.flushOnce.Do(func() {
+		close(p.flushed)
+	})
+}
+
+// closeProcessors closes any processors that implement processor.Closer.
+func (p *Processor) closeProcessors() {
+	p.closeOnce.Do(func() {
+		for _, proc := range p.msgProcessors {
+			if closer, ok := proc.(processor.Closer); ok {
+				closer.CloseAsync()
+			}
+		}
+	})
+}
+
 //------------------------------------------------------------------------------
 
 // StartReceiving assigns a messages channel for the pipeline to read.
@@ -171,13 +548,47 @@ func (p *Processor) ResponseChan() <-chan types.Response {
 	return p.responsesOut
 }
 
-// CloseAsync shuts down the pipeline and stops processing messages.
+// CloseAsync flushes the state of the processors of the pipeline, which then
+// closes once its input is closed. If the input is not closed within a timeout
+// then the pipeline and its processors are closed regardless. A pipeline whose
+// processors hold no state closes its processors and stops immediately.
 func (p *Processor) CloseAsync() {
 	if atomic.CompareAndSwapInt32(&p.running, 1, 0) {
 		close(p.closeChan)
+		if !p.flushes {
+			close(p.killChan)
+			p.closeProcessors()
+			return
+		}
+		go func() {
+			select {
+			case <-p.closed:
+			case <-time.After(killTimeout):
+				close(p.killChan)
+				p.closeProcessors()
+			}
+		}()
 	}
 }
 
+// Flushes returns true if the pipeline flushes the state of its processors
+// when closed, and therefore continues to propagate messages until its input is
+// closed.
+func (p *Processor) Flushes() bool {
+	return p.flushes
+}
+
+// WaitForFlush blocks until the pipeline has flushed the state of its
+// processors after being closed, or has closed.
+func (p *Processor) WaitForFlush(timeout time.Duration) error {
+	select {
+	case <-p.flushed:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
 // WaitForClose blocks until the StackBuffer output has closed down.
 func (p *Processor) WaitForClose(timeout time.Duration) error {
 	select {
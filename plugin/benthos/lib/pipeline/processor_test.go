@@ -21,15 +21,20 @@
 package pipeline
 
 import (
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/Jeffail/benthos/lib/processor"
 	"github.com/Jeffail/benthos/lib/types"
 	"github.com/Jeffail/benthos/lib/util/service/log"
 	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/benthos/lib/util/service/tracing"
 )
 
 var errMockProc = errors.New("this is an error from mock processor")
@@ -210,7 +215,600 @@ func TestProcessorPipeline(t *testing.T) {
 	}
 
 	proc.CloseAsync()
+	if err := proc.WaitForFlush(time.Second); err != nil {
+		t.Error(err)
+	}
+	close(msgChan)
 	if err := proc.WaitForClose(time.Second * 5); err != nil {
 		t.Error(err)
 	}
 }
+
+type mockFlushProcessor struct {
+	parts [][]byte
+}
+
+func (m *mockFlushProcessor) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	m.parts = append(m.parts, msg.Parts...)
+	return nil, types.NewUnacknowledgedResponse(), false
+}
+
+func (m *mockFlushProcessor) Flush() (*types.Message, bool) {
+	if len(m.parts) == 0 {
+		return nil, false
+	}
+	msg := types.NewMessage()
+	msg.Parts = m.parts
+	m.parts = nil
+	return &msg, true
+}
+
+type mockAppendProcessor struct{}
+
+func (m mockAppendProcessor) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	msg.Parts = append(msg.Parts, []byte("appended"))
+	return msg, nil, true
+}
+
+func TestProcessorPipelineFlush(t *testing.T) {
+	proc := NewProcessor(
+		log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}),
+		metrics.DudType{},
+		&mockFlushProcessor{},
+		mockAppendProcessor{},
+	)
+
+	msgChan, resChan := make(chan types.Message), make(chan types.Response)
+	if err := proc.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, part := range []string{"foo", "bar"} {
+		select {
+		case msgChan <- types.Message{Parts: [][]byte{[]byte(part)}}:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out")
+		}
+		select {
+		case res := <-proc.ResponseChan():
+			if !res.SkipAck() {
+				t.Error("Expected unacknowledged response")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out")
+		}
+	}
+
+	close(msgChan)
+
+	select {
+	case procMsg, open := <-proc.MessageChan():
+		if !open {
+			t.Fatal("Closed before flush")
+		}
+		exp := [][]byte{[]byte("foo"), []byte("bar"), []byte("appended")}
+		if act := procMsg.Parts; !reflect.DeepEqual(exp, act) {
+			t.Errorf("Wrong flushed message: %s != %s", act, exp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out")
+	}
+
+	select {
+	case resChan <- types.NewSimpleResponse(nil):
+	case <-time.After(time.Second):
+		t.Fatal("Timed out")
+	}
+
+	if err := proc.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+type mockTickProcessor struct {
+	mockFlushProcessor
+	closed bool
+}
+
+func (m *mockTickProcessor) Tick(now time.Time) (*types.Message, bool) {
+	return m.Flush()
+}
+
+func (m *mockTickProcessor) CloseAsync() {
+	m.closed = true
+}
+
+// sendAndRespond sends a message to a pipeline and returns its response.
+func sendAndRespond(t *testing.T, msgChan chan types.Message, resChan <-chan types.Response, part string) types.Response {
+	select {
+	case msgChan <- types.Message{Parts: [][]byte{[]byte(part)}}:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out")
+	}
+	select {
+	case res := <-resChan:
+		return res
+	case <-time.After(time.Second):
+		t.Fatal("Timed out")
+	}
+	return nil
+}
+
+// receiveAndRespond receives a message from a pipeline, checks its parts and
+// sends a response.
+func receiveAndRespond(t *testing.T, proc *Processor, resChan chan types.Response, exp [][]byte, res types.Response) {
+	select {
+	case procMsg, open := <-proc.MessageChan():
+		if !open {
+			t.Error("Closed early")
+			return
+		}
+		if act := procMsg.Parts; !reflect.DeepEqual(exp, act) {
+			t.Errorf("Wrong message: %s != %s", act, exp)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out")
+		return
+	}
+	select {
+	case resChan <- res:
+	case <-time.After(time.Second):
+		t.Error("Timed out")
+	}
+}
+
+func TestProcessorPipelineCloseFlush(t *testing.T) {
+	proc := NewProcessor(
+		log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}),
+		metrics.DudType{},
+		&mockFlushProcessor{},
+		mockAppendProcessor{},
+	)
+
+	msgChan, resChan := make(chan types.Message), make(chan types.Response)
+	if err := proc.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := sendAndRespond(t, msgChan, proc.ResponseChan(), "foo"); !res.SkipAck() {
+		t.Error("Expected unacknowledged response")
+	}
+
+	proc.CloseAsync()
+
+	// The next message is processed and the processors flushed before it is
+	// responded to, acknowledging all held messages.
+	go receiveAndRespond(t, proc, resChan, [][]byte{
+		[]byte("foo"), []byte("bar"), []byte("appended"),
+	}, types.NewSimpleResponse(nil))
+
+	res := sendAndRespond(t, msgChan, proc.ResponseChan(), "bar")
+	if res.Error() != nil {
+		t.Error(res.Error())
+	}
+	if res.SkipAck() {
+		t.Error("Expected acknowledged response")
+	}
+	if err := proc.WaitForFlush(time.Second); err != nil {
+		t.Error(err)
+	}
+
+	// The pipeline continues to process messages until its input closes,
+	// and then flushes again.
+	if res := sendAndRespond(t, msgChan, proc.ResponseChan(), "baz"); !res.SkipAck() {
+		t.Error("Expected unacknowledged response")
+	}
+
+	close(msgChan)
+	receiveAndRespond(t, proc, resChan, [][]byte{
+		[]byte("baz"), []byte("appended"),
+	}, types.NewSimpleResponse(nil))
+
+	if err := proc.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProcessorPipelineCloseFlushNoMessage(t *testing.T) {
+	proc := NewProcessor(
+		log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}),
+		metrics.DudType{},
+		&mockFlushProcessor{},
+	)
+
+	msgChan, resChan := make(chan types.Message), make(chan types.Response)
+	if err := proc.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := sendAndRespond(t, msgChan, proc.ResponseChan(), "foo"); !res.SkipAck() {
+		t.Error("Expected unacknowledged response")
+	}
+
+	proc.CloseAsync()
+
+	// Without another message the processors are flushed after a period, and
+	// flushed messages that are rejected are sent again.
+	receiveAndRespond(t, proc, resChan, [][]byte{
+		[]byte("foo"),
+	}, types.NewSimpleResponse(errors.New("nope")))
+	receiveAndRespond(t, proc, resChan, [][]byte{
+		[]byte("foo"),
+	}, types.NewSimpleResponse(nil))
+
+	if err := proc.WaitForFlush(time.Second); err != nil {
+		t.Error(err)
+	}
+	close(msgChan)
+	if err := proc.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProcessorPipelineTick(t *testing.T) {
+	mockProc := &mockTickProcessor{}
+	proc := NewProcessor(
+		log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}),
+		metrics.DudType{},
+		mockProc,
+		mockAppendProcessor{},
+	)
+
+	msgChan, resChan := make(chan types.Message), make(chan types.Response)
+	if err := proc.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := sendAndRespond(t, msgChan, proc.ResponseChan(), "foo"); !res.SkipAck() {
+		t.Error("Expected unacknowledged response")
+	}
+
+	receiveAndRespond(t, proc, resChan, [][]byte{
+		[]byte("foo"), []byte("appended"),
+	}, types.NewSimpleResponse(nil))
+
+	close(msgChan)
+	if err := proc.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+	if !mockProc.closed {
+		t.Error("Processor was not closed")
+	}
+}
+
+func TestProcessorPipelineSelectPartsDrop(t *testing.T) {
+	conf := processor.NewConfig()
+	conf.Type = "select_parts"
+	conf.SelectParts.Parts = []int{5}
+	selectProc, err := processor.New(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc := NewProcessor(
+		log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}),
+		metrics.DudType{},
+		selectProc,
+	)
+
+	msgChan, resChan := make(chan types.Message), make(chan types.Response)
+	if err := proc.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	res := sendAndRespond(t, msgChan, proc.ResponseChan(), "foo")
+	if res == nil {
+		t.Fatal("Expected response to dropped message")
+	}
+	if res.Error() != nil {
+		t.Error(res.Error())
+	}
+	if res.SkipAck() {
+		t.Error("Expected dropped message to be acknowledged")
+	}
+
+	proc.CloseAsync()
+	if err := proc.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+type mockUnackProcessor struct{}
+
+func (m mockUnackProcessor) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	return msg, types.NewUnacknowledgedResponse(), true
+}
+
+func TestProcessorPipelineSendUnacknowledged(t *testing.T) {
+	proc := NewProcessor(
+		log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}),
+		metrics.DudType{},
+		mockUnackProcessor{},
+		mockAppendProcessor{},
+	)
+
+	msgChan, resChan := make(chan types.Message), make(chan types.Response)
+	if err := proc.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	go receiveAndRespond(t, proc, resChan, [][]byte{
+		[]byte("foo"), []byte("appended"),
+	}, types.NewSimpleResponse(nil))
+	if res := sendAndRespond(t, msgChan, proc.ResponseChan(), "foo"); !res.SkipAck() {
+		t.Error("Expected unacknowledged response")
+	}
+
+	errRes := types.NewSimpleResponse(errors.New("nope"))
+	go receiveAndRespond(t, proc, resChan, [][]byte{
+		[]byte("bar"), []byte("appended"),
+	}, errRes)
+	if res := sendAndRespond(t, msgChan, proc.ResponseChan(), "bar"); res.Error() == nil {
+		t.Error("Expected error response")
+	}
+
+	proc.CloseAsync()
+	if err := proc.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProcessorPipelineCloseWithoutFlush(t *testing.T) {
+	proc := NewProcessor(
+		log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}),
+		metrics.DudType{},
+		mockAppendProcessor{},
+	)
+	if proc.Flushes() {
+		t.Error("Expected pipeline without flushing processors not to flush")
+	}
+
+	msgChan, resChan := make(chan types.Message), make(chan types.Response)
+	if err := proc.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pipeline stops without waiting for its input to close.
+	proc.CloseAsync()
+	if err := proc.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProcessorPipelineCloseInterruptsWait(t *testing.T) {
+	conf := processor.NewConfig()
+	conf.Type = "rate_limit"
+	conf.RateLimit.MessagesPerSecond = 0.1
+	conf.RateLimit.MessagesBurst = 1
+	rateProc, err := processor.New(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc := NewProcessor(
+		log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}),
+		metrics.DudType{},
+		rateProc,
+	)
+
+	msgChan, resChan := make(chan types.Message), make(chan types.Response)
+	if err := proc.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	go receiveAndRespond(t, proc, resChan, [][]byte{[]byte("foo")}, types.NewSimpleResponse(nil))
+	if res := sendAndRespond(t, msgChan, proc.ResponseChan(), "foo"); res.Error() != nil {
+		t.Fatal(res.Error())
+	}
+
+	// The second message waits for tokens until the pipeline is closed.
+	select {
+	case msgChan <- types.Message{Parts: [][]byte{[]byte("bar")}}:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out")
+	}
+	<-time.After(time.Millisecond * 10)
+
+	proc.CloseAsync()
+	if err := proc.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+type mockSplitProcessor struct {
+	split []*types.Message
+}
+
+func (m *mockSplitProcessor) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	m.split = nil
+	for _, part := range msg.Parts[1:] {
+		m.split = append(m.split, &types.Message{Parts: [][]byte{part}})
+	}
+	return &types.Message{Parts: msg.Parts[:1]}, nil, true
+}
+
+func (m *mockSplitProcessor) Split() []*types.Message {
+	split := m.split
+	m.split = nil
+	return split
+}
+
+func TestProcessorPipelineSplit(t *testing.T) {
+	proc := NewProcessor(
+		log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}),
+		metrics.DudType{},
+		&mockSplitProcessor{},
+		mockAppendProcessor{},
+	)
+
+	msgChan, resChan := make(chan types.Message), make(chan types.Response)
+	if err := proc.StartListening(resChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.StartReceiving(msgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msgChan <- types.Message{Parts: [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}}:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out")
+	}
+
+	receiveAndRespond(t, proc, resChan, [][]byte{
+		[]byte("foo"), []byte("appended"),
+	}, types.NewSimpleResponse(nil))
+
+	// A split message that is rejected is sent again.
+	receiveAndRespond(t, proc, resChan, [][]byte{
+		[]byte("bar"), []byte("appended"),
+	}, types.NewSimpleResponse(errors.New("nope")))
+	receiveAndRespond(t, proc, resChan, [][]byte{
+		[]byte("bar"), []byte("appended"),
+	}, types.NewSimpleResponse(nil))
+	receiveAndRespond(t, proc, resChan, [][]byte{
+		[]byte("baz"), []byte("appended"),
+	}, types.NewSimpleResponse(nil))
+
+	// The input message is acknowledged once all split messages are delivered.
+	select {
+	case res := <-proc.ResponseChan():
+		if res.Error() != nil {
+			t.Error(res.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out")
+	}
+
+	proc.CloseAsync()
+	if err := proc.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTracedProcessorPipelines(t *testing.T) {
+	spansChan := make(chan []map[string]interface{}, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var spans []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&spans); err != nil {
+			t.Error(err)
+		}
+		spansChan <- spans
+	}))
+	defer collector.Close()
+
+	logger := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	tConf := tracing.NewConfig()
+	tConf.SampleRatio = 1
+	tConf.CollectorURL = collector.URL
+	tracer := tracing.New(tConf, logger, metrics.DudType{})
+
+	inProc := NewTracedProcessor(logger, metrics.DudType{}, tracer, tracing.StageInput, mockAppendProcessor{})
+	outProc := NewTracedProcessor(logger, metrics.DudType{}, tracer, tracing.StageOutput, mockAppendProcessor{})
+
+	inMsgChan, inResChan := make(chan types.Message), make(chan types.Response)
+	outMsgChan, outResChan := make(chan types.Message), make(chan types.Response)
+	if err := inProc.StartListening(inResChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := inProc.StartReceiving(inMsgChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := outProc.StartListening(outResChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := outProc.StartReceiving(outMsgChan); err != nil {
+		t.Fatal(err)
+	}
+
+	passThrough := func(msgChan chan types.Message, resChan chan types.Response, p *Processor, msg types.Message) types.Message {
+		select {
+		case msgChan <- msg:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out")
+		}
+		var procMsg types.Message
+		select {
+		case procMsg = <-p.MessageChan():
+		case <-time.After(time.Second):
+			t.Fatal("Timed out")
+		}
+		select {
+		case resChan <- types.NewSimpleResponse(nil):
+		case <-time.After(time.Second):
+			t.Fatal("Timed out")
+		}
+		select {
+		case res := <-p.ResponseChan():
+			if res.Error() != nil {
+				t.Error(res.Error())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out")
+		}
+		return procMsg
+	}
+
+	msg := passThrough(inMsgChan, inResChan, inProc, types.Message{Parts: [][]byte{[]byte("foo")}})
+	traceID := tracing.TraceID(&msg)
+	if len(traceID) == 0 {
+		t.Fatal("Expected message to be traced")
+	}
+	passThrough(outMsgChan, outResChan, outProc, msg)
+
+	if err := tracer.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	var spans []map[string]interface{}
+	select {
+	case spans = <-spansChan:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for spans")
+	}
+
+	names := []string{}
+	for _, s := range spans {
+		if s["traceId"] != traceID {
+			t.Errorf("Wrong trace ID: %v != %v", s["traceId"], traceID)
+		}
+		names = append(names, s["name"].(string))
+	}
+	exp := []string{
+		"input.processor.0",
+		"input.send",
+		"buffer",
+		"output.processor.0",
+		"output.send",
+		"message",
+	}
+	if !reflect.DeepEqual(exp, names) {
+		t.Errorf("Wrong spans: %v != %v", names, exp)
+	}
+
+	close(inMsgChan)
+	close(outMsgChan)
+}
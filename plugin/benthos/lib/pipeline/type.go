@@ -20,7 +20,11 @@
 
 package pipeline
 
-import "github.com/Jeffail/benthos/lib/types"
+import (
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+)
 
 // ConstructorFunc is a common type for constructing a pipeline type.
 type ConstructorFunc func() (Type, error)
@@ -31,3 +35,16 @@ type Type interface {
 	types.Consumer
 	types.Closable
 }
+
+// Flusher is an optional interface implemented by pipelines that can flush the
+// state of their processors when closed, and which then continue to propagate
+// messages until their input is closed. Components that wrap a pipeline that
+// flushes wait for it to flush before closing the input of the pipeline.
+type Flusher interface {
+	// Flushes returns true if the pipeline flushes the state of its processors
+	// when closed.
+	Flushes() bool
+
+	// WaitForFlush blocks until the pipeline has flushed after being closed.
+	WaitForFlush(timeout time.Duration) error
+}
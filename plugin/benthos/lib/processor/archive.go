@@ -0,0 +1,224 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["archive"] = typeSpec{
+		constructor: NewArchive,
+		description: `
+Archives all the parts of a message into a single part using the selected
+format. Supported archive formats are: tar, zip, json_array, lines, binary.
+
+For the tar and zip formats each part is written as a file, named by the value
+of the metadata key 'name_metadata_key' of the part, or by the index of the part
+when that metadata is not set. The json_array format requires each part to be a
+valid JSON document, the lines format joins parts with a newline, and the binary
+format is the same encoding used by the 'multi_to_blob' processor.
+
+The resulting part adopts the metadata of the first part, except for the key
+'name_metadata_key', which names a single file. The 'unarchive' processor
+converts these archives back into multiple parts. Messages that fail to be
+archived are dropped.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// ArchiveConfig contains configuration for the Archive processor.
+type ArchiveConfig struct {
+	Format          string `json:"format" yaml:"format"`
+	NameMetadataKey string `json:"name_metadata_key" yaml:"name_metadata_key"`
+}
+
+// NewArchiveConfig returns a ArchiveConfig with default values.
+func NewArchiveConfig() ArchiveConfig {
+	return ArchiveConfig{
+		Format:          "tar",
+		NameMetadataKey: "name",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type archiveFunc func(nameKey string, msg *types.Message) ([]byte, error)
+
+// archiveFileName returns the file name of a message part.
+func archiveFileName(nameKey string, msg *types.Message, index int) string {
+	if name := msg.GetMetadata(index, nameKey); len(name) > 0 {
+		return name
+	}
+	return strconv.Itoa(index)
+}
+
+func tarArchive(nameKey string, msg *types.Message) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+
+	now := time.Now()
+	for i, part := range msg.Parts {
+		hdr := &tar.Header{
+			Name:    archiveFileName(nameKey, msg, i),
+			Mode:    0600,
+			Size:    int64(len(part)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(part); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func zipArchive(nameKey string, msg *types.Message) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+
+	for i, part := range msg.Parts {
+		w, err := zw.Create(archiveFileName(nameKey, msg, i))
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(part); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func jsonArrayArchive(nameKey string, msg *types.Message) ([]byte, error) {
+	docs := make([]json.RawMessage, len(msg.Parts))
+	for i, part := range msg.Parts {
+		if !json.Valid(part) {
+			return nil, fmt.Errorf("part %v is not a valid JSON document", i)
+		}
+		docs[i] = json.RawMessage(part)
+	}
+	return json.Marshal(docs)
+}
+
+func linesArchive(nameKey string, msg *types.Message) ([]byte, error) {
+	return bytes.Join(msg.Parts, []byte("\n")), nil
+}
+
+func binaryArchive(nameKey string, msg *types.Message) ([]byte, error) {
+	return msg.Bytes(), nil
+}
+
+func strToArchiver(str string) (archiveFunc, error) {
+	switch str {
+	case "tar":
+		return tarArchive, nil
+	case "zip":
+		return zipArchive, nil
+	case "json_array":
+		return jsonArrayArchive, nil
+	case "lines":
+		return linesArchive, nil
+	case "binary":
+		return binaryArchive, nil
+	}
+	return nil, fmt.Errorf("archive format not recognised: %v", str)
+}
+
+//------------------------------------------------------------------------------
+
+// Archive is a processor that archives the parts of a message into a single
+// part.
+type Archive struct {
+	conf  ArchiveConfig
+	log   log.Modular
+	stats metrics.Type
+
+	archive archiveFunc
+}
+
+// NewArchive returns a Archive processor.
+func NewArchive(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	archiver, err := strToArchiver(conf.Archive.Format)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{
+		conf:    conf.Archive,
+		log:     log.NewModule(".processor.archive"),
+		stats:   stats,
+		archive: archiver,
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// ProcessMessage archives the parts of a message into a single part.
+func (a *Archive) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	a.stats.Incr("processor.archive.count", 1)
+
+	if len(msg.Parts) == 0 {
+		a.stats.Incr("processor.archive.skipped", 1)
+		return msg, nil, true
+	}
+
+	result, err := a.archive(a.conf.NameMetadataKey, msg)
+	if err != nil {
+		a.stats.Incr("processor.archive.dropped", 1)
+		a.log.Errorf("Failed to create archive: %v\n", err)
+		return nil, types.NewSimpleResponse(nil), false
+	}
+
+	newMsg := types.NewMessage()
+	newMsg.Parts = [][]byte{result}
+	for k, v := range msg.PartMetadata(0) {
+		if k != a.conf.NameMetadataKey {
+			newMsg.SetMetadata(0, k, v)
+		}
+	}
+
+	a.stats.Incr("processor.archive.success", 1)
+	return &newMsg, nil, true
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,280 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func TestArchiveBadFormat(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Archive.Format = "does not exist"
+	if _, err := NewArchive(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad archive format")
+	}
+
+	conf.Unarchive.Format = "does not exist"
+	if _, err := NewUnarchive(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad unarchive format")
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	input := [][]byte{
+		[]byte(`{"id":"foo"}`),
+		[]byte(`{"id":"bar"}`),
+		[]byte(`["baz"]`),
+	}
+
+	for _, format := range []string{"tar", "zip", "json_array", "lines", "binary"} {
+		conf := NewConfig()
+		conf.Archive.Format = format
+		conf.Unarchive.Format = format
+
+		archiver, err := NewArchive(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		unarchiver, err := NewUnarchive(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := types.NewMessage()
+		msg.Parts = input
+
+		archived, _, propagate := archiver.ProcessMessage(&msg)
+		if !propagate {
+			t.Fatalf("%v: message was not archived", format)
+		}
+		if exp, act := 1, len(archived.Parts); exp != act {
+			t.Errorf("%v: wrong count of archived parts: %v != %v", format, act, exp)
+		}
+
+		unarchived, _, propagate := unarchiver.ProcessMessage(archived)
+		if !propagate {
+			t.Fatalf("%v: message was not unarchived", format)
+		}
+		if !reflect.DeepEqual(input, unarchived.Parts) {
+			t.Errorf("%v: wrong result: %s != %s", format, unarchived.Parts, input)
+		}
+	}
+}
+
+func TestArchiveFileNames(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	for _, format := range []string{"tar", "zip"} {
+		conf := NewConfig()
+		conf.Archive.Format = format
+		conf.Unarchive.Format = format
+		conf.Unarchive.NameMetadataKey = "path"
+
+		archiver, err := NewArchive(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		unarchiver, err := NewUnarchive(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := types.NewMessage()
+		msg.Parts = [][]byte{[]byte("foo"), []byte("bar")}
+		msg.SetMetadata(0, "name", "foo.txt")
+
+		archived, _, _ := archiver.ProcessMessage(&msg)
+		unarchived, _, propagate := unarchiver.ProcessMessage(archived)
+		if !propagate {
+			t.Fatalf("%v: message was not unarchived", format)
+		}
+		if exp, act := "foo.txt", unarchived.GetMetadata(0, "path"); exp != act {
+			t.Errorf("%v: wrong first file name: %v != %v", format, act, exp)
+		}
+		if exp, act := "1", unarchived.GetMetadata(1, "path"); exp != act {
+			t.Errorf("%v: wrong second file name: %v != %v", format, act, exp)
+		}
+	}
+}
+
+func TestArchiveJSONArrayInvalid(t *testing.T) {
+	conf := NewConfig()
+	conf.Archive.Format = "json_array"
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewArchive(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{[]byte(`{"id":"foo"}`), []byte(`not json`)}
+	if _, _, propagate := proc.ProcessMessage(&msg); propagate {
+		t.Error("Expected message with invalid JSON to be dropped")
+	}
+}
+
+func TestUnarchiveMultipleParts(t *testing.T) {
+	conf := NewConfig()
+	conf.Unarchive.Format = "lines"
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewUnarchive(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{[]byte("foo\nbar\n"), []byte("baz")}
+
+	msgOut, _, propagate := proc.ProcessMessage(&msg)
+	if !propagate {
+		t.Fatal("Message was not propagated")
+	}
+	exp := [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}
+	if !reflect.DeepEqual(exp, msgOut.Parts) {
+		t.Errorf("Wrong result: %s != %s", msgOut.Parts, exp)
+	}
+
+	conf.Unarchive.Format = "tar"
+	if proc, err = NewUnarchive(conf, testLog, metrics.DudType{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, propagate = proc.ProcessMessage(&msg); propagate {
+		t.Error("Expected message with invalid archive to be dropped")
+	}
+}
+
+func TestArchiveMetadata(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Archive.Format = "tar"
+	archiver, err := NewArchive(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Unarchive.Format = "tar"
+	unarchiver, err := NewUnarchive(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := types.Message{Parts: [][]byte{[]byte("foo"), []byte("bar")}}
+	msg.SetMetadata(0, "name", "foo.txt")
+	msg.SetMetadata(0, "source", "first")
+	msg.SetMetadata(1, "source", "second")
+
+	archived, _, propagate := archiver.ProcessMessage(&msg)
+	if !propagate {
+		t.Fatal("Message was not propagated")
+	}
+	if exp, act := map[string]string{"source": "first"}, archived.PartMetadata(0); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong archive metadata: %v != %v", act, exp)
+	}
+
+	unarchived, _, propagate := unarchiver.ProcessMessage(archived)
+	if !propagate {
+		t.Fatal("Message was not propagated")
+	}
+	exp := []map[string]string{
+		{"name": "foo.txt", "source": "first"},
+		{"name": "1", "source": "first"},
+	}
+	if act := unarchived.Metadata; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong unarchived metadata: %v != %v", act, exp)
+	}
+
+	conf.Archive.Format = "binary"
+	if archiver, err = NewArchive(conf, testLog, metrics.DudType{}); err != nil {
+		t.Fatal(err)
+	}
+	conf.Unarchive.Format = "binary"
+	if unarchiver, err = NewUnarchive(conf, testLog, metrics.DudType{}); err != nil {
+		t.Fatal(err)
+	}
+	if archived, _, propagate = archiver.ProcessMessage(&msg); !propagate {
+		t.Fatal("Message was not propagated")
+	}
+	if unarchived, _, propagate = unarchiver.ProcessMessage(archived); !propagate {
+		t.Fatal("Message was not propagated")
+	}
+	if exp, act := msg.Metadata, unarchived.Metadata; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong unarchived metadata: %v != %v", act, exp)
+	}
+}
+
+func TestUnarchiveMaxSize(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	input := [][]byte{
+		bytes.Repeat([]byte("a"), 600),
+		bytes.Repeat([]byte("b"), 600),
+	}
+
+	for _, format := range []string{"tar", "zip"} {
+		conf := NewConfig()
+		conf.Archive.Format = format
+		conf.Unarchive.Format = format
+		conf.Unarchive.MaxSize = 1000
+
+		archiver, err := NewArchive(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		unarchiver, err := NewUnarchive(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := types.NewMessage()
+		msg.Parts = input
+		archived, _, _ := archiver.ProcessMessage(&msg)
+
+		// Each file is within the max size but their total is not.
+		if _, _, propagate := unarchiver.ProcessMessage(archived); propagate {
+			t.Errorf("%v: archive exceeding max size was unarchived", format)
+		}
+
+		msg.Parts = input[:1]
+		archived, _, _ = archiver.ProcessMessage(&msg)
+		if _, _, propagate := unarchiver.ProcessMessage(archived); !propagate {
+			t.Errorf("%v: archive within max size was not unarchived", format)
+		}
+	}
+
+	conf := NewConfig()
+	conf.Unarchive.MaxSize = 0
+	if _, err := NewUnarchive(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from zero max size")
+	}
+}
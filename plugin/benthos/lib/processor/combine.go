@@ -67,6 +67,7 @@ type Combine struct {
 	stats metrics.Type
 	n     int
 	parts [][]byte
+	meta  []map[string]string
 }
 
 // NewCombine returns a Combine processor.
@@ -87,12 +88,18 @@ func (c *Combine) ProcessMessage(msg *types.Message) (*types.Message, types.Resp
 	c.stats.Incr("processor.combine.count", 1)
 
 	c.parts = append(c.parts, msg.Parts...)
+	for i := range msg.Parts {
+		c.meta = append(c.meta, msg.PartMetadata(i))
+	}
 
 	if len(c.parts) >= c.n {
 		msg.Parts = c.parts[:c.n]
-		oldParts := c.parts
+		msg.Metadata = c.meta[:c.n]
+		oldParts, oldMeta := c.parts, c.meta
 		c.parts = [][]byte{}
 		c.parts = append(c.parts, oldParts[c.n:]...)
+		c.meta = []map[string]string{}
+		c.meta = append(c.meta, oldMeta[c.n:]...)
 
 		return msg, nil, true
 	}
@@ -0,0 +1,222 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["compress"] = typeSpec{
+		constructor: NewCompress,
+		description: `
+Compresses parts of a message according to the selected algorithm. Supported
+compression types are: gzip, zlib, snappy, lz4, zstd. The parts to compress are
+listed by their index in 'parts', and when empty all parts are compressed.
+
+The 'level' field sets the level of compression, where -1 uses the default of
+the algorithm. For gzip and zlib the level ranges from 0 (none) to 9 (best). For
+zstd the level follows the standard zstd levels from 1 to 22. Snappy does not
+support levels, and for lz4 a level above zero enables high compression at that
+level.
+
+The ratio of compressed to uncompressed bytes of each message, as a percentage,
+is reported with the gauge 'processor.compress.ratio'.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// CompressConfig contains configuration for the Compress processor.
+type CompressConfig struct {
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+	Level     int    `json:"level" yaml:"level"`
+	Parts     []int  `json:"parts" yaml:"parts"`
+}
+
+// NewCompressConfig returns a CompressConfig with default values.
+func NewCompressConfig() CompressConfig {
+	return CompressConfig{
+		Algorithm: "gzip",
+		Level:     -1,
+		Parts:     []int{},
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type compressFunc func(level int, b []byte) ([]byte, error)
+
+func gzipCompress(level int, b []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(b); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func zlibCompress(level int, b []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := zlib.NewWriterLevel(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(b); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func snappyCompress(level int, b []byte) ([]byte, error) {
+	return snappy.Encode(nil, b), nil
+}
+
+func lz4Compress(level int, b []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := lz4.NewWriter(buf)
+	if level > 0 {
+		w.Header.CompressionLevel = level
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func zstdCompress(level int, b []byte) ([]byte, error) {
+	opts := []zstd.EOption{}
+	if level > 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	w, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	return w.EncodeAll(b, nil), nil
+}
+
+func strToCompressor(str string) (compressFunc, error) {
+	switch str {
+	case "gzip":
+		return gzipCompress, nil
+	case "zlib":
+		return zlibCompress, nil
+	case "snappy":
+		return snappyCompress, nil
+	case "lz4":
+		return lz4Compress, nil
+	case "zstd":
+		return zstdCompress, nil
+	}
+	return nil, fmt.Errorf("compression type not recognised: %v", str)
+}
+
+//------------------------------------------------------------------------------
+
+// Compress is a processor that compresses message parts.
+type Compress struct {
+	conf  CompressConfig
+	log   log.Modular
+	stats metrics.Type
+
+	comp compressFunc
+}
+
+// NewCompress returns a Compress processor.
+func NewCompress(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	cor, err := strToCompressor(conf.Compress.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &Compress{
+		conf:  conf.Compress,
+		log:   log.NewModule(".processor.compress"),
+		stats: stats,
+		comp:  cor,
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// ProcessMessage compresses the targeted parts of a message. The resulting
+// message is a shallow copy of the original where only the target parts are
+// replaced.
+func (c *Compress) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	c.stats.Incr("processor.compress.count", 1)
+
+	newMsg := types.Message{
+		Parts:    make([][]byte, len(msg.Parts)),
+		Metadata: msg.CopyMetadata(),
+	}
+	copy(newMsg.Parts, msg.Parts)
+
+	var bytesIn, bytesOut int64
+	for _, index := range partIndexes(c.conf.Parts, msg) {
+		if index < 0 || index >= len(msg.Parts) {
+			c.stats.Incr("processor.compress.skipped", 1)
+			continue
+		}
+		result, err := c.comp(c.conf.Level, msg.Parts[index])
+		if err != nil {
+			c.stats.Incr("processor.compress.error", 1)
+			c.log.Debugf("Failed to compress message part: %v\n", err)
+			continue
+		}
+		bytesIn += int64(len(msg.Parts[index]))
+		bytesOut += int64(len(result))
+		newMsg.Parts[index] = result
+	}
+
+	c.stats.Incr("processor.compress.bytes.in", bytesIn)
+	c.stats.Incr("processor.compress.bytes.out", bytesOut)
+	if bytesIn > 0 {
+		c.stats.Gauge("processor.compress.ratio", bytesOut*100/bytesIn)
+	}
+	return &newMsg, nil, true
+}
+
+//------------------------------------------------------------------------------
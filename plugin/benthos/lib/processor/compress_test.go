@@ -0,0 +1,203 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func TestCompressBadAlgo(t *testing.T) {
+	conf := NewConfig()
+	conf.Compress.Algorithm = "does not exist"
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	if _, err := NewCompress(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad algo")
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	input := [][]byte{
+		[]byte("hello world first part"),
+		bytes.Repeat([]byte("hello world second part "), 100),
+		[]byte(""),
+	}
+
+	for _, algo := range []string{"gzip", "zlib", "snappy", "lz4", "zstd"} {
+		for _, level := range []int{-1, 1, 9} {
+			conf := NewConfig()
+			conf.Compress.Algorithm = algo
+			conf.Compress.Level = level
+			conf.Decompress.Algorithm = algo
+
+			comp, err := NewCompress(conf, testLog, metrics.DudType{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			decomp, err := NewDecompress(conf, testLog, metrics.DudType{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg := types.NewMessage()
+			msg.Parts = input
+
+			compMsg, _, propagate := comp.ProcessMessage(&msg)
+			if !propagate {
+				t.Fatalf("%v: message was not propagated", algo)
+			}
+			if !reflect.DeepEqual(input, msg.Parts) {
+				t.Errorf("%v: input message was modified", algo)
+			}
+
+			decompMsg, _, propagate := decomp.ProcessMessage(compMsg)
+			if !propagate {
+				t.Fatalf("%v: message was not propagated", algo)
+			}
+			if !reflect.DeepEqual(input, decompMsg.Parts) {
+				t.Errorf("%v level %v: wrong result: %s != %s", algo, level, decompMsg.Parts, input)
+			}
+		}
+	}
+}
+
+func TestCompressParts(t *testing.T) {
+	conf := NewConfig()
+	conf.Compress.Parts = []int{1, 5}
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewCompress(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := bytes.Repeat([]byte("foo bar baz "), 100)
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{input, input}
+
+	msgOut, _, propagate := proc.ProcessMessage(&msg)
+	if !propagate {
+		t.Fatal("Message was not propagated")
+	}
+	if !reflect.DeepEqual(input, msgOut.Parts[0]) {
+		t.Error("Untargeted part was modified")
+	}
+	if len(msgOut.Parts[1]) >= len(input) {
+		t.Errorf("Part was not compressed: %v >= %v", len(msgOut.Parts[1]), len(input))
+	}
+}
+
+func TestDecompressMaxSize(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	input := bytes.Repeat([]byte("a"), 1000)
+
+	for _, algo := range []string{"gzip", "zlib", "snappy", "lz4", "zstd"} {
+		conf := NewConfig()
+		conf.Compress.Algorithm = algo
+		conf.Decompress.Algorithm = algo
+		conf.Decompress.MaxSize = 999
+
+		comp, err := NewCompress(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		decomp, err := NewDecompress(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := types.NewMessage()
+		msg.Parts = [][]byte{input}
+
+		compMsg, _, _ := comp.ProcessMessage(&msg)
+		decompMsg, _, propagate := decomp.ProcessMessage(compMsg)
+		if !propagate {
+			t.Fatalf("%v: message was not propagated", algo)
+		}
+		if !reflect.DeepEqual(compMsg.Parts, decompMsg.Parts) {
+			t.Errorf("%v: part exceeding max size was decompressed", algo)
+		}
+	}
+
+	conf := NewConfig()
+	conf.Decompress.MaxSize = 0
+	if _, err := NewDecompress(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from zero max size")
+	}
+}
+
+func TestDecompressZstdWindowSize(t *testing.T) {
+	conf := NewConfig()
+	conf.Decompress.Algorithm = "zstd"
+	conf.Decompress.MaxSize = 999
+
+	decomp, err := NewDecompress(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A frame declaring a 256MB window followed by a single raw block of one
+	// byte.
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x90, 0x09, 0x00, 0x00, 'a'}
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{frame}
+	decompMsg, _, propagate := decomp.ProcessMessage(&msg)
+	if !propagate {
+		t.Fatal("Message was not propagated")
+	}
+	if !reflect.DeepEqual(msg.Parts, decompMsg.Parts) {
+		t.Error("Frame with a window exceeding max size was decompressed")
+	}
+}
+
+func TestDecompressBadInput(t *testing.T) {
+	conf := NewConfig()
+	conf.Decompress.Algorithm = "gzip"
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewDecompress(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{[]byte("not compressed")}
+
+	msgOut, _, propagate := proc.ProcessMessage(&msg)
+	if !propagate {
+		t.Fatal("Message was not propagated")
+	}
+	if !reflect.DeepEqual(msg.Parts, msgOut.Parts) {
+		t.Errorf("Wrong result: %s != %s", msgOut.Parts, msg.Parts)
+	}
+}
@@ -46,6 +46,7 @@ var constructors = map[string]typeSpec{}
 // Config is the all encompassing configuration struct for all processor types.
 type Config struct {
 	Type        string            `json:"type" yaml:"type"`
+	Archive     ArchiveConfig     `json:"archive" yaml:"archive"`
 	BoundsCheck BoundsCheckConfig `json:"bounds_check" yaml:"bounds_check"`
 	SelectParts SelectPartsConfig `json:"select_parts" yaml:"select_parts"`
 	BlobToMulti struct{}          `json:"blob_to_multi" yaml:"blob_to_multi"`
@@ -53,12 +54,23 @@ type Config struct {
 	Sample      SampleConfig      `json:"sample" yaml:"sample"`
 	HashSample  HashSampleConfig  `json:"hash_sample" yaml:"hash_sample"`
 	Combine     CombineConfig     `json:"combine" yaml:"combine"`
+	Compress    CompressConfig    `json:"compress" yaml:"compress"`
+	Decompress  DecompressConfig  `json:"decompress" yaml:"decompress"`
+	JSON        JSONConfig        `json:"json" yaml:"json"`
+	Filter      FilterConfig      `json:"filter" yaml:"filter"`
+	Lua         LuaConfig         `json:"lua" yaml:"lua"`
+	Dedupe      DedupeConfig      `json:"dedupe" yaml:"dedupe"`
+	RateLimit   RateLimitConfig   `json:"rate_limit" yaml:"rate_limit"`
+	Unarchive   UnarchiveConfig   `json:"unarchive" yaml:"unarchive"`
+	Validate    ValidateConfig    `json:"validate" yaml:"validate"`
+	Window      WindowConfig      `json:"window" yaml:"window"`
 }
 
 // NewConfig returns a configuration struct fully populated with default values.
 func NewConfig() Config {
 	return Config{
 		Type:        "bounds_check",
+		Archive:     NewArchiveConfig(),
 		BoundsCheck: NewBoundsCheckConfig(),
 		SelectParts: NewSelectPartsConfig(),
 		BlobToMulti: struct{}{},
@@ -66,6 +78,16 @@ func NewConfig() Config {
 		Sample:      NewSampleConfig(),
 		HashSample:  NewHashSampleConfig(),
 		Combine:     NewCombineConfig(),
+		Compress:    NewCompressConfig(),
+		Decompress:  NewDecompressConfig(),
+		JSON:        NewJSONConfig(),
+		Filter:      NewFilterConfig(),
+		Lua:         NewLuaConfig(),
+		Dedupe:      NewDedupeConfig(),
+		RateLimit:   NewRateLimitConfig(),
+		Unarchive:   NewUnarchiveConfig(),
+		Validate:    NewValidateConfig(),
+		Window:      NewWindowConfig(),
 	}
 }
 
@@ -99,6 +121,17 @@ func (m *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 //------------------------------------------------------------------------------
 
+// TypeNames returns an alphabetically sorted list of the names of each
+// registered processor type.
+func TypeNames() []string {
+	names := []string{}
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Descriptions returns a formatted string of collated descriptions of each
 // type.
 func Descriptions() string {
@@ -0,0 +1,232 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["decompress"] = typeSpec{
+		constructor: NewDecompress,
+		description: `
+Decompresses parts of a message according to the selected algorithm. Supported
+decompression types are: gzip, zlib, snappy, lz4, zstd. The parts to decompress
+are listed by their index in 'parts', and when empty all parts are decompressed.
+
+In order to guard against decompression bombs a part is not decompressed when
+its decompressed size would exceed 'max_size' bytes, or for zstd when its frame
+declares a window larger than 'max_size' bytes. Parts that fail to decompress are
+left unchanged.
+
+The ratio of compressed to decompressed bytes of each message, as a percentage,
+is reported with the gauge 'processor.decompress.ratio'.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// DecompressConfig contains configuration for the Decompress processor.
+type DecompressConfig struct {
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+	MaxSize   int    `json:"max_size" yaml:"max_size"`
+	Parts     []int  `json:"parts" yaml:"parts"`
+}
+
+// NewDecompressConfig returns a DecompressConfig with default values.
+func NewDecompressConfig() DecompressConfig {
+	return DecompressConfig{
+		Algorithm: "gzip",
+		MaxSize:   104857600, // 100MB
+		Parts:     []int{},
+	}
+}
+
+//------------------------------------------------------------------------------
+
+var errDecompressTooLarge = errors.New("decompressed size exceeds max_size")
+
+type decompressFunc func(maxSize int, b []byte) ([]byte, error)
+
+// readLimited reads all data from a reader, returning an error if it exceeds
+// a maximum size.
+func readLimited(maxSize int, r io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxSize {
+		return nil, errDecompressTooLarge
+	}
+	return b, nil
+}
+
+func gzipDecompress(maxSize int, b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(maxSize, r)
+}
+
+func zlibDecompress(maxSize int, b []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(maxSize, r)
+}
+
+func snappyDecompress(maxSize int, b []byte) ([]byte, error) {
+	l, err := snappy.DecodedLen(b)
+	if err != nil {
+		return nil, err
+	}
+	if l > maxSize {
+		return nil, errDecompressTooLarge
+	}
+	return snappy.Decode(make([]byte, l), b)
+}
+
+func lz4Decompress(maxSize int, b []byte) ([]byte, error) {
+	return readLimited(maxSize, lz4.NewReader(bytes.NewReader(b)))
+}
+
+func zstdDecompress(maxSize int, b []byte) ([]byte, error) {
+	// Limit the memory allocated for the window declared by a frame, which is
+	// otherwise allocated before any data is read.
+	r, err := zstd.NewReader(
+		bytes.NewReader(b),
+		zstd.WithDecoderMaxMemory(uint64(maxSize)),
+		zstd.WithDecoderConcurrency(1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(maxSize, r)
+}
+
+func strToDecompressor(str string) (decompressFunc, error) {
+	switch str {
+	case "gzip":
+		return gzipDecompress, nil
+	case "zlib":
+		return zlibDecompress, nil
+	case "snappy":
+		return snappyDecompress, nil
+	case "lz4":
+		return lz4Decompress, nil
+	case "zstd":
+		return zstdDecompress, nil
+	}
+	return nil, fmt.Errorf("decompression type not recognised: %v", str)
+}
+
+//------------------------------------------------------------------------------
+
+// Decompress is a processor that decompresses message parts.
+type Decompress struct {
+	conf  DecompressConfig
+	log   log.Modular
+	stats metrics.Type
+
+	decomp decompressFunc
+}
+
+// NewDecompress returns a Decompress processor.
+func NewDecompress(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	dcor, err := strToDecompressor(conf.Decompress.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Decompress.MaxSize <= 0 {
+		return nil, errors.New("max_size must be greater than zero")
+	}
+	return &Decompress{
+		conf:   conf.Decompress,
+		log:    log.NewModule(".processor.decompress"),
+		stats:  stats,
+		decomp: dcor,
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// ProcessMessage decompresses the targeted parts of a message. The resulting
+// message is a shallow copy of the original where only the target parts are
+// replaced.
+func (d *Decompress) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	d.stats.Incr("processor.decompress.count", 1)
+
+	newMsg := types.Message{
+		Parts:    make([][]byte, len(msg.Parts)),
+		Metadata: msg.CopyMetadata(),
+	}
+	copy(newMsg.Parts, msg.Parts)
+
+	var bytesIn, bytesOut int64
+	for _, index := range partIndexes(d.conf.Parts, msg) {
+		if index < 0 || index >= len(msg.Parts) {
+			d.stats.Incr("processor.decompress.skipped", 1)
+			continue
+		}
+		result, err := d.decomp(d.conf.MaxSize, msg.Parts[index])
+		if err == errDecompressTooLarge {
+			d.stats.Incr("processor.decompress.error.too_large", 1)
+			d.log.Warnf("Refusing to decompress message part: %v\n", err)
+			continue
+		} else if err != nil {
+			d.stats.Incr("processor.decompress.error", 1)
+			d.log.Debugf("Failed to decompress message part: %v\n", err)
+			continue
+		}
+		bytesIn += int64(len(msg.Parts[index]))
+		bytesOut += int64(len(result))
+		newMsg.Parts[index] = result
+	}
+
+	d.stats.Incr("processor.decompress.bytes.in", bytesIn)
+	d.stats.Incr("processor.decompress.bytes.out", bytesOut)
+	if bytesOut > 0 {
+		d.stats.Gauge("processor.decompress.ratio", bytesIn*100/bytesOut)
+	}
+	return &newMsg, nil, true
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,406 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/gabs"
+	"github.com/OneOfOne/xxhash"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["dedupe"] = typeSpec{
+		constructor: NewDedupe,
+		description: `
+Drops messages that are duplicates of a message seen within a window. A message
+is identified by a hash of a single part, or of the value at a dot separated
+'json_path' within that part when set.
+
+Hashes are kept in an in-memory LRU cache holding at most 'cache_size' entries.
+When 'window_ms' is greater than zero a hash expires that many milliseconds
+after it was first seen, otherwise hashes are only evicted when the cache is
+full.
+
+The hash of a message is only added to the cache once the message has been
+delivered. A message that fails to be delivered is therefore not dropped when it
+is sent again, and duplicates of a message that are processed before its
+delivery is acknowledged are passed through. Duplicates that are dropped whilst
+an earlier message is still held downstream, such as by an output batching
+policy, are not acknowledged, as that would also acknowledge the held message.
+
+If 'snapshot_path' is set the cache is written to that file every
+'snapshot_period_ms' milliseconds and when the pipeline is closed, and is loaded
+from it on start up, so that the window is preserved across restarts. Hashes
+added since the last snapshot are lost if the process stops without closing.
+
+Messages where the target part does not exist, or where the JSON path cannot be
+resolved, are passed through unchanged.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// DedupeConfig contains any configuration for the Dedupe processor.
+type DedupeConfig struct {
+	Part             int    `json:"part" yaml:"part"`
+	JSONPath         string `json:"json_path" yaml:"json_path"`
+	CacheSize        int    `json:"cache_size" yaml:"cache_size"`
+	WindowMS         int64  `json:"window_ms" yaml:"window_ms"`
+	SnapshotPath     string `json:"snapshot_path" yaml:"snapshot_path"`
+	SnapshotPeriodMS int64  `json:"snapshot_period_ms" yaml:"snapshot_period_ms"`
+}
+
+// NewDedupeConfig returns a DedupeConfig with default values.
+func NewDedupeConfig() DedupeConfig {
+	return DedupeConfig{
+		Part:             0,
+		JSONPath:         "",
+		CacheSize:        100000,
+		WindowMS:         0,
+		SnapshotPath:     "",
+		SnapshotPeriodMS: 10000,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// dedupeEntry is an entry of the dedupe cache.
+type dedupeEntry struct {
+	hash uint64
+	seen time.Time
+}
+
+// dedupeCache is an LRU cache of message hashes with an optional expiry.
+type dedupeCache struct {
+	size   int
+	window time.Duration
+
+	entries map[uint64]*list.Element
+	order   *list.List
+}
+
+func newDedupeCache(size int, window time.Duration) *dedupeCache {
+	return &dedupeCache{
+		size:    size,
+		window:  window,
+		entries: map[uint64]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// Seen returns true if a hash exists in the cache and has not expired, in
+// which case it becomes the most recently used entry.
+func (d *dedupeCache) Seen(hash uint64, now time.Time) bool {
+	if ele, exists := d.entries[hash]; exists {
+		entry := ele.Value.(*dedupeEntry)
+		if d.window <= 0 || now.Sub(entry.seen) < d.window {
+			d.order.MoveToFront(ele)
+			return true
+		}
+		d.order.Remove(ele)
+		delete(d.entries, hash)
+	}
+	return false
+}
+
+func (d *dedupeCache) add(hash uint64, seen time.Time) {
+	d.entries[hash] = d.order.PushFront(&dedupeEntry{hash: hash, seen: seen})
+	for d.order.Len() > d.size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.entries, oldest.Value.(*dedupeEntry).hash)
+	}
+}
+
+// WriteTo writes all unexpired entries of the cache, from least to most
+// recently used, as pairs of big endian hashes and unix nano timestamps.
+func (d *dedupeCache) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	buf := make([]byte, 16)
+	for ele := d.order.Back(); ele != nil; ele = ele.Prev() {
+		entry := ele.Value.(*dedupeEntry)
+		binary.BigEndian.PutUint64(buf[:8], entry.hash)
+		binary.BigEndian.PutUint64(buf[8:], uint64(entry.seen.UnixNano()))
+		n, err := w.Write(buf)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom adds entries to the cache from a reader of entries written by
+// WriteTo, skipping any that have expired.
+func (d *dedupeCache) ReadFrom(r io.Reader) (int64, error) {
+	var read int64
+	now := time.Now()
+	buf := make([]byte, 16)
+	for {
+		n, err := io.ReadFull(r, buf)
+		read += int64(n)
+		if err == io.EOF {
+			return read, nil
+		}
+		if err != nil {
+			return read, err
+		}
+		seen := time.Unix(0, int64(binary.BigEndian.Uint64(buf[8:])))
+		if d.window > 0 && now.Sub(seen) >= d.window {
+			continue
+		}
+		d.add(binary.BigEndian.Uint64(buf[:8]), seen)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+var errDedupeNoValue = errors.New("json path did not resolve to a value")
+
+// Dedupe is a processor that drops messages that have already been seen
+// within a window.
+type Dedupe struct {
+	conf  Config
+	log   log.Modular
+	stats metrics.Type
+
+	snapshotPeriod time.Duration
+	lastSnapshot   time.Time
+
+	// The hash of the last propagated message, which is added to the cache
+	// once the message is acknowledged.
+	pending     bool
+	pendingHash uint64
+	pendingSeen time.Time
+
+	// held is true when the last successful response did not acknowledge its
+	// message, which is therefore still held downstream.
+	held bool
+
+	cache *dedupeCache
+	mut   sync.Mutex
+}
+
+// NewDedupe returns a Dedupe processor.
+func NewDedupe(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	if conf.Dedupe.CacheSize <= 0 {
+		return nil, errors.New("cache_size must be greater than zero")
+	}
+	d := &Dedupe{
+		conf:           conf,
+		log:            log.NewModule(".processor.dedupe"),
+		stats:          stats,
+		snapshotPeriod: time.Duration(conf.Dedupe.SnapshotPeriodMS) * time.Millisecond,
+		lastSnapshot:   time.Now(),
+		cache: newDedupeCache(
+			conf.Dedupe.CacheSize,
+			time.Duration(conf.Dedupe.WindowMS)*time.Millisecond,
+		),
+	}
+	if len(conf.Dedupe.SnapshotPath) > 0 {
+		if err := d.loadSnapshot(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+//------------------------------------------------------------------------------
+
+// loadSnapshot reads the cache from the snapshot file, if it exists.
+func (d *Dedupe) loadSnapshot() error {
+	f, err := os.Open(d.conf.Dedupe.SnapshotPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = d.cache.ReadFrom(bufio.NewReader(f))
+	return err
+}
+
+// writeSnapshot atomically replaces the snapshot file with the current cache.
+func (d *Dedupe) writeSnapshot() error {
+	path := d.conf.Dedupe.SnapshotPath
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if _, err = d.cache.WriteTo(w); err == nil {
+		if err = w.Flush(); err == nil {
+			err = f.Sync()
+		}
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// hashMessage returns the hash of the target of a message.
+func (d *Dedupe) hashMessage(msg *types.Message) (uint64, error) {
+	index := d.conf.Dedupe.Part
+	if index < 0 || index >= len(msg.Parts) {
+		return 0, errJSONPartOutOfBounds
+	}
+	if len(d.conf.Dedupe.JSONPath) == 0 {
+		return xxhash.Checksum64(msg.Parts[index]), nil
+	}
+
+	body, err := gabs.ParseJSON(msg.Parts[index])
+	if err != nil {
+		return 0, err
+	}
+	value := body.Path(d.conf.Dedupe.JSONPath).Data()
+	if value == nil {
+		return 0, errDedupeNoValue
+	}
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	return xxhash.Checksum64(valueBytes), nil
+}
+
+// ProcessMessage drops the message if it has been seen within the window.
+func (d *Dedupe) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	d.stats.Incr("processor.dedupe.count", 1)
+
+	hash, err := d.hashMessage(msg)
+	if err != nil {
+		d.stats.Incr("processor.dedupe.error.hash", 1)
+		d.log.Debugf("Failed to hash message, passing it through: %v\n", err)
+		return msg, nil, true
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if d.pending {
+		if hash == d.pendingHash {
+			// This could be the previous message sent again after failing to
+			// be delivered.
+			d.stats.Incr("processor.dedupe.pending", 1)
+			return msg, nil, true
+		}
+		// Inputs send a message again until it is delivered, and therefore
+		// the previous message was delivered without being acknowledged.
+		d.cache.add(d.pendingHash, d.pendingSeen)
+		d.pending = false
+	}
+
+	now := time.Now()
+	if d.cache.Seen(hash, now) {
+		d.stats.Incr("processor.dedupe.dropped", 1)
+		if d.held {
+			// Acknowledging now would also acknowledge messages that are
+			// still held downstream.
+			return nil, types.NewUnacknowledgedResponse(), false
+		}
+		return nil, types.NewSimpleResponse(nil), false
+	}
+	d.pending, d.pendingHash, d.pendingSeen = true, hash, now
+	return msg, nil, true
+}
+
+// Acknowledge adds the hash of the last propagated message to the cache if it
+// was delivered, otherwise the hash is discarded so that the message is not
+// dropped when it is sent again.
+func (d *Dedupe) Acknowledge(res types.Response) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if res.Error() == nil {
+		d.held = res.SkipAck()
+	}
+	if !d.pending {
+		return
+	}
+	if res.Error() == nil {
+		d.cache.add(d.pendingHash, d.pendingSeen)
+	}
+	d.pending = false
+}
+
+// snapshot writes the cache to the snapshot file, must be called with the lock
+// held.
+func (d *Dedupe) snapshot(now time.Time) {
+	d.lastSnapshot = now
+	if err := d.writeSnapshot(); err != nil {
+		d.stats.Incr("processor.dedupe.error.snapshot", 1)
+		d.log.Errorf("Failed to write snapshot: %v\n", err)
+	}
+}
+
+// Tick writes the cache to the snapshot file once the snapshot period has
+// passed since the last snapshot.
+func (d *Dedupe) Tick(now time.Time) (*types.Message, bool) {
+	if len(d.conf.Dedupe.SnapshotPath) == 0 {
+		return nil, false
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if now.Sub(d.lastSnapshot) >= d.snapshotPeriod {
+		d.snapshot(now)
+	}
+	return nil, false
+}
+
+// Flush writes the cache to the snapshot file.
+func (d *Dedupe) Flush() (*types.Message, bool) {
+	if len(d.conf.Dedupe.SnapshotPath) == 0 {
+		return nil, false
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	d.snapshot(time.Now())
+	return nil, false
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,253 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func dedupeExpect(t *testing.T, proc Type, input string, passed bool) {
+	msg := &types.Message{Parts: [][]byte{[]byte(input)}}
+	res, resp, propagated := proc.ProcessMessage(msg)
+	if propagated != passed {
+		t.Errorf("Wrong result for %v: %v != %v", input, propagated, passed)
+	}
+	if passed {
+		if res == nil || string(res.Parts[0]) != input {
+			t.Errorf("Wrong message returned for %v: %v", input, res)
+		}
+	} else if resp == nil || resp.Error() != nil {
+		t.Errorf("Expected nil error response for dropped message %v: %v", input, resp)
+	}
+	proc.(Acknowledger).Acknowledge(types.NewSimpleResponse(nil))
+}
+
+func TestDedupeBadConfig(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Dedupe.CacheSize = 0
+	if _, err := NewDedupe(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from zero cache size")
+	}
+}
+
+func TestDedupeBasic(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Dedupe.CacheSize = 2
+	proc, err := NewDedupe(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dedupeExpect(t, proc, "foo", true)
+	dedupeExpect(t, proc, "foo", false)
+	dedupeExpect(t, proc, "bar", true)
+	dedupeExpect(t, proc, "foo", false)
+
+	// Evicts bar, which is the least recently used.
+	dedupeExpect(t, proc, "baz", true)
+	dedupeExpect(t, proc, "foo", false)
+	dedupeExpect(t, proc, "bar", true)
+
+	// Parts out of bounds are passed through.
+	if _, _, propagated := proc.ProcessMessage(&types.Message{}); !propagated {
+		t.Error("Expected message without parts to be passed through")
+	}
+}
+
+func TestDedupeWindow(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Dedupe.WindowMS = 50
+	proc, err := NewDedupe(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dedupeExpect(t, proc, "foo", true)
+	dedupeExpect(t, proc, "foo", false)
+	<-time.After(time.Millisecond * 60)
+	dedupeExpect(t, proc, "foo", true)
+	dedupeExpect(t, proc, "foo", false)
+}
+
+func TestDedupeJSONPath(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Dedupe.JSONPath = "event.id"
+	proc, err := NewDedupe(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dedupeExpect(t, proc, `{"event":{"id":"a","value":1}}`, true)
+	dedupeExpect(t, proc, `{"event":{"id":"a","value":2}}`, false)
+	dedupeExpect(t, proc, `{"event":{"id":"b","value":1}}`, true)
+	dedupeExpect(t, proc, `{"event":{"id":{"nested":true}}}`, true)
+	dedupeExpect(t, proc, `{"event":{"id":{"nested":true}}}`, false)
+
+	// Messages without the path, or not JSON, are passed through.
+	dedupeExpect(t, proc, `{"event":{}}`, true)
+	dedupeExpect(t, proc, `{"event":{}}`, true)
+	dedupeExpect(t, proc, `not json`, true)
+	dedupeExpect(t, proc, `not json`, true)
+}
+
+func TestDedupeSnapshot(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	dir, err := ioutil.TempDir("", "benthos_dedupe_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewConfig()
+	conf.Dedupe.SnapshotPath = filepath.Join(dir, "snapshots", "dedupe")
+	conf.Dedupe.SnapshotPeriodMS = 0
+	conf.Dedupe.WindowMS = 60000
+
+	proc, err := NewDedupe(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dedupeExpect(t, proc, "foo", true)
+	dedupeExpect(t, proc, "bar", true)
+
+	// Snapshots are not written whilst processing messages.
+	if _, err = os.Stat(conf.Dedupe.SnapshotPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no snapshot before a tick: %v", err)
+	}
+	proc.(Ticker).Tick(time.Now())
+
+	if proc, err = NewDedupe(conf, testLog, metrics.DudType{}); err != nil {
+		t.Fatal(err)
+	}
+	dedupeExpect(t, proc, "foo", false)
+	dedupeExpect(t, proc, "bar", false)
+	dedupeExpect(t, proc, "baz", true)
+	proc.(Flusher).Flush()
+
+	if proc, err = NewDedupe(conf, testLog, metrics.DudType{}); err != nil {
+		t.Fatal(err)
+	}
+	dedupeExpect(t, proc, "baz", false)
+
+	// Entries that expired whilst stopped are not restored.
+	conf.Dedupe.WindowMS = 1
+	<-time.After(time.Millisecond * 5)
+	if proc, err = NewDedupe(conf, testLog, metrics.DudType{}); err != nil {
+		t.Fatal(err)
+	}
+	dedupeExpect(t, proc, "foo", true)
+}
+
+func TestDedupeFailedDelivery(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	proc, err := NewDedupe(NewConfig(), testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acker := proc.(Acknowledger)
+
+	msg := &types.Message{Parts: [][]byte{[]byte("foo")}}
+	if _, _, propagated := proc.ProcessMessage(msg); !propagated {
+		t.Fatal("Expected first message to be propagated")
+	}
+	acker.Acknowledge(types.NewSimpleResponse(errors.New("nope")))
+
+	// The message is sent again after failing and must not be dropped.
+	if _, _, propagated := proc.ProcessMessage(msg); !propagated {
+		t.Fatal("Expected message sent again to be propagated")
+	}
+
+	// Until the message is acknowledged it is passed through.
+	if _, _, propagated := proc.ProcessMessage(msg); !propagated {
+		t.Fatal("Expected unacknowledged message to be propagated")
+	}
+	acker.Acknowledge(types.NewSimpleResponse(nil))
+
+	if _, _, propagated := proc.ProcessMessage(msg); propagated {
+		t.Error("Expected delivered message to be dropped")
+	}
+
+	// Without an acknowledgement a different message implies the previous one
+	// was delivered.
+	bar := &types.Message{Parts: [][]byte{[]byte("bar")}}
+	proc.ProcessMessage(bar)
+	proc.ProcessMessage(msg)
+	if _, _, propagated := proc.ProcessMessage(bar); propagated {
+		t.Error("Expected delivered message to be dropped")
+	}
+}
+
+func TestDedupeHeldDownstream(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	proc, err := NewDedupe(NewConfig(), testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acker := proc.(Acknowledger)
+
+	foo := &types.Message{Parts: [][]byte{[]byte("foo")}}
+	bar := &types.Message{Parts: [][]byte{[]byte("bar")}}
+	proc.ProcessMessage(foo)
+	acker.Acknowledge(types.NewSimpleResponse(nil))
+
+	// The next message is delivered but held downstream.
+	proc.ProcessMessage(bar)
+	acker.Acknowledge(types.NewUnacknowledgedResponse())
+
+	_, res, propagated := proc.ProcessMessage(foo)
+	if propagated {
+		t.Fatal("Expected duplicate to be dropped")
+	}
+	if res.Error() != nil || !res.SkipAck() {
+		t.Error("Expected duplicate dropped whilst a message is held not to be acknowledged")
+	}
+	acker.Acknowledge(res)
+
+	// Once a later message is acknowledged duplicates are acknowledged again.
+	proc.ProcessMessage(&types.Message{Parts: [][]byte{[]byte("baz")}})
+	acker.Acknowledge(types.NewSimpleResponse(nil))
+
+	if _, res, _ = proc.ProcessMessage(foo); res.SkipAck() {
+		t.Error("Expected duplicate to be acknowledged")
+	}
+}
+
+//------------------------------------------------------------------------------
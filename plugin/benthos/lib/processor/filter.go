@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"github.com/Jeffail/benthos/lib/condition"
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["filter"] = typeSpec{
+		constructor: NewFilter,
+		description: `
+Tests each message against a condition, if the condition fails then the message
+is dropped. The available conditions can be listed with
+` + "`benthos --list-conditions`" + `, e.g. to drop all messages where the JSON
+field 'type' is not "foo":
+
+` + "``` yaml" + `
+type: filter
+filter:
+  type: json_field
+  json_field:
+    path: type
+    value: foo
+` + "```",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// FilterConfig contains configuration for the Filter processor.
+type FilterConfig struct {
+	condition.Config `json:",inline" yaml:",inline"`
+}
+
+// NewFilterConfig returns a FilterConfig with default values.
+func NewFilterConfig() FilterConfig {
+	return FilterConfig{
+		Config: condition.NewConfig(),
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Filter is a processor that checks each message against a condition and
+// rejects the message if the condition fails.
+type Filter struct {
+	log   log.Modular
+	stats metrics.Type
+
+	condition condition.Type
+}
+
+// NewFilter returns a Filter processor.
+func NewFilter(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	cond, err := condition.New(conf.Filter.Config, log.NewModule(".processor.filter"), stats)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{
+		log:       log.NewModule(".processor.filter"),
+		stats:     stats,
+		condition: cond,
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// ProcessMessage checks each message against a condition.
+func (f *Filter) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	f.stats.Incr("processor.filter.count", 1)
+
+	if !f.condition.Check(msg) {
+		f.stats.Incr("processor.filter.dropped", 1)
+		return nil, types.NewSimpleResponse(nil), false
+	}
+	return msg, nil, true
+}
+
+//------------------------------------------------------------------------------
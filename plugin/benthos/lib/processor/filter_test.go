@@ -0,0 +1,81 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func TestFilterJSONField(t *testing.T) {
+	conf := NewConfig()
+	conf.Filter.Type = "json_field"
+	conf.Filter.JSONField.Path = "type"
+	conf.Filter.JSONField.Value = "foo"
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewFilter(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goodParts := [][][]byte{
+		{[]byte(`{"type":"foo"}`)},
+		{[]byte(`{"type":"foo","bar":"baz"}`), []byte(`ignored`)},
+	}
+
+	badParts := [][][]byte{
+		{[]byte(`{"type":"bar"}`)},
+		{[]byte(`{"nope":"foo"}`)},
+		{[]byte(`not json`)},
+	}
+
+	for _, parts := range goodParts {
+		msg := &types.Message{Parts: parts}
+		if res, _, check := proc.ProcessMessage(msg); !check {
+			t.Errorf("Filter failed on: %s", parts)
+		} else if res != msg {
+			t.Error("Wrong message returned (expected same)")
+		}
+	}
+
+	for _, parts := range badParts {
+		if _, res, check := proc.ProcessMessage(&types.Message{Parts: parts}); check {
+			t.Errorf("Filter didnt fail on: %s", parts)
+		} else if res.Error() != nil {
+			t.Errorf("Expected nil error response: %v", res.Error())
+		}
+	}
+}
+
+func TestFilterBadConfig(t *testing.T) {
+	conf := NewConfig()
+	conf.Filter.Type = "does_not_exist"
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	if _, err := NewFilter(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad condition type")
+	}
+}
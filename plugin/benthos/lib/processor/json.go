@@ -0,0 +1,247 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/gabs"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["json"] = typeSpec{
+		constructor: NewJSON,
+		description: `
+Parses a message part as a JSON document and performs an operator on a field,
+identified by a dot separated path. If the part cannot be parsed as JSON then
+the message is passed on unchanged.
+
+The available operators are: get|set|delete|move|copy
+
+### ` + "`get`" + `
+
+Replaces the contents of the part with the value of the field at 'path'. String
+values are written as they are, all other values are written as JSON. If the
+field does not exist the part is replaced with 'null'.
+
+### ` + "`set`" + `
+
+Sets the field at 'path' to 'value', which can be any type including objects
+and arrays. An empty path replaces the whole document.
+
+### ` + "`delete`" + `
+
+Removes the field at 'path'.
+
+### ` + "`move`" + `
+
+Moves the field at 'path' to the 'destination' path, replacing any existing
+value. If the field does not exist the document is left unchanged.
+
+### ` + "`copy`" + `
+
+Copies the field at 'path' to the 'destination' path, replacing any existing
+value. If the field does not exist the document is left unchanged.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// JSONConfig contains configuration for the JSON processor.
+type JSONConfig struct {
+	Part        int         `json:"part" yaml:"part"`
+	Operator    string      `json:"operator" yaml:"operator"`
+	Path        string      `json:"path" yaml:"path"`
+	Value       interface{} `json:"value" yaml:"value"`
+	Destination string      `json:"destination" yaml:"destination"`
+}
+
+// NewJSONConfig returns a JSONConfig with default values.
+func NewJSONConfig() JSONConfig {
+	return JSONConfig{
+		Part:        0,
+		Operator:    "get",
+		Path:        "",
+		Value:       nil,
+		Destination: "",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type jsonOperator func(body *gabs.Container) ([]byte, error)
+
+// JSON is a processor that performs an operation on a field of a JSON message
+// part.
+type JSON struct {
+	part     int
+	operator jsonOperator
+
+	log   log.Modular
+	stats metrics.Type
+}
+
+// NewJSON returns a JSON processor.
+func NewJSON(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	j := &JSON{
+		part:  conf.JSON.Part,
+		log:   log.NewModule(".processor.json"),
+		stats: stats,
+	}
+
+	path, dest := conf.JSON.Path, conf.JSON.Destination
+	if conf.JSON.Operator != "get" && conf.JSON.Operator != "set" && len(path) == 0 {
+		return nil, fmt.Errorf("json operator '%v' requires a path", conf.JSON.Operator)
+	}
+
+	switch conf.JSON.Operator {
+	case "get":
+		j.operator = func(body *gabs.Container) ([]byte, error) {
+			if len(path) > 0 {
+				body = body.Path(path)
+			}
+			if str, ok := body.Data().(string); ok {
+				return []byte(str), nil
+			}
+			return json.Marshal(body.Data())
+		}
+	case "set":
+		value := sanitiseJSONValue(conf.JSON.Value)
+		j.operator = func(body *gabs.Container) ([]byte, error) {
+			if len(path) == 0 {
+				return json.Marshal(value)
+			}
+			if _, err := body.SetP(value, path); err != nil {
+				return nil, err
+			}
+			return body.Bytes(), nil
+		}
+	case "delete":
+		j.operator = func(body *gabs.Container) ([]byte, error) {
+			body.DeleteP(path)
+			return body.Bytes(), nil
+		}
+	case "move", "copy":
+		if len(dest) == 0 {
+			return nil, fmt.Errorf("json operator '%v' requires a destination", conf.JSON.Operator)
+		}
+		move := conf.JSON.Operator == "move"
+		j.operator = func(body *gabs.Container) ([]byte, error) {
+			if !body.ExistsP(path) {
+				return body.Bytes(), nil
+			}
+			value := body.Path(path).Data()
+			if move {
+				if err := body.DeleteP(path); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := body.SetP(value, dest); err != nil {
+				return nil, err
+			}
+			return body.Bytes(), nil
+		}
+	default:
+		return nil, fmt.Errorf("json operator not recognised: %v", conf.JSON.Operator)
+	}
+
+	return j, nil
+}
+
+//------------------------------------------------------------------------------
+
+// sanitiseJSONValue converts any maps with interface{} keys, as produced when
+// parsing YAML, into maps with string keys so that they can be serialised as
+// JSON.
+func sanitiseJSONValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprintf("%v", k)] = sanitiseJSONValue(v)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[k] = sanitiseJSONValue(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, v := range t {
+			s[i] = sanitiseJSONValue(v)
+		}
+		return s
+	}
+	return v
+}
+
+//------------------------------------------------------------------------------
+
+var errJSONPartOutOfBounds = errors.New("message part index out of bounds")
+
+// ProcessMessage parses a message part as JSON and performs an operation on
+// it. The resulting message is a shallow copy of the original where only the
+// target part is replaced.
+func (j *JSON) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	j.stats.Incr("processor.json.count", 1)
+
+	index := j.part
+	if index < 0 || index >= len(msg.Parts) {
+		j.stats.Incr("processor.json.skipped", 1)
+		j.log.Debugf("Skipping message: %v\n", errJSONPartOutOfBounds)
+		return msg, nil, true
+	}
+
+	body, err := gabs.ParseJSON(msg.Parts[index])
+	if err != nil {
+		j.stats.Incr("processor.json.error.json_parse", 1)
+		j.log.Debugf("Failed to parse message part as JSON: %v\n", err)
+		return msg, nil, true
+	}
+
+	result, err := j.operator(body)
+	if err != nil {
+		j.stats.Incr("processor.json.error.operator", 1)
+		j.log.Debugf("Failed to apply JSON operator: %v\n", err)
+		return msg, nil, true
+	}
+
+	newMsg := types.Message{
+		Parts:    make([][]byte, len(msg.Parts)),
+		Metadata: msg.CopyMetadata(),
+	}
+	copy(newMsg.Parts, msg.Parts)
+	newMsg.Parts[index] = result
+
+	j.stats.Incr("processor.json.success", 1)
+	return &newMsg, nil, true
+}
+
+//------------------------------------------------------------------------------
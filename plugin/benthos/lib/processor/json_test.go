@@ -0,0 +1,216 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func TestJSONBadConfig(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.JSON.Operator = "nope"
+	if _, err := NewJSON(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad operator")
+	}
+
+	conf = NewConfig()
+	conf.JSON.Operator = "delete"
+	if _, err := NewJSON(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from missing path")
+	}
+
+	conf = NewConfig()
+	conf.JSON.Operator = "copy"
+	conf.JSON.Path = "foo"
+	if _, err := NewJSON(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from missing destination")
+	}
+}
+
+func TestJSONOperators(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	type testCase struct {
+		name        string
+		operator    string
+		path        string
+		value       interface{}
+		destination string
+		input       string
+		output      string
+	}
+
+	tests := []testCase{
+		{
+			name:     "get string",
+			operator: "get",
+			path:     "foo.bar",
+			input:    `{"foo":{"bar":"baz"}}`,
+			output:   `baz`,
+		},
+		{
+			name:     "get object",
+			operator: "get",
+			path:     "foo",
+			input:    `{"foo":{"bar":5}}`,
+			output:   `{"bar":5}`,
+		},
+		{
+			name:     "get missing",
+			operator: "get",
+			path:     "foo.nope",
+			input:    `{"foo":{"bar":5}}`,
+			output:   `null`,
+		},
+		{
+			name:     "set new",
+			operator: "set",
+			path:     "foo.baz",
+			value:    "qux",
+			input:    `{"foo":{"bar":5}}`,
+			output:   `{"foo":{"bar":5,"baz":"qux"}}`,
+		},
+		{
+			name:     "set yaml object",
+			operator: "set",
+			path:     "foo",
+			value:    map[interface{}]interface{}{"a": []interface{}{1, "b"}},
+			input:    `{"foo":{"bar":5}}`,
+			output:   `{"foo":{"a":[1,"b"]}}`,
+		},
+		{
+			name:     "set root",
+			operator: "set",
+			path:     "",
+			value:    10,
+			input:    `{"foo":{"bar":5}}`,
+			output:   `10`,
+		},
+		{
+			name:     "delete",
+			operator: "delete",
+			path:     "foo.bar",
+			input:    `{"foo":{"bar":5,"baz":6}}`,
+			output:   `{"foo":{"baz":6}}`,
+		},
+		{
+			name:        "move",
+			operator:    "move",
+			path:        "foo.bar",
+			destination: "qux",
+			input:       `{"foo":{"bar":5,"baz":6}}`,
+			output:      `{"foo":{"baz":6},"qux":5}`,
+		},
+		{
+			name:        "copy",
+			operator:    "copy",
+			path:        "foo.bar",
+			destination: "qux.quz",
+			input:       `{"foo":{"bar":5}}`,
+			output:      `{"foo":{"bar":5},"qux":{"quz":5}}`,
+		},
+		{
+			name:        "copy missing",
+			operator:    "copy",
+			path:        "foo.nope",
+			destination: "qux",
+			input:       `{"foo":{"bar":5}}`,
+			output:      `{"foo":{"bar":5}}`,
+		},
+	}
+
+	for _, test := range tests {
+		conf := NewConfig()
+		conf.JSON.Part = 1
+		conf.JSON.Operator = test.operator
+		conf.JSON.Path = test.path
+		conf.JSON.Value = test.value
+		conf.JSON.Destination = test.destination
+
+		proc, err := NewJSON(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		input := &types.Message{Parts: [][]byte{
+			[]byte("first"),
+			[]byte(test.input),
+		}}
+		input.SetMetadata(1, "foo", "bar")
+
+		msg, _, propagate := proc.ProcessMessage(input)
+		if !propagate {
+			t.Errorf("%v: Message not propagated", test.name)
+			continue
+		}
+
+		exp := [][]byte{[]byte("first"), []byte(test.output)}
+		if act := msg.Parts; !reflect.DeepEqual(exp, act) {
+			t.Errorf("%v: Wrong result: %s != %s", test.name, act, exp)
+		}
+		if act := msg.GetMetadata(1, "foo"); act != "bar" {
+			t.Errorf("%v: Metadata not preserved: %v", test.name, act)
+		}
+		msg.SetMetadata(1, "foo", "changed")
+		if act := input.GetMetadata(1, "foo"); act != "bar" {
+			t.Errorf("%v: Input metadata was modified: %v", test.name, act)
+		}
+		if exp, act := test.input, string(input.Parts[1]); exp != act {
+			t.Errorf("%v: Input message was modified: %v != %v", test.name, act, exp)
+		}
+	}
+}
+
+func TestJSONPassThrough(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.JSON.Part = 1
+	conf.JSON.Operator = "delete"
+	conf.JSON.Path = "foo"
+
+	proc, err := NewJSON(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []*types.Message{
+		{Parts: [][]byte{[]byte(`{"foo":"bar"}`)}},
+		{Parts: [][]byte{[]byte(`{"foo":"bar"}`), []byte(`not json`)}},
+	}
+	for _, input := range inputs {
+		msg, _, propagate := proc.ProcessMessage(input)
+		if !propagate {
+			t.Error("Message not propagated")
+		} else if msg != input {
+			t.Error("Wrong message returned (expected same)")
+		}
+	}
+}
@@ -0,0 +1,433 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["lua"] = typeSpec{
+		constructor: NewLua,
+		description: `
+Executes a Lua script on each message. The script is provided either inline with
+the 'script' field or loaded from the path in the 'file' field, and must define a
+global function with the name set by the 'function' field.
+
+The function is called with two arguments, a table of the message parts as
+strings and a table of the metadata of each part as string key/value tables. It
+should return either a table of the new message parts, or nil in order to drop
+the message.
+
+The function may also return a second table containing the metadata of each
+returned part. If omitted then each part keeps the metadata of the part of the
+input message at the same index. Parts and metadata must be strings, or numbers
+which are converted to strings, and any other value is an error.
+
+` + "``` lua" + `
+function process(parts, metadata)
+  if parts[1] == "" then
+    return nil
+  end
+  return { string.upper(parts[1]) }
+end
+` + "```" + `
+
+In order to split a message into several messages the function can instead
+return a table of messages, each of which is a table of parts, along with an
+optional table of the metadata tables of each message. The messages are sent in
+order and the input message is only acknowledged once all of them have been
+delivered. For example, the following function splits a message into a message
+for each line of its first part:
+
+` + "``` lua" + `
+function process(parts, metadata)
+  local messages = {}
+  for line in string.gmatch(parts[1], "[^\n]+") do
+    table.insert(messages, { line })
+  end
+  return messages
+end
+` + "```" + `
+
+The script is compiled once and executed on a pool of at most 'vm_pool_size'
+Lua VMs. The 'on_error' field determines what happens to a message when the
+script fails or takes longer than 'timeout_ms' to run:
+
+'reject' returns the error as the response to the message. Most inputs will
+attempt to deliver a rejected message again, and therefore a script that always
+fails for a message blocks the input until the script is fixed.
+
+'drop' acknowledges and drops the message.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// LuaConfig contains configuration for the Lua processor.
+type LuaConfig struct {
+	Script     string `json:"script" yaml:"script"`
+	File       string `json:"file" yaml:"file"`
+	Function   string `json:"function" yaml:"function"`
+	VMPoolSize int    `json:"vm_pool_size" yaml:"vm_pool_size"`
+	TimeoutMS  int64  `json:"timeout_ms" yaml:"timeout_ms"`
+	OnError    string `json:"on_error" yaml:"on_error"`
+}
+
+// NewLuaConfig returns a LuaConfig with default values.
+func NewLuaConfig() LuaConfig {
+	return LuaConfig{
+		Script:     "",
+		File:       "",
+		Function:   "process",
+		VMPoolSize: 1,
+		TimeoutMS:  1000,
+		OnError:    "reject",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+var (
+	errLuaBadReturn = errors.New("lua function must return a table of parts, a table of messages or nil")
+	errLuaTimeout   = errors.New("lua function exceeded timeout")
+)
+
+// Lua is a processor that executes a Lua script on each message.
+type Lua struct {
+	log   log.Modular
+	stats metrics.Type
+
+	function string
+	timeout  time.Duration
+	drop     bool
+
+	// split holds the messages that follow the last message returned by
+	// ProcessMessage.
+	split []*types.Message
+
+	vms       chan *lua.LState
+	vmsMut    sync.Mutex
+	closed    bool
+	closeChan chan struct{}
+}
+
+// NewLua returns a Lua processor.
+func NewLua(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	script, name := conf.Lua.Script, "<script>"
+	if len(conf.Lua.File) > 0 {
+		if len(script) > 0 {
+			return nil, errors.New("lua processor requires either a script or a file, not both")
+		}
+		fileBytes, err := ioutil.ReadFile(conf.Lua.File)
+		if err != nil {
+			return nil, err
+		}
+		script, name = string(fileBytes), conf.Lua.File
+	}
+	if len(script) == 0 {
+		return nil, errors.New("lua processor requires a script or a file")
+	}
+	var drop bool
+	switch conf.Lua.OnError {
+	case "reject":
+	case "drop":
+		drop = true
+	default:
+		return nil, fmt.Errorf("lua on_error behaviour not recognised: %v", conf.Lua.OnError)
+	}
+	if conf.Lua.VMPoolSize < 1 {
+		return nil, fmt.Errorf("lua vm_pool_size must be at least 1: %v", conf.Lua.VMPoolSize)
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(script), name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lua script: %v", err)
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile lua script: %v", err)
+	}
+
+	l := &Lua{
+		log:      log.NewModule(".processor.lua"),
+		stats:    stats,
+		function: conf.Lua.Function,
+		timeout:  time.Duration(conf.Lua.TimeoutMS) * time.Millisecond,
+		drop:     drop,
+
+		vms:       make(chan *lua.LState, conf.Lua.VMPoolSize),
+		closeChan: make(chan struct{}),
+	}
+	for i := 0; i < conf.Lua.VMPoolSize; i++ {
+		vm, err := l.newVM(proto)
+		if err != nil {
+			l.closeVMs()
+			return nil, err
+		}
+		l.vms <- vm
+	}
+	return l, nil
+}
+
+// newVM creates a Lua VM and executes the compiled script within it, which
+// defines the process function.
+func (l *Lua) newVM(proto *lua.FunctionProto) (*lua.LState, error) {
+	vm := lua.NewState()
+	vm.Push(vm.NewFunctionFromProto(proto))
+	if err := vm.PCall(0, lua.MultRet, nil); err != nil {
+		vm.Close()
+		return nil, fmt.Errorf("failed to execute lua script: %v", err)
+	}
+	if vm.GetGlobal(l.function).Type() != lua.LTFunction {
+		vm.Close()
+		return nil, fmt.Errorf("lua script does not define a function '%v'", l.function)
+	}
+	vm.SetTop(0)
+	return vm, nil
+}
+
+// closeVMs closes all Lua VMs currently in the pool.
+func (l *Lua) closeVMs() {
+	for {
+		select {
+		case vm := <-l.vms:
+			vm.Close()
+		default:
+			return
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// call executes the process function of a VM with a message and returns the
+// resulting messages, where a nil result means the message should be dropped.
+func (l *Lua) call(vm *lua.LState, msg *types.Message) ([]*types.Message, error) {
+	defer vm.SetTop(0)
+
+	var ctx context.Context
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), l.timeout)
+		defer cancel()
+		vm.SetContext(ctx)
+		defer vm.RemoveContext()
+	}
+
+	partsTable, metaTable := vm.NewTable(), vm.NewTable()
+	for i, part := range msg.Parts {
+		partsTable.Append(lua.LString(part))
+		partMeta := vm.NewTable()
+		for k, v := range msg.PartMetadata(i) {
+			partMeta.RawSetString(k, lua.LString(v))
+		}
+		metaTable.Append(partMeta)
+	}
+
+	if err := vm.CallByParam(lua.P{
+		Fn:      vm.GetGlobal(l.function),
+		NRet:    2,
+		Protect: true,
+	}, partsTable, metaTable); err != nil {
+		if ctx != nil && ctx.Err() == context.DeadlineExceeded {
+			return nil, errLuaTimeout
+		}
+		return nil, err
+	}
+
+	retParts, retMeta := vm.Get(-2), vm.Get(-1)
+	if retParts == lua.LNil {
+		return nil, nil
+	}
+	partsResult, ok := retParts.(*lua.LTable)
+	if !ok {
+		return nil, errLuaBadReturn
+	}
+	metaResult, _ := retMeta.(*lua.LTable)
+
+	// A table of tables rather than strings is a table of messages.
+	if _, isMessages := partsResult.RawGetInt(1).(*lua.LTable); !isMessages {
+		newMsg, err := luaMessage(msg, partsResult, metaResult)
+		if err != nil {
+			return nil, err
+		}
+		return []*types.Message{newMsg}, nil
+	}
+
+	msgs := make([]*types.Message, partsResult.Len())
+	for i := range msgs {
+		msgParts, ok := partsResult.RawGetInt(i + 1).(*lua.LTable)
+		if !ok {
+			return nil, errLuaBadReturn
+		}
+		var msgMeta *lua.LTable
+		if metaResult != nil {
+			msgMeta, _ = metaResult.RawGetInt(i + 1).(*lua.LTable)
+		}
+		var err error
+		if msgs[i], err = luaMessage(msg, msgParts, msgMeta); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+// luaMessage creates a message from a table of parts returned by the process
+// function and an optional table of the metadata of each part. Without
+// metadata each part keeps the metadata of the part of the input message at
+// the same index.
+func luaMessage(in *types.Message, partsTable, metaTable *lua.LTable) (*types.Message, error) {
+	parts := make([][]byte, partsTable.Len())
+	for i := range parts {
+		v := partsTable.RawGetInt(i + 1)
+		if !lua.LVCanConvToString(v) {
+			return nil, fmt.Errorf("lua function returned a part of type %v, expected a string", v.Type())
+		}
+		parts[i] = []byte(lua.LVAsString(v))
+	}
+
+	var meta []map[string]string
+	if metaTable != nil {
+		meta = make([]map[string]string, len(parts))
+		for i := range meta {
+			partMeta, ok := metaTable.RawGetInt(i + 1).(*lua.LTable)
+			if !ok {
+				continue
+			}
+			meta[i] = map[string]string{}
+			var err error
+			partMeta.ForEach(func(k, v lua.LValue) {
+				if err != nil {
+					return
+				}
+				if !lua.LVCanConvToString(k) || !lua.LVCanConvToString(v) {
+					err = fmt.Errorf(
+						"lua function returned metadata of type %v: %v, expected strings",
+						k.Type(), v.Type(),
+					)
+					return
+				}
+				meta[i][lua.LVAsString(k)] = lua.LVAsString(v)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if len(in.Metadata) > 0 {
+		meta = make([]map[string]string, len(parts))
+		copy(meta, in.CopyMetadata())
+	}
+	return &types.Message{
+		Parts:    parts,
+		Metadata: meta,
+	}, nil
+}
+
+// release returns a VM to the pool, or closes it if the processor is closed.
+func (l *Lua) release(vm *lua.LState) {
+	l.vmsMut.Lock()
+	defer l.vmsMut.Unlock()
+	if l.closed {
+		vm.Close()
+		return
+	}
+	l.vms <- vm
+}
+
+// ProcessMessage executes the Lua script on a message, which can modify,
+// split or drop it.
+func (l *Lua) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	l.stats.Incr("processor.lua.count", 1)
+
+	var vm *lua.LState
+	select {
+	case vm = <-l.vms:
+	case <-l.closeChan:
+		return nil, types.NewSimpleResponse(types.ErrTypeClosed), false
+	}
+	msgs, err := l.call(vm, msg)
+	l.release(vm)
+	l.split = nil
+
+	if err != nil {
+		if err == errLuaTimeout {
+			l.stats.Incr("processor.lua.error.timeout", 1)
+		} else {
+			l.stats.Incr("processor.lua.error.script", 1)
+		}
+		l.log.Errorf("Failed to execute lua script: %v\n", err)
+		if !l.drop {
+			l.stats.Incr("processor.lua.rejected", 1)
+			return nil, types.NewSimpleResponse(err), false
+		}
+		l.stats.Incr("processor.lua.dropped", 1)
+		return nil, types.NewSimpleResponse(nil), false
+	}
+	if len(msgs) == 0 {
+		l.stats.Incr("processor.lua.dropped", 1)
+		return nil, types.NewSimpleResponse(nil), false
+	}
+
+	l.stats.Incr("processor.lua.success", 1)
+	if len(msgs) > 1 {
+		l.stats.Incr("processor.lua.split", int64(len(msgs)-1))
+		l.split = msgs[1:]
+	}
+	return msgs[0], nil, true
+}
+
+// Split returns the messages that follow the first message returned by the
+// last call to ProcessMessage, when the script returned several messages.
+func (l *Lua) Split() []*types.Message {
+	split := l.split
+	l.split = nil
+	return split
+}
+
+// CloseAsync closes the Lua VMs of the processor, including those currently in
+// use once they are returned to the pool.
+func (l *Lua) CloseAsync() {
+	l.vmsMut.Lock()
+	defer l.vmsMut.Unlock()
+	if l.closed {
+		return
+	}
+	l.closed = true
+	close(l.closeChan)
+	l.closeVMs()
+}
+
+//------------------------------------------------------------------------------
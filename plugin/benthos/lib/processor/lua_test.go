@@ -0,0 +1,406 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func TestLuaBadConfig(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	scripts := []string{
+		"",
+		"function process(parts",
+		"error('nope')",
+		"function not_process(parts) return parts end",
+	}
+
+	for _, script := range scripts {
+		conf := NewConfig()
+		conf.Lua.Script = script
+		if _, err := NewLua(conf, testLog, metrics.DudType{}); err == nil {
+			t.Errorf("Expected error from script: %v", script)
+		}
+	}
+
+	conf := NewConfig()
+	conf.Lua.Script = "function process(parts) return parts end"
+	conf.Lua.VMPoolSize = 0
+	if _, err := NewLua(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from zero pool size")
+	}
+}
+
+func TestLuaTransform(t *testing.T) {
+	conf := NewConfig()
+	conf.Lua.Script = `
+function process(parts, metadata)
+  local result = {}
+  for i, part in ipairs(parts) do
+    result[i] = string.upper(part) .. (metadata[i]["foo"] or "")
+  end
+  return result
+end`
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewLua(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := &types.Message{Parts: [][]byte{[]byte("hello"), []byte("world")}}
+	input.SetMetadata(1, "foo", "bar")
+
+	msg, _, propagate := proc.ProcessMessage(input)
+	if !propagate {
+		t.Fatal("Message not propagated")
+	}
+	if exp, act := [][]byte{[]byte("HELLO"), []byte("WORLDbar")}, msg.Parts; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+	if exp, act := "bar", msg.GetMetadata(1, "foo"); exp != act {
+		t.Errorf("Wrong metadata: %v != %v", act, exp)
+	}
+}
+
+func TestLuaSplitAndMetadata(t *testing.T) {
+	conf := NewConfig()
+	conf.Lua.Script = `
+function process(parts, metadata)
+  local result, meta = {}, {}
+  for word in string.gmatch(parts[1], "%S+") do
+    table.insert(result, word)
+    table.insert(meta, { word = word })
+  end
+  return result, meta
+end`
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewLua(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _, propagate := proc.ProcessMessage(&types.Message{
+		Parts: [][]byte{[]byte("foo bar baz")},
+	})
+	if !propagate {
+		t.Fatal("Message not propagated")
+	}
+	exp := [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}
+	if act := msg.Parts; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+	for i, part := range exp {
+		if act := msg.GetMetadata(i, "word"); act != string(part) {
+			t.Errorf("Wrong metadata for part %v: %v != %s", i, act, part)
+		}
+	}
+}
+
+func TestLuaSplitMessages(t *testing.T) {
+	conf := NewConfig()
+	conf.Lua.Script = `
+function process(parts, metadata)
+  local messages, meta = {}, {}
+  for word in string.gmatch(parts[1], "%S+") do
+    table.insert(messages, { word, "suffix" })
+    table.insert(meta, { { word = word } })
+  end
+  return messages, meta
+end`
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewLua(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _, propagate := proc.ProcessMessage(&types.Message{
+		Parts: [][]byte{[]byte("foo bar baz")},
+	})
+	if !propagate {
+		t.Fatal("Message not propagated")
+	}
+	msgs := append([]*types.Message{msg}, proc.(Splitter).Split()...)
+	if exp, act := 3, len(msgs); exp != act {
+		t.Fatalf("Wrong count of messages: %v != %v", act, exp)
+	}
+	for i, word := range []string{"foo", "bar", "baz"} {
+		exp := [][]byte{[]byte(word), []byte("suffix")}
+		if act := msgs[i].Parts; !reflect.DeepEqual(exp, act) {
+			t.Errorf("Wrong result for message %v: %s != %s", i, act, exp)
+		}
+		if act := msgs[i].GetMetadata(0, "word"); act != word {
+			t.Errorf("Wrong metadata for message %v: %v != %v", i, act, word)
+		}
+	}
+	if split := proc.(Splitter).Split(); len(split) > 0 {
+		t.Errorf("Split messages returned twice: %v", split)
+	}
+
+	// A single message does not split.
+	proc.ProcessMessage(&types.Message{Parts: [][]byte{[]byte("foo")}})
+	if split := proc.(Splitter).Split(); len(split) > 0 {
+		t.Errorf("Unexpected split messages: %v", split)
+	}
+}
+
+func TestLuaBadValues(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	for _, script := range []string{
+		`function process(parts, metadata) return { {} , "foo" } end`,
+		`function process(parts, metadata) return { true } end`,
+		`function process(parts, metadata) return { "foo", { "bar" } } end`,
+		`function process(parts, metadata) return { "foo" }, { { key = false } } end`,
+	} {
+		conf := NewConfig()
+		conf.Lua.Script = script
+		proc, err := NewLua(conf, testLog, metrics.DudType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, res, propagate := proc.ProcessMessage(&types.Message{
+			Parts: [][]byte{[]byte("foo")},
+		})
+		if propagate {
+			t.Errorf("Expected message to be rejected: %v", script)
+		} else if res.Error() == nil {
+			t.Errorf("Expected error response: %v", script)
+		}
+	}
+
+	conf := NewConfig()
+	conf.Lua.Script = `function process(parts, metadata) return { 10 } end`
+	proc, err := NewLua(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, _, propagate := proc.ProcessMessage(&types.Message{
+		Parts: [][]byte{[]byte("foo")},
+	})
+	if !propagate {
+		t.Fatal("Message not propagated")
+	}
+	if exp, act := "10", string(msg.Parts[0]); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+func TestLuaDrop(t *testing.T) {
+	conf := NewConfig()
+	conf.Lua.Script = `
+function process(parts)
+  if parts[1] == "drop" then
+    return nil
+  end
+  if parts[1] == "error" then
+    error("bad message")
+  end
+  return parts
+end`
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewLua(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, res, propagate := proc.ProcessMessage(&types.Message{
+		Parts: [][]byte{[]byte("drop")},
+	})
+	if propagate {
+		t.Error("Message not dropped")
+	} else if res.Error() != nil {
+		t.Errorf("Expected nil error response: %v", res.Error())
+	}
+
+	_, res, propagate = proc.ProcessMessage(&types.Message{
+		Parts: [][]byte{[]byte("error")},
+	})
+	if propagate {
+		t.Error("Message not rejected")
+	} else if res.Error() == nil {
+		t.Error("Expected error response from failed script")
+	}
+
+	msg, _, propagate := proc.ProcessMessage(&types.Message{
+		Parts: [][]byte{[]byte("keep")},
+	})
+	if !propagate {
+		t.Fatal("Message not propagated")
+	}
+	if exp, act := "keep", string(msg.Parts[0]); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+func TestLuaOnErrorDrop(t *testing.T) {
+	conf := NewConfig()
+	conf.Lua.OnError = "drop"
+	conf.Lua.Script = `
+function process(parts)
+  error("bad message")
+end`
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewLua(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, res, propagate := proc.ProcessMessage(&types.Message{
+		Parts: [][]byte{[]byte("hello")},
+	})
+	if propagate {
+		t.Error("Message not dropped")
+	} else if res.Error() != nil {
+		t.Errorf("Expected nil error response: %v", res.Error())
+	}
+
+	conf.Lua.OnError = "nope"
+	if _, err = NewLua(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad on_error")
+	}
+}
+
+func TestLuaClose(t *testing.T) {
+	conf := NewConfig()
+	conf.Lua.Script = "function process(parts) return parts end"
+	conf.Lua.VMPoolSize = 2
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewLua(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := proc.(*Lua)
+	vm := <-l.vms
+	l.CloseAsync()
+	l.CloseAsync()
+
+	if exp, act := 0, len(l.vms); exp != act {
+		t.Errorf("Wrong count of pooled VMs: %v != %v", act, exp)
+	}
+	l.release(vm)
+	if exp, act := 0, len(l.vms); exp != act {
+		t.Errorf("Wrong count of pooled VMs: %v != %v", act, exp)
+	}
+
+	_, res, propagate := proc.ProcessMessage(&types.Message{
+		Parts: [][]byte{[]byte("hello")},
+	})
+	if propagate {
+		t.Error("Message propagated after close")
+	} else if res.Error() != types.ErrTypeClosed {
+		t.Errorf("Wrong error response: %v != %v", res.Error(), types.ErrTypeClosed)
+	}
+}
+
+func TestLuaTimeout(t *testing.T) {
+	conf := NewConfig()
+	conf.Lua.TimeoutMS = 10
+	conf.Lua.Script = `
+function process(parts)
+  if parts[1] == "loop" then
+    while true do end
+  end
+  return parts
+end`
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewLua(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, res, propagate := proc.ProcessMessage(&types.Message{
+		Parts: [][]byte{[]byte("loop")},
+	}); propagate {
+		t.Error("Expected message to be rejected from timeout")
+	} else if res.Error() == nil {
+		t.Error("Expected error response from timeout")
+	}
+
+	// The VM must still be usable after a timeout.
+	if _, _, propagate := proc.ProcessMessage(&types.Message{
+		Parts: [][]byte{[]byte("hello")},
+	}); !propagate {
+		t.Error("Message not propagated after timeout")
+	}
+}
+
+func TestLuaFileAndPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_lua_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "script.lua")
+	script := `
+function transform(parts)
+  return { parts[1] .. "!" }
+end`
+	if err = ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewConfig()
+	conf.Lua.File = path
+	conf.Lua.Function = "transform"
+	conf.Lua.VMPoolSize = 4
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewLua(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg, _, propagate := proc.ProcessMessage(&types.Message{
+				Parts: [][]byte{[]byte("hello")},
+			})
+			if !propagate {
+				t.Error("Message not propagated")
+			} else if exp, act := "hello!", string(msg.Parts[0]); exp != act {
+				t.Errorf("Wrong result: %v != %v", act, exp)
+			}
+		}()
+	}
+	wg.Wait()
+}
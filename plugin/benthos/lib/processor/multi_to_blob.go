@@ -40,7 +40,11 @@ You can instead use this processor to encode multiple part messages into a
 binary single part message, which can be converted back further down the
 platform pipeline using the blob to multi processor.
 
-E.g. ZMQ => Benthos(multi to blob) => Kafka => Benthos(blob to multi)`,
+E.g. ZMQ => Benthos(multi to blob) => Kafka => Benthos(blob to multi)
+
+The metadata of each part is encoded within the blob and restored by the blob
+to multi processor. The resulting part also adopts the metadata of the first
+part so that it remains available to outputs.`,
 	}
 }
 
@@ -68,6 +72,9 @@ func NewMultiToBlob(conf Config, log log.Modular, stats metrics.Type) (Type, err
 func (m MultiToBlob) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
 	newMsg := types.NewMessage()
 	newMsg.Parts = append(newMsg.Parts, msg.Bytes())
+	for k, v := range msg.PartMetadata(0) {
+		newMsg.SetMetadata(0, k, v)
+	}
 	m.stats.Incr("processor.multi_to_blob.count", 1)
 	return &newMsg, nil, true
 }
@@ -54,3 +54,33 @@ func TestMultiToBlob(t *testing.T) {
 		t.Error("Failed on good message")
 	}
 }
+
+func TestMultiToBlobMetadata(t *testing.T) {
+	conf := NewConfig()
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewMultiToBlob(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testMsg := types.Message{Parts: [][]byte{[]byte("hello"), []byte("world")}}
+	testMsg.SetMetadata(0, "foo", "bar")
+	testMsg.SetMetadata(1, "foo", "baz")
+
+	res, _, ok := proc.ProcessMessage(&testMsg)
+	if !ok {
+		t.Fatal("Failed on good message")
+	}
+	if exp, act := "bar", res.GetMetadata(0, "foo"); exp != act {
+		t.Errorf("Wrong metadata value: %v != %v", act, exp)
+	}
+
+	decoded, err := types.FromBytes(res.Parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := testMsg.Metadata, decoded.Metadata; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong encoded metadata: %v != %v", act, exp)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["rate_limit"] = typeSpec{
+		constructor: NewRateLimit,
+		description: `
+Limits the rate of messages flowing through a pipeline using token buckets, one
+for the number of messages and one for the total bytes of all message parts.
+Either limit can be disabled by setting it to zero.
+
+Each bucket holds up to a burst of tokens, which defaults to one second worth of
+the limit when set to zero. When a message exceeds the available tokens the
+processor blocks until enough tokens have been refilled, which applies back
+pressure to the input rather than dropping messages. A message larger than the
+burst size is still let through once the bucket would have refilled by its size.
+
+Processors with the same non-empty 'name' share the same buckets, regardless of
+where they are configured, which allows a single limit to be applied across all
+outputs of a broker. Shared buckets exist for as long as a processor using them
+is running, and are released once all of those processors have closed, such as
+when the streams using them are stopped or updated. Processors that share a
+bucket must have the same limits, a processor created with different limits
+whilst the bucket exists uses the limits of the existing bucket and logs a
+warning.
+
+When a pipeline is closed any processor waiting for tokens stops waiting
+immediately and rejects its message, which is sent again by the input, and the
+tokens reserved for that message are returned to the buckets. A pipeline that
+also contains processors that flush state when closed, such as 'window',
+continues to apply the rate limit to the messages it flushes until it stops.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// RateLimitConfig contains any configuration for the RateLimit processor.
+type RateLimitConfig struct {
+	Name              string  `json:"name" yaml:"name"`
+	MessagesPerSecond float64 `json:"messages_per_second" yaml:"messages_per_second"`
+	MessagesBurst     int     `json:"messages_burst" yaml:"messages_burst"`
+	BytesPerSecond    float64 `json:"bytes_per_second" yaml:"bytes_per_second"`
+	BytesBurst        int     `json:"bytes_burst" yaml:"bytes_burst"`
+}
+
+// NewRateLimitConfig returns a RateLimitConfig with default values.
+func NewRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Name:              "",
+		MessagesPerSecond: 1000,
+		MessagesBurst:     0,
+		BytesPerSecond:    0,
+		BytesBurst:        0,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// tokenBucket is a token bucket that allows tokens to be reserved in advance of
+// being available, in which case the bucket goes into debt and the caller must
+// wait until it would have been refilled.
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	capacity := float64(burst)
+	if capacity <= 0 {
+		capacity = rate
+	}
+	return &tokenBucket{
+		rate:     rate,
+		capacity: capacity,
+		tokens:   capacity,
+		last:     now,
+	}
+}
+
+// reserve takes n tokens from the bucket and returns the duration to wait
+// before they are available.
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.last = now
+	}
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund returns n tokens reserved from the bucket.
+func (b *tokenBucket) refund(n float64) {
+	if b.tokens += n; b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// rateLimiter combines a message count and byte size token bucket, either of
+// which can be nil when disabled.
+type rateLimiter struct {
+	mut   sync.Mutex
+	msgs  *tokenBucket
+	bytes *tokenBucket
+}
+
+func newRateLimiter(conf RateLimitConfig) *rateLimiter {
+	now := time.Now()
+	r := &rateLimiter{}
+	if conf.MessagesPerSecond > 0 {
+		r.msgs = newTokenBucket(conf.MessagesPerSecond, conf.MessagesBurst, now)
+	}
+	if conf.BytesPerSecond > 0 {
+		r.bytes = newTokenBucket(conf.BytesPerSecond, conf.BytesBurst, now)
+	}
+	return r
+}
+
+// reserve reserves tokens for a message of a byte size and returns the
+// duration to wait before the message may proceed.
+func (r *rateLimiter) reserve(size int, now time.Time) time.Duration {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var wait time.Duration
+	if r.msgs != nil {
+		wait = r.msgs.reserve(1, now)
+	}
+	if r.bytes != nil {
+		if bytesWait := r.bytes.reserve(float64(size), now); bytesWait > wait {
+			wait = bytesWait
+		}
+	}
+	return wait
+}
+
+// refund returns the tokens reserved for a message of a byte size that did not
+// proceed.
+func (r *rateLimiter) refund(size int) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.msgs != nil {
+		r.msgs.refund(1)
+	}
+	if r.bytes != nil {
+		r.bytes.refund(float64(size))
+	}
+}
+
+// sharedRateLimiter is a rate limiter shared by name between processors, along
+// with the config it was created with and a count of the processors using it.
+type sharedRateLimiter struct {
+	limiter *rateLimiter
+	conf    RateLimitConfig
+	refs    int
+}
+
+var (
+	sharedRateLimiters    = map[string]*sharedRateLimiter{}
+	sharedRateLimitersMut sync.Mutex
+)
+
+// acquireSharedRateLimiter returns the rate limiter of a name, creating it if
+// it does not yet exist, and a bool flag that is false if the existing limiter
+// was created with a different config. The limiter must be released with
+// releaseSharedRateLimiter once it is no longer used.
+func acquireSharedRateLimiter(conf RateLimitConfig) (*rateLimiter, bool) {
+	sharedRateLimitersMut.Lock()
+	defer sharedRateLimitersMut.Unlock()
+
+	if s, exists := sharedRateLimiters[conf.Name]; exists {
+		s.refs++
+		return s.limiter, s.conf == conf
+	}
+	s := &sharedRateLimiter{
+		limiter: newRateLimiter(conf),
+		conf:    conf,
+		refs:    1,
+	}
+	sharedRateLimiters[conf.Name] = s
+	return s.limiter, true
+}
+
+// releaseSharedRateLimiter releases a rate limiter acquired by name, which is
+// removed once it has no remaining users.
+func releaseSharedRateLimiter(name string) {
+	sharedRateLimitersMut.Lock()
+	defer sharedRateLimitersMut.Unlock()
+
+	if s, exists := sharedRateLimiters[name]; exists {
+		if s.refs--; s.refs <= 0 {
+			delete(sharedRateLimiters, name)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// RateLimit is a processor that limits the rate of messages.
+type RateLimit struct {
+	conf  Config
+	log   log.Modular
+	stats metrics.Type
+
+	limiter *rateLimiter
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+// NewRateLimit returns a RateLimit processor.
+func NewRateLimit(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	rConf := conf.RateLimit
+	if rConf.MessagesPerSecond < 0 || rConf.BytesPerSecond < 0 {
+		return nil, errors.New("rate limits cannot be negative")
+	}
+	if rConf.MessagesPerSecond == 0 && rConf.BytesPerSecond == 0 {
+		return nil, errors.New("at least one of messages_per_second or bytes_per_second must be set")
+	}
+
+	r := &RateLimit{
+		conf:      conf,
+		log:       log.NewModule(".processor.rate_limit"),
+		stats:     stats,
+		closeChan: make(chan struct{}),
+	}
+	if len(rConf.Name) > 0 {
+		var matches bool
+		if r.limiter, matches = acquireSharedRateLimiter(rConf); !matches {
+			r.log.Warnf(
+				"Rate limit '%v' is already in use with different limits, the existing limits are used.\n",
+				rConf.Name,
+			)
+		}
+	} else {
+		r.limiter = newRateLimiter(rConf)
+	}
+	return r, nil
+}
+
+//------------------------------------------------------------------------------
+
+// ProcessMessage blocks until the message is within the rate limit.
+func (r *RateLimit) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	r.stats.Incr("processor.rate_limit.count", 1)
+
+	size := 0
+	for _, part := range msg.Parts {
+		size += len(part)
+	}
+
+	if wait := r.limiter.reserve(size, time.Now()); wait > 0 {
+		r.stats.Incr("processor.rate_limit.throttled", 1)
+		r.stats.Timing("processor.rate_limit.wait", int64(wait))
+		select {
+		case <-time.After(wait):
+		case <-r.closeChan:
+			r.limiter.refund(size)
+			r.stats.Incr("processor.rate_limit.closed", 1)
+			return nil, types.NewSimpleResponse(types.ErrTypeClosed), false
+		}
+	}
+	return msg, nil, true
+}
+
+// CloseAsync stops any calls to ProcessMessage that are waiting for tokens and
+// releases the shared rate limiter of the processor.
+func (r *RateLimit) CloseAsync() {
+	r.closeOnce.Do(func() {
+		close(r.closeChan)
+		if len(r.conf.RateLimit.Name) > 0 {
+			releaseSharedRateLimiter(r.conf.RateLimit.Name)
+		}
+	})
+}
+
+//------------------------------------------------------------------------------
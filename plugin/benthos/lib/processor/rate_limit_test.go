@@ -0,0 +1,211 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTokenBucket(10, 2, now)
+
+	for i := 0; i < 2; i++ {
+		if exp, act := time.Duration(0), b.reserve(1, now); exp != act {
+			t.Errorf("Wrong wait for burst token %v: %v != %v", i, act, exp)
+		}
+	}
+	if exp, act := time.Millisecond*100, b.reserve(1, now); exp != act {
+		t.Errorf("Wrong wait: %v != %v", act, exp)
+	}
+	if exp, act := time.Millisecond*200, b.reserve(1, now); exp != act {
+		t.Errorf("Wrong wait: %v != %v", act, exp)
+	}
+
+	now = now.Add(time.Second)
+	if exp, act := time.Duration(0), b.reserve(2, now); exp != act {
+		t.Errorf("Wrong wait after refill: %v != %v", act, exp)
+	}
+
+	now = now.Add(time.Hour)
+	if exp, act := time.Millisecond*300, b.reserve(5, now); exp != act {
+		t.Errorf("Wrong wait for oversized reservation: %v != %v", act, exp)
+	}
+}
+
+func TestRateLimiterBytes(t *testing.T) {
+	conf := NewRateLimitConfig()
+	conf.MessagesPerSecond = 0
+	conf.BytesPerSecond = 100
+
+	now := time.Unix(0, 0)
+	r := newRateLimiter(conf)
+	r.bytes.last = now
+
+	if exp, act := time.Duration(0), r.reserve(100, now); exp != act {
+		t.Errorf("Wrong wait: %v != %v", act, exp)
+	}
+	if exp, act := time.Millisecond*500, r.reserve(50, now); exp != act {
+		t.Errorf("Wrong wait: %v != %v", act, exp)
+	}
+}
+
+func TestRateLimitBadConfig(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.RateLimit.MessagesPerSecond = 0
+	if _, err := NewRateLimit(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from no limits")
+	}
+
+	conf.RateLimit.MessagesPerSecond = -1
+	if _, err := NewRateLimit(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from negative limit")
+	}
+}
+
+func TestRateLimitBlocks(t *testing.T) {
+	conf := NewConfig()
+	conf.RateLimit.MessagesPerSecond = 100
+	conf.RateLimit.MessagesBurst = 1
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewRateLimit(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		msgIn := types.NewMessage()
+		msgOut, res, propagate := proc.ProcessMessage(&msgIn)
+		if !propagate {
+			t.Fatal("Message was not propagated")
+		}
+		if res != nil {
+			t.Errorf("Unexpected response: %v", res)
+		}
+		if msgOut != &msgIn {
+			t.Error("Wrong message returned")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*45 {
+		t.Errorf("Messages were not throttled, took: %v", elapsed)
+	}
+}
+
+func TestRateLimitShared(t *testing.T) {
+	conf := NewConfig()
+	conf.RateLimit.Name = "test_rate_limit_shared"
+	conf.RateLimit.MessagesPerSecond = 10
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	procOne, err := NewRateLimit(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf.RateLimit.MessagesPerSecond = 1000
+	procTwo, err := NewRateLimit(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf.RateLimit.Name = ""
+	procThree, err := NewRateLimit(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if procOne.(*RateLimit).limiter != procTwo.(*RateLimit).limiter {
+		t.Error("Named rate limits do not share a limiter")
+	}
+	if procOne.(*RateLimit).limiter == procThree.(*RateLimit).limiter {
+		t.Error("Unnamed rate limit shares a limiter")
+	}
+	if exp, act := float64(10), procTwo.(*RateLimit).limiter.msgs.rate; exp != act {
+		t.Errorf("Wrong shared rate: %v != %v", act, exp)
+	}
+
+	procOne.(Closer).CloseAsync()
+	procOne.(Closer).CloseAsync()
+	if _, exists := sharedRateLimiters["test_rate_limit_shared"]; !exists {
+		t.Error("Shared limiter released whilst in use")
+	}
+	procTwo.(Closer).CloseAsync()
+	if _, exists := sharedRateLimiters["test_rate_limit_shared"]; exists {
+		t.Error("Shared limiter not released")
+	}
+
+	// Once released a new config takes effect.
+	conf.RateLimit.Name = "test_rate_limit_shared"
+	procFour, err := NewRateLimit(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer procFour.(Closer).CloseAsync()
+	if exp, act := float64(1000), procFour.(*RateLimit).limiter.msgs.rate; exp != act {
+		t.Errorf("Wrong shared rate: %v != %v", act, exp)
+	}
+}
+
+func TestRateLimitClose(t *testing.T) {
+	conf := NewConfig()
+	conf.RateLimit.MessagesPerSecond = 0.1
+	conf.RateLimit.MessagesBurst = 1
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewRateLimit(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := types.NewMessage()
+	if _, _, propagate := proc.ProcessMessage(&msg); !propagate {
+		t.Fatal("Message was not propagated")
+	}
+
+	go func() {
+		<-time.After(time.Millisecond * 10)
+		proc.(Closer).CloseAsync()
+	}()
+
+	start := time.Now()
+	_, res, propagate := proc.ProcessMessage(&msg)
+	if propagate {
+		t.Error("Message propagated after close")
+	} else if res.Error() != types.ErrTypeClosed {
+		t.Errorf("Wrong error response: %v != %v", res.Error(), types.ErrTypeClosed)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close did not interrupt wait, took: %v", elapsed)
+	}
+	if tokens := proc.(*RateLimit).limiter.msgs.tokens; tokens < -0.5 {
+		t.Errorf("Tokens of interrupted wait were not refunded: %v", tokens)
+	}
+}
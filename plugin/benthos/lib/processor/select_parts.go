@@ -89,6 +89,9 @@ func (m *SelectParts) ProcessMessage(msg *types.Message) (*types.Message, types.
 		if index < lParts {
 			m.stats.Incr("processor.select_parts.selected", 1)
 			newMsg.Parts = append(newMsg.Parts, msg.Parts[index])
+			for k, v := range msg.PartMetadata(index) {
+				newMsg.SetMetadata(len(newMsg.Parts)-1, k, v)
+			}
 		} else {
 			m.stats.Incr("processor.select_parts.skipped", 1)
 		}
@@ -114,3 +114,34 @@ func TestSelectPartsEmpty(t *testing.T) {
 		t.Error("Expected failure with zero parts selected")
 	}
 }
+
+func TestSelectPartsMetadata(t *testing.T) {
+	conf := NewConfig()
+	conf.SelectParts.Parts = []int{2, 0}
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewSelectParts(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	inMsg := types.Message{Parts: [][]byte{
+		[]byte("0"),
+		[]byte("1"),
+		[]byte("2"),
+	}}
+	inMsg.SetMetadata(0, "foo", "from 0")
+	inMsg.SetMetadata(2, "foo", "from 2")
+
+	msg, _, check := proc.ProcessMessage(&inMsg)
+	if !check {
+		t.Fatal("Select Parts failed")
+	}
+	if exp, act := "from 2", msg.GetMetadata(0, "foo"); exp != act {
+		t.Errorf("Wrong metadata: %v != %v", act, exp)
+	}
+	if exp, act := "from 0", msg.GetMetadata(1, "foo"); exp != act {
+		t.Errorf("Wrong metadata: %v != %v", act, exp)
+	}
+}
@@ -21,6 +21,8 @@
 package processor
 
 import (
+	"time"
+
 	"github.com/Jeffail/benthos/lib/types"
 )
 
@@ -32,8 +34,69 @@ type Type interface {
 	// ProcessMessage attempts to process a message. Since processing can fail
 	// this call returns both a message in case of success, a response in case
 	// of failure, and a bool flag indicating (true == success) which of the two
-	// should be used.
+	// should be used. A response that skips acknowledgement can also be
+	// returned along with a message, in which case the message is propagated
+	// but the input message is not acknowledged once it is delivered.
 	ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool)
 }
 
 //------------------------------------------------------------------------------
+
+// Flusher is an optional interface implemented by processors that hold state
+// across messages. When a pipeline is closed, or its input is closed, any
+// remaining state is flushed as a final message.
+type Flusher interface {
+	// Flush returns a message containing any state held by the processor and a
+	// bool flag indicating whether that message should be propagated.
+	Flush() (*types.Message, bool)
+}
+
+//------------------------------------------------------------------------------
+
+// Ticker is an optional interface implemented by processors that hold state
+// which expires with the passing of time. A pipeline ticks its processors
+// periodically, which allows them to emit state without waiting for another
+// message.
+type Ticker interface {
+	// Tick returns a message containing any state of the processor that has
+	// expired at a time and a bool flag indicating whether that message should
+	// be propagated.
+	Tick(now time.Time) (*types.Message, bool)
+}
+
+//------------------------------------------------------------------------------
+
+// Acknowledger is an optional interface implemented by processors that depend
+// on whether the messages they process are delivered. Once a message has been
+// processed a pipeline calls each processor that received it with the response
+// that is sent upstream for that message.
+type Acknowledger interface {
+	// Acknowledge is called with the response to the last message processed.
+	Acknowledge(res types.Response)
+}
+
+//------------------------------------------------------------------------------
+
+// Splitter is an optional interface implemented by processors that can split a
+// message into several messages. When ProcessMessage returns a message to
+// propagate a pipeline calls Split for any further messages split from the same
+// message, which are propagated in order after it. The message processed is
+// only acknowledged once all of the messages split from it are delivered.
+type Splitter interface {
+	// Split returns the messages that follow the message returned by the last
+	// call to ProcessMessage.
+	Split() []*types.Message
+}
+
+//------------------------------------------------------------------------------
+
+// Closer is an optional interface implemented by processors that hold
+// resources or that can block while processing a message. A pipeline closes its
+// processors once it stops processing messages.
+type Closer interface {
+	// CloseAsync releases any resources held by the processor and unblocks any
+	// call to ProcessMessage in progress.
+	CloseAsync()
+}
+
+//------------------------------------------------------------------------------
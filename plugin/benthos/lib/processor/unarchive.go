@@ -0,0 +1,249 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["unarchive"] = typeSpec{
+		constructor: NewUnarchive,
+		description: `
+Unarchives parts of a message into multiple parts using the selected format.
+Supported archive formats are: tar, zip, json_array, lines, binary.
+
+Each part of a message is unarchived and the resulting parts are combined into a
+single message. For the tar and zip formats each file within the archive becomes
+a part, and the file name is set as the metadata key 'name_metadata_key' of the
+part. For the json_array format each element of the array becomes a part, and
+for the lines format each line becomes a part.
+
+Each resulting part inherits the metadata of the part it was unarchived from.
+For the binary format the metadata encoded within the archive is also restored,
+and takes precedence over inherited metadata.
+
+In order to guard against archive bombs a part of the tar or zip formats is not
+unarchived when the total size of its files would exceed 'max_size' bytes.
+Messages that fail to be unarchived are dropped.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// UnarchiveConfig contains configuration for the Unarchive processor.
+type UnarchiveConfig struct {
+	Format          string `json:"format" yaml:"format"`
+	NameMetadataKey string `json:"name_metadata_key" yaml:"name_metadata_key"`
+	MaxSize         int    `json:"max_size" yaml:"max_size"`
+}
+
+// NewUnarchiveConfig returns a UnarchiveConfig with default values.
+func NewUnarchiveConfig() UnarchiveConfig {
+	return UnarchiveConfig{
+		Format:          "tar",
+		NameMetadataKey: "name",
+		MaxSize:         104857600, // 100MB
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// unarchiveFunc unarchives a part and returns the resulting message along with
+// the file names of its parts, which may be empty. Archive formats that compress
+// their files return an error if the total size of the files exceeds maxSize.
+type unarchiveFunc func(maxSize int, part []byte) (msg types.Message, names []string, err error)
+
+func tarUnarchive(maxSize int, part []byte) (types.Message, []string, error) {
+	tr := tar.NewReader(bytes.NewReader(part))
+
+	parts, names := [][]byte{}, []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return types.Message{}, nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		data, err := readLimited(maxSize, tr)
+		if err != nil {
+			return types.Message{}, nil, err
+		}
+		maxSize -= len(data)
+		parts = append(parts, data)
+		names = append(names, hdr.Name)
+	}
+	return types.Message{Parts: parts}, names, nil
+}
+
+func zipUnarchive(maxSize int, part []byte) (types.Message, []string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(part), int64(len(part)))
+	if err != nil {
+		return types.Message{}, nil, err
+	}
+
+	parts, names := [][]byte{}, []string{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return types.Message{}, nil, err
+		}
+		data, err := readLimited(maxSize, rc)
+		rc.Close()
+		if err != nil {
+			return types.Message{}, nil, err
+		}
+		maxSize -= len(data)
+		parts = append(parts, data)
+		names = append(names, f.Name)
+	}
+	return types.Message{Parts: parts}, names, nil
+}
+
+func jsonArrayUnarchive(maxSize int, part []byte) (types.Message, []string, error) {
+	var docs []json.RawMessage
+	if err := json.Unmarshal(part, &docs); err != nil {
+		return types.Message{}, nil, err
+	}
+	parts := make([][]byte, len(docs))
+	for i, doc := range docs {
+		parts[i] = []byte(doc)
+	}
+	return types.Message{Parts: parts}, nil, nil
+}
+
+func linesUnarchive(maxSize int, part []byte) (types.Message, []string, error) {
+	if len(part) == 0 {
+		return types.NewMessage(), nil, nil
+	}
+	return types.Message{
+		Parts: bytes.Split(bytes.TrimSuffix(part, []byte("\n")), []byte("\n")),
+	}, nil, nil
+}
+
+func binaryUnarchive(maxSize int, part []byte) (types.Message, []string, error) {
+	msg, err := types.FromBytes(part)
+	return msg, nil, err
+}
+
+func strToUnarchiver(str string) (unarchiveFunc, error) {
+	switch str {
+	case "tar":
+		return tarUnarchive, nil
+	case "zip":
+		return zipUnarchive, nil
+	case "json_array":
+		return jsonArrayUnarchive, nil
+	case "lines":
+		return linesUnarchive, nil
+	case "binary":
+		return binaryUnarchive, nil
+	}
+	return nil, fmt.Errorf("archive format not recognised: %v", str)
+}
+
+//------------------------------------------------------------------------------
+
+// Unarchive is a processor that unarchives parts of a message into multiple
+// parts.
+type Unarchive struct {
+	conf  UnarchiveConfig
+	log   log.Modular
+	stats metrics.Type
+
+	unarchive unarchiveFunc
+}
+
+// NewUnarchive returns a Unarchive processor.
+func NewUnarchive(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	unarchiver, err := strToUnarchiver(conf.Unarchive.Format)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Unarchive.MaxSize <= 0 {
+		return nil, errors.New("max_size must be greater than zero")
+	}
+	return &Unarchive{
+		conf:      conf.Unarchive,
+		log:       log.NewModule(".processor.unarchive"),
+		stats:     stats,
+		unarchive: unarchiver,
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// ProcessMessage unarchives each part of a message into multiple parts.
+func (u *Unarchive) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	u.stats.Incr("processor.unarchive.count", 1)
+
+	newMsg := types.NewMessage()
+	for i, part := range msg.Parts {
+		unarchived, names, err := u.unarchive(u.conf.MaxSize, part)
+		if err != nil {
+			u.stats.Incr("processor.unarchive.dropped", 1)
+			u.log.Errorf("Failed to unarchive message part: %v\n", err)
+			return nil, types.NewSimpleResponse(nil), false
+		}
+		for j, p := range unarchived.Parts {
+			newMsg.Parts = append(newMsg.Parts, p)
+			index := len(newMsg.Parts) - 1
+			for k, v := range msg.PartMetadata(i) {
+				newMsg.SetMetadata(index, k, v)
+			}
+			for k, v := range unarchived.PartMetadata(j) {
+				newMsg.SetMetadata(index, k, v)
+			}
+			if j < len(names) {
+				newMsg.SetMetadata(index, u.conf.NameMetadataKey, names[j])
+			}
+		}
+	}
+
+	if len(newMsg.Parts) == 0 {
+		u.stats.Incr("processor.unarchive.dropped", 1)
+		return nil, types.NewSimpleResponse(nil), false
+	}
+
+	u.stats.Incr("processor.unarchive.success", 1)
+	return &newMsg, nil, true
+}
+
+//------------------------------------------------------------------------------
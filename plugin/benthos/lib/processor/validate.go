@@ -0,0 +1,320 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/linkedin/goavro"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["validate"] = typeSpec{
+		constructor: NewValidate,
+		description: `
+Validates message parts against a schema, which is either set inline with
+'schema' or loaded from the file at 'schema_path'. The 'schema_type' can be
+either 'json_schema' or 'avro'. The parts to validate are listed by their index
+in 'parts', and when empty all parts of a message are validated.
+
+Avro parts are decoded according to 'avro_input_format', which is either 'json'
+or 'binary'. Valid parts can then be converted by setting 'avro_output_format'
+to 'json' or 'binary', which allows Avro encoded topics to be consumed and
+produced as JSON. Avro JSON uses the standard Avro JSON encoding, where union
+values are wrapped in an object keyed by their type.
+
+The 'on_failure' field determines what happens to a message with invalid parts:
+
+'error_part' (the default) adds a new part to the beginning of the message
+containing a JSON object with a 'validation_errors' field, which lists the index
+and error of each invalid part. These messages can then be routed to a separate
+output with the 'switch' output and a 'json_field' condition.
+
+'drop' acknowledges and drops the message.
+
+'reject' returns the validation error as the response to the message. Most
+inputs will attempt to deliver a rejected message again, and since an invalid
+message fails validation every time this blocks the input until the schema or
+the data is fixed.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// ValidateConfig contains configuration for the Validate processor.
+type ValidateConfig struct {
+	Parts            []int  `json:"parts" yaml:"parts"`
+	SchemaType       string `json:"schema_type" yaml:"schema_type"`
+	Schema           string `json:"schema" yaml:"schema"`
+	SchemaPath       string `json:"schema_path" yaml:"schema_path"`
+	AvroInputFormat  string `json:"avro_input_format" yaml:"avro_input_format"`
+	AvroOutputFormat string `json:"avro_output_format" yaml:"avro_output_format"`
+	OnFailure        string `json:"on_failure" yaml:"on_failure"`
+}
+
+// NewValidateConfig returns a ValidateConfig with default values.
+func NewValidateConfig() ValidateConfig {
+	return ValidateConfig{
+		Parts:            []int{},
+		SchemaType:       "json_schema",
+		Schema:           "",
+		SchemaPath:       "",
+		AvroInputFormat:  "json",
+		AvroOutputFormat: "",
+		OnFailure:        "error_part",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// validatorFunc validates a message part and returns the part to replace it
+// with, which may be converted into a different format.
+type validatorFunc func(part []byte) ([]byte, error)
+
+func newJSONSchemaValidator(schema string) (validatorFunc, error) {
+	s, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON schema: %v", err)
+	}
+	return func(part []byte) ([]byte, error) {
+		result, err := s.Validate(gojsonschema.NewBytesLoader(part))
+		if err != nil {
+			return nil, err
+		}
+		if !result.Valid() {
+			errs := []string{}
+			for _, desc := range result.Errors() {
+				errs = append(errs, desc.String())
+			}
+			return nil, errors.New(strings.Join(errs, ", "))
+		}
+		return part, nil
+	}, nil
+}
+
+func newAvroValidator(schema, inputFormat, outputFormat string) (validatorFunc, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse avro schema: %v", err)
+	}
+
+	var decode func(part []byte) (interface{}, error)
+	switch inputFormat {
+	case "json":
+		decode = func(part []byte) (interface{}, error) {
+			native, remaining, err := codec.NativeFromTextual(part)
+			if err == nil && len(strings.TrimSpace(string(remaining))) > 0 {
+				err = errors.New("unexpected data after avro datum")
+			}
+			return native, err
+		}
+	case "binary":
+		decode = func(part []byte) (interface{}, error) {
+			native, remaining, err := codec.NativeFromBinary(part)
+			if err == nil && len(remaining) > 0 {
+				err = errors.New("unexpected data after avro datum")
+			}
+			return native, err
+		}
+	default:
+		return nil, fmt.Errorf("avro input format not recognised: %v", inputFormat)
+	}
+
+	var encode func(native interface{}) ([]byte, error)
+	switch outputFormat {
+	case "":
+	case "json":
+		encode = func(native interface{}) ([]byte, error) {
+			return codec.TextualFromNative(nil, native)
+		}
+	case "binary":
+		encode = func(native interface{}) ([]byte, error) {
+			return codec.BinaryFromNative(nil, native)
+		}
+	default:
+		return nil, fmt.Errorf("avro output format not recognised: %v", outputFormat)
+	}
+
+	return func(part []byte) ([]byte, error) {
+		native, err := decode(part)
+		if err != nil {
+			return nil, err
+		}
+		if encode == nil {
+			return part, nil
+		}
+		return encode(native)
+	}, nil
+}
+
+// partIndexes returns the indexes of a message to target, where an empty list
+// of configured indexes targets all parts.
+func partIndexes(indexes []int, msg *types.Message) []int {
+	if len(indexes) > 0 {
+		return indexes
+	}
+	all := make([]int, len(msg.Parts))
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// validateError is the validation error of a message part.
+type validateError struct {
+	Part  int    `json:"part"`
+	Error string `json:"error"`
+}
+
+// Validate is a processor that validates message parts against a schema.
+type Validate struct {
+	conf  Config
+	log   log.Modular
+	stats metrics.Type
+
+	validator validatorFunc
+}
+
+// NewValidate returns a Validate processor.
+func NewValidate(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	vConf := conf.Validate
+
+	schema := vConf.Schema
+	if len(vConf.SchemaPath) > 0 {
+		if len(schema) > 0 {
+			return nil, errors.New("schema and schema_path cannot both be set")
+		}
+		schemaBytes, err := ioutil.ReadFile(vConf.SchemaPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema file: %v", err)
+		}
+		schema = string(schemaBytes)
+	}
+	if len(schema) == 0 {
+		return nil, errors.New("a schema or schema_path must be set")
+	}
+
+	switch vConf.OnFailure {
+	case "reject", "drop", "error_part":
+	default:
+		return nil, fmt.Errorf("on_failure action not recognised: %v", vConf.OnFailure)
+	}
+
+	v := &Validate{
+		conf:  conf,
+		log:   log.NewModule(".processor.validate"),
+		stats: stats,
+	}
+
+	var err error
+	switch vConf.SchemaType {
+	case "json_schema":
+		v.validator, err = newJSONSchemaValidator(schema)
+	case "avro":
+		v.validator, err = newAvroValidator(
+			schema, vConf.AvroInputFormat, vConf.AvroOutputFormat,
+		)
+	default:
+		err = fmt.Errorf("schema type not recognised: %v", vConf.SchemaType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+//------------------------------------------------------------------------------
+
+// ProcessMessage validates message parts against the schema.
+func (v *Validate) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	v.stats.Incr("processor.validate.count", 1)
+
+	newMsg := types.Message{
+		Parts:    make([][]byte, len(msg.Parts)),
+		Metadata: msg.CopyMetadata(),
+	}
+	copy(newMsg.Parts, msg.Parts)
+
+	errs := []validateError{}
+	for _, index := range partIndexes(v.conf.Validate.Parts, msg) {
+		if index < 0 || index >= len(msg.Parts) {
+			v.stats.Incr("processor.validate.skipped", 1)
+			continue
+		}
+		result, err := v.validator(msg.Parts[index])
+		if err != nil {
+			errs = append(errs, validateError{Part: index, Error: err.Error()})
+			continue
+		}
+		newMsg.Parts[index] = result
+	}
+
+	if len(errs) == 0 {
+		v.stats.Incr("processor.validate.success", 1)
+		return &newMsg, nil, true
+	}
+
+	v.stats.Incr("processor.validate.failed", 1)
+	v.log.Debugf("Message failed validation: %v\n", errs)
+
+	switch v.conf.Validate.OnFailure {
+	case "drop":
+		v.stats.Incr("processor.validate.dropped", 1)
+		return nil, types.NewSimpleResponse(nil), false
+	case "error_part":
+		errPart, err := json.Marshal(map[string]interface{}{
+			"validation_errors": errs,
+		})
+		if err != nil {
+			v.stats.Incr("processor.validate.error.marshal", 1)
+			v.log.Errorf("Failed to marshal validation errors: %v\n", err)
+			return nil, types.NewSimpleResponse(err), false
+		}
+		errMsg := types.NewMessage()
+		errMsg.Parts = append([][]byte{errPart}, msg.Parts...)
+		for i := range msg.Parts {
+			for k, val := range msg.PartMetadata(i) {
+				errMsg.SetMetadata(i+1, k, val)
+			}
+		}
+		return &errMsg, nil, true
+	}
+
+	errStrs := []string{}
+	for _, e := range errs {
+		errStrs = append(errStrs, fmt.Sprintf("part %v: %v", e.Part, e.Error))
+	}
+	return nil, types.NewSimpleResponse(
+		fmt.Errorf("validation failed: %v", strings.Join(errStrs, "; ")),
+	), false
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,271 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+const testJSONSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string"},
+		"age": {"type": "integer", "minimum": 0}
+	},
+	"required": ["name"]
+}`
+
+const testAvroSchema = `{
+	"type": "record",
+	"name": "person",
+	"fields": [
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": "int"}
+	]
+}`
+
+func TestValidateBadConfig(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	tests := map[string]func(c *ValidateConfig){
+		"no schema": func(c *ValidateConfig) {},
+		"bad schema type": func(c *ValidateConfig) {
+			c.Schema = testJSONSchema
+			c.SchemaType = "nope"
+		},
+		"bad json schema": func(c *ValidateConfig) {
+			c.Schema = `{"type":5}`
+		},
+		"bad avro schema": func(c *ValidateConfig) {
+			c.SchemaType = "avro"
+			c.Schema = `{"type":"nope"}`
+		},
+		"bad avro format": func(c *ValidateConfig) {
+			c.SchemaType = "avro"
+			c.Schema = testAvroSchema
+			c.AvroOutputFormat = "nope"
+		},
+		"bad on failure": func(c *ValidateConfig) {
+			c.Schema = testJSONSchema
+			c.OnFailure = "nope"
+		},
+		"missing schema file": func(c *ValidateConfig) {
+			c.SchemaPath = "/does/not/exist.json"
+		},
+	}
+
+	for name, mod := range tests {
+		conf := NewConfig()
+		mod(&conf.Validate)
+		if _, err := NewValidate(conf, testLog, metrics.DudType{}); err == nil {
+			t.Errorf("Expected error from %v", name)
+		}
+	}
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_validate_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	schemaPath := filepath.Join(dir, "schema.json")
+	if err = ioutil.WriteFile(schemaPath, []byte(testJSONSchema), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewConfig()
+	conf.Validate.SchemaPath = schemaPath
+	conf.Validate.OnFailure = "reject"
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewValidate(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{
+		[]byte(`{"name":"foo","age":10}`),
+		[]byte(`{"name":"bar"}`),
+	}
+	msgOut, res, propagate := proc.ProcessMessage(&msg)
+	if !propagate {
+		t.Fatalf("Valid message was not propagated: %v", res.Error())
+	}
+	if !reflect.DeepEqual(msg.Parts, msgOut.Parts) {
+		t.Errorf("Wrong result: %s != %s", msgOut.Parts, msg.Parts)
+	}
+
+	msg.Parts = [][]byte{
+		[]byte(`{"name":"foo","age":10}`),
+		[]byte(`{"age":-1}`),
+	}
+	if _, res, propagate = proc.ProcessMessage(&msg); propagate {
+		t.Fatal("Invalid message was propagated")
+	}
+	if res.Error() == nil {
+		t.Fatal("Expected error response")
+	}
+	if errStr := res.Error().Error(); !strings.Contains(errStr, "part 1") {
+		t.Errorf("Wrong error: %v", errStr)
+	}
+}
+
+func TestValidateParts(t *testing.T) {
+	conf := NewConfig()
+	conf.Validate.Schema = testJSONSchema
+	conf.Validate.Parts = []int{1, 5}
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewValidate(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{
+		[]byte(`not json`),
+		[]byte(`{"name":"bar"}`),
+	}
+	if _, res, propagate := proc.ProcessMessage(&msg); !propagate {
+		t.Errorf("Valid message was not propagated: %v", res.Error())
+	}
+}
+
+func TestValidateOnFailure(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Validate.Schema = testJSONSchema
+	conf.Validate.OnFailure = "drop"
+
+	proc, err := NewValidate(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{
+		[]byte(`{"name":"foo"}`),
+		[]byte(`{"name":5}`),
+	}
+	msg.SetMetadata(1, "foo", "bar")
+
+	_, res, propagate := proc.ProcessMessage(&msg)
+	if propagate {
+		t.Error("Invalid message was propagated")
+	} else if res.Error() != nil {
+		t.Errorf("Expected dropped message to be acknowledged: %v", res.Error())
+	}
+
+	conf.Validate.OnFailure = "error_part"
+	if proc, err = NewValidate(conf, testLog, metrics.DudType{}); err != nil {
+		t.Fatal(err)
+	}
+
+	msgOut, _, propagate := proc.ProcessMessage(&msg)
+	if !propagate {
+		t.Fatal("Invalid message was not propagated with error part")
+	}
+	if exp, act := 3, len(msgOut.Parts); exp != act {
+		t.Fatalf("Wrong count of parts: %v != %v", act, exp)
+	}
+	expErr := `{"validation_errors":[{"part":1,"error":"name: Invalid type. Expected: string, given: integer"}]}`
+	if errPart := string(msgOut.Parts[0]); expErr != errPart {
+		t.Errorf("Wrong error part: %v != %v", errPart, expErr)
+	}
+	if !reflect.DeepEqual(msg.Parts, msgOut.Parts[1:]) {
+		t.Errorf("Wrong result: %s != %s", msgOut.Parts[1:], msg.Parts)
+	}
+	if exp, act := "bar", msgOut.GetMetadata(2, "foo"); exp != act {
+		t.Errorf("Wrong metadata: %v != %v", act, exp)
+	}
+}
+
+func TestValidateAvroConversion(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Validate.SchemaType = "avro"
+	conf.Validate.Schema = testAvroSchema
+	conf.Validate.AvroInputFormat = "json"
+	conf.Validate.AvroOutputFormat = "binary"
+	conf.Validate.OnFailure = "reject"
+
+	toBinary, err := NewValidate(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Validate.AvroInputFormat = "binary"
+	conf.Validate.AvroOutputFormat = "json"
+
+	toJSON, err := NewValidate(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{[]byte(`{"name":"foo","age":21}`)}
+
+	binMsg, res, propagate := toBinary.ProcessMessage(&msg)
+	if !propagate {
+		t.Fatalf("Valid message was not propagated: %v", res.Error())
+	}
+	if exp, act := []byte("\x06foo\x2a"), binMsg.Parts[0]; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong binary result: %v != %v", act, exp)
+	}
+
+	jsonMsg, res, propagate := toJSON.ProcessMessage(binMsg)
+	if !propagate {
+		t.Fatalf("Valid message was not propagated: %v", res.Error())
+	}
+	var act interface{}
+	if err = json.Unmarshal(jsonMsg.Parts[0], &act); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]interface{}{"name": "foo", "age": float64(21)}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong JSON result: %v != %v", act, exp)
+	}
+
+	msg.Parts = [][]byte{[]byte(`{"name":"foo","age":"21"}`)}
+	if _, _, propagate = toBinary.ProcessMessage(&msg); propagate {
+		t.Error("Invalid avro JSON was propagated")
+	}
+
+	msg.Parts = [][]byte{[]byte("\x06foo\x2a\x00")}
+	if _, _, propagate = toJSON.ProcessMessage(&msg); propagate {
+		t.Error("Avro binary with trailing data was propagated")
+	}
+}
@@ -0,0 +1,425 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/gabs"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["window"] = typeSpec{
+		constructor: NewWindow,
+		description: `
+Aggregates JSON messages over time windows and emits a summary of each window
+once it closes. Windows are either 'tumbling', where each window of 'size_ms'
+follows the last, or 'sliding', where a window of 'size_ms' begins every
+'slide_ms' and a message can therefore belong to several windows.
+
+Messages are grouped within each window by the value at 'key_path', or into a
+single group when the path is empty. The summary of each group is a JSON object
+containing the key, the window start and end as RFC3339 timestamps, the count
+of messages and, when 'value_path' is set, the sum, min and max of the numerical
+value at that path. The summary adopts the metadata of the first message
+aggregated into the group.
+
+The time of a message is taken from 'timestamp_path', which can either be a
+number of seconds since the unix epoch or an RFC3339 string, and otherwise is
+the time at which the message was processed. A window closes once a message
+arrives with a time at least 'grace_period_ms' beyond the end of the window.
+Messages that arrive for a window that has already closed are dropped as late
+data. All windows closed by a message are emitted as a single message with a
+part for each group, and all windows still open are emitted when the pipeline
+is closed.
+
+When 'timestamp_path' is empty windows also close with the passing of time,
+and are emitted once no messages have been processed for a short period.
+
+Messages are not acknowledged whilst any window is open, since inputs
+acknowledge messages cumulatively and an acknowledgement would include messages
+held within those windows. This includes a message that closes a window, which
+belongs to the windows that follow, and therefore the summary of a closed window
+is sent without acknowledging the message that closed it. Held messages are
+acknowledged by the response to the next message processed once no windows
+remain open, and when the pipeline is closed it flushes all windows before
+responding to the next message from its input, which acknowledges all messages
+held by the windows. This processor holds state in memory and therefore should
+not be used within a pipeline of more than one thread.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// WindowConfig contains configuration for the Window processor.
+type WindowConfig struct {
+	Type          string `json:"type" yaml:"type"`
+	Part          int    `json:"part" yaml:"part"`
+	SizeMS        int    `json:"size_ms" yaml:"size_ms"`
+	SlideMS       int    `json:"slide_ms" yaml:"slide_ms"`
+	GracePeriodMS int    `json:"grace_period_ms" yaml:"grace_period_ms"`
+	KeyPath       string `json:"key_path" yaml:"key_path"`
+	ValuePath     string `json:"value_path" yaml:"value_path"`
+	TimestampPath string `json:"timestamp_path" yaml:"timestamp_path"`
+}
+
+// NewWindowConfig returns a WindowConfig with default values.
+func NewWindowConfig() WindowConfig {
+	return WindowConfig{
+		Type:          "tumbling",
+		Part:          0,
+		SizeMS:        60000,
+		SlideMS:       10000,
+		GracePeriodMS: 0,
+		KeyPath:       "",
+		ValuePath:     "",
+		TimestampPath: "",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+var (
+	errWindowNoKey       = errors.New("key not found")
+	errWindowNoValue     = errors.New("value not found")
+	errWindowBadValue    = errors.New("value is not a number")
+	errWindowNoTimestamp = errors.New("timestamp not found")
+)
+
+// windowID identifies the aggregation of a group within a window.
+type windowID struct {
+	start int64
+	key   string
+}
+
+// windowAgg is the aggregated state of a group within a window, along with the
+// metadata of the first message aggregated.
+type windowAgg struct {
+	count int64
+	sum   float64
+	min   float64
+	max   float64
+	meta  map[string]string
+}
+
+//------------------------------------------------------------------------------
+
+// Window is a processor that aggregates messages over time windows.
+type Window struct {
+	conf  Config
+	log   log.Modular
+	stats metrics.Type
+
+	size  int64
+	slide int64
+	grace int64
+
+	watermark int64
+	windows   map[windowID]*windowAgg
+
+	// active is true when a message has been processed since the last tick.
+	active bool
+}
+
+// NewWindow returns a Window processor.
+func NewWindow(conf Config, log log.Modular, stats metrics.Type) (Type, error) {
+	wConf := conf.Window
+	if wConf.SizeMS <= 0 {
+		return nil, errors.New("size_ms must be greater than zero")
+	}
+	if wConf.GracePeriodMS < 0 {
+		return nil, errors.New("grace_period_ms cannot be negative")
+	}
+
+	w := &Window{
+		conf:    conf,
+		log:     log.NewModule(".processor.window"),
+		stats:   stats,
+		size:    int64(wConf.SizeMS),
+		grace:   int64(wConf.GracePeriodMS),
+		windows: map[windowID]*windowAgg{},
+	}
+
+	switch wConf.Type {
+	case "tumbling":
+		w.slide = w.size
+	case "sliding":
+		if wConf.SlideMS <= 0 || wConf.SlideMS > wConf.SizeMS {
+			return nil, errors.New("slide_ms must be greater than zero and no greater than size_ms")
+		}
+		w.slide = int64(wConf.SlideMS)
+	default:
+		return nil, fmt.Errorf("window type not recognised: %v", wConf.Type)
+	}
+	return w, nil
+}
+
+//------------------------------------------------------------------------------
+
+// parseTimestamp returns the time of a message in milliseconds since the unix
+// epoch.
+func parseTimestamp(v interface{}) (int64, error) {
+	switch t := v.(type) {
+	case float64:
+		return int64(t * 1000), nil
+	case string:
+		ts, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return 0, err
+		}
+		return ts.UnixNano() / int64(time.Millisecond), nil
+	case nil:
+		return 0, errWindowNoTimestamp
+	}
+	return 0, fmt.Errorf("timestamp type not recognised: %T", v)
+}
+
+// extract returns the group key, value and time of a message.
+func (w *Window) extract(msg *types.Message) (key string, value float64, ts int64, err error) {
+	wConf := w.conf.Window
+
+	index := wConf.Part
+	if index < 0 || index >= len(msg.Parts) {
+		err = errJSONPartOutOfBounds
+		return
+	}
+
+	var body *gabs.Container
+	if body, err = gabs.ParseJSON(msg.Parts[index]); err != nil {
+		return
+	}
+
+	if len(wConf.KeyPath) > 0 {
+		switch k := body.Path(wConf.KeyPath).Data().(type) {
+		case nil:
+			err = errWindowNoKey
+			return
+		case string:
+			key = k
+		default:
+			var keyBytes []byte
+			if keyBytes, err = json.Marshal(k); err != nil {
+				return
+			}
+			key = string(keyBytes)
+		}
+	}
+
+	if len(wConf.ValuePath) > 0 {
+		switch v := body.Path(wConf.ValuePath).Data().(type) {
+		case nil:
+			err = errWindowNoValue
+			return
+		case float64:
+			value = v
+		default:
+			err = errWindowBadValue
+			return
+		}
+	}
+
+	if len(wConf.TimestampPath) > 0 {
+		ts, err = parseTimestamp(body.Path(wConf.TimestampPath).Data())
+	} else {
+		ts = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	return
+}
+
+// floorDiv divides a by b rounding towards negative infinity.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// add aggregates a value into every window containing a time, returning false
+// if all of those windows have already closed. Windows created by the value
+// adopt a copy of its metadata.
+func (w *Window) add(key string, value float64, ts int64, meta map[string]string) bool {
+	added := false
+	for start := floorDiv(ts, w.slide) * w.slide; start+w.size > ts; start -= w.slide {
+		if start+w.size+w.grace <= w.watermark {
+			continue
+		}
+		id := windowID{start: start, key: key}
+		agg, exists := w.windows[id]
+		if !exists {
+			agg = &windowAgg{min: value, max: value}
+			if len(meta) > 0 {
+				agg.meta = make(map[string]string, len(meta))
+				for k, v := range meta {
+					agg.meta[k] = v
+				}
+			}
+			w.windows[id] = agg
+		}
+		agg.count++
+		agg.sum += value
+		if value < agg.min {
+			agg.min = value
+		}
+		if value > agg.max {
+			agg.max = value
+		}
+		added = true
+	}
+	return added
+}
+
+// emit removes all windows that end at or before a time and returns a message
+// containing their summaries, or nil if there are none.
+func (w *Window) emit(before int64) *types.Message {
+	ids := []windowID{}
+	for id := range w.windows {
+		if id.start+w.size <= before {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].start == ids[j].start {
+			return ids[i].key < ids[j].key
+		}
+		return ids[i].start < ids[j].start
+	})
+
+	msg := types.NewMessage()
+	for _, id := range ids {
+		agg := w.windows[id]
+		delete(w.windows, id)
+
+		summary := map[string]interface{}{
+			"start": time.Unix(0, id.start*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano),
+			"end":   time.Unix(0, (id.start+w.size)*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano),
+			"count": agg.count,
+		}
+		if len(w.conf.Window.KeyPath) > 0 {
+			summary["key"] = id.key
+		}
+		if len(w.conf.Window.ValuePath) > 0 {
+			summary["sum"] = agg.sum
+			summary["min"] = agg.min
+			summary["max"] = agg.max
+		}
+
+		part, err := json.Marshal(summary)
+		if err != nil {
+			w.stats.Incr("processor.window.error.marshal", 1)
+			w.log.Errorf("Failed to marshal window summary: %v\n", err)
+			continue
+		}
+		msg.Parts = append(msg.Parts, part)
+		for k, v := range agg.meta {
+			msg.SetMetadata(len(msg.Parts)-1, k, v)
+		}
+	}
+	w.stats.Incr("processor.window.emitted", int64(len(msg.Parts)))
+	if len(msg.Parts) == 0 {
+		return nil
+	}
+	return &msg
+}
+
+//------------------------------------------------------------------------------
+
+// ProcessMessage aggregates a message into its windows and returns a summary
+// of any windows that have closed.
+func (w *Window) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	w.stats.Incr("processor.window.count", 1)
+	w.active = true
+
+	key, value, ts, err := w.extract(msg)
+	if err != nil {
+		w.stats.Incr("processor.window.error.extract", 1)
+		w.log.Debugf("Failed to extract window fields from message: %v\n", err)
+		return nil, w.response(), false
+	}
+
+	if !w.add(key, value, ts, msg.PartMetadata(w.conf.Window.Part)) {
+		w.stats.Incr("processor.window.late", 1)
+	}
+	if ts > w.watermark {
+		w.watermark = ts
+	}
+
+	if summary := w.emit(w.watermark - w.grace); summary != nil {
+		if len(w.windows) > 0 {
+			return summary, types.NewUnacknowledgedResponse(), true
+		}
+		return summary, nil, true
+	}
+	return nil, w.response(), false
+}
+
+// response returns the response to a message that is not propagated, which
+// does not acknowledge it whilst windows that hold messages are open.
+func (w *Window) response() types.Response {
+	if len(w.windows) > 0 {
+		return types.NewUnacknowledgedResponse()
+	}
+	return types.NewSimpleResponse(nil)
+}
+
+// Tick returns a summary of any windows that have closed by processing time,
+// unless a message has been processed since the last tick, in which case a
+// later message closes them. Windows based on the time of messages are only
+// closed by later messages.
+func (w *Window) Tick(now time.Time) (*types.Message, bool) {
+	if len(w.conf.Window.TimestampPath) > 0 || len(w.windows) == 0 {
+		return nil, false
+	}
+	if w.active {
+		w.active = false
+		return nil, false
+	}
+	if ts := now.UnixNano() / int64(time.Millisecond); ts > w.watermark {
+		w.watermark = ts
+	}
+	msg := w.emit(w.watermark - w.grace)
+	return msg, msg != nil
+}
+
+// Flush returns a summary of all windows that are still open.
+func (w *Window) Flush() (*types.Message, bool) {
+	if len(w.windows) == 0 {
+		return nil, false
+	}
+	w.stats.Incr("processor.window.flush", 1)
+	msg := w.emit(math.MaxInt64)
+	return msg, msg != nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,364 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package processor
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+func newTestWindow(t *testing.T, wConf WindowConfig) *Window {
+	conf := NewConfig()
+	conf.Window = wConf
+
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+	proc, err := NewWindow(conf, testLog, metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proc.(*Window)
+}
+
+func windowParts(msg *types.Message) []string {
+	if msg == nil {
+		return nil
+	}
+	parts := []string{}
+	for _, part := range msg.Parts {
+		parts = append(parts, string(part))
+	}
+	return parts
+}
+
+func TestWindowBadConfig(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	conf.Window.SizeMS = 0
+	if _, err := NewWindow(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from zero size")
+	}
+
+	conf = NewConfig()
+	conf.Window.Type = "nope"
+	if _, err := NewWindow(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad type")
+	}
+
+	conf = NewConfig()
+	conf.Window.Type = "sliding"
+	conf.Window.SlideMS = conf.Window.SizeMS + 1
+	if _, err := NewWindow(conf, testLog, metrics.DudType{}); err == nil {
+		t.Error("Expected error from slide larger than size")
+	}
+}
+
+func TestWindowTumbling(t *testing.T) {
+	wConf := NewWindowConfig()
+	wConf.SizeMS = 10000
+	wConf.KeyPath = "user"
+	wConf.ValuePath = "amount"
+	wConf.TimestampPath = "ts"
+	proc := newTestWindow(t, wConf)
+
+	type step struct {
+		input    string
+		expParts []string
+	}
+	steps := []step{
+		{input: `{"user":"a","amount":1,"ts":1}`},
+		{input: `{"user":"b","amount":5,"ts":2}`},
+		{input: `{"user":"a","amount":3,"ts":9.999}`},
+		{
+			input: `{"user":"a","amount":10,"ts":10}`,
+			expParts: []string{
+				`{"count":2,"end":"1970-01-01T00:00:10Z","key":"a","max":3,"min":1,"start":"1970-01-01T00:00:00Z","sum":4}`,
+				`{"count":1,"end":"1970-01-01T00:00:10Z","key":"b","max":5,"min":5,"start":"1970-01-01T00:00:00Z","sum":5}`,
+			},
+		},
+		{input: `{"user":"a","amount":2,"ts":15}`},
+	}
+
+	for i, s := range steps {
+		msg := types.NewMessage()
+		msg.Parts = [][]byte{[]byte(s.input)}
+		msgOut, res, propagate := proc.ProcessMessage(&msg)
+		if s.expParts == nil {
+			if propagate {
+				t.Errorf("Step %v: unexpected message: %s", i, windowParts(msgOut))
+			} else if !res.SkipAck() {
+				t.Errorf("Step %v: expected unacknowledged response", i)
+			}
+			continue
+		}
+		if !propagate {
+			t.Errorf("Step %v: expected message", i)
+			continue
+		}
+		if res == nil || !res.SkipAck() {
+			t.Errorf("Step %v: expected summary not to acknowledge the message that closed the window", i)
+		}
+		if act := windowParts(msgOut); !reflect.DeepEqual(act, s.expParts) {
+			t.Errorf("Step %v: wrong result: %s != %s", i, act, s.expParts)
+		}
+	}
+
+	msgOut, propagate := proc.Flush()
+	if !propagate {
+		t.Fatal("Expected flushed message")
+	}
+	exp := []string{
+		`{"count":2,"end":"1970-01-01T00:00:20Z","key":"a","max":10,"min":2,"start":"1970-01-01T00:00:10Z","sum":12}`,
+	}
+	if act := windowParts(msgOut); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong flush result: %s != %s", act, exp)
+	}
+	if _, propagate = proc.Flush(); propagate {
+		t.Error("Expected nothing left to flush")
+	}
+}
+
+func TestWindowMetadata(t *testing.T) {
+	wConf := NewWindowConfig()
+	wConf.SizeMS = 10000
+	wConf.KeyPath = "user"
+	wConf.TimestampPath = "ts"
+	proc := newTestWindow(t, wConf)
+
+	for _, input := range []struct {
+		body   string
+		source string
+	}{
+		{body: `{"user":"a","ts":1}`, source: "first"},
+		{body: `{"user":"b","ts":2}`},
+		{body: `{"user":"a","ts":3}`, source: "second"},
+	} {
+		msg := types.Message{Parts: [][]byte{[]byte(input.body)}}
+		if len(input.source) > 0 {
+			msg.SetMetadata(0, "source", input.source)
+		}
+		if _, _, propagate := proc.ProcessMessage(&msg); propagate {
+			t.Fatal("Unexpected message")
+		}
+	}
+
+	msgOut, propagate := proc.Flush()
+	if !propagate {
+		t.Fatal("Expected flushed message")
+	}
+	exp := []map[string]string{{"source": "first"}}
+	if act := msgOut.Metadata; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong metadata: %v != %v", act, exp)
+	}
+}
+
+func TestWindowSliding(t *testing.T) {
+	wConf := NewWindowConfig()
+	wConf.Type = "sliding"
+	wConf.SizeMS = 10000
+	wConf.SlideMS = 5000
+	wConf.TimestampPath = "ts"
+	proc := newTestWindow(t, wConf)
+
+	type step struct {
+		input    string
+		expParts []string
+	}
+	steps := []step{
+		{input: `{"ts":"1970-01-01T00:00:01Z"}`},
+		{
+			input: `{"ts":"1970-01-01T00:00:06Z"}`,
+			expParts: []string{
+				`{"count":1,"end":"1970-01-01T00:00:05Z","start":"1969-12-31T23:59:55Z"}`,
+			},
+		},
+		{
+			input: `{"ts":"1970-01-01T00:00:10Z"}`,
+			expParts: []string{
+				`{"count":2,"end":"1970-01-01T00:00:10Z","start":"1970-01-01T00:00:00Z"}`,
+			},
+		},
+	}
+
+	for i, s := range steps {
+		msg := types.NewMessage()
+		msg.Parts = [][]byte{[]byte(s.input)}
+		msgOut, _, propagate := proc.ProcessMessage(&msg)
+		if s.expParts == nil {
+			if propagate {
+				t.Errorf("Step %v: unexpected message: %s", i, windowParts(msgOut))
+			}
+			continue
+		}
+		if !propagate {
+			t.Errorf("Step %v: expected message", i)
+			continue
+		}
+		if act := windowParts(msgOut); !reflect.DeepEqual(act, s.expParts) {
+			t.Errorf("Step %v: wrong result: %s != %s", i, act, s.expParts)
+		}
+	}
+
+	msgOut, propagate := proc.Flush()
+	if !propagate {
+		t.Fatal("Expected flushed message")
+	}
+	exp := []string{
+		`{"count":2,"end":"1970-01-01T00:00:15Z","start":"1970-01-01T00:00:05Z"}`,
+		`{"count":1,"end":"1970-01-01T00:00:20Z","start":"1970-01-01T00:00:10Z"}`,
+	}
+	if act := windowParts(msgOut); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong flush result: %s != %s", act, exp)
+	}
+}
+
+func TestWindowGracePeriod(t *testing.T) {
+	wConf := NewWindowConfig()
+	wConf.SizeMS = 10000
+	wConf.GracePeriodMS = 5000
+	wConf.TimestampPath = "ts"
+	proc := newTestWindow(t, wConf)
+
+	for _, input := range []string{
+		`{"ts":1}`,
+		`{"ts":12}`,
+		`{"ts":2}`,
+	} {
+		msg := types.NewMessage()
+		msg.Parts = [][]byte{[]byte(input)}
+		if _, _, propagate := proc.ProcessMessage(&msg); propagate {
+			t.Errorf("Unexpected message from input: %v", input)
+		}
+	}
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{[]byte(`{"ts":15}`)}
+	msgOut, _, propagate := proc.ProcessMessage(&msg)
+	if !propagate {
+		t.Fatal("Expected message")
+	}
+	exp := []string{
+		`{"count":2,"end":"1970-01-01T00:00:10Z","start":"1970-01-01T00:00:00Z"}`,
+	}
+	if act := windowParts(msgOut); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+
+	msg = types.NewMessage()
+	msg.Parts = [][]byte{[]byte(`{"ts":3}`)}
+	if _, _, propagate = proc.ProcessMessage(&msg); propagate {
+		t.Error("Unexpected message from late data")
+	}
+
+	msgOut, _ = proc.Flush()
+	exp = []string{
+		`{"count":2,"end":"1970-01-01T00:00:20Z","start":"1970-01-01T00:00:10Z"}`,
+	}
+	if act := windowParts(msgOut); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong flush result: %s != %s", act, exp)
+	}
+}
+
+func TestWindowBadMessages(t *testing.T) {
+	wConf := NewWindowConfig()
+	wConf.KeyPath = "key"
+	wConf.ValuePath = "value"
+	proc := newTestWindow(t, wConf)
+
+	for _, input := range []string{
+		`not json`,
+		`{"value":1}`,
+		`{"key":"a"}`,
+		`{"key":"a","value":"nope"}`,
+	} {
+		msg := types.NewMessage()
+		msg.Parts = [][]byte{[]byte(input)}
+		_, res, propagate := proc.ProcessMessage(&msg)
+		if propagate {
+			t.Errorf("Unexpected message from input: %v", input)
+		} else if res.SkipAck() || res.Error() != nil {
+			t.Errorf("Expected dropped message to be acknowledged: %v", input)
+		}
+	}
+	if _, propagate := proc.Flush(); propagate {
+		t.Error("Expected nothing to flush")
+	}
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{[]byte(`{"key":"a","value":1}`)}
+	if _, _, propagate := proc.ProcessMessage(&msg); propagate {
+		t.Fatal("Unexpected message")
+	}
+	msg.Parts = [][]byte{[]byte(`not json`)}
+	if _, res, propagate := proc.ProcessMessage(&msg); propagate {
+		t.Error("Unexpected message")
+	} else if !res.SkipAck() {
+		t.Error("Expected dropped message not to be acknowledged whilst a window is open")
+	}
+}
+
+func TestWindowTick(t *testing.T) {
+	wConf := NewWindowConfig()
+	wConf.SizeMS = 10000
+	proc := newTestWindow(t, wConf)
+
+	msg := types.NewMessage()
+	msg.Parts = [][]byte{[]byte(`{}`)}
+	if _, res, propagate := proc.ProcessMessage(&msg); propagate {
+		t.Fatal("Unexpected message")
+	} else if !res.SkipAck() {
+		t.Error("Expected unacknowledged response")
+	}
+
+	later := time.Now().Add(time.Second * 20)
+	if _, propagate := proc.Tick(later); propagate {
+		t.Error("Expected no message from tick after a processed message")
+	}
+	if _, propagate := proc.Tick(time.Now().Add(-time.Second * 20)); propagate {
+		t.Error("Expected no message before the window closes")
+	}
+
+	msgOut, propagate := proc.Tick(later)
+	if !propagate {
+		t.Fatal("Expected message from tick")
+	}
+	if exp, act := 1, len(msgOut.Parts); exp != act {
+		t.Errorf("Wrong count of summaries: %v != %v", act, exp)
+	}
+	if _, propagate = proc.Flush(); propagate {
+		t.Error("Expected nothing left to flush")
+	}
+
+	wConf.TimestampPath = "ts"
+	proc = newTestWindow(t, wConf)
+	msg.Parts = [][]byte{[]byte(`{"ts":1}`)}
+	proc.ProcessMessage(&msg)
+	proc.Tick(later)
+	if _, propagate = proc.Tick(later); propagate {
+		t.Error("Expected no message from tick with message timestamps")
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stream
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Jeffail/benthos/lib/buffer"
+	"github.com/Jeffail/benthos/lib/condition"
+	"github.com/Jeffail/benthos/lib/input"
+	"github.com/Jeffail/benthos/lib/output"
+	"github.com/Jeffail/benthos/lib/processor"
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// dryRunner validates components from their configs without connecting or
+// starting them, collecting any errors encountered along the way.
+type dryRunner struct {
+	log   log.Modular
+	stats metrics.Type
+	errs  []string
+}
+
+// Inputs, outputs and buffers of these types bind sockets, create or recover
+// files, or start goroutines when constructed, and are therefore only validated
+// structurally during a dry run.
+var (
+	dryRunInputTypes = map[string]struct{}{
+		"file":                  {},
+		"scalability_protocols": {},
+		"zmq4":                  {},
+	}
+	dryRunOutputTypes = map[string]struct{}{
+		"file":                  {},
+		"scalability_protocols": {},
+		"zmq4":                  {},
+	}
+	dryRunBufferTypes = map[string]struct{}{
+		"mmap_file": {},
+		"wal":       {},
+	}
+)
+
+// sharedEndpoint returns true if an input or output registers its endpoint with
+// the default HTTP server when constructed. Such a registration would clash with
+// a running stream, therefore these components are only validated structurally.
+func sharedEndpoint(typeStr, httpAddress, wsMode, wsAddress string) bool {
+	switch typeStr {
+	case "http_server":
+		return len(httpAddress) == 0
+	case "websocket":
+		return wsMode == "server" && len(wsAddress) == 0
+	}
+	return false
+}
+
+// knownType returns true if a type is within a list of type names.
+func knownType(names []string, typeStr string) bool {
+	for _, name := range names {
+		if name == typeStr {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dryRunner) fail(path string, err error) {
+	d.errs = append(d.errs, fmt.Sprintf("%v: %v", path, err))
+}
+
+func (d *dryRunner) processors(path string, confs []processor.Config) {
+	for i, conf := range confs {
+		proc, err := processor.New(conf, d.log, d.stats)
+		if err != nil {
+			d.fail(fmt.Sprintf("%v.processors[%v]", path, i), err)
+			continue
+		}
+		if closer, ok := proc.(processor.Closer); ok {
+			closer.CloseAsync()
+		}
+	}
+}
+
+func (d *dryRunner) input(path string, conf input.Config) {
+	d.processors(path, conf.Processors)
+
+	// The fan_in input would couple its children, and therefore start them,
+	// so instead we construct each child individually.
+	if conf.Type == "fan_in" {
+		confs, err := input.ParseConfigs(conf.FanIn.Inputs)
+		if err != nil {
+			d.fail(path+".fan_in.inputs", err)
+			return
+		}
+		if len(confs) == 0 {
+			d.fail(path+".fan_in.inputs", input.ErrFanInNoInputs)
+		}
+		for i, c := range confs {
+			d.input(fmt.Sprintf("%v.fan_in.inputs[%v]", path, i), c)
+		}
+		return
+	}
+
+	_, structural := dryRunInputTypes[conf.Type]
+	if structural || sharedEndpoint(conf.Type, conf.HTTPServer.Address, conf.Websocket.Mode, conf.Websocket.Address) {
+		if !knownType(input.TypeNames(), conf.Type) {
+			d.fail(path, types.ErrInvalidInputType)
+		}
+		return
+	}
+
+	conf.Processors = nil
+	in, err := input.New(conf, d.log, d.stats)
+	if err != nil {
+		d.fail(path, err)
+		return
+	}
+	in.CloseAsync()
+}
+
+func (d *dryRunner) outputs(path string, boxed []interface{}, errEmpty error) {
+	confs, err := output.ParseConfigs(boxed)
+	if err != nil {
+		d.fail(path, err)
+		return
+	}
+	if len(confs) == 0 && errEmpty != nil {
+		d.fail(path, errEmpty)
+	}
+	for i, c := range confs {
+		d.output(fmt.Sprintf("%v[%v]", path, i), c)
+	}
+}
+
+func (d *dryRunner) outputChild(path string, boxed interface{}) {
+	if boxed == nil {
+		d.fail(path, errors.New("output is missing"))
+		return
+	}
+	confs, err := output.ParseConfigs([]interface{}{boxed})
+	if err != nil {
+		d.fail(path, err)
+		return
+	}
+	for _, c := range confs {
+		d.output(path, c)
+	}
+}
+
+func (d *dryRunner) output(path string, conf output.Config) {
+	d.processors(path, conf.Processors)
+
+	if conf.Retry.DeadLetter != nil {
+		if conf.Retry.MaxAttempts <= 0 {
+			d.fail(path+".retry", output.ErrDeadLetterNoMaxAttempts)
+		}
+		d.outputChild(path+".retry.dead_letter", conf.Retry.DeadLetter)
+	}
+
+	// Broker outputs would couple their children, and therefore start them,
+	// so instead we construct each child individually.
+	switch conf.Type {
+	case "fan_out":
+		d.outputs(path+".fan_out.outputs", conf.FanOut.Outputs, output.ErrFanOutNoOutputs)
+		return
+	case "round_robin":
+		d.outputs(path+".round_robin.outputs", conf.RoundRobin.Outputs, output.ErrRoundRobinNoOutputs)
+		return
+	case "switch":
+		if len(conf.Switch.Cases) == 0 && conf.Switch.Fallback == nil {
+			d.fail(path+".switch", output.ErrSwitchNoOutputs)
+		}
+		for i, c := range conf.Switch.Cases {
+			casePath := fmt.Sprintf("%v.switch.cases[%v]", path, i)
+			if _, err := condition.New(c.Condition, d.log, d.stats); err != nil {
+				d.fail(casePath+".condition", err)
+			}
+			d.outputChild(casePath+".output", c.Output)
+		}
+		if conf.Switch.Fallback != nil {
+			d.outputChild(path+".switch.fallback", conf.Switch.Fallback)
+		}
+		return
+	}
+
+	_, structural := dryRunOutputTypes[conf.Type]
+	if structural || sharedEndpoint(conf.Type, conf.HTTPServer.Address, conf.Websocket.Mode, conf.Websocket.Address) {
+		if !knownType(output.TypeNames(), conf.Type) {
+			d.fail(path, types.ErrInvalidOutputType)
+		}
+		return
+	}
+
+	// The retry, batch and idempotence wrappers start the output they wrap,
+	// and an idempotence log is loaded from disk, so they are disabled here.
+	conf.Processors = nil
+	conf.Retry = output.NewRetryConfig()
+	conf.Batch = output.NewBatchConfig()
+	conf.Idempotence = output.NewIdempotenceConfig()
+	out, err := output.New(conf, d.log, d.stats)
+	if err != nil {
+		d.fail(path, err)
+		return
+	}
+	out.CloseAsync()
+}
+
+func (d *dryRunner) buffer(path string, conf buffer.Config) {
+	if _, structural := dryRunBufferTypes[conf.Type]; structural {
+		if !knownType(buffer.TypeNames(), conf.Type) {
+			d.fail(path, types.ErrInvalidBufferType)
+		}
+		return
+	}
+	buf, err := buffer.New(conf, d.log, d.stats)
+	if err != nil {
+		d.fail(path, err)
+		return
+	}
+	buf.CloseAsync()
+}
+
+func (d *dryRunner) stream(path string, conf Config) {
+	d.input(path+"input", conf.Input)
+	d.buffer(path+"buffer", conf.Buffer)
+	d.output(path+"output", conf.Output)
+}
+
+// DryRun validates every input, buffer, processor and output of a stream config
+// without connecting or starting them. Components are constructed where doing
+// so has no side effects, and those that would bind sockets, touch the
+// filesystem or register HTTP endpoints are only checked structurally. Returns a
+// list of errors found, each prefixed with the path of the offending component.
+func DryRun(conf Config, log log.Modular, stats metrics.Type) []string {
+	d := dryRunner{log: log, stats: stats}
+	d.stream("", conf)
+	return d.errs
+}
+
+//------------------------------------------------------------------------------
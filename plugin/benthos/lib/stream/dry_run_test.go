@@ -0,0 +1,110 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package stream
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Jeffail/benthos/lib/output"
+	"github.com/Jeffail/benthos/lib/processor"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func TestDryRun(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	if errs := DryRun(NewConfig(), testLog, metrics.DudType{}); len(errs) > 0 {
+		t.Errorf("Unexpected errors from default config: %v", errs)
+	}
+
+	conf := NewConfig()
+	conf.Input.Type = "does_not_exist"
+	procConf := processor.NewConfig()
+	procConf.Type = "does_not_exist"
+	conf.Output.Processors = []processor.Config{procConf}
+
+	errs := DryRun(conf, testLog, metrics.DudType{})
+	paths := []string{}
+	for _, err := range errs {
+		paths = append(paths, strings.Split(err, ":")[0])
+	}
+	if exp, act := []string{"input", "output.processors[0]"}, paths; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong error paths: %v != %v", act, exp)
+	}
+}
+
+func TestDryRunNoSideEffects(t *testing.T) {
+	testLog := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	dir, err := ioutil.TempDir("", "benthos_dry_run_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dlConf := output.NewConfig()
+	dlConf.Type = "file"
+	dlConf.File.Path = filepath.Join(dir, "dead_letter.txt")
+
+	conf := NewConfig()
+	conf.Input.Type = "scalability_protocols"
+	conf.Input.ScaleProto.URLs = []string{"tcp://localhost:0"}
+	conf.Input.ScaleProto.Bind = true
+	conf.Buffer.Type = "wal"
+	conf.Buffer.WAL.Path = filepath.Join(dir, "wal")
+	conf.Output.Type = "file"
+	conf.Output.File.Path = filepath.Join(dir, "out.txt")
+	conf.Output.File.MaxSize = 1024
+	conf.Output.Batch.Count = 10
+	conf.Output.Idempotence.IDMetadataKeys = []string{"id"}
+	conf.Output.Idempotence.Path = filepath.Join(dir, "ids")
+	conf.Output.Retry.DeadLetter = dlConf
+
+	errs := DryRun(conf, testLog, metrics.DudType{})
+	if exp, act := []string{"output.retry: " + output.ErrDeadLetterNoMaxAttempts.Error()}, errs; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong errors: %v != %v", act, exp)
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		t.Errorf("Dry run created file: %v", info.Name())
+	}
+
+	conf = NewConfig()
+	conf.Buffer.Type = "does_not_exist"
+	conf.Output.Type = "http_server"
+	conf.Output.Retry.MaxAttempts = 3
+	if exp, act := []string{"buffer: buffer type was not recognised"}, DryRun(conf, testLog, metrics.DudType{}); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong errors: %v != %v", act, exp)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,202 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/stream"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//------------------------------------------------------------------------------
+
+type streamSummary struct {
+	Active    bool    `json:"active"`
+	Uptime    float64 `json:"uptime"`
+	UptimeStr string  `json:"uptime_str"`
+}
+
+type streamInfo struct {
+	streamSummary
+	InputCount  int64         `json:"input_count"`
+	OutputCount int64         `json:"output_count"`
+	Config      stream.Config `json:"config"`
+}
+
+func newStreamSummary(status StreamStatus) streamSummary {
+	return streamSummary{
+		Active:    status.Active,
+		Uptime:    status.Uptime.Seconds(),
+		UptimeStr: status.Uptime.String(),
+	}
+}
+
+// parseStreamConfig reads a stream config from a request body, which can be
+// either JSON or YAML. Unlike config files, environment variables are not
+// replaced, as the API would otherwise expose them to its callers.
+func parseStreamConfig(r *http.Request) (stream.Config, error) {
+	conf := stream.NewConfig()
+
+	confBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return conf, err
+	}
+
+	if err = json.Unmarshal(confBytes, &conf); err != nil {
+		conf = stream.NewConfig()
+		err = yaml.Unmarshal(confBytes, &conf)
+	}
+	return conf, err
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	resBytes, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resBytes)
+}
+
+//------------------------------------------------------------------------------
+
+// StreamsHandler returns an HTTP handler that writes a JSON object of all
+// managed stream IDs mapped to a summary of their status.
+func (m *Type) StreamsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		summaries := map[string]streamSummary{}
+		for _, id := range m.IDs() {
+			if status, err := m.Read(id); err == nil {
+				summaries[id] = newStreamSummary(status)
+			}
+		}
+		writeJSON(w, summaries)
+	}
+}
+
+// StreamCRUDHandler returns an HTTP handler for creating, reading, updating
+// and deleting individual streams, where the stream ID is the final segment of
+// the request path (/streams/{id}):
+//
+// - GET obtains the status and active configuration of the stream.
+// - POST creates a new stream from a JSON or YAML config in the request body.
+// - PUT replaces the config of a stream, the existing stream is drained first.
+// - DELETE drains and removes the stream.
+//
+// The timeout is applied when stopping streams for updates and deletes.
+func (m *Type) StreamCRUDHandler(timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path
+		if i := strings.LastIndex(id, "/streams/"); i >= 0 {
+			id = id[i+len("/streams/"):]
+		}
+		if len(id) == 0 || strings.Contains(id, "/") {
+			http.Error(w, "a single stream id is required", http.StatusBadRequest)
+			return
+		}
+
+		m.handleStream(w, r, id, timeout)
+	}
+}
+
+// StreamHandler returns an HTTP handler for reading and updating a single
+// stream of a fixed ID, which is used for hot reloading the config of a single
+// pipeline:
+//
+// - GET obtains the status and active configuration of the stream.
+// - PUT replaces the config of the stream, the existing stream is drained first.
+//
+// The timeout is applied when stopping the stream for updates.
+func (m *Type) StreamHandler(id string, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "PUT" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		m.handleStream(w, r, id, timeout)
+	}
+}
+
+// handleStream creates, reads, updates or deletes a stream depending on the
+// method of a request.
+func (m *Type) handleStream(w http.ResponseWriter, r *http.Request, id string, timeout time.Duration) {
+	var err error
+	switch r.Method {
+	case "GET":
+		var status StreamStatus
+		if status, err = m.Read(id); err == nil {
+			writeJSON(w, streamInfo{
+				streamSummary: newStreamSummary(status),
+				InputCount:    status.InputCount,
+				OutputCount:   status.OutputCount,
+				Config:        status.Config,
+			})
+			return
+		}
+	case "POST", "PUT":
+		var conf stream.Config
+		if conf, err = parseStreamConfig(r); err != nil {
+			http.Error(w, fmt.Sprintf("failed to parse config: %v", err), http.StatusBadRequest)
+			return
+		}
+		if r.Method == "POST" {
+			err = m.Create(id, conf)
+		} else {
+			err = m.Update(id, conf, timeout)
+		}
+	case "DELETE":
+		err = m.Delete(id, timeout)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch err {
+	case nil:
+		w.Write([]byte("OK"))
+	case ErrStreamDoesNotExist:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrStreamExists:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case ErrStreamUpdating:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		if r.Method == "DELETE" {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,241 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/stream"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+func apiRequest(t *testing.T, handler http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestAPIStreamLifecycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_manager_api_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inPath := filepath.Join(dir, "in.txt")
+	if err = ioutil.WriteFile(inPath, []byte("foo\nbar\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := New(log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	defer mgr.Stop(time.Second)
+
+	crud := mgr.StreamCRUDHandler(time.Second * 5)
+	list := mgr.StreamsHandler()
+
+	yamlConf := `
+input:
+  type: file
+  file:
+    path: ` + inPath + `
+output:
+  type: file
+  file:
+    path: ` + filepath.Join(dir, "out_1.txt") + `
+`
+	if w := apiRequest(t, crud, "POST", "/benthos/streams/foo", yamlConf); w.Code != http.StatusOK {
+		t.Fatalf("Unexpected create result: %v %s", w.Code, w.Body.String())
+	}
+	if w := apiRequest(t, crud, "POST", "/streams/foo", yamlConf); w.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected duplicate create result: %v %s", w.Code, w.Body.String())
+	}
+	if w := apiRequest(t, crud, "POST", "/streams/bar", "not: [valid"); w.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected bad config result: %v %s", w.Code, w.Body.String())
+	}
+
+	w := apiRequest(t, list, "GET", "/streams", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected list result: %v %s", w.Code, w.Body.String())
+	}
+	summaries := map[string]streamSummary{}
+	if err = json.Unmarshal(w.Body.Bytes(), &summaries); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := summaries["foo"]; !exists || len(summaries) != 1 {
+		t.Errorf("Wrong streams listed: %s", w.Body.String())
+	}
+
+	for i := 0; ; i++ {
+		if status, _ := mgr.Read("foo"); status.OutputCount == 2 {
+			break
+		}
+		if i >= 500 {
+			t.Fatal("Timed out waiting for stream output")
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+
+	jsonConf := `{"input":{"type":"file","file":{"path":"` + inPath + `"}},` +
+		`"output":{"type":"file","file":{"path":"` + filepath.Join(dir, "out_2.txt") + `"}}}`
+	if w = apiRequest(t, crud, "PUT", "/streams/foo", jsonConf); w.Code != http.StatusOK {
+		t.Fatalf("Unexpected update result: %v %s", w.Code, w.Body.String())
+	}
+	if w = apiRequest(t, crud, "PUT", "/streams/bar", jsonConf); w.Code != http.StatusNotFound {
+		t.Errorf("Unexpected missing update result: %v %s", w.Code, w.Body.String())
+	}
+	if w = apiRequest(t, crud, "PUT", "/streams/foo", `{"output":{"type":"does_not_exist"}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected bad update result: %v %s", w.Code, w.Body.String())
+	}
+
+	if w = apiRequest(t, crud, "GET", "/streams/foo", ""); w.Code != http.StatusOK {
+		t.Fatalf("Unexpected read result: %v %s", w.Code, w.Body.String())
+	}
+	info := streamInfo{}
+	if err = json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := filepath.Join(dir, "out_2.txt"), info.Config.Output.File.Path; exp != act {
+		t.Errorf("Config not rolled back to last good update: %v != %v", act, exp)
+	}
+
+	if w = apiRequest(t, crud, "DELETE", "/streams/foo", ""); w.Code != http.StatusOK {
+		t.Errorf("Unexpected delete result: %v %s", w.Code, w.Body.String())
+	}
+	if w = apiRequest(t, crud, "GET", "/streams/foo", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Unexpected read result: %v %s", w.Code, w.Body.String())
+	}
+	if w = apiRequest(t, crud, "DELETE", "/streams/foo", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Unexpected delete result: %v %s", w.Code, w.Body.String())
+	}
+	if w = apiRequest(t, crud, "GET", "/streams/", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected empty id result: %v %s", w.Code, w.Body.String())
+	}
+	if w = apiRequest(t, list, "POST", "/streams", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Unexpected list post result: %v %s", w.Code, w.Body.String())
+	}
+
+	outBytes, err := ioutil.ReadFile(filepath.Join(dir, "out_1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "foo\nbar\n", string(outBytes); exp != act {
+		t.Errorf("Wrong output: %q != %q", act, exp)
+	}
+}
+
+func TestAPIStreamHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_manager_api_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mgr := New(log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	defer mgr.Stop(time.Second)
+
+	handler := mgr.StreamHandler("", time.Second*5)
+
+	if w := apiRequest(t, handler, "GET", "/stream", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Unexpected missing read result: %v %s", w.Code, w.Body.String())
+	}
+
+	conf := stream.NewConfig()
+	conf.Input.Type = "http_server"
+	conf.Input.HTTPServer.Address = "127.0.0.1:0"
+	if err = mgr.Create("", conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := apiRequest(t, handler, "POST", "/stream", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Unexpected create result: %v %s", w.Code, w.Body.String())
+	}
+	if w := apiRequest(t, handler, "DELETE", "/stream", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Unexpected delete result: %v %s", w.Code, w.Body.String())
+	}
+
+	yamlConf := `
+input:
+  type: http_server
+  http_server:
+    address: 127.0.0.1:0
+output:
+  type: file
+  file:
+    path: ` + filepath.Join(dir, "out.txt") + `
+`
+	if w := apiRequest(t, handler, "PUT", "/stream", yamlConf); w.Code != http.StatusOK {
+		t.Fatalf("Unexpected update result: %v %s", w.Code, w.Body.String())
+	}
+
+	w := apiRequest(t, handler, "GET", "/stream", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected read result: %v %s", w.Code, w.Body.String())
+	}
+	info := streamInfo{}
+	if err = json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := filepath.Join(dir, "out.txt"), info.Config.Output.File.Path; exp != act {
+		t.Errorf("Wrong config after update: %v != %v", act, exp)
+	}
+	if !info.Active {
+		t.Error("Expected updated stream to be active")
+	}
+}
+
+func TestAPIParseStreamConfigNoEnv(t *testing.T) {
+	os.Setenv("BENTHOS_API_TEST_SECRET", "secret")
+	defer os.Unsetenv("BENTHOS_API_TEST_SECRET")
+
+	req, err := http.NewRequest("POST", "/streams/foo", bytes.NewBufferString(`
+output:
+  type: file
+  file:
+    path: ${BENTHOS_API_TEST_SECRET}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := parseStreamConfig(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "${BENTHOS_API_TEST_SECRET}", conf.Output.File.Path; exp != act {
+		t.Errorf("Wrong path: %v != %v", act, exp)
+	}
+}
+
+//------------------------------------------------------------------------------
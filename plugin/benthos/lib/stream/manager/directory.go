@@ -0,0 +1,67 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package manager
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/Jeffail/benthos/lib/stream"
+	"github.com/Jeffail/benthos/lib/util/service/config"
+)
+
+//------------------------------------------------------------------------------
+
+// LoadStreamConfigsFromDirectory reads a map of stream ids to configurations
+// by walking a directory of .json and .yaml files. The ID of each stream is the
+// name of the file it was read from without the extension, files of other
+// extensions and sub directories are ignored.
+func LoadStreamConfigsFromDirectory(replaceEnvVars bool, dir string) (map[string]stream.Config, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	streamMap := map[string]stream.Config{}
+	for _, info := range files {
+		if info.IsDir() {
+			continue
+		}
+
+		ext := filepath.Ext(info.Name())
+		switch ext {
+		case ".js", ".json", ".yml", ".yaml":
+		default:
+			continue
+		}
+
+		conf := stream.NewConfig()
+		if err = config.Read(filepath.Join(dir, info.Name()), replaceEnvVars, &conf); err != nil {
+			return nil, err
+		}
+		streamMap[strings.TrimSuffix(info.Name(), ext)] = conf
+	}
+
+	return streamMap, nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,94 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestLoadStreamConfigsFromDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_manager_dir_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"foo.yaml": "input:\n  type: file\n  file:\n    path: /tmp/foo\n",
+		"bar.json": `{"output":{"type":"file","file":{"path":"/tmp/bar"}}}`,
+		"baz.txt":  "ignored",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Mkdir(filepath.Join(dir, "qux.yaml"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	confs, err := LoadStreamConfigsFromDirectory(true, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, act := 2, len(confs); exp != act {
+		t.Fatalf("Wrong count of streams: %v != %v", act, exp)
+	}
+	if exp, act := "/tmp/foo", confs["foo"].Input.File.Path; exp != act {
+		t.Errorf("Wrong input path: %v != %v", act, exp)
+	}
+	if exp, act := "stdout", confs["foo"].Output.Type; exp != act {
+		t.Errorf("Wrong default output type: %v != %v", act, exp)
+	}
+	if exp, act := "/tmp/bar", confs["bar"].Output.File.Path; exp != act {
+		t.Errorf("Wrong output path: %v != %v", act, exp)
+	}
+	if exp, act := "stdin", confs["bar"].Input.Type; exp != act {
+		t.Errorf("Wrong default input type: %v != %v", act, exp)
+	}
+}
+
+func TestLoadStreamConfigsFromDirectoryErrors(t *testing.T) {
+	if _, err := LoadStreamConfigsFromDirectory(true, "/does/not/exist"); err == nil {
+		t.Error("Expected error from missing directory")
+	}
+
+	dir, err := ioutil.TempDir("", "benthos_manager_dir_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "foo.json"), []byte("not json"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadStreamConfigsFromDirectory(true, dir); err == nil {
+		t.Error("Expected error from bad config file")
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,335 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/stream"
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/benthos/lib/util/service/tracing"
+)
+
+//------------------------------------------------------------------------------
+
+// Errors specifically returned by a stream manager.
+var (
+	ErrStreamExists       = errors.New("stream already exists")
+	ErrStreamDoesNotExist = errors.New("stream does not exist")
+	ErrStreamUpdating     = errors.New("stream is being updated")
+)
+
+//------------------------------------------------------------------------------
+
+// StreamStatus describes the state of a managed stream.
+type StreamStatus struct {
+	Active      bool
+	Uptime      time.Duration
+	InputCount  int64
+	OutputCount int64
+	Config      stream.Config
+}
+
+func newStreamStatus(strm *stream.Type) StreamStatus {
+	return StreamStatus{
+		Active:      strm.Active(),
+		Uptime:      strm.Uptime(),
+		InputCount:  strm.InputCount(),
+		OutputCount: strm.OutputCount(),
+		Config:      strm.Config(),
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Type manages a collection of streams, providing APIs for creating and
+// removing them. Each stream is given logs and metrics that are isolated by
+// being prefixed with the stream ID, except for a stream with an empty ID,
+// which is used for running a single pipeline.
+type Type struct {
+	streams    map[string]*stream.Type
+	updating   map[string]struct{}
+	terminated map[string]chan struct{}
+
+	log    log.Modular
+	stats  metrics.Type
+	tracer *tracing.Tracer
+
+	lock sync.Mutex
+}
+
+// New creates a new stream manager.
+func New(log log.Modular, stats metrics.Type) *Type {
+	return NewWithTracer(log, stats, nil)
+}
+
+// NewWithTracer creates a new stream manager where messages of each stream are
+// traced with a tracer, which can be nil.
+func NewWithTracer(log log.Modular, stats metrics.Type, tracer *tracing.Tracer) *Type {
+	return &Type{
+		streams:    map[string]*stream.Type{},
+		updating:   map[string]struct{}{},
+		terminated: map[string]chan struct{}{},
+		log:        log,
+		stats:      stats,
+		tracer:     tracer,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// newStream creates a stream with logs and metrics isolated by its ID.
+func (m *Type) newStream(id string, conf stream.Config) (*stream.Type, error) {
+	if len(id) == 0 {
+		return stream.NewWithTracer(conf, m.log, m.stats, m.tracer)
+	}
+	return stream.NewWithTracer(
+		conf,
+		m.log.NewModule("."+id),
+		metrics.NewNamespaced(m.stats, id),
+		m.tracer,
+	)
+}
+
+// stopGracefully attempts to drain a stream within half of the timeout period,
+// and if that fails the stream is forced to stop within the remaining time.
+func stopGracefully(strm *stream.Type, timeout time.Duration) error {
+	if err := strm.StopGracefully(timeout / 2); err == nil {
+		return nil
+	}
+	return strm.Stop(timeout / 2)
+}
+
+// Create attempts to construct and run a new stream under a unique ID. If the
+// ID already exists an error is returned.
+func (m *Type) Create(id string, conf stream.Config) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.streams[id]; exists {
+		return ErrStreamExists
+	}
+
+	strm, err := m.newStream(id, conf)
+	if err != nil {
+		return err
+	}
+	m.streams[id] = strm
+
+	m.watch(id, strm)
+	return nil
+}
+
+// watch logs when a stream terminates whilst it is still being managed, and
+// closes the terminated channel of the stream ID if one exists.
+func (m *Type) watch(id string, strm *stream.Type) {
+	go func() {
+		<-strm.Terminated()
+
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		if _, updating := m.updating[id]; updating || m.streams[id] != strm {
+			return
+		}
+		if len(id) > 0 {
+			m.log.Infof("Stream '%v' has terminated.\n", id)
+		}
+		m.closeTerminated(id)
+	}()
+}
+
+// closeTerminated closes the terminated channel of a stream ID if one exists,
+// must be called with the lock held.
+func (m *Type) closeTerminated(id string) {
+	if c, exists := m.terminated[id]; exists {
+		close(c)
+		delete(m.terminated, id)
+	}
+}
+
+// Terminated returns a channel that is closed when the stream of an ID
+// terminates whilst it is still being managed, or is lost because an update
+// failed to roll back. Streams that are stopped in order to be updated, deleted
+// or when the manager stops are not considered terminated.
+func (m *Type) Terminated(id string) <-chan struct{} {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	c, exists := m.terminated[id]
+	if !exists {
+		c = make(chan struct{})
+		m.terminated[id] = c
+	}
+	return c
+}
+
+// Read obtains the status of a stream by its ID. Returns an error if the
+// stream was not found.
+func (m *Type) Read(id string) (StreamStatus, error) {
+	m.lock.Lock()
+	strm, exists := m.streams[id]
+	m.lock.Unlock()
+
+	if !exists {
+		return StreamStatus{}, ErrStreamDoesNotExist
+	}
+	return newStreamStatus(strm), nil
+}
+
+// Update attempts to replace the configuration of a stream by its ID. The new
+// configuration is validated with a dry run before the existing stream is
+// drained and stopped, which only checks components that bind sockets, touch
+// the filesystem or share the default HTTP server structurally. Those are
+// constructed along with the new stream once the existing stream has stopped.
+// If the new stream fails to be created the existing configuration is restored
+// and an error is returned.
+func (m *Type) Update(id string, conf stream.Config, timeout time.Duration) error {
+	m.lock.Lock()
+	oldStrm, exists := m.streams[id]
+	_, updating := m.updating[id]
+	if exists && !updating {
+		m.updating[id] = struct{}{}
+	}
+	m.lock.Unlock()
+
+	if !exists {
+		return ErrStreamDoesNotExist
+	}
+	if updating {
+		return ErrStreamUpdating
+	}
+	defer func() {
+		m.lock.Lock()
+		delete(m.updating, id)
+		m.lock.Unlock()
+	}()
+
+	if errs := stream.DryRun(conf, m.log.NewModule("."+id), metrics.DudType{}); len(errs) > 0 {
+		return fmt.Errorf("invalid config: %v", strings.Join(errs, ", "))
+	}
+
+	// The existing stream remains managed whilst it is drained, and if it
+	// fails to stop we must not run another stream alongside it.
+	if err := stopGracefully(oldStrm, timeout); err != nil {
+		m.log.Errorf("Stream '%v' failed to stop for update: %v\n", id, err)
+		return fmt.Errorf("failed to stop existing stream: %v", err)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.streams[id] != oldStrm {
+		// The manager was stopped whilst the stream was draining.
+		return ErrStreamDoesNotExist
+	}
+
+	strm, err := m.newStream(id, conf)
+	if err != nil {
+		m.log.Errorf("Failed to update stream '%v', rolling back: %v\n", id, err)
+		if strm, err := m.newStream(id, oldStrm.Config()); err == nil {
+			m.streams[id] = strm
+			m.watch(id, strm)
+		} else {
+			m.log.Errorf("Failed to roll back stream '%v': %v\n", id, err)
+			delete(m.streams, id)
+			m.closeTerminated(id)
+		}
+		return err
+	}
+	m.streams[id] = strm
+	m.watch(id, strm)
+	return nil
+}
+
+// IDs returns a sorted slice of the IDs of all streams being managed.
+func (m *Type) IDs() []string {
+	m.lock.Lock()
+	ids := make([]string, 0, len(m.streams))
+	for id := range m.streams {
+		ids = append(ids, id)
+	}
+	m.lock.Unlock()
+
+	sort.Strings(ids)
+	return ids
+}
+
+// Delete attempts to drain, stop and remove a stream by its ID. Returns an error
+// if the stream was not found, is being updated, or if clean up fails in the
+// specified time period.
+func (m *Type) Delete(id string, timeout time.Duration) error {
+	m.lock.Lock()
+	strm, exists := m.streams[id]
+	_, updating := m.updating[id]
+	if exists && !updating {
+		delete(m.streams, id)
+	}
+	m.lock.Unlock()
+
+	if !exists {
+		return ErrStreamDoesNotExist
+	}
+	if updating {
+		return ErrStreamUpdating
+	}
+	return stopGracefully(strm, timeout)
+}
+
+// Stop attempts to gracefully shut down all active streams and close the
+// stream manager within the specified timeout.
+func (m *Type) Stop(timeout time.Duration) error {
+	m.lock.Lock()
+	streams := m.streams
+	m.streams = map[string]*stream.Type{}
+	m.lock.Unlock()
+
+	errChan := make(chan error, len(streams))
+	for k, v := range streams {
+		go func(id string, strm *stream.Type) {
+			err := strm.Stop(timeout)
+			if err != nil {
+				m.log.Errorf("Failed to stop stream '%v': %v\n", id, err)
+			}
+			errChan <- err
+		}(k, v)
+	}
+
+	failed := false
+	for range streams {
+		if err := <-errChan; err != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
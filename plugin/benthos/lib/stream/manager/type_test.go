@@ -0,0 +1,297 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/stream"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+type recordedStats struct {
+	metrics.DudType
+	sync.Mutex
+	paths map[string]struct{}
+}
+
+func (r *recordedStats) Incr(path string, count int64) error {
+	r.Lock()
+	r.paths[path] = struct{}{}
+	r.Unlock()
+	return nil
+}
+
+func (r *recordedStats) hasPrefix(prefix string) bool {
+	r.Lock()
+	defer r.Unlock()
+	for k := range r.paths {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func fileStreamConf(t *testing.T, dir, id string) stream.Config {
+	inPath := filepath.Join(dir, id+"_in.txt")
+	if err := ioutil.WriteFile(inPath, []byte("foo\nbar\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	conf := stream.NewConfig()
+	conf.Input.Type = "file"
+	conf.Input.File.Path = inPath
+	conf.Output.Type = "file"
+	conf.Output.File.Path = filepath.Join(dir, id+"_out.txt")
+	return conf
+}
+
+//------------------------------------------------------------------------------
+
+func TestTypeBasicOperations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_manager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stats := &recordedStats{paths: map[string]struct{}{}}
+	mgr := New(log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), stats)
+
+	if err = mgr.Create("foo", fileStreamConf(t, dir, "foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err = mgr.Create("bar", fileStreamConf(t, dir, "bar")); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := ErrStreamExists, mgr.Create("foo", fileStreamConf(t, dir, "foo")); exp != act {
+		t.Errorf("Wrong error returned: %v != %v", act, exp)
+	}
+
+	if exp, act := []string{"bar", "foo"}, mgr.IDs(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong stream IDs: %v != %v", act, exp)
+	}
+
+	for _, id := range []string{"foo", "bar"} {
+		for i := 0; !stats.hasPrefix(id+".output."); i++ {
+			if i >= 500 {
+				t.Fatalf("Timed out waiting for stream '%v' output metrics", id)
+			}
+			<-time.After(time.Millisecond * 10)
+		}
+	}
+
+	if err = mgr.Delete("foo", time.Second*5); err != nil {
+		t.Error(err)
+	}
+	if exp, act := ErrStreamDoesNotExist, mgr.Delete("foo", time.Second); exp != act {
+		t.Errorf("Wrong error returned: %v != %v", act, exp)
+	}
+	if exp, act := []string{"bar"}, mgr.IDs(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong stream IDs: %v != %v", act, exp)
+	}
+
+	if err = mgr.Stop(time.Second * 5); err != nil {
+		t.Error(err)
+	}
+	if exp, act := []string{}, mgr.IDs(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong stream IDs: %v != %v", act, exp)
+	}
+
+	for _, id := range []string{"foo", "bar"} {
+		if !stats.hasPrefix(id + ".input.") {
+			t.Errorf("Expected metrics namespaced by stream '%v'", id)
+		}
+	}
+	if stats.hasPrefix("input.") {
+		t.Error("Expected no metrics without a stream namespace")
+	}
+}
+
+func TestTypeBadStream(t *testing.T) {
+	mgr := New(log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+
+	conf := stream.NewConfig()
+	conf.Output.Type = "does_not_exist"
+
+	if err := mgr.Create("foo", conf); err == nil {
+		t.Error("Expected error from bad stream config")
+	}
+	if exp, act := []string{}, mgr.IDs(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong stream IDs: %v != %v", act, exp)
+	}
+}
+
+func TestTypeUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_manager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mgr := New(log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	defer mgr.Stop(time.Second)
+
+	// An input that never ends keeps the stream running.
+	conf := stream.NewConfig()
+	conf.Input.Type = "http_server"
+	conf.Input.HTTPServer.Address = "127.0.0.1:0"
+	conf.Output.Type = "file"
+	conf.Output.File.Path = filepath.Join(dir, "out.txt")
+
+	if err = mgr.Create("foo", conf); err != nil {
+		t.Fatal(err)
+	}
+	terminated := mgr.Terminated("foo")
+
+	mgr.lock.Lock()
+	oldStrm := mgr.streams["foo"]
+	mgr.lock.Unlock()
+
+	badConf := stream.NewConfig()
+	badConf.Output.Type = "does_not_exist"
+	if err = mgr.Update("foo", badConf, time.Second*5); err == nil {
+		t.Error("Expected error from bad stream config")
+	}
+
+	mgr.lock.Lock()
+	currentStrm := mgr.streams["foo"]
+	mgr.lock.Unlock()
+
+	if currentStrm != oldStrm {
+		t.Error("Expected existing stream to be untouched by a bad update")
+	}
+	if !oldStrm.Active() {
+		t.Error("Expected existing stream to still be active")
+	}
+
+	mgr.lock.Lock()
+	mgr.updating["foo"] = struct{}{}
+	mgr.lock.Unlock()
+
+	if exp, act := ErrStreamUpdating, mgr.Update("foo", conf, time.Second); exp != act {
+		t.Errorf("Wrong error returned: %v != %v", act, exp)
+	}
+	if exp, act := ErrStreamUpdating, mgr.Delete("foo", time.Second); exp != act {
+		t.Errorf("Wrong error returned: %v != %v", act, exp)
+	}
+
+	mgr.lock.Lock()
+	delete(mgr.updating, "foo")
+	mgr.lock.Unlock()
+
+	conf.Output.File.Path = filepath.Join(dir, "out_2.txt")
+	if err = mgr.Update("foo", conf, time.Second*5); err != nil {
+		t.Fatal(err)
+	}
+	if oldStrm.Active() {
+		t.Error("Expected previous stream to be stopped")
+	}
+	status, err := mgr.Read("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := conf.Output.File.Path, status.Config.Output.File.Path; exp != act {
+		t.Errorf("Wrong config after update: %v != %v", act, exp)
+	}
+
+	select {
+	case <-terminated:
+		t.Error("Expected stream replaced by an update to not be terminated")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestTypeSharedEndpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_manager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mgr := New(log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	defer mgr.Stop(time.Second)
+
+	// Without an address the input binds its path to the default server.
+	conf := stream.NewConfig()
+	conf.Input.Type = "http_server"
+	conf.Input.HTTPServer.Path = "/manager_test/shared"
+	conf.Output.Type = "file"
+	conf.Output.File.Path = filepath.Join(dir, "out.txt")
+
+	if err = mgr.Create("foo", conf); err != nil {
+		t.Fatal(err)
+	}
+	if err = mgr.Create("bar", conf); err == nil {
+		t.Error("Expected error from a second stream binding the same path")
+	}
+
+	conf.Output.File.Path = filepath.Join(dir, "out_2.txt")
+	if err = mgr.Update("foo", conf, time.Second*5); err != nil {
+		t.Fatal(err)
+	}
+	if err = mgr.Delete("foo", time.Second*5); err != nil {
+		t.Fatal(err)
+	}
+	if err = mgr.Create("foo", conf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTypeEmptyID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_manager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stats := &recordedStats{paths: map[string]struct{}{}}
+	mgr := New(log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), stats)
+	defer mgr.Stop(time.Second)
+
+	terminated := mgr.Terminated("")
+	if err = mgr.Create("", fileStreamConf(t, dir, "foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file input ends after reading the file, terminating the stream.
+	select {
+	case <-terminated:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for stream to terminate")
+	}
+
+	if !stats.hasPrefix("input.") {
+		t.Error("Expected metrics without a stream namespace")
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,282 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stream
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/buffer"
+	"github.com/Jeffail/benthos/lib/input"
+	"github.com/Jeffail/benthos/lib/output"
+	"github.com/Jeffail/benthos/lib/pipeline"
+	"github.com/Jeffail/benthos/lib/processor"
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	"github.com/Jeffail/benthos/lib/util/service/tracing"
+)
+
+//------------------------------------------------------------------------------
+
+// msgCounter is a processor that counts the messages that pass through it.
+type msgCounter struct {
+	count int64
+}
+
+// ProcessMessage increments the counter and returns the message unchanged.
+func (m *msgCounter) ProcessMessage(msg *types.Message) (*types.Message, types.Response, bool) {
+	atomic.AddInt64(&m.count, 1)
+	return msg, nil, true
+}
+
+// pipeline returns a constructor for a pipeline that feeds the counter.
+func (m *msgCounter) pipeline(log log.Modular) pipeline.ConstructorFunc {
+	return func() (pipeline.Type, error) {
+		return pipeline.NewProcessor(log, metrics.DudType{}, processor.Type(m)), nil
+	}
+}
+
+// tracedPipeline returns a constructor for a pipeline that executes the
+// processors of a stage and records spans for them with a tracer.
+func tracedPipeline(
+	stage, typeStr string,
+	confs []processor.Config,
+	tracer *tracing.Tracer,
+	log log.Modular,
+	stats metrics.Type,
+) pipeline.ConstructorFunc {
+	return func() (pipeline.Type, error) {
+		processors := make([]processor.Type, len(confs))
+		for i, procConf := range confs {
+			var err error
+			processors[i], err = processor.New(procConf, log.NewModule("."+typeStr), stats)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return pipeline.NewTracedProcessor(log, stats, tracer, stage, processors...), nil
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Type creates and manages the lifetime of a stream, which is an input coupled
+// to a buffer, which is in turn coupled to an output.
+type Type struct {
+	conf Config
+
+	inputLayer  input.Type
+	bufferLayer buffer.Type
+	outputLayer output.Type
+
+	// We have a tiered (t1) and a non-tiered (t2) pool. If the tiered pool
+	// cannot close within our allotted time period then we try closing the
+	// second non-tiered pool.
+	poolt1 *util.ClosablePool
+	poolt2 *util.ClosablePool
+
+	log   log.Modular
+	stats metrics.Type
+
+	started     time.Time
+	inputCount  *msgCounter
+	outputCount *msgCounter
+
+	terminatedChan chan struct{}
+}
+
+// New creates a new stream from a configuration, the stream begins processing
+// messages immediately.
+func New(conf Config, log log.Modular, stats metrics.Type) (*Type, error) {
+	return NewWithTracer(conf, log, stats, nil)
+}
+
+// NewWithTracer creates a new stream from a configuration where messages are
+// traced through the input and output layers with a tracer, which can be nil.
+// The stream begins processing messages immediately.
+func NewWithTracer(
+	conf Config,
+	log log.Modular,
+	stats metrics.Type,
+	tracer *tracing.Tracer,
+) (*Type, error) {
+	t := &Type{
+		conf:           conf,
+		poolt1:         util.NewClosablePool(),
+		poolt2:         util.NewClosablePool(),
+		log:            log,
+		stats:          stats,
+		started:        time.Now(),
+		inputCount:     &msgCounter{},
+		outputCount:    &msgCounter{},
+		terminatedChan: make(chan struct{}),
+	}
+
+	// When tracing, the processors of the input and output layers are
+	// executed within traced pipelines rather than by the layers themselves.
+	inConf, outConf := conf.Input, conf.Output
+	inPipes := []pipeline.ConstructorFunc{t.inputCount.pipeline(log)}
+	outPipes := []pipeline.ConstructorFunc{t.outputCount.pipeline(log)}
+	if tracer != nil {
+		inPipes = append([]pipeline.ConstructorFunc{tracedPipeline(
+			tracing.StageInput, inConf.Type, inConf.Processors, tracer, log, stats,
+		)}, inPipes...)
+		inConf.Processors = nil
+		outPipes = append([]pipeline.ConstructorFunc{tracedPipeline(
+			tracing.StageOutput, outConf.Type, outConf.Processors, tracer, log, stats,
+		)}, outPipes...)
+		outConf.Processors = nil
+	}
+
+	var err error
+	if t.inputLayer, err = input.New(inConf, log, stats, inPipes...); err != nil {
+		log.Errorf("Input error (%s): %v\n", conf.Input.Type, err)
+		return nil, err
+	}
+	t.poolt1.Add(1, t.inputLayer)
+	t.poolt2.Add(0, t.inputLayer)
+
+	if t.bufferLayer, err = buffer.New(conf.Buffer, log, stats); err != nil {
+		log.Errorf("Buffer error (%s): %v\n", conf.Buffer.Type, err)
+		t.inputLayer.CloseAsync()
+		return nil, err
+	}
+	t.poolt1.Add(3, t.bufferLayer)
+	t.poolt2.Add(0, t.bufferLayer)
+
+	if t.outputLayer, err = output.New(outConf, log, stats, outPipes...); err != nil {
+		log.Errorf("Output error (%s): %v\n", conf.Output.Type, err)
+		t.inputLayer.CloseAsync()
+		t.bufferLayer.CloseAsync()
+		return nil, err
+	}
+	t.poolt1.Add(10, t.outputLayer)
+	t.poolt2.Add(0, t.outputLayer)
+
+	if err = util.Couple(t.bufferLayer, t.outputLayer); err != nil {
+		t.poolt2.Close(time.Second)
+		return nil, err
+	}
+	if err = util.Couple(t.inputLayer, t.bufferLayer); err != nil {
+		t.poolt2.Close(time.Second)
+		return nil, err
+	}
+
+	go func() {
+		for {
+			if err := t.outputLayer.WaitForClose(time.Second * 60); err == nil {
+				close(t.terminatedChan)
+				return
+			}
+		}
+	}()
+
+	return t, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Config returns the configuration that the stream was created with.
+func (t *Type) Config() Config {
+	return t.conf
+}
+
+// Uptime returns the duration since the stream was created.
+func (t *Type) Uptime() time.Duration {
+	return time.Since(t.started)
+}
+
+// InputCount returns the number of messages that have been read from the input
+// layer of the stream, including any that are sent more than once due to
+// errors downstream.
+func (t *Type) InputCount() int64 {
+	return atomic.LoadInt64(&t.inputCount.count)
+}
+
+// OutputCount returns the number of messages that have been sent to the output
+// layer of the stream, including any that are sent more than once due to
+// errors.
+func (t *Type) OutputCount() int64 {
+	return atomic.LoadInt64(&t.outputCount.count)
+}
+
+// Active returns true if the stream is still running, or false if it has
+// terminated.
+func (t *Type) Active() bool {
+	select {
+	case <-t.terminatedChan:
+		return false
+	default:
+	}
+	return true
+}
+
+// Terminated returns a channel that is closed once the output layer of the
+// stream has closed, either because the stream was stopped or because the
+// pipeline has run to completion.
+func (t *Type) Terminated() <-chan struct{} {
+	return t.terminatedChan
+}
+
+// StopGracefully attempts to close the stream in the most graceful way by only
+// closing the input layer and waiting for all other layers to terminate by
+// proxy. This should guarantee that all in-flight and buffered data is
+// resolved before shutting down.
+func (t *Type) StopGracefully(timeout time.Duration) error {
+	t.inputLayer.CloseAsync()
+	select {
+	case <-t.terminatedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+// StopOrdered attempts to close all components of the stream in the order of
+// their positions in the pipeline, starting with the input layer.
+func (t *Type) StopOrdered(timeout time.Duration) error {
+	return t.poolt1.Close(timeout)
+}
+
+// StopUnordered attempts to close all components of the stream in parallel
+// without allowing the stream to gracefully wind down in the order of
+// component layers.
+func (t *Type) StopUnordered(timeout time.Duration) error {
+	return t.poolt2.Close(timeout)
+}
+
+// Stop attempts to close the stream within the specified timeout period.
+// Initially the stream attempts to close in order, if that fails within half
+// of the timeout period then all remaining components are closed in parallel.
+func (t *Type) Stop(timeout time.Duration) error {
+	if err := t.StopOrdered(timeout / 2); err != nil {
+		t.log.Warnln(
+			"Stream failed to close using ordered tiers, you may receive a " +
+				"duplicate message on the next start.",
+		)
+		return t.StopUnordered(timeout / 2)
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
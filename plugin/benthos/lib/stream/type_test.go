@@ -0,0 +1,137 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stream
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//------------------------------------------------------------------------------
+
+func TestConfigDefaults(t *testing.T) {
+	confs := map[string]Config{}
+	if err := yaml.Unmarshal([]byte(`
+foo:
+  input:
+    type: file
+    file:
+      path: /tmp/foo
+`), &confs); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"bar":{"output":{"type":"file"}}}`), &confs); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, act := "/tmp/foo", confs["foo"].Input.File.Path; exp != act {
+		t.Errorf("Wrong input path: %v != %v", act, exp)
+	}
+	if exp, act := "stdout", confs["foo"].Output.Type; exp != act {
+		t.Errorf("Wrong default output type: %v != %v", act, exp)
+	}
+	if exp, act := "stdin", confs["bar"].Input.Type; exp != act {
+		t.Errorf("Wrong default input type: %v != %v", act, exp)
+	}
+	if exp, act := "file", confs["bar"].Output.Type; exp != act {
+		t.Errorf("Wrong output type: %v != %v", act, exp)
+	}
+	if exp, act := "none", confs["bar"].Buffer.Type; exp != act {
+		t.Errorf("Wrong default buffer type: %v != %v", act, exp)
+	}
+}
+
+func TestTypeFileToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_stream_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inPath, outPath := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt")
+	if err = ioutil.WriteFile(inPath, []byte("foo\nbar\nbaz\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewConfig()
+	conf.Input.Type = "file"
+	conf.Input.File.Path = inPath
+	conf.Buffer.Type = "memory"
+	conf.Output.Type = "file"
+	conf.Output.File.Path = outPath
+
+	strm, err := New(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-strm.Terminated():
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for stream to terminate")
+	}
+	if err = strm.Stop(time.Second); err != nil {
+		t.Error(err)
+	}
+	if strm.Active() {
+		t.Error("Expected stream to be inactive")
+	}
+	if exp, act := int64(3), strm.InputCount(); exp != act {
+		t.Errorf("Wrong input count: %v != %v", act, exp)
+	}
+	if exp, act := int64(3), strm.OutputCount(); exp != act {
+		t.Errorf("Wrong output count: %v != %v", act, exp)
+	}
+
+	outBytes, err := ioutil.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "foo\nbar\nbaz\n", string(outBytes); exp != act {
+		t.Errorf("Wrong output: %q != %q", act, exp)
+	}
+}
+
+func TestTypeBadConfig(t *testing.T) {
+	conf := NewConfig()
+	conf.Input.Type = "does_not_exist"
+
+	if _, err := New(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad input type")
+	}
+
+	conf = NewConfig()
+	conf.Output.Type = "does_not_exist"
+
+	if _, err := New(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}), metrics.DudType{}); err == nil {
+		t.Error("Expected error from bad output type")
+	}
+}
+
+//------------------------------------------------------------------------------
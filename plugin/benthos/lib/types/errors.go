@@ -34,6 +34,7 @@ var (
 	ErrTypeClosed = errors.New("type was closed")
 
 	ErrInvalidProcessorType = errors.New("processor type was not recognised")
+	ErrInvalidConditionType = errors.New("condition type was not recognised")
 	ErrInvalidBufferType    = errors.New("buffer type was not recognised")
 	ErrInvalidInputType     = errors.New("input type was not recognised")
 	ErrInvalidOutputType    = errors.New("output type was not recognised")
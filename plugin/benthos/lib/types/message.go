@@ -20,12 +20,17 @@
 
 package types
 
+import (
+	"sort"
+)
+
 //------------------------------------------------------------------------------
 
 // Message is a struct containing any relevant fields of a benthos message and
 // helper functions.
 type Message struct {
-	Parts [][]byte `json:"parts"`
+	Parts    [][]byte            `json:"parts"`
+	Metadata []map[string]string `json:"metadata,omitempty"`
 }
 
 // NewMessage initializes an empty message.
@@ -37,6 +42,63 @@ func NewMessage() Message {
 
 //------------------------------------------------------------------------------
 
+// GetMetadata returns the value of a metadata key of a message part, or an
+// empty string if the part or key does not exist.
+func (m *Message) GetMetadata(part int, key string) string {
+	if part < 0 || part >= len(m.Metadata) {
+		return ""
+	}
+	return m.Metadata[part][key]
+}
+
+// SetMetadata sets the value of a metadata key of a message part. Setting
+// metadata of a part index that does not exist has no effect.
+func (m *Message) SetMetadata(part int, key, value string) {
+	if part < 0 || part >= len(m.Parts) {
+		return
+	}
+	if len(m.Metadata) < len(m.Parts) {
+		newMeta := make([]map[string]string, len(m.Parts))
+		copy(newMeta, m.Metadata)
+		m.Metadata = newMeta
+	}
+	if m.Metadata[part] == nil {
+		m.Metadata[part] = map[string]string{}
+	}
+	m.Metadata[part][key] = value
+}
+
+// PartMetadata returns the full metadata map of a message part, which might be
+// nil. The returned map should not be modified.
+func (m *Message) PartMetadata(part int) map[string]string {
+	if part < 0 || part >= len(m.Metadata) {
+		return nil
+	}
+	return m.Metadata[part]
+}
+
+// CopyMetadata returns a deep copy of the metadata of a message, allowing a new
+// message to be created with metadata that can be modified without affecting
+// the original, which might be resent on error.
+func (m *Message) CopyMetadata() []map[string]string {
+	if len(m.Metadata) == 0 {
+		return nil
+	}
+	meta := make([]map[string]string, len(m.Metadata))
+	for i, partMeta := range m.Metadata {
+		if partMeta == nil {
+			continue
+		}
+		meta[i] = make(map[string]string, len(partMeta))
+		for k, v := range partMeta {
+			meta[i][k] = v
+		}
+	}
+	return meta
+}
+
+//------------------------------------------------------------------------------
+
 /*
 Internal message blob format:
 
@@ -45,7 +107,7 @@ Internal message blob format:
     + Four bytes containing length of message part in big endian
     + Content of message part
 
-                                         # Of bytes in message 2
+                                         # Of bytes in message 2
                                          |
 # Of message parts (big endian)          |           Content of message 2
 |                                        |           |
@@ -57,44 +119,125 @@ v                                        v           v
               |           Content of message 1
               |
               # Of bytes in message 1 (big endian)
+
+Messages that carry metadata are serialised in a versioned format, which is
+prefixed with four bytes containing the format version with the most
+significant bit set. The legacy format above can never begin with this bit set
+as the number of parts must be smaller than the length of the blob.
+
+Version 1:
+
+- Four bytes containing the version (0x80000001) in big endian
+- The message parts as described in the legacy format above
+- For each message part:
+    + Four bytes containing the number of metadata pairs in big endian
+    + For each metadata pair, sorted by key:
+        * Four bytes containing the length of the key in big endian
+        * Content of the key
+        * Four bytes containing the length of the value in big endian
+        * Content of the value
 */
 
 // Reserve bytes for our length counter (4 * 8 = 32 bit)
 var intLen uint32 = 4
 
-// Bytes serialises the message into a single byte array.
+const (
+	blobVersionFlag uint32 = 0x80000000
+	blobVersion     uint32 = 1
+)
+
+func putUint32(b []byte, v uint32) []byte {
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
+	return b[intLen:]
+}
+
+func readUint32(b []byte) (uint32, []byte, error) {
+	if uint32(len(b)) < intLen {
+		return 0, nil, ErrBadMessageBytes
+	}
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]), b[intLen:], nil
+}
+
+func readSized(b []byte) ([]byte, []byte, error) {
+	size, b, err := readUint32(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint32(len(b)) < size {
+		return nil, nil, ErrBadMessageBytes
+	}
+	return b[:size], b[size:], nil
+}
+
+// hasMetadata returns true if any part of the message has metadata.
+func (m *Message) hasMetadata() bool {
+	for _, meta := range m.Metadata {
+		if len(meta) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Bytes serialises the message into a single byte array. Messages without
+// metadata are serialised in the legacy format.
 func (m *Message) Bytes() []byte {
 	lenParts := uint32(len(m.Parts))
+	withMeta := m.hasMetadata()
 
 	l := (lenParts + 1) * intLen
 	for i := range m.Parts {
 		l += uint32(len(m.Parts[i]))
 	}
+
+	var metaKeys [][]string
+	if withMeta {
+		l += intLen * (lenParts + 1)
+		metaKeys = make([][]string, lenParts)
+		for i := range m.Parts {
+			meta := m.PartMetadata(i)
+			keys := make([]string, 0, len(meta))
+			for k, v := range meta {
+				keys = append(keys, k)
+				l += intLen*2 + uint32(len(k)+len(v))
+			}
+			sort.Strings(keys)
+			metaKeys[i] = keys
+		}
+	}
 	b := make([]byte, l)
 
-	b[0] = byte(lenParts >> 24)
-	b[1] = byte(lenParts >> 16)
-	b[2] = byte(lenParts >> 8)
-	b[3] = byte(lenParts)
+	b2 := b
+	if withMeta {
+		b2 = putUint32(b2, blobVersionFlag|blobVersion)
+	}
+	b2 = putUint32(b2, lenParts)
 
-	b2 := b[intLen:]
 	for i := range m.Parts {
-		le := uint32(len(m.Parts[i]))
-
-		b2[0] = byte(le >> 24)
-		b2[1] = byte(le >> 16)
-		b2[2] = byte(le >> 8)
-		b2[3] = byte(le)
-
-		b2 = b2[intLen:]
+		b2 = putUint32(b2, uint32(len(m.Parts[i])))
 
 		copy(b2, m.Parts[i])
 		b2 = b2[len(m.Parts[i]):]
 	}
+
+	for i, keys := range metaKeys {
+		meta := m.PartMetadata(i)
+		b2 = putUint32(b2, uint32(len(keys)))
+		for _, k := range keys {
+			b2 = putUint32(b2, uint32(len(k)))
+			b2 = b2[copy(b2, k):]
+			b2 = putUint32(b2, uint32(len(meta[k])))
+			b2 = b2[copy(b2, meta[k]):]
+		}
+	}
 	return b
 }
 
-// FromBytes deserialises a Message from a byte array.
+// FromBytes deserialises a Message from a byte array, the array can be in
+// either the legacy or a versioned format.
 func FromBytes(b []byte) (Message, error) {
 	var m Message
 
@@ -103,6 +246,21 @@ func FromBytes(b []byte) (Message, error) {
 	}
 
 	numParts := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+
+	withMeta := false
+	if numParts&blobVersionFlag != 0 {
+		if numParts != blobVersionFlag|blobVersion {
+			return m, ErrBadMessageBytes
+		}
+		withMeta = true
+		b = b[4:]
+
+		var err error
+		if numParts, _, err = readUint32(b); err != nil {
+			return m, err
+		}
+	}
+
 	if numParts >= uint32(len(b)) {
 		return m, ErrBadMessageBytes
 	}
@@ -124,6 +282,37 @@ func FromBytes(b []byte) (Message, error) {
 		m.Parts[i] = b[:partSize]
 		b = b[partSize:]
 	}
+
+	if !withMeta {
+		return m, nil
+	}
+
+	m.Metadata = make([]map[string]string, numParts)
+	for i := uint32(0); i < numParts; i++ {
+		var numPairs uint32
+		var err error
+		if numPairs, b, err = readUint32(b); err != nil {
+			return m, err
+		}
+		if numPairs == 0 {
+			continue
+		}
+		if numPairs > uint32(len(b)) {
+			return m, ErrBadMessageBytes
+		}
+		meta := make(map[string]string, numPairs)
+		for j := uint32(0); j < numPairs; j++ {
+			var k, v []byte
+			if k, b, err = readSized(b); err != nil {
+				return m, err
+			}
+			if v, b, err = readSized(b); err != nil {
+				return m, err
+			}
+			meta[string(k)] = string(v)
+		}
+		m.Metadata[i] = meta
+	}
 	return m, nil
 }
 
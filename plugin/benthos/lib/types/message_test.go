@@ -72,3 +72,130 @@ func TestMessageInvalidBytesFormat(t *testing.T) {
 		}
 	}
 }
+
+func TestMessageMetadata(t *testing.T) {
+	m := Message{
+		Parts: [][]byte{
+			[]byte("hello"),
+			[]byte("world"),
+		},
+	}
+
+	if exp, act := "", m.GetMetadata(0, "foo"); exp != act {
+		t.Errorf("Wrong metadata value: %v != %v", act, exp)
+	}
+
+	m.SetMetadata(1, "foo", "bar")
+	m.SetMetadata(5, "foo", "baz")
+
+	if exp, act := "bar", m.GetMetadata(1, "foo"); exp != act {
+		t.Errorf("Wrong metadata value: %v != %v", act, exp)
+	}
+	if exp, act := "", m.GetMetadata(0, "foo"); exp != act {
+		t.Errorf("Wrong metadata value: %v != %v", act, exp)
+	}
+	if exp, act := "", m.GetMetadata(5, "foo"); exp != act {
+		t.Errorf("Wrong metadata value: %v != %v", act, exp)
+	}
+	if exp, act := 2, len(m.Metadata); exp != act {
+		t.Errorf("Wrong metadata length: %v != %v", act, exp)
+	}
+	if m.PartMetadata(0) != nil {
+		t.Errorf("Expected nil metadata: %v", m.PartMetadata(0))
+	}
+}
+
+func TestMessageCopyMetadata(t *testing.T) {
+	m := Message{
+		Parts: [][]byte{
+			[]byte("hello"),
+			[]byte("world"),
+		},
+	}
+	if meta := m.CopyMetadata(); meta != nil {
+		t.Errorf("Expected nil metadata: %v", meta)
+	}
+
+	m.SetMetadata(1, "foo", "bar")
+
+	c := Message{Parts: m.Parts, Metadata: m.CopyMetadata()}
+	c.SetMetadata(1, "foo", "baz")
+	c.SetMetadata(0, "foo", "qux")
+
+	if exp, act := "bar", m.GetMetadata(1, "foo"); exp != act {
+		t.Errorf("Original metadata was modified: %v != %v", act, exp)
+	}
+	if m.PartMetadata(0) != nil {
+		t.Errorf("Expected nil metadata: %v", m.PartMetadata(0))
+	}
+	if exp, act := "baz", c.GetMetadata(1, "foo"); exp != act {
+		t.Errorf("Wrong metadata value: %v != %v", act, exp)
+	}
+}
+
+func TestMessageMetadataSerialization(t *testing.T) {
+	m := Message{
+		Parts: [][]byte{
+			[]byte("hello"),
+			[]byte("world"),
+			[]byte("12345"),
+		},
+	}
+	m.SetMetadata(0, "kafka_key", "foo")
+	m.SetMetadata(0, "kafka_partition", "3")
+	m.SetMetadata(2, "empty", "")
+
+	b := m.Bytes()
+	if exp, act := []byte{0x80, 0x00, 0x00, 0x01}, b[:4]; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong version header: %v != %v", act, exp)
+	}
+
+	m2, err := FromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(m.Parts, m2.Parts) {
+		t.Errorf("Parts not equal: %s != %s", m2.Parts, m.Parts)
+	}
+	for i := range m.Parts {
+		if exp, act := m.PartMetadata(i), m2.PartMetadata(i); !reflect.DeepEqual(exp, act) {
+			t.Errorf("Metadata of part %v not equal: %v != %v", i, act, exp)
+		}
+	}
+}
+
+func TestMessageLegacySerialization(t *testing.T) {
+	m := Message{
+		Parts: [][]byte{
+			[]byte("hello"),
+			[]byte("world"),
+		},
+		Metadata: []map[string]string{nil, {}},
+	}
+
+	exp := []byte{
+		0, 0, 0, 2,
+		0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o',
+		0, 0, 0, 5, 'w', 'o', 'r', 'l', 'd',
+	}
+	if act := m.Bytes(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong serialised bytes: %v != %v", act, exp)
+	}
+}
+
+func TestMessageInvalidVersionedBytesFormat(t *testing.T) {
+	cases := [][]byte{
+		{0x80, 0x00, 0x00, 0x01},
+		{0x80, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00},
+		{0x80, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00},
+		{0x80, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+		{0x80, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 'a'},
+	}
+
+	for _, c := range cases {
+		if _, err := FromBytes(c); err == nil {
+			t.Errorf("Received nil error from invalid byte sequence: %v", c)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+//------------------------------------------------------------------------------
+
+// ErrPathBound is returned when binding a handler to a path of the default
+// server that is already bound to another handler.
+var ErrPathBound = errors.New("path is already bound to a handler")
+
+// slot is the handler of a path registered with the default server, which can
+// be swapped out after registration.
+type slot struct {
+	sync.RWMutex
+	handler http.HandlerFunc
+}
+
+func (s *slot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.RLock()
+	handler := s.handler
+	s.RUnlock()
+
+	if handler == nil {
+		http.NotFound(w, r)
+		return
+	}
+	handler(w, r)
+}
+
+var (
+	slotsMut sync.Mutex
+	slots    = map[string]*slot{}
+)
+
+// register adds a slot to the default server, converting the panic caused by
+// a duplicate pattern into an error.
+func register(path string, s *slot) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to register path '%v': %v", path, r)
+		}
+	}()
+	http.Handle(path, s)
+	return nil
+}
+
+// Bind sets the handler of a path of the default HTTP server. Paths can be
+// bound again once released, which allows components that share the default
+// server to be closed and recreated. Returns a func that releases the path, or
+// an error if the path is already bound.
+func Bind(path string, handler http.HandlerFunc) (func(), error) {
+	slotsMut.Lock()
+	defer slotsMut.Unlock()
+
+	s, exists := slots[path]
+	if !exists {
+		s = &slot{}
+		if err := register(path, s); err != nil {
+			return nil, err
+		}
+		slots[path] = s
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.handler != nil {
+		return nil, fmt.Errorf("failed to bind path '%v': %v", path, ErrPathBound)
+	}
+	s.handler = handler
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.Lock()
+			s.handler = nil
+			s.Unlock()
+		})
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Bindings is a set of paths bound to the default HTTP server by a component,
+// which can be released together once the component is closed. The zero value
+// is ready to use.
+type Bindings struct {
+	mut      sync.Mutex
+	releases []func()
+}
+
+// Bind sets the handler of a path of the default HTTP server and adds it to
+// the set.
+func (b *Bindings) Bind(path string, handler http.HandlerFunc) error {
+	release, err := Bind(path, handler)
+	if err != nil {
+		return err
+	}
+	b.mut.Lock()
+	b.releases = append(b.releases, release)
+	b.mut.Unlock()
+	return nil
+}
+
+// Release releases each path of the set, allowing them to be bound again.
+func (b *Bindings) Release() {
+	b.mut.Lock()
+	for _, release := range b.releases {
+		release()
+	}
+	b.releases = nil
+	b.mut.Unlock()
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,80 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package endpoint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func testRequest(path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestBindRelease(t *testing.T) {
+	path := "/endpoint_test/bind"
+
+	var b Bindings
+	if err := b.Bind(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("foo"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "foo", testRequest(path).Body.String(); exp != act {
+		t.Errorf("Wrong response: %v != %v", act, exp)
+	}
+
+	if _, err := Bind(path, func(w http.ResponseWriter, r *http.Request) {}); err == nil {
+		t.Error("Expected error from binding a bound path")
+	}
+
+	b.Release()
+	if exp, act := http.StatusNotFound, testRequest(path).Code; exp != act {
+		t.Errorf("Wrong status after release: %v != %v", act, exp)
+	}
+
+	release, err := Bind(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("bar"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if exp, act := "bar", testRequest(path).Body.String(); exp != act {
+		t.Errorf("Wrong response: %v != %v", act, exp)
+	}
+}
+
+func TestBindRegisteredPath(t *testing.T) {
+	path := "/endpoint_test/registered"
+	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {})
+
+	if _, err := Bind(path, func(w http.ResponseWriter, r *http.Request) {}); err == nil {
+		t.Error("Expected error from binding a path registered elsewhere")
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,343 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+//------------------------------------------------------------------------------
+
+// Linter checks a config file against the structure that it is read into and
+// reports any content that would otherwise be silently ignored or fall back to
+// a default value. This includes unknown fields, values of the wrong type,
+// unrecognised `type` values and sections belonging to types that are not
+// selected, unless those sections match their default values.
+type Linter struct {
+	registry
+}
+
+// NewLinter creates a new Linter with no registered types.
+func NewLinter() *Linter {
+	return &Linter{
+		registry: newRegistry(),
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Lint reads a config file and returns a list of human readable lint errors
+// found when comparing its contents against the structure of config. An error
+// is returned if the file could not be read or parsed.
+func (l *Linter) Lint(path string, replaceEnvs bool, config interface{}) ([]string, error) {
+	configBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if replaceEnvs {
+		configBytes = ReplaceEnvVariables(configBytes)
+	}
+
+	var root interface{}
+	ext := filepath.Ext(path)
+	if ".js" == ext || ".json" == ext {
+		if err = json.Unmarshal(configBytes, &root); err != nil {
+			return nil, err
+		}
+		return l.LintValue(root, true, config), nil
+	} else if ".yml" == ext || ".yaml" == ext {
+		if err = yaml.Unmarshal(configBytes, &root); err != nil {
+			return nil, err
+		}
+		return l.LintValue(root, false, config), nil
+	}
+	return nil, fmt.Errorf("config file extension not recognised: %v", path)
+}
+
+// LintValue returns a list of lint errors found when comparing a generic
+// parsed config against the structure of config. When jsonTags is true fields
+// are matched by their JSON tags, otherwise by their YAML tags.
+func (l *Linter) LintValue(root interface{}, jsonTags bool, config interface{}) []string {
+	w := linter{Linter: l, jsonTags: jsonTags}
+	w.walk("", root, reflect.TypeOf(config))
+	return w.lints
+}
+
+//------------------------------------------------------------------------------
+
+// linter holds the state of a single lint run.
+type linter struct {
+	*Linter
+	jsonTags bool
+	lints    []string
+}
+
+func (l *linter) report(path, format string, args ...interface{}) {
+	if len(path) == 0 {
+		path = "root"
+	}
+	l.lints = append(l.lints, path+": "+fmt.Sprintf(format, args...))
+}
+
+func joinPath(path, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
+
+// fieldName returns the key that a struct field is parsed from, or an empty
+// string if the field is ignored.
+func fieldName(f reflect.StructField, jsonTags bool) (name string, inline bool) {
+	tag := f.Tag.Get("yaml")
+	if jsonTags {
+		tag = f.Tag.Get("json")
+	}
+	if tag == "-" {
+		return "", false
+	}
+	opts := strings.Split(tag, ",")
+	for _, opt := range opts[1:] {
+		if opt == "inline" {
+			return "", true
+		}
+	}
+	if len(opts[0]) > 0 {
+		return opts[0], false
+	}
+	return strings.ToLower(f.Name), false
+}
+
+// fields collects the parseable fields of a struct type, including those of
+// inlined structs, along with the types of any inlined structs.
+func (l *linter) fields(t reflect.Type, into map[string]reflect.StructField, inlined *[]reflect.Type) {
+	*inlined = append(*inlined, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 && !f.Anonymous {
+			continue
+		}
+		name, inline := fieldName(f, l.jsonTags)
+		if inline && f.Type.Kind() == reflect.Struct {
+			l.fields(f.Type, into, inlined)
+		} else if len(name) > 0 {
+			into[name] = f
+		}
+	}
+}
+
+func kindName(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int64, uint64, float64:
+		return "number"
+	case []interface{}:
+		return "list"
+	case map[interface{}]interface{}, map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// toMap converts the object types produced by either parser into a map with
+// string keys.
+func toMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		sm := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			sm[fmt.Sprintf("%v", k)] = v
+		}
+		return sm, true
+	}
+	return nil, false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isWhole(v interface{}) bool {
+	switch n := v.(type) {
+	case int, int64, uint64:
+		return true
+	case float64:
+		return n == float64(int64(n))
+	}
+	return false
+}
+
+// isDefault returns true if a generic value parses into the same structure as
+// a default value.
+func isDefault(def reflect.Value, v interface{}) bool {
+	defBytes, err := yaml.Marshal(def.Interface())
+	if err != nil {
+		return false
+	}
+	valBytes, err := yaml.Marshal(v)
+	if err != nil {
+		return false
+	}
+	expected, actual := reflect.New(def.Type()), reflect.New(def.Type())
+	if err = yaml.Unmarshal(defBytes, expected.Interface()); err != nil {
+		return false
+	}
+	if err = yaml.Unmarshal(defBytes, actual.Interface()); err != nil {
+		return false
+	}
+	if err = yaml.Unmarshal(valBytes, actual.Interface()); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(expected.Interface(), actual.Interface())
+}
+
+func (l *linter) walk(path string, v interface{}, t reflect.Type) {
+	if v == nil {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	expected := ""
+	switch t.Kind() {
+	case reflect.Struct:
+		l.walkStruct(path, v, t)
+		return
+	case reflect.Map:
+		m, ok := toMap(v)
+		if !ok {
+			expected = "object"
+			break
+		}
+		for _, k := range sortedKeys(m) {
+			l.walk(joinPath(path, k), m[k], t.Elem())
+		}
+		return
+	case reflect.Slice, reflect.Array:
+		s, ok := v.([]interface{})
+		if !ok {
+			expected = "list"
+			break
+		}
+		for i, e := range s {
+			l.walk(fmt.Sprintf("%v[%v]", path, i), e, t.Elem())
+		}
+		return
+	case reflect.String:
+		switch v.(type) {
+		case []interface{}, map[interface{}]interface{}, map[string]interface{}:
+			expected = "string"
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			expected = "bool"
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !isWhole(v) {
+			expected = "integer"
+		}
+	case reflect.Float32, reflect.Float64:
+		if kindName(v) != "number" {
+			expected = "number"
+		}
+	}
+	if len(expected) > 0 {
+		l.report(path, "expected %v, found %v", expected, kindName(v))
+	}
+}
+
+func (l *linter) walkStruct(path string, v interface{}, t reflect.Type) {
+	m, ok := toMap(v)
+	if !ok {
+		l.report(path, "expected object, found %v", kindName(v))
+		return
+	}
+
+	fields := map[string]reflect.StructField{}
+	inlined := []reflect.Type{}
+	l.fields(t, fields, &inlined)
+
+	// Find the section selected by a type field, if this struct has one.
+	var section *typedSection
+	selected := ""
+	for _, it := range inlined {
+		if ts, exists := l.typed[it]; exists {
+			section = &ts
+			selected = ts.defaultType
+			if s, isStr := m["type"].(string); isStr {
+				selected = s
+			}
+			if !ts.has(selected) {
+				l.report(joinPath(path, "type"), "unrecognised type '%v'", selected)
+			}
+			break
+		}
+	}
+
+	for _, k := range sortedKeys(m) {
+		f, exists := fields[k]
+		if !exists {
+			l.report(joinPath(path, k), "unknown field")
+			continue
+		}
+		if section != nil && k != selected && section.has(k) && section.hasExact(selected) {
+			if !isDefault(section.defaults.FieldByIndex(f.Index), m[k]) {
+				l.report(joinPath(path, k), "section is ignored as type is '%v'", selected)
+			}
+			continue
+		}
+		ft := f.Type
+		for _, it := range inlined {
+			if dv, isDynamic := l.dynamic[it][k]; isDynamic {
+				if ft.Kind() == reflect.Slice {
+					ft = reflect.SliceOf(dv.Type())
+				} else {
+					ft = dv.Type()
+				}
+				break
+			}
+		}
+		l.walk(joinPath(path, k), m[k], ft)
+	}
+}
+
+//------------------------------------------------------------------------------
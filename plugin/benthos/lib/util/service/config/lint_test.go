@@ -0,0 +1,253 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+type lintFooConfig struct {
+	Name  string `json:"name" yaml:"name"`
+	Count int    `json:"count" yaml:"count"`
+}
+
+type lintBarConfig struct {
+	Enabled bool     `json:"enabled" yaml:"enabled"`
+	Tags    []string `json:"tags" yaml:"tags"`
+}
+
+type lintComponentConfig struct {
+	Type string        `json:"type" yaml:"type"`
+	Foo  lintFooConfig `json:"foo" yaml:"foo"`
+	Bar  lintBarConfig `json:"bar" yaml:"bar"`
+}
+
+func newLintComponentConfig() lintComponentConfig {
+	return lintComponentConfig{
+		Type: "foo",
+		Foo: lintFooConfig{
+			Name:  "default",
+			Count: 10,
+		},
+		Bar: lintBarConfig{
+			Enabled: false,
+			Tags:    []string{},
+		},
+	}
+}
+
+type lintInlineConfig struct {
+	lintComponentConfig `json:",inline" yaml:",inline"`
+}
+
+type lintBrokerConfig struct {
+	Children []interface{}    `json:"children" yaml:"children"`
+	Inline   lintInlineConfig `json:"inline" yaml:"inline"`
+}
+
+type lintRootConfig struct {
+	Component lintComponentConfig `json:"component" yaml:"component"`
+	Broker    lintBrokerConfig    `json:"broker" yaml:"broker"`
+	Ratio     float64             `json:"ratio" yaml:"ratio"`
+	Free      interface{}         `json:"free" yaml:"free"`
+}
+
+func newTestLinter() *Linter {
+	l := NewLinter()
+	l.RegisterTypes(newLintComponentConfig(), []string{"foo", "bar", "baz", "ditto*"})
+	l.RegisterDynamic(lintBrokerConfig{}, "children", newLintComponentConfig())
+	return l
+}
+
+//------------------------------------------------------------------------------
+
+func TestLintYAML(t *testing.T) {
+	tests := map[string][]string{
+		`
+component:
+  type: foo
+  foo:
+    name: hello
+    count: 5
+ratio: 0.5
+free:
+  anything: [goes, here]
+`: nil,
+		`
+component:
+  type: foo
+  foo:
+    nmae: hello
+  qux: nope
+`: {
+			"component.foo.nmae: unknown field",
+			"component.qux: unknown field",
+		},
+		`
+component:
+  foo:
+    count: 1.5
+  bar:
+    enabled: true
+ratio: nope
+`: {
+			"component.bar: section is ignored as type is 'foo'",
+			"component.foo.count: expected integer, found number",
+			"ratio: expected number, found string",
+		},
+		`
+component:
+  type: bar
+  foo:
+    name: default
+    count: 10
+  bar:
+    enabled: yes please
+    tags: notalist
+`: {
+			"component.bar.enabled: expected bool, found string",
+			"component.bar.tags: expected list, found string",
+		},
+		`
+component:
+  type: nope
+`: {
+			"component.type: unrecognised type 'nope'",
+		},
+		`
+broker:
+  children:
+  - type: bar
+    bar:
+      enabled: true
+      tagz: []
+  - type: ditto_2
+    bar:
+      enabled: false
+  - type: nah
+  inline:
+    type: baz
+    foo:
+      name: changed
+`: {
+			"broker.children[0].bar.tagz: unknown field",
+			"broker.children[2].type: unrecognised type 'nah'",
+			"broker.inline.foo: section is ignored as type is 'baz'",
+		},
+		`
+- not
+- an
+- object
+`: {
+			"root: expected object, found list",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "benthos_lint_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	l := newTestLinter()
+
+	for conf, exp := range tests {
+		if err = ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+			t.Fatal(err)
+		}
+		act, err := l.Lint(path, false, lintRootConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(exp, act) {
+			t.Errorf("Wrong lints for config %v: %#v != %#v", conf, act, exp)
+		}
+	}
+}
+
+func TestLintJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_lint_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("BENTHOS_TEST_LINT_COUNT", "20")
+	defer os.Unsetenv("BENTHOS_TEST_LINT_COUNT")
+
+	path := filepath.Join(dir, "config.json")
+	conf := `{
+	"component": {
+		"type": "foo",
+		"foo": {
+			"count": ${BENTHOS_TEST_LINT_COUNT},
+			"name": 10
+		},
+		"bar": {
+			"enabled": true
+		}
+	},
+	"ratio": 1
+}`
+	if err = ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"component.bar: section is ignored as type is 'foo'",
+	}
+	act, err := newTestLinter().Lint(path, true, lintRootConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong lints: %#v != %#v", act, exp)
+	}
+
+	if _, err = newTestLinter().Lint(path, false, lintRootConfig{}); err == nil {
+		t.Error("Expected error from unreplaced environment variable")
+	}
+}
+
+func TestLintBadExtension(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benthos_lint_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.txt")
+	if err = ioutil.WriteFile(path, []byte("foo: bar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewLinter().Lint(path, false, lintRootConfig{}); err == nil {
+		t.Error("Expected error from unrecognised extension")
+	}
+}
+
+//------------------------------------------------------------------------------
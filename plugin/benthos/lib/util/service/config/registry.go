@@ -0,0 +1,134 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+//------------------------------------------------------------------------------
+
+// typedSection describes a config struct that selects one of a number of named
+// sections with a `type` field.
+type typedSection struct {
+	defaults    reflect.Value
+	defaultType string
+	names       map[string]struct{}
+	prefixes    []string
+}
+
+func (t typedSection) hasExact(name string) bool {
+	_, exists := t.names[name]
+	return exists
+}
+
+func (t typedSection) has(name string) bool {
+	if _, exists := t.names[name]; exists {
+		return true
+	}
+	for _, p := range t.prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// registry holds the config structs that select a section with a `type` field
+// and the generic fields that are later parsed into other config structs,
+// which together allow a config structure to be walked in its entirety.
+type registry struct {
+	typed           map[reflect.Type]typedSection
+	dynamic         map[reflect.Type]map[string]reflect.Value
+	dynamicDefaults map[reflect.Type]reflect.Value
+}
+
+func newRegistry() registry {
+	return registry{
+		typed:           map[reflect.Type]typedSection{},
+		dynamic:         map[reflect.Type]map[string]reflect.Value{},
+		dynamicDefaults: map[reflect.Type]reflect.Value{},
+	}
+}
+
+// defaults returns the default value registered for a config struct type. If
+// there isn't one then the zero value of the type is returned, where any struct
+// fields are populated with their own defaults.
+func (r *registry) defaults(t reflect.Type) reflect.Value {
+	if ts, exists := r.typed[t]; exists {
+		return ts.defaults
+	}
+	if v, exists := r.dynamicDefaults[t]; exists {
+		return v
+	}
+	v := reflect.New(t).Elem()
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			if f := v.Field(i); f.CanSet() && f.Kind() == reflect.Struct {
+				f.Set(r.defaults(f.Type()))
+			}
+		}
+	}
+	return v
+}
+
+// RegisterTypes declares that config structs of the same type as conf select a
+// section by the value of their `type` field, where names is the full list of
+// valid types. The value of the `type` field within conf is used when a config
+// does not specify one. A name ending with `*` accepts any type with that
+// prefix.
+func (r *registry) RegisterTypes(conf interface{}, names []string) {
+	t := typedSection{names: map[string]struct{}{}}
+	for _, name := range names {
+		if strings.HasSuffix(name, "*") {
+			t.prefixes = append(t.prefixes, strings.TrimSuffix(name, "*"))
+		} else {
+			t.names[name] = struct{}{}
+		}
+	}
+	v := reflect.Indirect(reflect.ValueOf(conf))
+	t.defaults = v
+	if f := v.FieldByName("Type"); f.IsValid() && f.Kind() == reflect.String {
+		t.defaultType = f.String()
+	}
+	r.typed[v.Type()] = t
+}
+
+// RegisterDynamic declares that the field key of config structs of the same
+// type as parent holds a generic value, or list of generic values, which is
+// later parsed into the same type as conf. The first conf registered for a
+// type is also used as the default value of that type.
+func (r *registry) RegisterDynamic(parent interface{}, key string, conf interface{}) {
+	pt := reflect.Indirect(reflect.ValueOf(parent)).Type()
+	fields, exists := r.dynamic[pt]
+	if !exists {
+		fields = map[string]reflect.Value{}
+		r.dynamic[pt] = fields
+	}
+	v := reflect.Indirect(reflect.ValueOf(conf))
+	fields[key] = v
+	if _, exists := r.dynamicDefaults[v.Type()]; !exists {
+		r.dynamicDefaults[v.Type()] = v
+	}
+}
+
+//------------------------------------------------------------------------------
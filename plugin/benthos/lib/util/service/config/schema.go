@@ -0,0 +1,253 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+)
+
+//------------------------------------------------------------------------------
+
+// Schema generates JSON Schema documents from config structures, including the
+// default value of each field. Config structs registered with RegisterTypes
+// have their `type` field restricted to the list of valid types, and generic
+// fields registered with RegisterDynamic are described by the structure they
+// are later parsed into.
+type Schema struct {
+	registry
+}
+
+// NewSchema creates a new Schema with no registered types.
+func NewSchema() *Schema {
+	return &Schema{
+		registry: newRegistry(),
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Generate returns a JSON Schema (draft 07) document describing the structure
+// of config, where the values of config are used as defaults. Config structs
+// that contain themselves, such as broker types, are described once within the
+// definitions section and then referenced.
+func (s *Schema) Generate(config interface{}) map[string]interface{} {
+	g := schemaGen{
+		Schema:      s,
+		stack:       map[reflect.Type]int{},
+		definitions: map[string]interface{}{},
+	}
+	root := g.value(reflect.ValueOf(config))
+	root["$schema"] = "http://json-schema.org/draft-07/schema#"
+	if len(g.definitions) > 0 {
+		root["definitions"] = g.definitions
+	}
+	return root
+}
+
+//------------------------------------------------------------------------------
+
+// schemaGen holds the state of a single schema generation.
+type schemaGen struct {
+	*Schema
+	stack       map[reflect.Type]int
+	definitions map[string]interface{}
+}
+
+// jsonValue converts a value into the generic form that it is serialised as.
+func jsonValue(v reflect.Value) (interface{}, bool) {
+	rawBytes, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, false
+	}
+	var generic interface{}
+	if err = json.Unmarshal(rawBytes, &generic); err != nil {
+		return nil, false
+	}
+	return generic, true
+}
+
+// ref returns a reference to the definition of a config struct type, adding the
+// definition if it does not yet exist.
+func (g *schemaGen) ref(t reflect.Type) map[string]interface{} {
+	name := t.String()
+	if _, exists := g.definitions[name]; !exists {
+		// Claim the name before generating as the definition refers to itself,
+		// and generate with a fresh stack so that the definition stands alone.
+		g.definitions[name] = nil
+		stack := g.stack
+		g.stack = map[reflect.Type]int{}
+		g.definitions[name] = g.object(g.defaults(t))
+		g.stack = stack
+	}
+	return map[string]interface{}{
+		"$ref": "#/definitions/" + name,
+	}
+}
+
+func (g *schemaGen) value(v reflect.Value) map[string]interface{} {
+	t := v.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if v.IsNil() {
+			v = reflect.Zero(t)
+		} else {
+			v = v.Elem()
+		}
+	}
+
+	var schema map[string]interface{}
+	switch t.Kind() {
+	case reflect.Struct:
+		if g.stack[t] > 0 {
+			return g.ref(t)
+		}
+		return g.object(v)
+	case reflect.Map:
+		schema = map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": g.value(g.defaults(t.Elem())),
+		}
+		if v.Len() == 0 {
+			return schema
+		}
+	case reflect.Slice, reflect.Array:
+		schema = map[string]interface{}{
+			"type":  "array",
+			"items": g.value(g.defaults(t.Elem())),
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return schema
+		}
+	case reflect.String:
+		schema = map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		schema = map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema = map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		schema = map[string]interface{}{"type": "number"}
+	default:
+		// Generic values can hold anything.
+		schema = map[string]interface{}{}
+		if v.Kind() == reflect.Interface && v.IsNil() {
+			return schema
+		}
+	}
+	if def, ok := jsonValue(v); ok {
+		schema["default"] = def
+	}
+	return schema
+}
+
+// dynamic returns the schema of a generic field that is later parsed into a
+// registered config struct. A single value is optional and can therefore also
+// be null.
+func (g *schemaGen) dynamic(v reflect.Value, conf reflect.Value) map[string]interface{} {
+	if v.Kind() == reflect.Slice {
+		return map[string]interface{}{
+			"type":  "array",
+			"items": g.value(conf),
+		}
+	}
+	return map[string]interface{}{
+		"anyOf": []interface{}{
+			map[string]interface{}{"type": "null"},
+			g.value(conf),
+		},
+	}
+}
+
+// properties adds the properties of a struct value to props, including those of
+// any inlined structs.
+func (g *schemaGen) properties(v reflect.Value, props map[string]interface{}) {
+	t := v.Type()
+	dynamic := g.registry.dynamic[t]
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 && !f.Anonymous {
+			continue
+		}
+		name, inline := fieldName(f, true)
+		if inline && f.Type.Kind() == reflect.Struct {
+			g.properties(v.Field(i), props)
+			continue
+		}
+		if len(name) == 0 {
+			continue
+		}
+		if conf, isDynamic := dynamic[name]; isDynamic {
+			props[name] = g.dynamic(v.Field(i), conf)
+		} else {
+			props[name] = g.value(v.Field(i))
+		}
+	}
+
+	if ts, isTyped := g.typed[t]; isTyped {
+		names := []string{}
+		for name := range ts.names {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		typeSchema := map[string]interface{}{
+			"type": "string",
+		}
+		if def, ok := jsonValue(v.FieldByName("Type")); ok {
+			typeSchema["default"] = def
+		}
+		if len(ts.prefixes) == 0 {
+			typeSchema["enum"] = names
+		} else {
+			anyOf := []interface{}{
+				map[string]interface{}{"enum": names},
+			}
+			for _, p := range ts.prefixes {
+				anyOf = append(anyOf, map[string]interface{}{
+					"pattern": "^" + regexp.QuoteMeta(p),
+				})
+			}
+			typeSchema["anyOf"] = anyOf
+		}
+		props["type"] = typeSchema
+	}
+}
+
+func (g *schemaGen) object(v reflect.Value) map[string]interface{} {
+	t := v.Type()
+	g.stack[t]++
+	defer func() {
+		g.stack[t]--
+	}()
+
+	props := map[string]interface{}{}
+	g.properties(v, props)
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+//------------------------------------------------------------------------------
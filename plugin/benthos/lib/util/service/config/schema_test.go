@@ -0,0 +1,197 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+type schemaNodeConfig struct {
+	Name     string        `json:"name" yaml:"name"`
+	Children []interface{} `json:"children" yaml:"children"`
+	Fallback interface{}   `json:"fallback" yaml:"fallback"`
+}
+
+type schemaPairConfig struct {
+	First  lintComponentConfig `json:"first" yaml:"first"`
+	Second lintComponentConfig `json:"second" yaml:"second"`
+}
+
+type schemaRootConfig struct {
+	Component lintComponentConfig         `json:"component" yaml:"component"`
+	Node      schemaNodeConfig            `json:"node" yaml:"node"`
+	Labels    map[string]string           `json:"labels" yaml:"labels"`
+	Others    map[string]schemaPairConfig `json:"others,omitempty" yaml:"others,omitempty"`
+	Ratio     float64                     `json:"ratio" yaml:"ratio"`
+	Free      interface{}                 `json:"free" yaml:"free"`
+	Hidden    string                      `json:"-" yaml:"-"`
+}
+
+// schemaAt returns the generic form of a schema at a path of object keys.
+func schemaAt(t *testing.T, schema interface{}, path ...string) interface{} {
+	rawBytes, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generic interface{}
+	if err = json.Unmarshal(rawBytes, &generic); err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range path {
+		obj, ok := generic.(map[string]interface{})
+		if !ok {
+			t.Fatalf("Schema at %v is not an object", path[:i])
+		}
+		if generic, ok = obj[key]; !ok {
+			t.Fatalf("Schema at %v does not exist", path[:i+1])
+		}
+	}
+	return generic
+}
+
+func checkSchemaAt(t *testing.T, schema interface{}, exp string, path ...string) {
+	var expGeneric interface{}
+	if err := json.Unmarshal([]byte(exp), &expGeneric); err != nil {
+		t.Fatal(err)
+	}
+	if act := schemaAt(t, schema, path...); !reflect.DeepEqual(expGeneric, act) {
+		actBytes, _ := json.Marshal(act)
+		t.Errorf("Wrong schema at %v: %s != %s", path, actBytes, exp)
+	}
+}
+
+func TestSchemaGenerate(t *testing.T) {
+	s := NewSchema()
+	s.RegisterTypes(newLintComponentConfig(), []string{"foo", "bar"})
+	s.RegisterDynamic(schemaNodeConfig{}, "children", schemaNodeConfig{Name: "child"})
+	s.RegisterDynamic(schemaNodeConfig{}, "fallback", schemaNodeConfig{Name: "fallback"})
+
+	conf := schemaRootConfig{
+		Component: newLintComponentConfig(),
+		Node: schemaNodeConfig{
+			Name: "root",
+		},
+		Labels: map[string]string{"foo": "bar"},
+		Ratio:  0.5,
+	}
+	conf.Component.Type = "bar"
+	conf.Component.Foo.Count = 20
+
+	schema := s.Generate(conf)
+
+	checkSchemaAt(t, schema, `"http://json-schema.org/draft-07/schema#"`, "$schema")
+	checkSchemaAt(t, schema, `false`, "additionalProperties")
+	checkSchemaAt(t, schema, `{
+	"type": "string",
+	"enum": ["bar", "foo"],
+	"default": "bar"
+}`, "properties", "component", "properties", "type")
+	checkSchemaAt(t, schema, `{
+	"type": "object",
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "default": "default"},
+		"count": {"type": "integer", "default": 20}
+	}
+}`, "properties", "component", "properties", "foo")
+	checkSchemaAt(t, schema, `{
+	"type": "array",
+	"items": {"type": "string", "default": ""},
+	"default": []
+}`, "properties", "component", "properties", "bar", "properties", "tags")
+	checkSchemaAt(t, schema, `{
+	"type": "object",
+	"additionalProperties": {"type": "string", "default": ""},
+	"default": {"foo": "bar"}
+}`, "properties", "labels")
+	checkSchemaAt(t, schema, `{"type": "number", "default": 0.5}`, "properties", "ratio")
+	checkSchemaAt(t, schema, `{}`, "properties", "free")
+
+	// Map values of unregistered structs use the defaults of their fields.
+	checkSchemaAt(t, schema, `{
+	"type": "string",
+	"enum": ["bar", "foo"],
+	"default": "foo"
+}`, "properties", "others", "additionalProperties", "properties", "first", "properties", "type")
+	checkSchemaAt(t, schema, `{"type": "integer", "default": 10}`,
+		"properties", "others", "additionalProperties", "properties", "second", "properties", "foo", "properties", "count")
+
+	props := schemaAt(t, schema, "properties").(map[string]interface{})
+	if _, exists := props["hidden"]; exists {
+		t.Error("Ignored field was included in schema")
+	}
+
+	// Recursive structures are defined once and referenced.
+	checkSchemaAt(t, schema, `{
+	"type": "object",
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "default": "root"},
+		"children": {
+			"type": "array",
+			"items": {"$ref": "#/definitions/config.schemaNodeConfig"}
+		},
+		"fallback": {
+			"anyOf": [
+				{"type": "null"},
+				{"$ref": "#/definitions/config.schemaNodeConfig"}
+			]
+		}
+	}
+}`, "properties", "node")
+	checkSchemaAt(t, schema, `{
+	"type": "object",
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "default": "child"},
+		"children": {
+			"type": "array",
+			"items": {"$ref": "#/definitions/config.schemaNodeConfig"}
+		},
+		"fallback": {
+			"anyOf": [
+				{"type": "null"},
+				{"$ref": "#/definitions/config.schemaNodeConfig"}
+			]
+		}
+	}
+}`, "definitions", "config.schemaNodeConfig")
+}
+
+func TestSchemaTypePrefixes(t *testing.T) {
+	s := NewSchema()
+	s.RegisterTypes(newLintComponentConfig(), []string{"foo", "bar", "ditto*"})
+
+	checkSchemaAt(t, s.Generate(newLintComponentConfig()), `{
+	"type": "string",
+	"anyOf": [
+		{"enum": ["bar", "foo"]},
+		{"pattern": "^ditto"}
+	],
+	"default": "foo"
+}`, "properties", "type")
+}
+
+//------------------------------------------------------------------------------
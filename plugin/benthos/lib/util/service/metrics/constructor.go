@@ -50,26 +50,39 @@ var constructors = map[string]typeSpec{}
 // Config is the all encompassing configuration struct for all metric output
 // types.
 type Config struct {
-	Type    string        `json:"type" yaml:"type"`
-	Prefix  string        `json:"prefix" yaml:"prefix"`
-	HTTP    struct{}      `json:"http_server" yaml:"http_server"`
-	Riemann RiemannConfig `json:"riemann" yaml:"riemann"`
-	Statsd  StatsdConfig  `json:"statsd" yaml:"statsd"`
+	Type       string           `json:"type" yaml:"type"`
+	Prefix     string           `json:"prefix" yaml:"prefix"`
+	HTTP       struct{}         `json:"http_server" yaml:"http_server"`
+	Prometheus PrometheusConfig `json:"prometheus" yaml:"prometheus"`
+	Riemann    RiemannConfig    `json:"riemann" yaml:"riemann"`
+	Statsd     StatsdConfig     `json:"statsd" yaml:"statsd"`
 }
 
 // NewConfig returns a configuration struct fully populated with default values.
 func NewConfig() Config {
 	return Config{
-		Type:    "http_server",
-		Prefix:  "service",
-		HTTP:    struct{}{},
-		Riemann: NewRiemannConfig(),
-		Statsd:  NewStatsdConfig(),
+		Type:       "http_server",
+		Prefix:     "service",
+		HTTP:       struct{}{},
+		Prometheus: NewPrometheusConfig(),
+		Riemann:    NewRiemannConfig(),
+		Statsd:     NewStatsdConfig(),
 	}
 }
 
 //------------------------------------------------------------------------------
 
+// TypeNames returns an alphabetically sorted list of the names of each
+// registered metrics type.
+func TypeNames() []string {
+	names := []string{}
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Descriptions returns a formatted string of collated descriptions of each
 // type.
 func Descriptions() string {
@@ -0,0 +1,76 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+//------------------------------------------------------------------------------
+
+// Namespaced is a metrics type that wraps another metrics type and prefixes all
+// metric paths with a namespace. Closing a Namespaced type does not close the
+// wrapped type, as it is expected to be shared.
+type Namespaced struct {
+	ns    string
+	child Type
+}
+
+// NewNamespaced creates a new Namespaced metrics type that prefixes all paths
+// with ns before passing them on to child.
+func NewNamespaced(child Type, ns string) *Namespaced {
+	return &Namespaced{
+		ns:    ns,
+		child: child,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (n *Namespaced) path(p string) string {
+	if len(n.ns) == 0 {
+		return p
+	}
+	return n.ns + "." + p
+}
+
+// Incr increments a metric by an amount.
+func (n *Namespaced) Incr(path string, count int64) error {
+	return n.child.Incr(n.path(path), count)
+}
+
+// Decr decrements a metric by an amount.
+func (n *Namespaced) Decr(path string, count int64) error {
+	return n.child.Decr(n.path(path), count)
+}
+
+// Timing sets a timing metric.
+func (n *Namespaced) Timing(path string, delta int64) error {
+	return n.child.Timing(n.path(path), delta)
+}
+
+// Gauge sets a gauge metric.
+func (n *Namespaced) Gauge(path string, value int64) error {
+	return n.child.Gauge(n.path(path), value)
+}
+
+// Close does nothing, the wrapped metrics type is left open.
+func (n *Namespaced) Close() error {
+	return nil
+}
+
+//------------------------------------------------------------------------------
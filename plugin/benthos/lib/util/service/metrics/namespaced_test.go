@@ -0,0 +1,75 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+type recordedStats struct {
+	DudType
+	paths []string
+}
+
+func (r *recordedStats) Incr(path string, count int64) error {
+	r.paths = append(r.paths, path)
+	return nil
+}
+
+func (r *recordedStats) Gauge(path string, value int64) error {
+	r.paths = append(r.paths, path)
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+func TestNamespacedInterface(t *testing.T) {
+	o := &Namespaced{}
+	if Type(o) == nil {
+		t.Errorf("Type does not satisfy Type interface.")
+	}
+}
+
+func TestNamespacedPaths(t *testing.T) {
+	rec := &recordedStats{}
+
+	foo := NewNamespaced(rec, "foo")
+	foo.Incr("input.count", 1)
+	foo.Gauge("buffer.backlog", 10)
+
+	NewNamespaced(rec, "").Incr("output.count", 1)
+	NewNamespaced(foo, "bar").Incr("output.count", 1)
+
+	exp := []string{
+		"foo.input.count",
+		"foo.buffer.backlog",
+		"output.count",
+		"foo.bar.output.count",
+	}
+	if !reflect.DeepEqual(exp, rec.paths) {
+		t.Errorf("Wrong paths: %v != %v", rec.paths, exp)
+	}
+}
+
+//------------------------------------------------------------------------------
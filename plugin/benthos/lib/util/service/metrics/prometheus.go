@@ -0,0 +1,360 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["prometheus"] = typeSpec{
+		constructor: NewPrometheus,
+		description: `
+Host a Prometheus scrape endpoint at '/metrics' on the Benthos HTTP server.
+
+Metric paths are converted into Prometheus names by joining the prefix and path
+with underscores and replacing any invalid characters, e.g. the path
+'input.kafka.count' with the prefix 'service' becomes
+'service_input_kafka_count'. Incremented metrics are exposed as counters with a
+'_total' suffix, timings as histograms in seconds with a '_seconds' suffix and
+gauges as gauges. A metric that is ever decremented or set as a gauge is exposed
+as a gauge.
+
+The 'labels' field adds constant labels to all metrics, and 'buckets' sets the
+upper bounds in seconds of the timing histogram buckets.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// PrometheusConfig is config for the Prometheus metrics type.
+type PrometheusConfig struct {
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+	Buckets []float64         `json:"buckets" yaml:"buckets"`
+}
+
+// NewPrometheusConfig creates a PrometheusConfig struct with default values.
+func NewPrometheusConfig() PrometheusConfig {
+	return PrometheusConfig{
+		Labels:  map[string]string{},
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// promCounter is the value of a metric that is incremented, decremented or set,
+// which is exposed as a gauge if it is ever decremented or set.
+type promCounter struct {
+	value   int64
+	isGauge bool
+}
+
+type promHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Prometheus is a stats object that aggregates metrics and exposes them in the
+// Prometheus text format.
+type Prometheus struct {
+	prefix  string
+	labels  string
+	buckets []float64
+
+	counters   map[string]*promCounter
+	histograms map[string]*promHistogram
+	paths      map[string]string
+
+	sync.Mutex
+}
+
+// NewPrometheus creates and returns a new Prometheus object.
+func NewPrometheus(config Config) (Type, error) {
+	buckets := append([]float64{}, config.Prometheus.Buckets...)
+	sort.Float64s(buckets)
+
+	labelNames := []string{}
+	for k := range config.Prometheus.Labels {
+		labelNames = append(labelNames, k)
+	}
+	sort.Strings(labelNames)
+
+	labels := make([]string, len(labelNames))
+	for i, k := range labelNames {
+		labels[i] = fmt.Sprintf(
+			"%v=\"%v\"", sanitisePromName(k, true), escapePromLabel(config.Prometheus.Labels[k]),
+		)
+	}
+
+	return &Prometheus{
+		prefix:     config.Prefix,
+		labels:     strings.Join(labels, ","),
+		buckets:    buckets,
+		counters:   map[string]*promCounter{},
+		histograms: map[string]*promHistogram{},
+		paths:      map[string]string{},
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// sanitisePromName replaces any characters that are not valid within a
+// Prometheus metric or label name with underscores.
+func sanitisePromName(name string, isLabel bool) string {
+	b := []byte(name)
+	for i, c := range b {
+		valid := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' ||
+			(c == ':' && !isLabel) || (c >= '0' && c <= '9' && i > 0)
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// escapePromLabel escapes a label value.
+func escapePromLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// name returns the Prometheus name of a metric path, must be called with the
+// lock held.
+func (p *Prometheus) name(path string) string {
+	if name, exists := p.paths[path]; exists {
+		return name
+	}
+	name := path
+	if len(p.prefix) > 0 {
+		name = p.prefix + "." + path
+	}
+	name = sanitisePromName(name, false)
+	p.paths[path] = name
+	return name
+}
+
+// withLabels returns a metric name with the constant labels and any extra
+// labels.
+func (p *Prometheus) withLabels(name string, extra string) string {
+	labels := p.labels
+	if len(extra) > 0 {
+		if len(labels) > 0 {
+			labels += ","
+		}
+		labels += extra
+	}
+	if len(labels) == 0 {
+		return name
+	}
+	return name + "{" + labels + "}"
+}
+
+func formatPromFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+//------------------------------------------------------------------------------
+
+// promFamily is a metric family to be written in the Prometheus text format.
+type promFamily struct {
+	name    string
+	t       string
+	samples []string
+	write   func(buf *bytes.Buffer)
+}
+
+// families returns the metric families of all metrics sorted by name, must be
+// called with the lock held. Counters and histograms are namespaced with type
+// suffixes, and any family with a sample name that collides with a previous
+// family is omitted, since duplicate names are rejected by Prometheus.
+func (p *Prometheus) families() []promFamily {
+	families := []promFamily{}
+	for name, c := range p.counters {
+		name, c := name, c
+		t := "counter"
+		if c.isGauge {
+			t = "gauge"
+		} else {
+			name = name + "_total"
+		}
+		families = append(families, promFamily{
+			name:    name,
+			t:       t,
+			samples: []string{name},
+			write: func(buf *bytes.Buffer) {
+				fmt.Fprintf(buf, "# TYPE %v %v\n", name, t)
+				fmt.Fprintf(buf, "%v %v\n", p.withLabels(name, ""), c.value)
+			},
+		})
+	}
+	for name, h := range p.histograms {
+		name, h := name+"_seconds", h
+		families = append(families, promFamily{
+			name:    name,
+			t:       "histogram",
+			samples: []string{name, name + "_bucket", name + "_sum", name + "_count"},
+			write: func(buf *bytes.Buffer) {
+				fmt.Fprintf(buf, "# TYPE %v histogram\n", name)
+				var cumulative uint64
+				for i, bound := range p.buckets {
+					cumulative += h.counts[i]
+					fmt.Fprintf(buf, "%v %v\n", p.withLabels(name+"_bucket", `le="`+formatPromFloat(bound)+`"`), cumulative)
+				}
+				fmt.Fprintf(buf, "%v %v\n", p.withLabels(name+"_bucket", `le="+Inf"`), h.count)
+				fmt.Fprintf(buf, "%v %v\n", p.withLabels(name+"_sum", ""), formatPromFloat(h.sum))
+				fmt.Fprintf(buf, "%v %v\n", p.withLabels(name+"_count", ""), h.count)
+			},
+		})
+	}
+	sort.Slice(families, func(i, j int) bool {
+		if families[i].name == families[j].name {
+			return families[i].t < families[j].t
+		}
+		return families[i].name < families[j].name
+	})
+
+	seen := map[string]struct{}{}
+	deduped := families[:0]
+	for _, f := range families {
+		collides := false
+		for _, sample := range f.samples {
+			if _, exists := seen[sample]; exists {
+				collides = true
+			}
+		}
+		if collides {
+			continue
+		}
+		for _, sample := range f.samples {
+			seen[sample] = struct{}{}
+		}
+		deduped = append(deduped, f)
+	}
+	return deduped
+}
+
+// writeText writes all metrics in the Prometheus text exposition format.
+func (p *Prometheus) writeText(buf *bytes.Buffer) {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, f := range p.families() {
+		f.write(buf)
+	}
+}
+
+// HandlerFunc returns a handler for scraping metrics.
+func (p *Prometheus) HandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buf := bytes.Buffer{}
+		p.writeText(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Incr increments a stat by a value.
+func (p *Prometheus) Incr(stat string, value int64) error {
+	p.Lock()
+	name := p.name(stat)
+	c, exists := p.counters[name]
+	if !exists {
+		c = &promCounter{}
+		p.counters[name] = c
+	}
+	c.value += value
+	p.Unlock()
+	return nil
+}
+
+// Decr decrements a stat by a value, since Prometheus counters can only go up
+// the stat is exposed as a gauge.
+func (p *Prometheus) Decr(stat string, value int64) error {
+	p.Lock()
+	name := p.name(stat)
+	c, exists := p.counters[name]
+	if !exists {
+		c = &promCounter{}
+		p.counters[name] = c
+	}
+	c.value -= value
+	c.isGauge = true
+	p.Unlock()
+	return nil
+}
+
+// Timing adds a duration in nanoseconds to a histogram.
+func (p *Prometheus) Timing(stat string, delta int64) error {
+	seconds := time.Duration(delta).Seconds()
+
+	p.Lock()
+	name := p.name(stat)
+	h, exists := p.histograms[name]
+	if !exists {
+		h = &promHistogram{counts: make([]uint64, len(p.buckets))}
+		p.histograms[name] = h
+	}
+	for i, bound := range p.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+	p.Unlock()
+	return nil
+}
+
+// Gauge sets a stat as a gauge value.
+func (p *Prometheus) Gauge(stat string, value int64) error {
+	p.Lock()
+	name := p.name(stat)
+	c, exists := p.counters[name]
+	if !exists {
+		c = &promCounter{}
+		p.counters[name] = c
+	}
+	c.value = value
+	c.isGauge = true
+	p.Unlock()
+	return nil
+}
+
+// Close stops the Prometheus object from aggregating metrics and cleans up
+// resources.
+func (p *Prometheus) Close() error {
+	return nil
+}
+
+//------------------------------------------------------------------------------
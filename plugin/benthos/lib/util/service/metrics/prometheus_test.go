@@ -0,0 +1,155 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusInterface(t *testing.T) {
+	o := &Prometheus{}
+	if Type(o) == nil {
+		t.Errorf("Type does not satisfy Type interface.")
+	}
+}
+
+func TestPrometheusSanitiseName(t *testing.T) {
+	tests := map[string]string{
+		"foo.bar.baz":    "foo_bar_baz",
+		"foo-bar:baz":    "foo_bar:baz",
+		"1foo.2bar":      "_foo_2bar",
+		"Foo_Bar.baz123": "Foo_Bar_baz123",
+	}
+	for input, exp := range tests {
+		if act := sanitisePromName(input, false); act != exp {
+			t.Errorf("Wrong name for %v: %v != %v", input, act, exp)
+		}
+	}
+	if exp, act := "foo_bar", sanitisePromName("foo:bar", true); exp != act {
+		t.Errorf("Wrong label name: %v != %v", act, exp)
+	}
+}
+
+func TestPrometheusExposition(t *testing.T) {
+	conf := NewConfig()
+	conf.Type = "prometheus"
+	conf.Prefix = "benthos"
+	conf.Prometheus.Labels = map[string]string{
+		"pipeline": `foo "bar"`,
+	}
+	conf.Prometheus.Buckets = []float64{1, 0.1}
+
+	stats, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := stats.(*Prometheus)
+	if !ok {
+		t.Fatal("Wrong metrics type returned")
+	}
+
+	p.Incr("input.kafka.count", 3)
+	p.Incr("input.kafka.count", 2)
+	p.Incr("buffer.level", 10)
+	p.Decr("buffer.level", 4)
+	p.Gauge("buffer.backlog", 42)
+	p.Timing("output.latency", int64(50*time.Millisecond))
+	p.Timing("output.latency", int64(500*time.Millisecond))
+	p.Timing("output.latency", int64(5*time.Second))
+
+	rec := httptest.NewRecorder()
+	p.HandlerFunc()(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, _ := ioutil.ReadAll(rec.Body)
+	exp := `# TYPE benthos_buffer_backlog gauge
+benthos_buffer_backlog{pipeline="foo \"bar\""} 42
+# TYPE benthos_buffer_level gauge
+benthos_buffer_level{pipeline="foo \"bar\""} 6
+# TYPE benthos_input_kafka_count_total counter
+benthos_input_kafka_count_total{pipeline="foo \"bar\""} 5
+# TYPE benthos_output_latency_seconds histogram
+benthos_output_latency_seconds_bucket{pipeline="foo \"bar\"",le="0.1"} 1
+benthos_output_latency_seconds_bucket{pipeline="foo \"bar\"",le="1"} 2
+benthos_output_latency_seconds_bucket{pipeline="foo \"bar\"",le="+Inf"} 3
+benthos_output_latency_seconds_sum{pipeline="foo \"bar\""} 5.55
+benthos_output_latency_seconds_count{pipeline="foo \"bar\""} 3
+`
+	if act := string(body); act != exp {
+		t.Errorf("Wrong exposition: %v != %v", act, exp)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Wrong content type: %v", ct)
+	}
+}
+
+func TestPrometheusDuplicateNames(t *testing.T) {
+	conf := NewConfig()
+	conf.Type = "prometheus"
+	conf.Prefix = ""
+
+	stats, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := stats.(*Prometheus)
+	if !ok {
+		t.Fatal("Wrong metrics type returned")
+	}
+
+	p.Incr("foo.bar", 1)
+	p.Gauge("foo.bar", 5)
+	p.Incr("foo-bar", 2)
+	p.Incr("baz", 3)
+	p.Gauge("baz.total", 4)
+	p.Timing("baz", int64(time.Second))
+
+	buf := bytes.Buffer{}
+	p.writeText(&buf)
+
+	exp := `# TYPE baz_seconds histogram
+baz_seconds_bucket{le="0.005"} 0
+baz_seconds_bucket{le="0.01"} 0
+baz_seconds_bucket{le="0.025"} 0
+baz_seconds_bucket{le="0.05"} 0
+baz_seconds_bucket{le="0.1"} 0
+baz_seconds_bucket{le="0.25"} 0
+baz_seconds_bucket{le="0.5"} 0
+baz_seconds_bucket{le="1"} 1
+baz_seconds_bucket{le="2.5"} 1
+baz_seconds_bucket{le="5"} 1
+baz_seconds_bucket{le="10"} 1
+baz_seconds_bucket{le="+Inf"} 1
+baz_seconds_sum 1
+baz_seconds_count 1
+# TYPE baz_total counter
+baz_total 3
+# TYPE foo_bar gauge
+foo_bar 7
+`
+	if act := buf.String(); act != exp {
+		t.Errorf("Wrong exposition: %v != %v", act, exp)
+	}
+}
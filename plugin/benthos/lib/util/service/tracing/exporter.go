@@ -0,0 +1,153 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// zipkinEndpoint is the endpoint of a span in the Zipkin v2 format.
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+// zipkinSpan is a span in the Zipkin v2 JSON format, which is accepted by
+// Jaeger collectors and other OpenTracing compatible backends.
+type zipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint zipkinEndpoint    `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+// newZipkinSpan converts a span into the Zipkin v2 format, where timestamps
+// and durations are in microseconds.
+func newZipkinSpan(serviceName string, s Span) zipkinSpan {
+	duration := int64(s.Duration / time.Microsecond)
+	if duration < 1 {
+		duration = 1
+	}
+	return zipkinSpan{
+		TraceID:       s.TraceID,
+		ID:            s.ID,
+		ParentID:      s.ParentID,
+		Name:          s.Name,
+		Timestamp:     s.Start.UnixNano() / int64(time.Microsecond),
+		Duration:      duration,
+		LocalEndpoint: zipkinEndpoint{ServiceName: serviceName},
+		Tags:          s.Tags,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// exporter batches completed spans and sends them to a collector.
+type exporter struct {
+	url         string
+	serviceName string
+	client      http.Client
+
+	log   log.Modular
+	stats metrics.Type
+
+	spansMut sync.Mutex
+	spans    []zipkinSpan
+}
+
+// maxPendingSpans is the number of spans that can be queued for export before
+// further spans are dropped.
+const maxPendingSpans = 100000
+
+func newExporter(url, serviceName string, log log.Modular, stats metrics.Type) *exporter {
+	return &exporter{
+		url:         url,
+		serviceName: serviceName,
+		client:      http.Client{Timeout: time.Second * 5},
+		log:         log,
+		stats:       stats,
+	}
+}
+
+// add queues spans for export.
+func (e *exporter) add(spans []Span) {
+	e.spansMut.Lock()
+	defer e.spansMut.Unlock()
+	if len(e.spans)+len(spans) > maxPendingSpans {
+		e.stats.Incr("tracing.export.dropped", int64(len(spans)))
+		return
+	}
+	for _, s := range spans {
+		e.spans = append(e.spans, newZipkinSpan(e.serviceName, s))
+	}
+}
+
+// flush sends all queued spans to the collector. Spans that fail to be sent
+// are discarded.
+func (e *exporter) flush() {
+	e.spansMut.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.spansMut.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	if err := e.send(spans); err != nil {
+		e.log.Errorf("Failed to export spans: %v\n", err)
+		e.stats.Incr("tracing.export.error", 1)
+		return
+	}
+	e.stats.Incr("tracing.export.success", 1)
+	e.stats.Incr("tracing.export.spans", int64(len(spans)))
+}
+
+func (e *exporter) send(spans []zipkinSpan) error {
+	body, err := json.Marshal(spans)
+	if err != nil {
+		return err
+	}
+	res, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("collector returned status: %v", res.StatusCode)
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,218 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tracing
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+// TapSpan is a span of a tapped message.
+type TapSpan struct {
+	Name       string    `json:"name"`
+	Start      time.Time `json:"start"`
+	DurationUS int64     `json:"duration_us"`
+}
+
+// TapEvent is a message observed passing through a stage of the pipeline,
+// along with the spans recorded for it so far if it is being traced.
+type TapEvent struct {
+	Stage     string              `json:"stage"`
+	Timestamp time.Time           `json:"timestamp"`
+	TraceID   string              `json:"trace_id,omitempty"`
+	Parts     []string            `json:"parts"`
+	Metadata  []map[string]string `json:"metadata,omitempty"`
+	Spans     []TapSpan           `json:"spans,omitempty"`
+}
+
+// tap is a subscription to the messages passing through a stage.
+type tap struct {
+	stage  string
+	ratio  float64
+	events chan TapEvent
+}
+
+//------------------------------------------------------------------------------
+
+// Tap subscribes to a sample of messages passing through a stage, where ratio
+// is the fraction of messages to observe. Events are dropped when the
+// subscriber falls behind. The returned function cancels the subscription,
+// after which the events channel is closed.
+func (t *Tracer) Tap(stage string, ratio float64) (<-chan TapEvent, func()) {
+	tp := &tap{
+		stage:  stage,
+		ratio:  ratio,
+		events: make(chan TapEvent, 100),
+	}
+
+	t.tapsMut.Lock()
+	if atomic.LoadInt32(&t.running) != 1 {
+		t.tapsMut.Unlock()
+		close(tp.events)
+		return tp.events, func() {}
+	}
+	t.taps[tp] = struct{}{}
+	atomic.AddInt32(&t.tapCount, 1)
+	t.tapsMut.Unlock()
+
+	return tp.events, func() {
+		t.tapsMut.Lock()
+		if _, exists := t.taps[tp]; exists {
+			delete(t.taps, tp)
+			atomic.AddInt32(&t.tapCount, -1)
+			close(tp.events)
+		}
+		t.tapsMut.Unlock()
+	}
+}
+
+func (t *Tracer) closeTaps() {
+	t.tapsMut.Lock()
+	for tp := range t.taps {
+		close(tp.events)
+	}
+	t.taps = map[*tap]struct{}{}
+	atomic.StoreInt32(&t.tapCount, 0)
+	t.tapsMut.Unlock()
+}
+
+// tapMessage passes a message to each tap of a stage.
+func (t *Tracer) tapMessage(stage string, msg *types.Message) {
+	if atomic.LoadInt32(&t.tapCount) == 0 {
+		return
+	}
+
+	t.tapsMut.RLock()
+	defer t.tapsMut.RUnlock()
+
+	var event *TapEvent
+	for tp := range t.taps {
+		if tp.stage != stage || rand.Float64() >= tp.ratio {
+			continue
+		}
+		if event == nil {
+			event = t.newTapEvent(stage, msg)
+		}
+		select {
+		case tp.events <- *event:
+		default:
+			t.stats.Incr("tracing.tap.dropped", 1)
+		}
+	}
+}
+
+func (t *Tracer) newTapEvent(stage string, msg *types.Message) *TapEvent {
+	event := &TapEvent{
+		Stage:     stage,
+		Timestamp: time.Now(),
+		TraceID:   TraceID(msg),
+		Parts:     make([]string, len(msg.Parts)),
+		Metadata:  make([]map[string]string, len(msg.Parts)),
+	}
+	for i, p := range msg.Parts {
+		event.Parts[i] = string(p)
+		event.Metadata[i] = map[string]string{}
+		for k, v := range msg.PartMetadata(i) {
+			event.Metadata[i][k] = v
+		}
+	}
+	for _, s := range t.Spans(event.TraceID) {
+		event.Spans = append(event.Spans, TapSpan{
+			Name:       s.Name,
+			Start:      s.Start,
+			DurationUS: int64(s.Duration / time.Microsecond),
+		})
+	}
+	return event
+}
+
+//------------------------------------------------------------------------------
+
+// TapHandler returns a handler that streams tapped messages as line delimited
+// JSON objects. The stage to tap is set with the query parameter 'stage', and
+// the fraction of messages to observe with 'sample_ratio' (defaults to 1).
+func (t *Tracer) TapHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Incorrect method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		stage := r.URL.Query().Get("stage")
+		switch stage {
+		case StageInput, StageBuffer, StageOutput:
+		default:
+			http.Error(w, "Query parameter 'stage' must be one of: input, buffer, output", http.StatusBadRequest)
+			return
+		}
+
+		ratio := 1.0
+		if ratioStr := r.URL.Query().Get("sample_ratio"); len(ratioStr) > 0 {
+			var err error
+			if ratio, err = strconv.ParseFloat(ratioStr, 64); err != nil {
+				http.Error(w, "Failed to parse sample_ratio: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		events, cancel := t.Tap(stage, ratio)
+		defer cancel()
+
+		t.stats.Incr("tracing.tap.opened", 1)
+		defer t.stats.Incr("tracing.tap.closed", 1)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		enc := json.NewEncoder(w)
+		for {
+			select {
+			case event, open := <-events:
+				if !open {
+					return
+				}
+				if err := enc.Encode(event); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
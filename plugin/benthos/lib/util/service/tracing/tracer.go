@@ -0,0 +1,391 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tracing
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// Stages of a pipeline that are traced.
+const (
+	StageInput  = "input"
+	StageBuffer = "buffer"
+	StageOutput = "output"
+)
+
+// MetadataKey is the metadata key of message parts that holds the trace ID.
+const MetadataKey = "benthos_trace_id"
+
+//------------------------------------------------------------------------------
+
+// Config is configuration for the Tracer type.
+type Config struct {
+	Enabled         bool    `json:"enabled" yaml:"enabled"`
+	ServiceName     string  `json:"service_name" yaml:"service_name"`
+	SampleRatio     float64 `json:"sample_ratio" yaml:"sample_ratio"`
+	MaxTraces       int     `json:"max_traces" yaml:"max_traces"`
+	TraceTimeoutMS  int64   `json:"trace_timeout_ms" yaml:"trace_timeout_ms"`
+	CollectorURL    string  `json:"collector_url" yaml:"collector_url"`
+	FlushIntervalMS int64   `json:"flush_interval_ms" yaml:"flush_interval_ms"`
+}
+
+// NewConfig creates a new Config with default values.
+func NewConfig() Config {
+	return Config{
+		Enabled:         false,
+		ServiceName:     "benthos",
+		SampleRatio:     0.01,
+		MaxTraces:       10000,
+		TraceTimeoutMS:  60000,
+		CollectorURL:    "",
+		FlushIntervalMS: 1000,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Span is a named and timed operation within a trace.
+type Span struct {
+	TraceID  string
+	ID       string
+	ParentID string
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	Tags     map[string]string
+}
+
+// trace is the state of a message that is being traced.
+type trace struct {
+	rootID   string
+	start    time.Time
+	lastExit time.Time
+	spans    []Span
+}
+
+//------------------------------------------------------------------------------
+
+// Tracer attaches trace IDs to a sample of messages entering a pipeline and
+// records spans for them as they pass through each stage. Completed traces are
+// exported to a collector, and messages passing through each stage can be
+// tapped.
+//
+// All methods are safe to call on a nil *Tracer, in which case they do
+// nothing.
+type Tracer struct {
+	running int32
+
+	conf  Config
+	log   log.Modular
+	stats metrics.Type
+
+	tracesMut sync.Mutex
+	traces    map[string]*trace
+	rand      *rand.Rand
+
+	exporter *exporter
+
+	tapsMut  sync.RWMutex
+	taps     map[*tap]struct{}
+	tapCount int32
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// New creates a new Tracer.
+func New(conf Config, log log.Modular, stats metrics.Type) *Tracer {
+	t := &Tracer{
+		running:    1,
+		conf:       conf,
+		log:        log.NewModule(".tracing"),
+		stats:      stats,
+		traces:     map[string]*trace{},
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		taps:       map[*tap]struct{}{},
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+	if len(conf.CollectorURL) > 0 {
+		t.exporter = newExporter(conf.CollectorURL, conf.ServiceName, t.log, stats)
+	}
+	go t.loop()
+	return t
+}
+
+//------------------------------------------------------------------------------
+
+// newID returns a random hex encoded ID of n bytes. Must be called whilst
+// holding tracesMut.
+func (t *Tracer) newID(n int) string {
+	b := make([]byte, n)
+	t.rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// TraceID returns the trace ID of a message, or an empty string if the message
+// is not being traced.
+func TraceID(msg *types.Message) string {
+	for i := range msg.Parts {
+		if id := msg.GetMetadata(i, MetadataKey); len(id) > 0 {
+			return id
+		}
+	}
+	return ""
+}
+
+// Sample decides whether a message entering a pipeline should be traced, and
+// if so attaches a new trace ID to each part of the message.
+func (t *Tracer) Sample(msg *types.Message) {
+	if t == nil || len(msg.Parts) == 0 || len(TraceID(msg)) > 0 {
+		return
+	}
+
+	t.tracesMut.Lock()
+	defer t.tracesMut.Unlock()
+
+	if t.rand.Float64() >= t.conf.SampleRatio {
+		return
+	}
+	if len(t.traces) >= t.conf.MaxTraces {
+		t.stats.Incr("tracing.trace.skipped", 1)
+		return
+	}
+
+	id := t.newID(16)
+	t.traces[id] = &trace{
+		rootID: t.newID(8),
+		start:  time.Now(),
+	}
+
+	// The metadata of a message may be shared with the input that created it,
+	// so we copy it before adding the trace ID.
+	meta := make([]map[string]string, len(msg.Parts))
+	for i := range msg.Parts {
+		meta[i] = map[string]string{}
+		for k, v := range msg.PartMetadata(i) {
+			meta[i][k] = v
+		}
+		meta[i][MetadataKey] = id
+	}
+	msg.Metadata = meta
+
+	t.stats.Incr("tracing.trace.started", 1)
+}
+
+// Record adds a span to the trace of a message that began at start and ends
+// now.
+func (t *Tracer) Record(msg *types.Message, name string, start time.Time) {
+	t.RecordTagged(msg, name, start, nil)
+}
+
+// RecordTagged adds a span with tags to the trace of a message that began at
+// start and ends now.
+func (t *Tracer) RecordTagged(msg *types.Message, name string, start time.Time, tags map[string]string) {
+	if t == nil {
+		return
+	}
+	id := TraceID(msg)
+	if len(id) == 0 {
+		return
+	}
+
+	t.tracesMut.Lock()
+	if tr, exists := t.traces[id]; exists {
+		tr.spans = append(tr.spans, Span{
+			TraceID:  id,
+			ID:       t.newID(8),
+			ParentID: tr.rootID,
+			Name:     name,
+			Start:    start,
+			Duration: time.Since(start),
+			Tags:     tags,
+		})
+	}
+	t.tracesMut.Unlock()
+}
+
+// Enter marks a message entering a stage of the pipeline. For stages other
+// than the input this records a span for the time the message spent in the
+// buffer, and the message is also passed to any taps of the buffer stage.
+func (t *Tracer) Enter(stage string, msg *types.Message) {
+	if t == nil || stage == StageInput {
+		return
+	}
+	if id := TraceID(msg); len(id) > 0 {
+		t.tracesMut.Lock()
+		if tr, exists := t.traces[id]; exists && !tr.lastExit.IsZero() {
+			tr.spans = append(tr.spans, Span{
+				TraceID:  id,
+				ID:       t.newID(8),
+				ParentID: tr.rootID,
+				Name:     StageBuffer,
+				Start:    tr.lastExit,
+				Duration: time.Since(tr.lastExit),
+			})
+		}
+		t.tracesMut.Unlock()
+	}
+	t.tapMessage(StageBuffer, msg)
+}
+
+// Exit marks a message leaving a stage of the pipeline after processing, and
+// passes the message to any taps of the stage.
+func (t *Tracer) Exit(stage string, msg *types.Message) {
+	if t == nil {
+		return
+	}
+	if id := TraceID(msg); len(id) > 0 {
+		t.tracesMut.Lock()
+		if tr, exists := t.traces[id]; exists {
+			tr.lastExit = time.Now()
+		}
+		t.tracesMut.Unlock()
+	}
+	t.tapMessage(stage, msg)
+}
+
+// Finish completes the trace of a message, recording a root span covering the
+// lifetime of the trace with the outcome as a tag, and queues the trace for
+// export.
+func (t *Tracer) Finish(msg *types.Message, outcome string) {
+	if t == nil {
+		return
+	}
+	if id := TraceID(msg); len(id) > 0 {
+		t.finish(id, outcome)
+	}
+}
+
+func (t *Tracer) finish(id, outcome string) {
+	t.tracesMut.Lock()
+	tr, exists := t.traces[id]
+	if !exists {
+		t.tracesMut.Unlock()
+		return
+	}
+	delete(t.traces, id)
+	t.tracesMut.Unlock()
+
+	spans := append(tr.spans, Span{
+		TraceID:  id,
+		ID:       tr.rootID,
+		Name:     "message",
+		Start:    tr.start,
+		Duration: time.Since(tr.start),
+		Tags:     map[string]string{"outcome": outcome},
+	})
+	t.stats.Incr("tracing.trace.finished", 1)
+	if t.exporter != nil {
+		t.exporter.add(spans)
+	}
+}
+
+// Spans returns a copy of the spans recorded so far for a trace ID.
+func (t *Tracer) Spans(id string) []Span {
+	if t == nil {
+		return nil
+	}
+	t.tracesMut.Lock()
+	defer t.tracesMut.Unlock()
+	tr, exists := t.traces[id]
+	if !exists {
+		return nil
+	}
+	return append([]Span(nil), tr.spans...)
+}
+
+//------------------------------------------------------------------------------
+
+// expire finishes traces that have exceeded the trace timeout, which happens
+// when messages are lost, or lose their metadata, within the pipeline.
+func (t *Tracer) expire() {
+	timeout := time.Duration(t.conf.TraceTimeoutMS) * time.Millisecond
+
+	var expired []string
+	t.tracesMut.Lock()
+	for id, tr := range t.traces {
+		if time.Since(tr.start) > timeout {
+			expired = append(expired, id)
+		}
+	}
+	t.tracesMut.Unlock()
+
+	for _, id := range expired {
+		t.stats.Incr("tracing.trace.expired", 1)
+		t.finish(id, "expired")
+	}
+}
+
+func (t *Tracer) loop() {
+	defer close(t.closedChan)
+
+	flushInterval := time.Duration(t.conf.FlushIntervalMS) * time.Millisecond
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.expire()
+			if t.exporter != nil {
+				t.exporter.flush()
+			}
+		case <-t.closeChan:
+			if t.exporter != nil {
+				t.exporter.flush()
+			}
+			return
+		}
+	}
+}
+
+// Close stops the tracer and flushes any completed traces to the collector.
+func (t *Tracer) Close(timeout time.Duration) error {
+	if t == nil {
+		return nil
+	}
+	if atomic.CompareAndSwapInt32(&t.running, 1, 0) {
+		t.closeTaps()
+		close(t.closeChan)
+	}
+	select {
+	case <-t.closedChan:
+	case <-time.After(timeout):
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,214 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/lib/types"
+	"github.com/Jeffail/benthos/lib/util/service/log"
+	"github.com/Jeffail/benthos/lib/util/service/metrics"
+)
+
+var logger = log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+func TestTracerNil(t *testing.T) {
+	var tracer *Tracer
+	msg := types.Message{Parts: [][]byte{[]byte("foo")}}
+	tracer.Sample(&msg)
+	tracer.Enter(StageOutput, &msg)
+	tracer.Exit(StageOutput, &msg)
+	tracer.Finish(&msg, "delivered")
+	if id := TraceID(&msg); len(id) > 0 {
+		t.Errorf("Unexpected trace ID: %v", id)
+	}
+	if err := tracer.Close(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTracerSample(t *testing.T) {
+	conf := NewConfig()
+	conf.SampleRatio = 0
+	tracer := New(conf, logger, metrics.DudType{})
+	defer tracer.Close(time.Second)
+
+	msg := types.Message{Parts: [][]byte{[]byte("foo"), []byte("bar")}}
+	tracer.Sample(&msg)
+	if id := TraceID(&msg); len(id) > 0 {
+		t.Errorf("Unexpected trace ID: %v", id)
+	}
+
+	tracer.conf.SampleRatio = 1
+	original := types.Message{Parts: msg.Parts}
+	original.SetMetadata(0, "foo", "bar")
+	msg = original
+	tracer.Sample(&msg)
+
+	id := TraceID(&msg)
+	if len(id) != 32 {
+		t.Fatalf("Wrong trace ID: %v", id)
+	}
+	for i := range msg.Parts {
+		if act := msg.GetMetadata(i, MetadataKey); act != id {
+			t.Errorf("Wrong trace ID of part %v: %v != %v", i, act, id)
+		}
+	}
+	if exp, act := "bar", msg.GetMetadata(0, "foo"); exp != act {
+		t.Errorf("Wrong metadata: %v != %v", act, exp)
+	}
+	if act := TraceID(&original); len(act) > 0 {
+		t.Errorf("Original message was modified: %v", act)
+	}
+
+	// Messages already being traced are not sampled again.
+	tracer.Sample(&msg)
+	if act := TraceID(&msg); act != id {
+		t.Errorf("Trace ID changed: %v != %v", act, id)
+	}
+}
+
+func TestTracerExport(t *testing.T) {
+	spansChan := make(chan []zipkinSpan, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var spans []zipkinSpan
+		if err := json.NewDecoder(r.Body).Decode(&spans); err != nil {
+			t.Error(err)
+		}
+		spansChan <- spans
+	}))
+	defer collector.Close()
+
+	conf := NewConfig()
+	conf.SampleRatio = 1
+	conf.CollectorURL = collector.URL
+	conf.TraceTimeoutMS = 50
+	conf.FlushIntervalMS = 10
+	tracer := New(conf, logger, metrics.DudType{})
+	defer tracer.Close(time.Second)
+
+	msg := types.Message{Parts: [][]byte{[]byte("foo")}}
+	tracer.Sample(&msg)
+	id := TraceID(&msg)
+
+	tracer.Record(&msg, "input.processor.0", time.Now())
+	tracer.Exit(StageInput, &msg)
+	tracer.Enter(StageOutput, &msg)
+	tracer.Finish(&msg, "delivered")
+
+	var spans []zipkinSpan
+	select {
+	case spans = <-spansChan:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for spans")
+	}
+	if len(spans) != 3 {
+		t.Fatalf("Wrong count of spans: %v", len(spans))
+	}
+	root := spans[2]
+	if root.Name != "message" || root.TraceID != id || len(root.ParentID) > 0 {
+		t.Errorf("Wrong root span: %+v", root)
+	}
+	if exp, act := "delivered", root.Tags["outcome"]; exp != act {
+		t.Errorf("Wrong outcome: %v != %v", act, exp)
+	}
+	for i, exp := range []string{"input.processor.0", StageBuffer} {
+		if spans[i].Name != exp {
+			t.Errorf("Wrong span name: %v != %v", spans[i].Name, exp)
+		}
+		if spans[i].ParentID != root.ID {
+			t.Errorf("Wrong parent ID: %v != %v", spans[i].ParentID, root.ID)
+		}
+		if exp, act := "benthos", spans[i].LocalEndpoint.ServiceName; exp != act {
+			t.Errorf("Wrong service name: %v != %v", act, exp)
+		}
+	}
+
+	// Traces that are never finished expire.
+	msg = types.Message{Parts: [][]byte{[]byte("bar")}}
+	tracer.Sample(&msg)
+	select {
+	case spans = <-spansChan:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for spans")
+	}
+	if len(spans) != 1 || spans[0].Tags["outcome"] != "expired" {
+		t.Errorf("Wrong expired spans: %+v", spans)
+	}
+}
+
+func TestTracerTapHandler(t *testing.T) {
+	conf := NewConfig()
+	conf.SampleRatio = 1
+	tracer := New(conf, logger, metrics.DudType{})
+
+	server := httptest.NewServer(tracer.TapHandler())
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "?stage=nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong status code: %v", res.StatusCode)
+	}
+
+	if res, err = http.Get(server.URL + "?stage=output"); err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	msg := types.Message{Parts: [][]byte{[]byte("foo")}}
+	tracer.Sample(&msg)
+	tracer.Record(&msg, "output.processor.0", time.Now())
+
+	// Messages of other stages are not tapped.
+	tracer.Exit(StageInput, &msg)
+	tracer.Exit(StageOutput, &msg)
+
+	var event TapEvent
+	if err = json.NewDecoder(bufio.NewReader(res.Body)).Decode(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Stage != StageOutput {
+		t.Errorf("Wrong stage: %v", event.Stage)
+	}
+	if event.TraceID != TraceID(&msg) {
+		t.Errorf("Wrong trace ID: %v != %v", event.TraceID, TraceID(&msg))
+	}
+	if len(event.Parts) != 1 || event.Parts[0] != "foo" {
+		t.Errorf("Wrong parts: %v", event.Parts)
+	}
+	if len(event.Spans) != 1 || event.Spans[0].Name != "output.processor.0" {
+		t.Errorf("Wrong spans: %+v", event.Spans)
+	}
+
+	if err = tracer.Close(time.Second); err != nil {
+		t.Error(err)
+	}
+}
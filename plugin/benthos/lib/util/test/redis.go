@@ -0,0 +1,730 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+// RedisStreamEntry is an entry of a stream held by a RedisServer.
+type RedisStreamEntry struct {
+	ID     string
+	Values map[string]string
+}
+
+// RedisPendingEntry is an entry of a stream that has been delivered to a
+// consumer of a group and not yet acknowledged.
+type RedisPendingEntry struct {
+	ID        string
+	Consumer  string
+	Delivered time.Time
+	Count     int64
+}
+
+type redisGroup struct {
+	lastID  string
+	pending []*RedisPendingEntry
+}
+
+type redisStream struct {
+	entries []RedisStreamEntry
+	groups  map[string]*redisGroup
+}
+
+// RedisServer is a minimal in process Redis server for testing components that
+// use lists and streams. Only the subset of commands used by Benthos is
+// supported, and every command received is recorded so that tests can check
+// the order in which commands were sent.
+type RedisServer struct {
+	listener net.Listener
+
+	mut      sync.Mutex
+	lists    map[string][]string
+	streams  map[string]*redisStream
+	commands [][]string
+	lastID   int64
+
+	closeChan chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewRedisServer starts a RedisServer listening on a random local port.
+func NewRedisServer() (*RedisServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	r := &RedisServer{
+		listener:  listener,
+		lists:     map[string][]string{},
+		streams:   map[string]*redisStream{},
+		closeChan: make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.accept()
+	return r, nil
+}
+
+// URL returns a URL for connecting to the server.
+func (r *RedisServer) URL() string {
+	return "tcp://" + r.listener.Addr().String()
+}
+
+// Close stops the server and closes all connections.
+func (r *RedisServer) Close() {
+	close(r.closeChan)
+	r.listener.Close()
+	r.wg.Wait()
+}
+
+//------------------------------------------------------------------------------
+
+// List returns the contents of a list from head to tail.
+func (r *RedisServer) List(key string) []string {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return append([]string{}, r.lists[key]...)
+}
+
+// SetList replaces the contents of a list, given from head to tail.
+func (r *RedisServer) SetList(key string, values ...string) {
+	r.mut.Lock()
+	r.lists[key] = append([]string{}, values...)
+	r.mut.Unlock()
+}
+
+// Stream returns the entries of a stream.
+func (r *RedisServer) Stream(key string) []RedisStreamEntry {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if s, exists := r.streams[key]; exists {
+		return append([]RedisStreamEntry{}, s.entries...)
+	}
+	return nil
+}
+
+// AddStreamEntry adds an entry to a stream and returns its ID.
+func (r *RedisServer) AddStreamEntry(key string, values map[string]string) string {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.xadd(key, values)
+}
+
+// Pending returns the entries of a stream that are pending for a group.
+func (r *RedisServer) Pending(key, group string) []RedisPendingEntry {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	var pending []RedisPendingEntry
+	if s, exists := r.streams[key]; exists {
+		if g, exists := s.groups[group]; exists {
+			for _, p := range g.pending {
+				pending = append(pending, *p)
+			}
+		}
+	}
+	return pending
+}
+
+// Commands returns the name of each command received, in upper case, followed
+// by its arguments.
+func (r *RedisServer) Commands() [][]string {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return append([][]string{}, r.commands...)
+}
+
+//------------------------------------------------------------------------------
+
+func (r *RedisServer) accept() {
+	defer r.wg.Done()
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		r.wg.Add(2)
+		go r.serve(conn)
+		go func() {
+			defer r.wg.Done()
+			<-r.closeChan
+			conn.Close()
+		}()
+	}
+}
+
+func (r *RedisServer) serve(conn net.Conn) {
+	defer r.wg.Done()
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		args, err := readRedisCommand(reader)
+		if err != nil {
+			return
+		}
+		r.handle(writer, args)
+		if err = writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readRedisCommand reads a command sent as an array of bulk strings.
+func readRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readRedisLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, fmt.Errorf("unexpected line: %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = readRedisLine(reader); err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("unexpected line: %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readRedisLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+//------------------------------------------------------------------------------
+
+// redisNil is a null reply.
+type redisNil struct{}
+
+// redisStatus is a simple string reply.
+type redisStatus string
+
+// writeRedisReply writes a reply, where nil values are written as null bulk
+// strings and slices as arrays.
+func writeRedisReply(w *bufio.Writer, v interface{}) {
+	switch t := v.(type) {
+	case redisNil:
+		w.WriteString("*-1\r\n")
+	case redisStatus:
+		w.WriteString("+" + string(t) + "\r\n")
+	case error:
+		w.WriteString("-" + t.Error() + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(t, 10) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(t) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(t)) + "\r\n" + t + "\r\n")
+	case nil:
+		w.WriteString("$-1\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(t)) + "\r\n")
+		for _, e := range t {
+			writeRedisReply(w, e)
+		}
+	default:
+		w.WriteString("-ERR unsupported reply type\r\n")
+	}
+}
+
+func (r *RedisServer) handle(w *bufio.Writer, args []string) {
+	if len(args) == 0 {
+		writeRedisReply(w, errors.New("ERR empty command"))
+		return
+	}
+	name := strings.ToUpper(args[0])
+
+	r.mut.Lock()
+	r.commands = append(r.commands, append([]string{name}, args[1:]...))
+	r.mut.Unlock()
+
+	var res interface{}
+	switch name {
+	case "PING":
+		res = redisStatus("PONG")
+	case "LPUSH":
+		res = r.lpush(args[1:])
+	case "RPOPLPUSH":
+		res = r.rpoplpush(args[1:], false)
+	case "BRPOPLPUSH":
+		res = r.rpoplpush(args[1:], true)
+	case "LREM":
+		res = r.lrem(args[1:])
+	case "XADD":
+		res = r.xaddCmd(args[1:])
+	case "XGROUP":
+		res = r.xgroup(args[1:])
+	case "XREADGROUP":
+		res = r.xreadgroup(args[1:])
+	case "XPENDING":
+		res = r.xpending(args[1:])
+	case "XCLAIM":
+		res = r.xclaim(args[1:])
+	case "XACK":
+		res = r.xack(args[1:])
+	default:
+		res = fmt.Errorf("ERR unknown command '%v'", args[0])
+	}
+	writeRedisReply(w, res)
+}
+
+// block calls fn until it returns a non-nil reply or the timeout elapses,
+// where a zero timeout blocks until the server is closed.
+func (r *RedisServer) block(timeout time.Duration, fn func() interface{}) interface{} {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+	for {
+		if res := fn(); res != nil {
+			return res
+		}
+		select {
+		case <-time.After(time.Millisecond * 5):
+		case <-deadline:
+			return nil
+		case <-r.closeChan:
+			return nil
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (r *RedisServer) lpush(args []string) interface{} {
+	if len(args) < 2 {
+		return errors.New("ERR wrong number of arguments for 'lpush' command")
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	list := r.lists[args[0]]
+	for _, v := range args[1:] {
+		list = append([]string{v}, list...)
+	}
+	r.lists[args[0]] = list
+	return len(list)
+}
+
+func (r *RedisServer) rpoplpush(args []string, blocking bool) interface{} {
+	if (blocking && len(args) != 3) || (!blocking && len(args) != 2) {
+		return errors.New("ERR wrong number of arguments for 'rpoplpush' command")
+	}
+	pop := func() interface{} {
+		r.mut.Lock()
+		defer r.mut.Unlock()
+		src := r.lists[args[0]]
+		if len(src) == 0 {
+			return nil
+		}
+		v := src[len(src)-1]
+		r.lists[args[0]] = src[:len(src)-1]
+		r.lists[args[1]] = append([]string{v}, r.lists[args[1]]...)
+		return v
+	}
+	if !blocking {
+		return pop()
+	}
+	secs, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errors.New("ERR timeout is not an integer")
+	}
+	return r.block(time.Duration(secs)*time.Second, pop)
+}
+
+func (r *RedisServer) lrem(args []string) interface{} {
+	if len(args) != 3 {
+		return errors.New("ERR wrong number of arguments for 'lrem' command")
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < 0 {
+		return errors.New("ERR only positive counts are supported")
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	var kept []string
+	removed := 0
+	for _, v := range r.lists[args[0]] {
+		if v == args[2] && (count == 0 || removed < count) {
+			removed++
+			continue
+		}
+		kept = append(kept, v)
+	}
+	r.lists[args[0]] = kept
+	return removed
+}
+
+//------------------------------------------------------------------------------
+
+// compareIDs compares two stream entry IDs of the form ms-seq.
+func compareIDs(a, b string) int {
+	parse := func(id string) (int64, int64) {
+		split := strings.SplitN(id, "-", 2)
+		ms, _ := strconv.ParseInt(split[0], 10, 64)
+		var seq int64
+		if len(split) > 1 {
+			seq, _ = strconv.ParseInt(split[1], 10, 64)
+		}
+		return ms, seq
+	}
+	aMs, aSeq := parse(a)
+	bMs, bSeq := parse(b)
+	switch {
+	case aMs < bMs, aMs == bMs && aSeq < bSeq:
+		return -1
+	case aMs == bMs && aSeq == bSeq:
+		return 0
+	}
+	return 1
+}
+
+func (s *redisStream) entry(id string) (RedisStreamEntry, bool) {
+	for _, e := range s.entries {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return RedisStreamEntry{}, false
+}
+
+func entryReply(e RedisStreamEntry) interface{} {
+	values := []interface{}{}
+	for k, v := range e.Values {
+		values = append(values, k, v)
+	}
+	return []interface{}{e.ID, values}
+}
+
+// xadd must be called with the lock held.
+func (r *RedisServer) xadd(key string, values map[string]string) string {
+	s, exists := r.streams[key]
+	if !exists {
+		s = &redisStream{groups: map[string]*redisGroup{}}
+		r.streams[key] = s
+	}
+	r.lastID++
+	id := fmt.Sprintf("%v-0", r.lastID)
+	s.entries = append(s.entries, RedisStreamEntry{ID: id, Values: values})
+	return id
+}
+
+func (r *RedisServer) xaddCmd(args []string) interface{} {
+	if len(args) < 2 {
+		return errors.New("ERR wrong number of arguments for 'xadd' command")
+	}
+	key, args := args[0], args[1:]
+	maxLen := -1
+	if strings.ToUpper(args[0]) == "MAXLEN" {
+		args = args[1:]
+		if len(args) > 0 && args[0] == "~" {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return errors.New("ERR syntax error")
+		}
+		var err error
+		if maxLen, err = strconv.Atoi(args[0]); err != nil {
+			return errors.New("ERR value is not an integer")
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 || args[0] != "*" || len(args)%2 != 1 {
+		return errors.New("ERR only generated IDs are supported")
+	}
+	values := map[string]string{}
+	for i := 1; i < len(args); i += 2 {
+		values[args[i]] = args[i+1]
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	id := r.xadd(key, values)
+	if s := r.streams[key]; maxLen >= 0 && len(s.entries) > maxLen {
+		s.entries = s.entries[len(s.entries)-maxLen:]
+	}
+	return id
+}
+
+func (r *RedisServer) xgroup(args []string) interface{} {
+	if len(args) < 4 || strings.ToUpper(args[0]) != "CREATE" {
+		return errors.New("ERR only XGROUP CREATE is supported")
+	}
+	key, group, start := args[1], args[2], args[3]
+	mkStream := len(args) > 4 && strings.ToUpper(args[4]) == "MKSTREAM"
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	s, exists := r.streams[key]
+	if !exists {
+		if !mkStream {
+			return errors.New("ERR The XGROUP subcommand requires the key to exist")
+		}
+		s = &redisStream{groups: map[string]*redisGroup{}}
+		r.streams[key] = s
+	}
+	if _, exists = s.groups[group]; exists {
+		return errors.New("BUSYGROUP Consumer Group name already exists")
+	}
+	if start == "$" {
+		start = "0-0"
+		if len(s.entries) > 0 {
+			start = s.entries[len(s.entries)-1].ID
+		}
+	}
+	s.groups[group] = &redisGroup{lastID: start}
+	return redisStatus("OK")
+}
+
+func (r *RedisServer) xreadgroup(args []string) interface{} {
+	if len(args) < 3 || strings.ToUpper(args[0]) != "GROUP" {
+		return errors.New("ERR syntax error")
+	}
+	group, consumer := args[1], args[2]
+	args = args[3:]
+
+	count, block := 0, time.Duration(-1)
+	var streamArgs []string
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			i++
+			count, _ = strconv.Atoi(args[i])
+		case "BLOCK":
+			i++
+			ms, _ := strconv.Atoi(args[i])
+			block = time.Duration(ms) * time.Millisecond
+		case "STREAMS":
+			streamArgs = args[i+1:]
+			i = len(args)
+		}
+	}
+	if len(streamArgs) == 0 || len(streamArgs)%2 != 0 {
+		return errors.New("ERR Unbalanced XREADGROUP list of streams")
+	}
+	keys, ids := streamArgs[:len(streamArgs)/2], streamArgs[len(streamArgs)/2:]
+
+	read := func() interface{} {
+		r.mut.Lock()
+		defer r.mut.Unlock()
+
+		var res []interface{}
+		newEntries := false
+		for i, key := range keys {
+			s, exists := r.streams[key]
+			if !exists {
+				return errors.New("NOGROUP No such key or consumer group")
+			}
+			g, exists := s.groups[group]
+			if !exists {
+				return errors.New("NOGROUP No such key or consumer group")
+			}
+			entries := []interface{}{}
+			if ids[i] == ">" {
+				for _, e := range s.entries {
+					if count > 0 && len(entries) >= count {
+						break
+					}
+					if compareIDs(e.ID, g.lastID) <= 0 {
+						continue
+					}
+					g.lastID = e.ID
+					g.pending = append(g.pending, &RedisPendingEntry{
+						ID: e.ID, Consumer: consumer, Delivered: time.Now(), Count: 1,
+					})
+					entries = append(entries, entryReply(e))
+				}
+				if len(entries) == 0 {
+					continue
+				}
+				newEntries = true
+			} else {
+				for _, p := range g.pending {
+					if count > 0 && len(entries) >= count {
+						break
+					}
+					if p.Consumer != consumer || compareIDs(p.ID, ids[i]) <= 0 {
+						continue
+					}
+					if e, exists := s.entry(p.ID); exists {
+						p.Delivered = time.Now()
+						p.Count++
+						entries = append(entries, entryReply(e))
+					}
+				}
+			}
+			res = append(res, []interface{}{key, entries})
+		}
+		if len(res) == 0 || (!newEntries && ids[0] == ">") {
+			return nil
+		}
+		return res
+	}
+
+	var res interface{}
+	if ids[0] == ">" && block >= 0 {
+		res = r.block(block, read)
+	} else {
+		res = read()
+	}
+	if res == nil {
+		return redisNil{}
+	}
+	return res
+}
+
+func (r *RedisServer) xpending(args []string) interface{} {
+	if len(args) < 5 {
+		return errors.New("ERR only the extended form of XPENDING is supported")
+	}
+	key, group, start, end := args[0], args[1], args[2], args[3]
+	count, _ := strconv.Atoi(args[4])
+	var consumer string
+	if len(args) > 5 {
+		consumer = args[5]
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	s, exists := r.streams[key]
+	if !exists {
+		return errors.New("NOGROUP No such key or consumer group")
+	}
+	g, exists := s.groups[group]
+	if !exists {
+		return errors.New("NOGROUP No such key or consumer group")
+	}
+
+	res := []interface{}{}
+	for _, p := range g.pending {
+		if len(res) >= count {
+			break
+		}
+		if (start != "-" && compareIDs(p.ID, start) < 0) ||
+			(end != "+" && compareIDs(p.ID, end) > 0) ||
+			(len(consumer) > 0 && p.Consumer != consumer) {
+			continue
+		}
+		idle := int64(time.Since(p.Delivered) / time.Millisecond)
+		res = append(res, []interface{}{p.ID, p.Consumer, idle, p.Count})
+	}
+	return res
+}
+
+func (r *RedisServer) xclaim(args []string) interface{} {
+	if len(args) < 5 {
+		return errors.New("ERR wrong number of arguments for 'xclaim' command")
+	}
+	key, group, consumer := args[0], args[1], args[2]
+	minIdleMS, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return errors.New("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	minIdle := time.Duration(minIdleMS) * time.Millisecond
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	s, exists := r.streams[key]
+	if !exists {
+		return errors.New("NOGROUP No such key or consumer group")
+	}
+	g, exists := s.groups[group]
+	if !exists {
+		return errors.New("NOGROUP No such key or consumer group")
+	}
+
+	res := []interface{}{}
+	for _, id := range args[4:] {
+		for _, p := range g.pending {
+			if p.ID != id || time.Since(p.Delivered) < minIdle {
+				continue
+			}
+			if e, exists := s.entry(id); exists {
+				p.Consumer = consumer
+				p.Delivered = time.Now()
+				p.Count++
+				res = append(res, entryReply(e))
+			}
+		}
+	}
+	return res
+}
+
+func (r *RedisServer) xack(args []string) interface{} {
+	if len(args) < 3 {
+		return errors.New("ERR wrong number of arguments for 'xack' command")
+	}
+	key, group := args[0], args[1]
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	s, exists := r.streams[key]
+	if !exists {
+		return 0
+	}
+	g, exists := s.groups[group]
+	if !exists {
+		return 0
+	}
+
+	acked := 0
+	for _, id := range args[2:] {
+		for i, p := range g.pending {
+			if p.ID == id {
+				g.pending = append(g.pending[:i], g.pending[i+1:]...)
+				acked++
+				break
+			}
+		}
+	}
+	return acked
+}
+
+//------------------------------------------------------------------------------